@@ -0,0 +1,124 @@
+// Command eval runs declarative desktop scenarios through the agent on a
+// private Xvfb display and reports success rate, iterations, tokens and time.
+//
+//	eval -scenarios eval/scenarios -out eval-results --provider=deepseek --key=...
+//	eval -scenarios eval/scenarios -out eval-results      (scenarios with "replay" bundles need no key)
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/eval"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/screenshot"
+	"useless-agent/internal/tracing"
+)
+
+var (
+	scenariosPath = flag.String("scenarios", "", "scenario JSON file or directory of scenario files")
+	outDir        = flag.String("out", "eval-results", "directory for report.json, junit.xml and trajectories")
+	xvfbDisplay   = flag.String("xvfb-display", ":99", "display number of the private Xvfb server")
+	xvfbScreen    = flag.String("xvfb-screen", "1920x1080x24", "Xvfb screen geometry and depth")
+	windowManager = flag.String("wm", "xfwm4", "window manager to run on the private display (empty for none)")
+)
+
+var logger = logging.For("eval")
+
+func main() {
+	flag.Parse()
+	if *scenariosPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: eval -scenarios <file|dir> [-out dir] [-xvfb-display :99] [-wm xfwm4] [LLM flags]")
+		os.Exit(2)
+	}
+
+	logOptions, err := logging.OptionsFromFlags()
+	if err == nil {
+		err = logging.Setup(logOptions)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		os.Exit(2)
+	}
+	defer logging.Close()
+
+	if err := tracing.Setup(tracing.OptionsFromFlags()); err != nil {
+		fatal("Failed to set up tracing", err)
+	}
+	defer tracing.Shutdown()
+
+	scenarios, err := eval.LoadScenarios(*scenariosPath)
+	if err != nil {
+		fatal("Failed to load scenarios", err)
+	}
+
+	if err := os.MkdirAll(*outDir, 0o755); err != nil {
+		fatal("Failed to create output directory", err)
+	}
+	*config.TrajectoryDir = eval.TrajectoryRoot(*outDir)
+	// The report links every scenario's bundle
+	*config.TrajectoryKeep = 0
+
+	if err := screenshot.SuppressXGBLogs(); err != nil {
+		fatal("Failed to suppress xgb logs", err)
+	}
+
+	// Scenarios with a replay bundle bring their own LLM client
+	for _, s := range scenarios {
+		if s.Replay == "" {
+			if err := llm.InitializeLLM(); err != nil {
+				fatal("Failed to initialize LLM", err)
+			}
+			break
+		}
+	}
+
+	display, err := eval.StartDisplay(*xvfbDisplay, *xvfbScreen, *windowManager)
+	if err != nil {
+		fatal("Failed to start private display", err)
+	}
+	*config.Display = display.Name
+	os.Setenv("DISPLAY", display.Name)
+
+	startedAt := time.Now()
+	results := eval.Run(display, scenarios)
+	display.Stop()
+
+	report := eval.NewReport(startedAt, results)
+	if err := report.WriteJSON(filepath.Join(*outDir, "report.json")); err != nil {
+		logger.Error("Failed to write JSON report", "error", err)
+	}
+	if err := report.WriteJUnit(filepath.Join(*outDir, "junit.xml")); err != nil {
+		logger.Error("Failed to write JUnit report", "error", err)
+	}
+
+	fmt.Printf("%-30s %-8s %-10s %10s %8s %10s\n", "SCENARIO", "RESULT", "STATUS", "ITERATIONS", "TOKENS", "TIME")
+	for _, r := range results {
+		verdict := "FAIL"
+		if r.Success {
+			verdict = "PASS"
+		}
+		fmt.Printf("%-30s %-8s %-10s %10d %8d %9.1fs\n", r.Name, verdict, r.TaskStatus, r.Iterations, r.Tokens, float64(r.DurationMs)/1000)
+	}
+	fmt.Printf("success rate: %d/%d (%.0f%%), %d iterations, %d tokens, %.1fs\n",
+		report.Passed, report.Total, report.SuccessRate*100, report.Iterations, report.Tokens, float64(report.DurationMs)/1000)
+
+	if report.Passed < report.Total {
+		tracing.Shutdown()
+		logging.Close()
+		os.Exit(1)
+	}
+}
+
+// fatal logs an error and exits, closing the log file first
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	tracing.Shutdown()
+	logging.Close()
+	os.Exit(1)
+}
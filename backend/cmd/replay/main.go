@@ -0,0 +1,231 @@
+// Command replay re-runs a recorded trajectory bundle offline and shows where
+// the current perception, prompts and parser would decide differently.
+//
+//	replay -bundle trajectories/<taskID>            interactive step-through
+//	replay -bundle trajectory-<taskID>.zip -all     replay everything, print a summary
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"useless-agent/internal/replay"
+)
+
+var (
+	bundlePath = flag.String("bundle", "", "trajectory bundle directory or zip archive")
+	replayAll  = flag.Bool("all", false, "replay every iteration and print a summary instead of stepping interactively")
+	jsonOutput = flag.Bool("json", false, "with -all, print every replayed step as JSON")
+	verbose    = flag.Bool("verbose", false, "show the agent's own log output while replaying")
+)
+
+// out is the real stdout; the agent pipeline prints a lot, which is hidden
+// unless -verbose is set
+var out = os.Stdout
+
+func main() {
+	flag.Parse()
+	if *bundlePath == "" {
+		fmt.Fprintln(os.Stderr, "usage: replay -bundle <dir|zip> [-all] [-json] [-verbose]")
+		os.Exit(2)
+	}
+
+	if !*verbose {
+		devNull, err := os.OpenFile(os.DevNull, os.O_WRONLY, 0)
+		if err == nil {
+			os.Stdout = devNull
+			log.SetOutput(devNull)
+		}
+	}
+
+	session, err := replay.Open(*bundlePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	defer session.Close()
+
+	m := session.Manifest()
+	fmt.Fprintf(out, "Task %s (%s): %q, %d iterations, %d LLM calls\n", m.TaskID, m.Status, m.Goal, session.Len(), len(m.LLMCalls))
+
+	if *replayAll {
+		os.Exit(runAll(session))
+	}
+	runInteractive(session)
+}
+
+// runAll replays every iteration and returns 1 if any decision changed
+func runAll(session *replay.Session) int {
+	changed := 0
+	for {
+		step, err := session.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			return 2
+		}
+		if *jsonOutput {
+			data, _ := json.Marshal(step)
+			fmt.Fprintln(out, string(data))
+		} else {
+			fmt.Fprintln(out, summarize(step))
+		}
+		if step.ActionsChanged || step.VerdictChanged {
+			changed++
+		}
+	}
+	fmt.Fprintf(out, "%d of %d iterations decided differently\n", changed, session.Len())
+	if changed > 0 {
+		return 1
+	}
+	return 0
+}
+
+func runInteractive(session *replay.Session) {
+	fmt.Fprintln(out, "commands: n(ext), g(oto) <index>, p(rompt) [kind], d(iff) [kind], a(ctions), v(erdict), l(ist), q(uit)")
+
+	var step *replay.Step
+	scanner := bufio.NewScanner(os.Stdin)
+	for {
+		fmt.Fprintf(out, "replay[%d/%d]> ", session.Position(), session.Len())
+		if !scanner.Scan() {
+			return
+		}
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		arg := ""
+		if len(fields) > 1 {
+			arg = fields[1]
+		}
+
+		switch fields[0] {
+		case "n", "next":
+			next, err := session.Next()
+			if errors.Is(err, io.EOF) {
+				fmt.Fprintln(out, "end of trajectory")
+				continue
+			}
+			if err != nil {
+				fmt.Fprintln(out, "error:", err)
+				continue
+			}
+			step = next
+			fmt.Fprintln(out, summarize(step))
+		case "g", "goto":
+			index, err := strconv.Atoi(arg)
+			if err == nil {
+				err = session.Seek(index)
+			}
+			if err != nil {
+				fmt.Fprintln(out, "error:", err)
+			}
+		case "l", "list":
+			for i, it := range session.Manifest().Iterations {
+				fmt.Fprintf(out, "%3d  iteration %d, subtask %d: %s\n", i, it.Number, it.SubtaskID, it.Subtask)
+			}
+		case "p", "prompt", "d", "diff":
+			if step == nil {
+				fmt.Fprintln(out, "no iteration replayed yet, use 'next'")
+				continue
+			}
+			call := findCall(step, arg)
+			if call == nil {
+				fmt.Fprintf(out, "no %q call in this iteration\n", kindOrDefault(arg))
+				continue
+			}
+			if fields[0] == "p" || fields[0] == "prompt" {
+				fmt.Fprint(out, replay.RenderRequest(call.Request))
+			} else if !call.PromptChanged {
+				fmt.Fprintln(out, "prompt is identical to the recorded one")
+			} else {
+				fmt.Fprint(out, call.PromptDiff)
+			}
+		case "a", "actions":
+			if step == nil {
+				fmt.Fprintln(out, "no iteration replayed yet, use 'next'")
+				continue
+			}
+			printJSON("recorded", step.OriginalActions)
+			printJSON("replayed", step.Actions)
+			if step.ActionsError != "" {
+				fmt.Fprintln(out, "replay error:", step.ActionsError)
+			}
+		case "v", "verdict":
+			if step == nil {
+				fmt.Fprintln(out, "no iteration replayed yet, use 'next'")
+				continue
+			}
+			printJSON("recorded", step.OriginalVerdict)
+			printJSON("replayed", step.Verdict)
+		case "q", "quit", "exit":
+			return
+		default:
+			fmt.Fprintln(out, "unknown command", fields[0])
+		}
+	}
+}
+
+// summarize describes a replayed iteration on one line
+func summarize(step *replay.Step) string {
+	var changedPrompts []string
+	for _, c := range step.Calls {
+		if c.PromptChanged {
+			changedPrompts = append(changedPrompts, c.Kind)
+		}
+	}
+	prompts := "prompts same"
+	if len(changedPrompts) > 0 {
+		prompts = "prompts changed (" + strings.Join(changedPrompts, ", ") + ")"
+	}
+	actions := "actions same"
+	if step.ActionsChanged {
+		actions = "ACTIONS CHANGED"
+	}
+	verdict := "no verdict"
+	if step.Verdict != nil {
+		verdict = fmt.Sprintf("verdict completed=%t", step.Verdict.Completed)
+		if step.VerdictChanged {
+			verdict = "VERDICT CHANGED: " + verdict
+		}
+	}
+	return fmt.Sprintf("iteration %d, subtask %d: %s; %s; %s", step.Iteration, step.SubtaskID, prompts, actions, verdict)
+}
+
+func kindOrDefault(kind string) string {
+	if kind == "" {
+		return "actions"
+	}
+	return kind
+}
+
+// findCall returns the first call of a kind, the act call by default
+func findCall(step *replay.Step, kind string) *replay.Call {
+	kind = kindOrDefault(kind)
+	for i := range step.Calls {
+		if step.Calls[i].Kind == kind {
+			return &step.Calls[i]
+		}
+	}
+	return nil
+}
+
+func printJSON(label string, v interface{}) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		fmt.Fprintln(out, label+": failed to encode:", err)
+		return
+	}
+	fmt.Fprintf(out, "%s:\n%s\n", label, data)
+}
@@ -2,113 +2,110 @@ package main
 
 import (
 	"flag"
-	"log"
-	"net"
+	"fmt"
 	"net/http"
 	"os"
-	"strconv"
-	"strings"
-	"sync"
 
+	"useless-agent/internal/auth"
 	"useless-agent/internal/config"
 	httpHandlers "useless-agent/internal/http"
 	"useless-agent/internal/llm"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
 	"useless-agent/internal/mouse"
 	"useless-agent/internal/screenshot"
+	"useless-agent/internal/server"
+	"useless-agent/internal/skill"
+	"useless-agent/internal/stream"
+	"useless-agent/internal/task"
+	"useless-agent/internal/tracing"
 	"useless-agent/internal/websocket"
 )
 
+var logger = logging.For("server")
+
 func main() {
 	flag.Parse()
 
-	if err := screenshot.SuppressXGBLogs(); err != nil {
-		log.Fatalf("Failed to suppress xgb logs: %v", err)
+	logOptions, err := logging.OptionsFromFlags()
+	if err == nil {
+		err = logging.Setup(logOptions)
 	}
-
-	// Initialize LLM system
-	if err := llm.InitializeLLM(); err != nil {
-		log.Fatalf("Failed to initialize LLM: %v", err)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "Failed to set up logging:", err)
+		os.Exit(2)
 	}
+	defer logging.Close()
 
-	// Set up stdout interception for log streaming
-	if err := setupLogStreaming(); err != nil {
-		log.Printf("Warning: Failed to setup log streaming: %v", err)
+	if err := tracing.Setup(tracing.OptionsFromFlags()); err != nil {
+		fatal("Failed to set up tracing", err)
 	}
-
-	// Set up HTTP routes
-	mux := http.NewServeMux()
-	mux.HandleFunc("/ws", websocket.WSHandler)
-	mux.HandleFunc("/screenshot", httpHandlers.ScreenshotHandler)
-	mux.HandleFunc("/mouse-input", mouse.MouseInputHandler)
-	mux.HandleFunc("/mouse-click", mouse.MouseClickHandler)
-	mux.HandleFunc("/llm-input", httpHandlers.LLMInputHandler)
-	mux.HandleFunc("/video2", httpHandlers.Video2Handler)
-	mux.HandleFunc("/task-cancel", httpHandlers.TaskCancelHandler)
-	mux.HandleFunc("/user-assist", httpHandlers.UserAssistHandler)
-	mux.HandleFunc("/execution-state", httpHandlers.ExecutionStateHandler)
-	mux.HandleFunc("/ping", httpHandlers.PingHandler)
-
-	bindAddr := net.JoinHostPort(*config.BindIP, strconv.Itoa(*config.BindPORT))
-	log.Println("Server running on http://" + bindAddr)
-
-	if err := http.ListenAndServe(bindAddr, httpHandlers.CORSMiddleware(mux)); err != nil {
-		log.Fatal("Server error:", err)
+	defer tracing.Shutdown()
+
+	if *config.AuthConfig != "" {
+		authConfig, err := auth.LoadFile(*config.AuthConfig)
+		if err != nil {
+			fatal("Failed to load auth config", err)
+		}
+		auth.Use(authConfig)
+		logger.Info("API authentication enabled", "tokens", len(authConfig.Tokens), "hmacKeys", len(authConfig.HMACKeys), "origins", authConfig.Origins)
+	} else {
+		logger.Warn("API authentication disabled, anyone who can reach the server controls the desktop (see -auth-config)")
 	}
-}
 
-// setupLogStreaming creates a custom logger that duplicates output to both stdout and WebSocket clients
-func setupLogStreaming() error {
-	// Create a custom writer that sends to both stdout and WebSocket
-	logWriter := &LogWriter{
-		original: os.Stdout,
+	if err := screenshot.SuppressXGBLogs(); err != nil {
+		fatal("Failed to suppress xgb logs", err)
 	}
 
-	// Replace the standard logger with our custom one
-	log.SetOutput(logWriter)
-	log.SetFlags(log.LstdFlags)
-
-	return nil
-}
-
-// LogWriter is a custom writer that duplicates writes to both original stdout and WebSocket clients
-type LogWriter struct {
-	original *os.File
-	mu       sync.Mutex
-}
-
-func (lw *LogWriter) Write(p []byte) (n int, err error) {
-	lw.mu.Lock()
-	defer lw.mu.Unlock()
-
-	// Write to original stdout
-	n, err = lw.original.Write(p)
-	if err != nil {
-		return n, err
+	// Initialize LLM system
+	if err := llm.InitializeLLM(); err != nil {
+		fatal("Failed to initialize LLM", err)
 	}
 
-	// Filter out empty or problematic log lines before sending to WebSocket clients
-	logData := string(p)
+	// Stream log records to the WebSocket log channel
+	logging.Subscribe(websocket.SendLogEntry)
+	httpHandlers.RegisterWebSocketCommands()
 
-	// Skip empty lines
-	if len(strings.TrimSpace(logData)) == 0 {
-		return n, nil
-	}
+	// Operator input can be recorded as skills
+	task.OnOperatorInput(skill.Observe)
 
-	// Skip lines that are just separators (like "====" or "ASK ===")
-	trimmed := strings.TrimSpace(logData)
-	if strings.HasPrefix(trimmed, "===") && len(trimmed) <= 10 {
-		return n, nil
+	// Schedules queue their tasks like POST /api/v2/tasks
+	task.SetScheduleRunner(httpHandlers.RunScheduledRequest)
+	if err := task.StartScheduler(); err != nil {
+		fatal("Failed to start the scheduler", err)
 	}
 
-	// Skip lines containing "ASK ===" pattern
-	if strings.Contains(logData, "ASK ===") {
-		return n, nil
-	}
+	// Set up HTTP routes
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ws", auth.Require(auth.ScopeView, websocket.WSHandler))
+	mux.HandleFunc("/ws/schema.json", websocket.SchemaHandler)
+	mux.HandleFunc("/screenshot", auth.Require(auth.ScopeView, httpHandlers.ScreenshotHandler))
+	mux.HandleFunc("/mouse-input", auth.Require(auth.ScopeInput, mouse.MouseInputHandler))
+	mux.HandleFunc("/mouse-click", auth.Require(auth.ScopeInput, mouse.MouseClickHandler))
+	mux.HandleFunc("/llm-input", auth.Require(auth.ScopeSubmit, httpHandlers.LLMInputHandler))
+	mux.HandleFunc("/video2", auth.Require(auth.ScopeView, httpHandlers.Video2Handler))
+	mux.HandleFunc("/stream", auth.Require(auth.ScopeView, stream.WSHandler))
+	mux.HandleFunc("/stream.mjpeg", auth.Require(auth.ScopeView, stream.MJPEGHandler))
+	mux.HandleFunc("/task-cancel", auth.Require(auth.ScopeSubmit, httpHandlers.TaskCancelHandler))
+	mux.HandleFunc("/user-assist", auth.Require(auth.ScopeSubmit, httpHandlers.UserAssistHandler))
+	mux.HandleFunc("/execution-state", auth.Require(auth.ScopeView, httpHandlers.ExecutionStateHandler))
+	mux.HandleFunc("/task-trajectory", auth.Require(auth.ScopeView, httpHandlers.TrajectoryHandler))
+	mux.HandleFunc("/ping", httpHandlers.PingHandler)
+	mux.HandleFunc("/metrics", auth.Require(auth.ScopeView, metrics.Handler))
+	httpHandlers.RegisterAPIv2(mux)
 
-	// Send to WebSocket clients (non-blocking)
-	go func() {
-		websocket.SendLogMessage(logData)
-	}()
+	// Serves until SIGINT/SIGTERM, then cancels the tasks and closes the
+	// WebSocket clients before the deferred log and trace flushes
+	if err := server.Run(server.OptionsFromFlags(), httpHandlers.CORSMiddleware(mux)); err != nil {
+		fatal("Server error", err)
+	}
+	logger.Info("server stopped")
+}
 
-	return n, nil
+// fatal logs an error and exits, closing the log file first
+func fatal(msg string, err error) {
+	logger.Error(msg, "error", err)
+	tracing.Shutdown()
+	logging.Close()
+	os.Exit(1)
 }
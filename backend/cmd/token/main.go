@@ -0,0 +1,65 @@
+// Command token mints an HMAC-signed API token with a key from the auth
+// config file.
+//
+//	token -auth-config auth.json -key fleet -sub alice -scopes view,submit -ttl 720h
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/config"
+)
+
+var (
+	keyID   = flag.String("key", "", "id of the hmacKeys entry to sign with")
+	subject = flag.String("sub", "", "who the token is for, shown in the server log")
+	scopes  = flag.String("scopes", "view", "comma separated scopes: view, submit, input")
+	ttl     = flag.Duration("ttl", 30*24*time.Hour, "token lifetime (0 for no expiry)")
+)
+
+func main() {
+	flag.Parse()
+	if *config.AuthConfig == "" || *keyID == "" || *subject == "" {
+		fmt.Fprintln(os.Stderr, "usage: token -auth-config <file> -key <id> -sub <name> [-scopes view,submit,input] [-ttl 720h]")
+		os.Exit(2)
+	}
+
+	cfg, err := auth.LoadFile(*config.AuthConfig)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var key *auth.HMACKey
+	for i := range cfg.HMACKeys {
+		if cfg.HMACKeys[i].ID == *keyID {
+			key = &cfg.HMACKeys[i]
+		}
+	}
+	if key == nil {
+		fmt.Fprintf(os.Stderr, "no hmacKeys entry with id %q in %s\n", *keyID, *config.AuthConfig)
+		os.Exit(1)
+	}
+
+	var requested []auth.Scope
+	for _, s := range strings.Split(*scopes, ",") {
+		scope := auth.Scope(strings.TrimSpace(s))
+		if !(auth.Principal{Scopes: key.Scopes}).Has(scope) {
+			fmt.Fprintf(os.Stderr, "key %q does not allow scope %q\n", key.ID, scope)
+			os.Exit(1)
+		}
+		requested = append(requested, scope)
+	}
+
+	token, err := auth.Sign(*key, *subject, requested, *ttl)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println(token)
+}
@@ -8,7 +8,7 @@ require (
 	github.com/gorilla/websocket v1.5.3
 	github.com/otiai10/gosseract/v2 v2.4.1
 	github.com/trustsight-io/deepseek-go v0.1.1
-	golang.org/x/image v0.41.0 // indirect
+	golang.org/x/image v0.41.0
 )
 
 require (
@@ -38,6 +38,7 @@ require (
 	github.com/yusufpapurcu/wmi v1.2.4 // indirect
 	golang.org/x/exp v0.0.0-20260212183809-81e46e3db34a // indirect
 	golang.org/x/sys v0.41.0 // indirect
+	golang.org/x/text v0.37.0 // indirect
 )
 
 require internal/vision v1.0.0
@@ -1,14 +1,18 @@
 package action
 
 import (
-	"fmt"
+	"context"
 	"time"
 
-	"github.com/go-vgo/robotgo"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/tracing"
+	"useless-agent/pkg/x11"
 )
 
+var logger = logging.For("action")
+
 // actionFunctions maps action names to their execution functions
-var actionFunctions = map[string]func(*Action, ...interface{}){
+var actionFunctions = map[string]func(context.Context, *Action, ...interface{}){
 	"mouseMove":            mouseMoveExecution,
 	"mouseMoveRelative":    mouseMoveRelativeExecution,
 	"mouseClickLeft":       mouseClickLeftExecution,
@@ -18,6 +22,7 @@ var actionFunctions = map[string]func(*Action, ...interface{}){
 	"stopIteration":        stopIterationActionExecution,
 	"printString":          printStringActionExecution,
 	"keyTap":               keyTapActionExecution,
+	"hotkey":               hotkeyActionExecution,
 	"dragSmooth":           dragSmoothActionExecution,
 	"keyDown":              keyDownActionExecution,
 	"keyUp":                keyUpActionExecution,
@@ -25,6 +30,19 @@ var actionFunctions = map[string]func(*Action, ...interface{}){
 	"repeat":               repeatActionExecution,
 }
 
+// validators check the parameters of actions registered with a validator
+var validators = map[string]func(*Action) error{}
+
+// RegisterAction adds an action implemented outside this package, such as
+// runSkill. validate may be nil; it is called by Validate. Register from an
+// init function, the maps are not guarded.
+func RegisterAction(name string, execute func(context.Context, *Action, ...interface{}), validate func(*Action) error) {
+	actionFunctions[name] = execute
+	if validate != nil {
+		validators[name] = validate
+	}
+}
+
 // SetExecuteFunction sets the Execute function for an action based on its Action field
 func SetExecuteFunction(action *Action) {
 	if execFunc, exists := actionFunctions[action.Action]; exists {
@@ -37,93 +55,110 @@ func SetExecuteFunction(action *Action) {
 
 // Action execution functions
 
-func mouseMoveExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing 'mouseMove' Action (ID: %d)\n", a.ActionSequenceID)
+func mouseMoveExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.Coordinates.X != 0 || a.Coordinates.Y != 0 {
-		fmt.Printf("Coordinates: X=%d, Y=%d\n", a.Coordinates.X, a.Coordinates.Y)
-		robotgo.MoveSmooth(a.Coordinates.X, a.Coordinates.Y)
+		moveSmooth(a.Coordinates.X, a.Coordinates.Y)
 	} else {
-		fmt.Println("No coordinates provided.")
+		logger.WarnContext(ctx, "no coordinates provided", "action", a.Action)
 	}
 }
 
-func mouseMoveRelativeExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing 'mouseMoveRelative' Action (ID: %d)\n", a.ActionSequenceID)
+func mouseMoveRelativeExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.Coordinates.X != 0 || a.Coordinates.Y != 0 {
-		fmt.Printf("Coordinates: X=%d, Y=%d\n", a.Coordinates.X, a.Coordinates.Y)
-		robotgo.MoveSmoothRelative(a.Coordinates.X, a.Coordinates.Y)
+		moveSmoothRelative(a.Coordinates.X, a.Coordinates.Y)
 	} else {
-		fmt.Println("No coordinates provided.")
+		logger.WarnContext(ctx, "no coordinates provided", "action", a.Action)
 	}
 }
 
-func mouseClickLeftExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing 'mouseClickLeft' Action (ID: %d)\n", a.ActionSequenceID)
-	robotgo.Click()
+func mouseClickLeftExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
+	click("left", false)
 }
 
-func mouseClickLeftDoubleExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing 'mouseClickLeftDouble' Action (ID: %d)\n", a.ActionSequenceID)
-	robotgo.Click("left", true)
+func mouseClickLeftDoubleExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
+	click("left", true)
 }
 
-func mouseClickRightExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing 'mouseClickRight' Action (ID: %d)\n", a.ActionSequenceID)
-	robotgo.Click("right")
+func mouseClickRightExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
+	click("right", false)
 }
 
-func nopActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing nop action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func nopActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.Duration > 0 {
 		time.Sleep(time.Duration(a.Duration) * time.Second)
 	}
 }
 
-func stopIterationActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing stopIteration action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func stopIterationActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 }
 
-func printStringActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing printString action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func printStringActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.InputString != "" {
-		robotgo.TypeStrDelay(a.InputString, 100)
+		typeString(a.InputString)
 	}
 }
 
-func keyTapActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing keyTap action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func keyTapActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.KeyTapString != "" {
-		robotgo.KeyTap(a.KeyTapString)
+		// keyTap also accepts combos such as "ctrl+l"
+		keys, err := x11.ParseKeyCombo(a.KeyTapString)
+		if err != nil {
+			logger.WarnContext(ctx, "invalid keyTapString", "keyTapString", a.KeyTapString, "error", err)
+			return
+		}
+		tapKeys(keys)
+	}
+}
+
+func hotkeyActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
+	keys, err := hotkeyKeys(a)
+	if err != nil {
+		logger.WarnContext(ctx, "invalid hotkey", "error", err)
+		return
 	}
+	tapKeys(keys)
 }
 
-func dragSmoothActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing DragSmooth action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func dragSmoothActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.Coordinates.X != 0 || a.Coordinates.Y != 0 {
-		robotgo.DragSmooth(a.Coordinates.X, a.Coordinates.Y)
+		// DragSmooth holds the left button for the whole move
+		markButtonHeld("left", true)
+		dragSmooth(a.Coordinates.X, a.Coordinates.Y)
+		markButtonHeld("left", false)
 	}
 }
 
-func keyDownActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing keyDown action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func keyDownActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.KeyString != "" {
-		robotgo.KeyDown(a.KeyString)
+		pressKey(a.KeyString)
 	}
 }
 
-func keyUpActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing keyUp action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
+func keyUpActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
 	if a.KeyString != "" {
-		robotgo.KeyUp(a.KeyString)
+		releaseKey(a.KeyString)
 	}
 }
 
-func scrollSmoothActionExecution(a *Action, params ...interface{}) {
-	fmt.Printf("Executing scrollSmooth action '%s' (ID: %d)\n", a.Action, a.ActionSequenceID)
-	robotgo.ScrollSmooth(a.Coordinates.Y)
+func scrollSmoothActionExecution(ctx context.Context, a *Action, params ...interface{}) {
+	logger.DebugContext(ctx, "executing action", "action", a.Action, "id", a.ActionSequenceID)
+	scrollSmooth(a.Coordinates.Y)
 }
 
-func repeatActionExecution(a *Action, params ...interface{}) {
+func repeatActionExecution(ctx context.Context, a *Action, params ...interface{}) {
 	if len(params) == 0 {
 		return
 	}
@@ -152,7 +187,10 @@ func repeatActionExecution(a *Action, params ...interface{}) {
 	for i := 0; i < a.RepeatTimes; i++ {
 		for j := start; j < end; j++ {
 			if j < len(actions) {
-				actions[j].Execute(&actions[j])
+				spanCtx, span := tracing.Start(ctx, "action."+actions[j].Action,
+					"action.name", actions[j].Action, "action.index", j, "action.repeatRound", i+1)
+				actions[j].Execute(spanCtx, &actions[j])
+				span.End()
 			}
 		}
 	}
@@ -0,0 +1,130 @@
+package action
+
+import (
+	"context"
+	"image"
+	"testing"
+
+	"useless-agent/internal/screen"
+)
+
+func moveAction(x, y int) Action {
+	a := Action{Action: "mouseMove"}
+	a.Coordinates.X, a.Coordinates.Y = x, y
+	return a
+}
+
+func dragAction(x, y int) Action {
+	a := Action{Action: "dragSmooth"}
+	a.Coordinates.X, a.Coordinates.Y = x, y
+	return a
+}
+
+// TestActionsOnSyntheticDesktop runs agent actions through the executor
+// against a synthetic desktop and checks what they did to it
+func TestActionsOnSyntheticDesktop(t *testing.T) {
+	tests := []struct {
+		name    string
+		actions func(editor *screen.Window, save *screen.Button) []Action
+		check   func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button)
+	}{
+		{
+			name: "click a button",
+			actions: func(editor *screen.Window, save *screen.Button) []Action {
+				c := save.Center()
+				return []Action{moveAction(c.X, c.Y), {Action: "mouseClickLeft"}}
+			},
+			check: func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button) {
+				if save.Clicks() != 1 {
+					t.Errorf("button clicked %d times, want 1", save.Clicks())
+				}
+			},
+		},
+		{
+			name: "double click a button",
+			actions: func(editor *screen.Window, save *screen.Button) []Action {
+				c := save.Center()
+				return []Action{moveAction(c.X, c.Y), {Action: "mouseClickLeftDouble"}}
+			},
+			check: func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button) {
+				if save.Clicks() != 2 {
+					t.Errorf("button clicked %d times, want 2", save.Clicks())
+				}
+			},
+		},
+		{
+			name: "drag a window by its title bar",
+			actions: func(editor *screen.Window, save *screen.Button) []Action {
+				b := editor.Bounds()
+				return []Action{moveAction(b.Min.X+40, b.Min.Y+10), dragAction(b.Min.X+240, b.Min.Y+110)}
+			},
+			check: func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button) {
+				if got, want := editor.Bounds().Min, image.Pt(300, 200); got != want {
+					t.Errorf("window at %v, want %v", got, want)
+				}
+				if keys, buttons := HeldInputs(); len(keys) != 0 || len(buttons) != 0 {
+					t.Errorf("inputs still held: %v %v", keys, buttons)
+				}
+			},
+		},
+		{
+			name: "type text",
+			actions: func(editor *screen.Window, save *screen.Button) []Action {
+				return []Action{
+					{Action: "printString", InputString: "hello"},
+					{Action: "keyTap", KeyTapString: "enter"},
+					{Action: "keyDown", KeyString: "shift"},
+					{Action: "keyTap", KeyTapString: "w"},
+					{Action: "keyUp", KeyString: "shift"},
+				}
+			},
+			check: func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button) {
+				if got, want := editor.Text(), "hello\nW"; got != want {
+					t.Errorf("typed %q, want %q", got, want)
+				}
+				if keys, _ := HeldInputs(); len(keys) != 0 {
+					t.Errorf("keys still held: %v", keys)
+				}
+			},
+		},
+		{
+			name: "alt+f4 closes the focused window",
+			actions: func(editor *screen.Window, save *screen.Button) []Action {
+				return []Action{{Action: "hotkey", Keys: []string{"alt", "f4"}}}
+			},
+			check: func(t *testing.T, d *screen.Desktop, editor *screen.Window, save *screen.Button) {
+				if !editor.Closed() {
+					t.Error("window is still open")
+				}
+				if w := d.FindWindow("Terminal"); w == nil || d.Focused() != w {
+					t.Errorf("focus went to %v, want the terminal below", d.Focused())
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			d, err := screen.NewDesktop(1024, 768)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d.AddWindow("Terminal", 500, 400, 400, 300)
+			editor := d.AddWindow("Editor", 100, 100, 400, 300)
+			save := editor.AddButton("Save", 20, 20, nil)
+			screen.Use(d)
+			defer screen.Use(nil)
+			defer ReleaseAll()
+
+			actions := tt.actions(editor, save)
+			for i := range actions {
+				if err := Validate(&actions[i]); err != nil {
+					t.Fatalf("action %d: %v", i+1, err)
+				}
+				SetExecuteFunction(&actions[i])
+				actions[i].Execute(context.Background(), &actions[i], &actions)
+			}
+			tt.check(t, d, editor, save)
+		})
+	}
+}
@@ -0,0 +1,77 @@
+package action
+
+import (
+	"sort"
+	"sync"
+
+	"useless-agent/pkg/x11"
+)
+
+// Keys and mouse buttons currently held down by executed actions. Anything
+// left here when a batch or task ends is released by ReleaseAll, so a batch
+// cut short after a keyDown can not leave a modifier stuck.
+var (
+	heldKeys    = make(map[string]bool)
+	heldButtons = make(map[string]bool)
+	heldMutex   sync.Mutex
+)
+
+func markKeyHeld(name string, held bool) {
+	heldMutex.Lock()
+	defer heldMutex.Unlock()
+	if held {
+		heldKeys[name] = true
+	} else {
+		delete(heldKeys, name)
+	}
+}
+
+func markButtonHeld(button string, held bool) {
+	heldMutex.Lock()
+	defer heldMutex.Unlock()
+	if held {
+		heldButtons[button] = true
+	} else {
+		delete(heldButtons, button)
+	}
+}
+
+// HeldInputs returns the names of keys and buttons currently held down
+func HeldInputs() (keys []string, buttons []string) {
+	heldMutex.Lock()
+	defer heldMutex.Unlock()
+	for key := range heldKeys {
+		keys = append(keys, key)
+	}
+	for button := range heldButtons {
+		buttons = append(buttons, button)
+	}
+	sort.Strings(keys)
+	sort.Strings(buttons)
+	return keys, buttons
+}
+
+// ReleaseAll releases every key and mouse button still held by an action.
+// It is safe to call at any time, including from a deferred panic handler.
+func ReleaseAll() {
+	keys, buttons := HeldInputs()
+	if len(keys) == 0 && len(buttons) == 0 {
+		return
+	}
+
+	logger.Info("releasing held inputs", "keys", keys, "buttons", buttons)
+
+	// Release non-modifiers first so a dangling "ctrl" does not turn the
+	// release of another key into a shortcut
+	sort.SliceStable(keys, func(i, j int) bool {
+		return !x11.IsModifierKey(keys[i]) && x11.IsModifierKey(keys[j])
+	})
+	for _, key := range keys {
+		releaseKey(key)
+		markKeyHeld(key, false)
+	}
+	for _, button := range buttons {
+		releaseButton(button)
+		markButtonHeld(button, false)
+	}
+}
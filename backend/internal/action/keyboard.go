@@ -0,0 +1,193 @@
+package action
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-vgo/robotgo"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/screen"
+	"useless-agent/pkg/x11"
+)
+
+// Delay between typed characters, matches the previous robotgo typing speed
+const typingDelay = 100 * time.Millisecond
+
+// Shared XTest keyboard, opened lazily on first use
+var (
+	keyboard      *x11.Keyboard
+	keyboardMutex sync.Mutex
+)
+
+// keyInjector types and presses keys, the XTest keyboard or a screen backend
+type keyInjector interface {
+	KeyDown(name string) error
+	KeyUp(name string) error
+	TypeString(text string, delay time.Duration) error
+}
+
+// getKeyboard returns the installed screen backend, or the shared layout-aware
+// keyboard with an up to date mapping, or an error if XTest is unavailable
+// (callers then fall back to robotgo)
+func getKeyboard() (keyInjector, error) {
+	if b := screen.Current(); b != nil {
+		return b, nil
+	}
+
+	keyboardMutex.Lock()
+	defer keyboardMutex.Unlock()
+
+	if keyboard == nil {
+		kb, err := x11.NewKeyboard(*config.Display)
+		if err != nil {
+			return nil, err
+		}
+		keyboard = kb
+		return keyboard, nil
+	}
+
+	// Reloaded only when the server announced a new mapping, the keyboard
+	// reads the active layout itself on every key
+	if err := keyboard.RefreshIfChanged(); err != nil {
+		keyboard.Close()
+		keyboard = nil
+		return nil, err
+	}
+	return keyboard, nil
+}
+
+// typeString types text using the active keyboard layout
+func typeString(text string) {
+	kb, err := getKeyboard()
+	if err != nil {
+		logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+		robotgo.TypeStrDelay(text, int(typingDelay/time.Millisecond))
+		return
+	}
+
+	if err := kb.TypeString(text, typingDelay); err != nil {
+		logger.Error("failed to type string", "error", err)
+	}
+}
+
+// tapKeys presses the keys of a combo in order and releases them in reverse order
+func tapKeys(keys []string) {
+	kb, err := getKeyboard()
+	if err != nil {
+		logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+		robotgoTapKeys(keys)
+		return
+	}
+
+	// Keys are tracked as held while the combo is down, and released in
+	// reverse order even if pressing a later key fails or panics
+	pressed := make([]string, 0, len(keys))
+	defer func() {
+		for i := len(pressed) - 1; i >= 0; i-- {
+			if err := kb.KeyUp(pressed[i]); err != nil {
+				logger.Error("failed to release key", "key", pressed[i], "error", err)
+				continue
+			}
+			markKeyHeld(pressed[i], false)
+		}
+	}()
+
+	for _, key := range keys {
+		if err := kb.KeyDown(key); err != nil {
+			logger.Error("failed to press key", "key", key, "keys", keys, "error", err)
+			return
+		}
+		markKeyHeld(key, true)
+		pressed = append(pressed, key)
+	}
+}
+
+// pressKey presses a single named key
+func pressKey(name string) {
+	kb, err := getKeyboard()
+	if err != nil {
+		logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+		robotgo.KeyDown(name)
+		markKeyHeld(name, true)
+		return
+	}
+
+	if err := kb.KeyDown(name); err != nil {
+		logger.Error("failed to press key", "key", name, "error", err)
+		return
+	}
+	markKeyHeld(name, true)
+}
+
+// releaseKey releases a single named key
+func releaseKey(name string) {
+	kb, err := getKeyboard()
+	if err != nil {
+		logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+		robotgo.KeyUp(name)
+		markKeyHeld(name, false)
+		return
+	}
+
+	if err := kb.KeyUp(name); err != nil {
+		logger.Error("failed to release key", "key", name, "error", err)
+		return
+	}
+	markKeyHeld(name, false)
+}
+
+func robotgoTapKeys(keys []string) {
+	if len(keys) == 0 {
+		return
+	}
+	last := keys[len(keys)-1]
+	modifiers := make([]interface{}, 0, len(keys)-1)
+	for _, key := range keys[:len(keys)-1] {
+		modifiers = append(modifiers, key)
+	}
+	robotgo.KeyTap(last, modifiers...)
+}
+
+// Validate checks action parameters that can be verified before execution
+func Validate(a *Action) error {
+	switch a.Action {
+	case "keyTap":
+		if _, err := x11.ParseKeyCombo(a.KeyTapString); err != nil {
+			return fmt.Errorf("invalid keyTapString: %w", err)
+		}
+	case "hotkey":
+		if _, err := hotkeyKeys(a); err != nil {
+			return fmt.Errorf("invalid hotkey: %w", err)
+		}
+	case "keyDown", "keyUp":
+		if _, ok := x11.KeyNameToKeysym(a.KeyString); !ok {
+			return fmt.Errorf("unknown key name %q", a.KeyString)
+		}
+	default:
+		if validate, ok := validators[a.Action]; ok {
+			return validate(a)
+		}
+	}
+	return nil
+}
+
+// hotkeyKeys returns the validated keys of a hotkey action, taken from the
+// "keys" array or, for older prompts, from a "ctrl+shift+t" style keyString
+func hotkeyKeys(a *Action) ([]string, error) {
+	if len(a.Keys) == 0 {
+		return x11.ParseKeyCombo(a.KeyString)
+	}
+
+	keys := make([]string, 0, len(a.Keys))
+	for _, key := range a.Keys {
+		key = strings.TrimSpace(key)
+		if _, ok := x11.KeyNameToKeysym(key); !ok {
+			return nil, fmt.Errorf("unknown key name %q", key)
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
@@ -0,0 +1,109 @@
+package action
+
+import (
+	"github.com/go-vgo/robotgo"
+
+	"useless-agent/internal/screen"
+)
+
+// Pointer input goes to the installed screen backend if there is one, to the
+// X display through robotgo otherwise
+
+func moveSmooth(x, y int) {
+	if b := screen.Current(); b != nil {
+		b.MoveMouse(x, y)
+		return
+	}
+	robotgo.MoveSmooth(x, y)
+}
+
+func moveSmoothRelative(dx, dy int) {
+	if b := screen.Current(); b != nil {
+		x, y := b.CursorPosition()
+		b.MoveMouse(x+dx, y+dy)
+		return
+	}
+	robotgo.MoveSmoothRelative(dx, dy)
+}
+
+func click(button string, double bool) {
+	if b := screen.Current(); b != nil {
+		for i := 0; i < 1+boolToInt(double); i++ {
+			b.MouseDown(button)
+			b.MouseUp(button)
+		}
+		return
+	}
+	robotgo.Click(robotgoButton(button), double)
+}
+
+// moveTo jumps to a position, for operator input
+func moveTo(x, y int) {
+	if b := screen.Current(); b != nil {
+		b.MoveMouse(x, y)
+		return
+	}
+	robotgo.Move(x, y)
+}
+
+func cursorPosition() (int, int) {
+	if b := screen.Current(); b != nil {
+		return b.CursorPosition()
+	}
+	return robotgo.Location()
+}
+
+func dragSmooth(x, y int) {
+	if b := screen.Current(); b != nil {
+		b.MouseDown("left")
+		b.MoveMouse(x, y)
+		b.MouseUp("left")
+		return
+	}
+	robotgo.DragSmooth(x, y)
+}
+
+// scrollSmooth scrolls vertically, negative dy scrolls down as in the prompt
+func scrollSmooth(dy int) {
+	if b := screen.Current(); b != nil {
+		b.Scroll(-dy)
+		return
+	}
+	robotgo.ScrollSmooth(dy)
+}
+
+// scroll scrolls at once, positive dy up and dx right; a screen backend
+// only scrolls vertically
+func scroll(dx, dy int) {
+	if b := screen.Current(); b != nil {
+		if dy != 0 {
+			b.Scroll(-dy)
+		}
+		return
+	}
+	robotgo.Scroll(dx, dy)
+}
+
+func releaseButton(button string) {
+	if b := screen.Current(); b != nil {
+		b.MouseUp(button)
+		return
+	}
+	robotgo.Toggle(robotgoButton(button), "up")
+}
+
+// robotgoButton returns robotgo's name for a button: it calls the middle
+// button "center" and takes any name it does not know for the left one
+func robotgoButton(button string) string {
+	if button == "middle" {
+		return "center"
+	}
+	return button
+}
+
+func boolToInt(v bool) int {
+	if v {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,232 @@
+package action
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-vgo/robotgo"
+
+	"useless-agent/internal/screen"
+	"useless-agent/pkg/x11"
+)
+
+// Input is one event of an operator at the remote-input API. Unlike agent
+// actions it is applied at once: moves jump, text is typed fast, and keys
+// and buttons stay down until the matching up event or
+// ReleaseOperatorInputs.
+type Input struct {
+	// keyDown, keyUp, keyTap, text, move, moveRelative, mouseDown, mouseUp,
+	// click or scroll
+	Type string `json:"type"`
+
+	Key    string   `json:"key,omitempty"`    // keyDown, keyUp: a key name; keyTap: a combo like "ctrl+c"
+	Keys   []string `json:"keys,omitempty"`   // keyTap: the keys of a hotkey, instead of key
+	Text   string   `json:"text,omitempty"`   // text
+	Button string   `json:"button,omitempty"` // left (default), middle or right
+	Double bool     `json:"double,omitempty"` // click
+
+	// Absolute position for move, and to move to first for mouseDown,
+	// mouseUp, click and scroll
+	X *int `json:"x,omitempty"`
+	Y *int `json:"y,omitempty"`
+
+	// Offset for moveRelative; scroll amount for scroll, positive dy scrolls
+	// up and positive dx right as in the prompt
+	DX int `json:"dx,omitempty"`
+	DY int `json:"dy,omitempty"`
+}
+
+// operatorTypingDelay is the delay between typed characters of operator
+// text, shorter than the agent's: the operator watches the stream
+const operatorTypingDelay = 10 * time.Millisecond
+
+// Keys and buttons held down by the operator, separate from the agent's so
+// ReleaseAll at the end of a batch leaves them alone
+var (
+	operatorKeys    = make(map[string]bool)
+	operatorButtons = make(map[string]bool)
+	operatorMutex   sync.Mutex
+)
+
+// ValidateInput checks an operator input before anything is applied
+func ValidateInput(in Input) error {
+	switch in.Type {
+	case "keyDown", "keyUp":
+		if _, ok := x11.KeyNameToKeysym(in.Key); !ok {
+			return fmt.Errorf("unknown key name %q", in.Key)
+		}
+	case "keyTap":
+		if _, err := inputKeys(in); err != nil {
+			return err
+		}
+	case "text":
+		if in.Text == "" {
+			return fmt.Errorf("text is required")
+		}
+	case "move":
+		if in.X == nil || in.Y == nil {
+			return fmt.Errorf("x and y are required")
+		}
+	case "moveRelative":
+	case "mouseDown", "mouseUp", "click":
+		if _, err := inputButton(in.Button); err != nil {
+			return err
+		}
+	case "scroll":
+		if in.DX == 0 && in.DY == 0 {
+			return fmt.Errorf("dx or dy is required")
+		}
+	case "":
+		return fmt.Errorf("type is required")
+	default:
+		return fmt.Errorf("unknown input type %q", in.Type)
+	}
+	if (in.X == nil) != (in.Y == nil) {
+		return fmt.Errorf("x and y go together")
+	}
+	return nil
+}
+
+// inputKeys returns the keys of a keyTap, from keys or the key combo
+func inputKeys(in Input) ([]string, error) {
+	if len(in.Keys) == 0 {
+		keys, err := x11.ParseKeyCombo(in.Key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key combo: %w", err)
+		}
+		return keys, nil
+	}
+	return hotkeyKeys(&Action{Keys: in.Keys})
+}
+
+func inputButton(button string) (string, error) {
+	switch button {
+	case "":
+		return "left", nil
+	case "left", "middle", "right":
+		return button, nil
+	}
+	return "", fmt.Errorf("unknown mouse button %q", button)
+}
+
+// ApplyInput validates and applies an operator input
+func ApplyInput(in Input) error {
+	if err := ValidateInput(in); err != nil {
+		return err
+	}
+	if in.X != nil && in.Type != "move" {
+		moveTo(*in.X, *in.Y)
+	}
+
+	switch in.Type {
+	case "keyDown", "keyUp":
+		return operatorKey(in.Key, in.Type == "keyDown")
+	case "keyTap":
+		keys, _ := inputKeys(in)
+		tapKeys(keys)
+	case "text":
+		kb, err := getKeyboard()
+		if err != nil {
+			logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+			robotgo.TypeStrDelay(in.Text, int(operatorTypingDelay/time.Millisecond))
+			return nil
+		}
+		return kb.TypeString(in.Text, operatorTypingDelay)
+	case "move":
+		moveTo(*in.X, *in.Y)
+	case "moveRelative":
+		x, y := cursorPosition()
+		moveTo(x+in.DX, y+in.DY)
+	case "mouseDown", "mouseUp":
+		button, _ := inputButton(in.Button)
+		operatorButton(button, in.Type == "mouseDown")
+	case "click":
+		button, _ := inputButton(in.Button)
+		click(button, in.Double)
+	case "scroll":
+		scroll(in.DX, in.DY)
+	}
+	return nil
+}
+
+// ReleaseOperatorInputs releases the keys and buttons the operator left
+// down, for when the operator gives control back
+func ReleaseOperatorInputs() {
+	operatorMutex.Lock()
+	keys := make([]string, 0, len(operatorKeys))
+	for key := range operatorKeys {
+		keys = append(keys, key)
+	}
+	buttons := make([]string, 0, len(operatorButtons))
+	for button := range operatorButtons {
+		buttons = append(buttons, button)
+	}
+	operatorMutex.Unlock()
+	if len(keys) == 0 && len(buttons) == 0 {
+		return
+	}
+
+	logger.Info("releasing operator inputs", "keys", keys, "buttons", buttons)
+	sort.SliceStable(keys, func(i, j int) bool {
+		return !x11.IsModifierKey(keys[i]) && x11.IsModifierKey(keys[j])
+	})
+	for _, key := range keys {
+		operatorKey(key, false)
+	}
+	for _, button := range buttons {
+		operatorButton(button, false)
+	}
+}
+
+func operatorKey(name string, down bool) error {
+	kb, err := getKeyboard()
+	if err != nil {
+		logger.Warn("layout-aware keyboard unavailable, falling back to robotgo", "error", err)
+		if down {
+			robotgo.KeyDown(name)
+		} else {
+			robotgo.KeyUp(name)
+		}
+	} else {
+		toggle := kb.KeyUp
+		if down {
+			toggle = kb.KeyDown
+		}
+		if err := toggle(name); err != nil {
+			return fmt.Errorf("key %s: %w", name, err)
+		}
+	}
+
+	operatorMutex.Lock()
+	defer operatorMutex.Unlock()
+	if down {
+		operatorKeys[name] = true
+	} else {
+		delete(operatorKeys, name)
+	}
+	return nil
+}
+
+func operatorButton(button string, down bool) {
+	if b := screen.Current(); b != nil {
+		if down {
+			b.MouseDown(button)
+		} else {
+			b.MouseUp(button)
+		}
+	} else if down {
+		robotgo.Toggle(robotgoButton(button), "down")
+	} else {
+		robotgo.Toggle(robotgoButton(button), "up")
+	}
+
+	operatorMutex.Lock()
+	defer operatorMutex.Unlock()
+	if down {
+		operatorButtons[button] = true
+	} else {
+		delete(operatorButtons, button)
+	}
+}
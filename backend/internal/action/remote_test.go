@@ -0,0 +1,81 @@
+package action
+
+import (
+	"testing"
+
+	"useless-agent/internal/screen"
+)
+
+func TestInputButton(t *testing.T) {
+	tests := []struct {
+		button   string
+		want     string
+		robotgo  string
+		rejected bool
+	}{
+		{button: "", want: "left", robotgo: "left"},
+		{button: "left", want: "left", robotgo: "left"},
+		{button: "middle", want: "middle", robotgo: "center"},
+		{button: "right", want: "right", robotgo: "right"},
+		{button: "center", rejected: true},
+		{button: "wheel", rejected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.button, func(t *testing.T) {
+			got, err := inputButton(tt.button)
+			if tt.rejected {
+				if err == nil {
+					t.Fatalf("inputButton(%q) = %q, want an error", tt.button, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("inputButton(%q): %v", tt.button, err)
+			}
+			if got != tt.want {
+				t.Errorf("inputButton(%q) = %q, want %q", tt.button, got, tt.want)
+			}
+			if rg := robotgoButton(got); rg != tt.robotgo {
+				t.Errorf("robotgoButton(%q) = %q, want %q", got, rg, tt.robotgo)
+			}
+		})
+	}
+}
+
+func TestApplyInputMiddleButton(t *testing.T) {
+	d, err := screen.NewDesktop(800, 600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	screen.Use(d)
+	defer screen.Use(nil)
+
+	x, y := 100, 100
+	for _, in := range []Input{
+		{Type: "mouseDown", Button: "middle", X: &x, Y: &y},
+		{Type: "mouseUp", Button: "middle"},
+		{Type: "click", Button: "middle"},
+	} {
+		if err := ApplyInput(in); err != nil {
+			t.Fatalf("ApplyInput(%+v): %v", in, err)
+		}
+	}
+
+	var buttons []string
+	for _, e := range d.Events() {
+		if e.Type == "down" || e.Type == "up" {
+			buttons = append(buttons, e.Button)
+		}
+	}
+	if len(buttons) != 4 {
+		t.Fatalf("got button events %v, want 4", buttons)
+	}
+	for _, b := range buttons {
+		if b != "middle" {
+			t.Errorf("backend got button %q, want middle", b)
+		}
+	}
+	if len(operatorButtons) != 0 {
+		t.Errorf("buttons still held after mouseUp: %v", operatorButtons)
+	}
+}
@@ -1,5 +1,7 @@
 package action
 
+import "context"
+
 // Action represents an action to be executed
 type Action struct {
 	ActionSequenceID int    `json:"actionSequenceID"`
@@ -8,13 +10,15 @@ type Action struct {
 		X int `json:"x"`
 		Y int `json:"y"`
 	} `json:"coordinates,omitempty"`
-	Duration     int                           `json:"duration,omitempty"`
-	InputString  string                        `json:"inputString,omitempty"`
-	KeyTapString string                        `json:"keyTapString,omitempty"`
-	KeyString    string                        `json:"keyString,omitempty"`
-	ActionsRange []int                         `json:"actionsRange,omitempty"`
-	RepeatTimes  int                           `json:"repeatTimes,omitempty"`
-	Parameters   interface{}                   `json:"parameters,omitempty"`
-	Description  string                        `json:"description,omitempty"`
-	Execute      func(*Action, ...interface{}) `json:"-"`
+	Duration     int                                            `json:"duration,omitempty"`
+	InputString  string                                         `json:"inputString,omitempty"`
+	KeyTapString string                                         `json:"keyTapString,omitempty"`
+	KeyString    string                                         `json:"keyString,omitempty"`
+	Keys         []string                                       `json:"keys,omitempty"`
+	ActionsRange []int                                          `json:"actionsRange,omitempty"`
+	RepeatTimes  int                                            `json:"repeatTimes,omitempty"`
+	Name         string                                         `json:"name,omitempty"` // runSkill
+	Parameters   interface{}                                    `json:"parameters,omitempty"`
+	Description  string                                         `json:"description,omitempty"`
+	Execute      func(context.Context, *Action, ...interface{}) `json:"-"`
 }
@@ -0,0 +1,383 @@
+// Package auth checks API tokens and origins. Without a config file every
+// request is allowed, as before; with one, every handler but /ping needs a
+// token carrying the handler's scope.
+package auth
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/logging"
+)
+
+var logger = logging.For("auth")
+
+// Scope is a permission granted to a token
+type Scope string
+
+const (
+	// ScopeView allows screenshots, the video stream, logs, task state and metrics
+	ScopeView Scope = "view"
+	// ScopeSubmit allows submitting and canceling tasks and user-assist messages
+	ScopeSubmit Scope = "submit"
+	// ScopeInput allows direct mouse and keyboard control
+	ScopeInput Scope = "input"
+)
+
+var knownScopes = map[Scope]bool{ScopeView: true, ScopeSubmit: true, ScopeInput: true}
+
+// Config is the JSON auth config file
+type Config struct {
+	// Origins allowed for CORS and WebSocket upgrades, empty allows any.
+	// "null" matches pages opened from file://
+	Origins []string `json:"origins"`
+
+	// Tokens are static bearer tokens
+	Tokens []StaticToken `json:"tokens"`
+
+	// HMACKeys sign tokens minted with Sign, see cmd/token
+	HMACKeys []HMACKey `json:"hmacKeys"`
+}
+
+// StaticToken is a bearer token given either in plain text or as the hex
+// SHA-256 of the token, so the config file need not hold the secret
+type StaticToken struct {
+	Name   string  `json:"name"`
+	Token  string  `json:"token,omitempty"`
+	SHA256 string  `json:"sha256,omitempty"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// HMACKey signs tokens of the form <id>.<payload>.<signature>. A signed token
+// gets the scopes it claims that the key allows.
+type HMACKey struct {
+	ID     string  `json:"id"`
+	Secret string  `json:"secret"`
+	Scopes []Scope `json:"scopes"`
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	Name   string
+	Scopes []Scope
+}
+
+// Has reports whether the principal was granted scope
+func (p Principal) Has(scope Scope) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymous is the principal of every request while auth is disabled
+var anonymous = Principal{Name: "anonymous", Scopes: []Scope{ScopeView, ScopeSubmit, ScopeInput}}
+
+type state struct {
+	origins map[string]bool
+	tokens  []staticToken
+	keys    map[string]HMACKey
+}
+
+type staticToken struct {
+	name   string
+	digest [sha256.Size]byte
+	scopes []Scope
+}
+
+var (
+	current    *state
+	stateMutex sync.RWMutex
+)
+
+// LoadFile reads and validates an auth config file
+func LoadFile(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read auth config: %w", err)
+	}
+	var cfg Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("failed to parse auth config %s: %w", path, err)
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid auth config %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// Validate checks that every token has a secret and known scopes
+func (c *Config) Validate() error {
+	if len(c.Tokens) == 0 && len(c.HMACKeys) == 0 {
+		return errors.New("no tokens or hmacKeys configured")
+	}
+	for i, t := range c.Tokens {
+		if (t.Token == "") == (t.SHA256 == "") {
+			return fmt.Errorf("tokens[%d] (%s): exactly one of token and sha256 is required", i, t.Name)
+		}
+		if t.SHA256 != "" {
+			if digest, err := hex.DecodeString(t.SHA256); err != nil || len(digest) != sha256.Size {
+				return fmt.Errorf("tokens[%d] (%s): sha256 must be 64 hex digits", i, t.Name)
+			}
+		}
+		if err := validateScopes(t.Scopes); err != nil {
+			return fmt.Errorf("tokens[%d] (%s): %w", i, t.Name, err)
+		}
+	}
+	ids := make(map[string]bool)
+	for i, k := range c.HMACKeys {
+		if k.ID == "" || strings.Contains(k.ID, ".") {
+			return fmt.Errorf("hmacKeys[%d]: id is required and can not contain '.'", i)
+		}
+		if ids[k.ID] {
+			return fmt.Errorf("hmacKeys[%d]: duplicate id %q", i, k.ID)
+		}
+		ids[k.ID] = true
+		if len(k.Secret) < 16 {
+			return fmt.Errorf("hmacKeys[%d] (%s): secret must be at least 16 characters", i, k.ID)
+		}
+		if err := validateScopes(k.Scopes); err != nil {
+			return fmt.Errorf("hmacKeys[%d] (%s): %w", i, k.ID, err)
+		}
+	}
+	return nil
+}
+
+func validateScopes(scopes []Scope) error {
+	if len(scopes) == 0 {
+		return errors.New("at least one scope is required")
+	}
+	for _, s := range scopes {
+		if !knownScopes[s] {
+			return fmt.Errorf("unknown scope %q (known: view, submit, input)", s)
+		}
+	}
+	return nil
+}
+
+// Use enables checking against cfg, nil disables auth
+func Use(cfg *Config) {
+	var s *state
+	if cfg != nil {
+		s = &state{origins: make(map[string]bool), keys: make(map[string]HMACKey)}
+		for _, origin := range cfg.Origins {
+			s.origins[strings.TrimRight(origin, "/")] = true
+		}
+		for _, t := range cfg.Tokens {
+			token := staticToken{name: t.Name, scopes: t.Scopes}
+			if t.Token != "" {
+				token.digest = sha256.Sum256([]byte(t.Token))
+			} else {
+				digest, _ := hex.DecodeString(t.SHA256)
+				copy(token.digest[:], digest)
+			}
+			s.tokens = append(s.tokens, token)
+		}
+		for _, k := range cfg.HMACKeys {
+			s.keys[k.ID] = k
+		}
+	}
+
+	stateMutex.Lock()
+	current = s
+	stateMutex.Unlock()
+}
+
+// Enabled reports whether requests need a token
+func Enabled() bool {
+	return active() != nil
+}
+
+func active() *state {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return current
+}
+
+// Authenticate resolves a token to its principal
+func Authenticate(token string) (Principal, error) {
+	s := active()
+	if s == nil {
+		return anonymous, nil
+	}
+	if token == "" {
+		return Principal{}, errors.New("missing token")
+	}
+
+	// Signed tokens have exactly two dots, static tokens are matched by digest
+	if parts := strings.Split(token, "."); len(parts) == 3 {
+		if key, ok := s.keys[parts[0]]; ok {
+			return verifySigned(key, parts)
+		}
+	}
+	digest := sha256.Sum256([]byte(token))
+	for _, t := range s.tokens {
+		if subtle.ConstantTimeCompare(digest[:], t.digest[:]) == 1 {
+			return Principal{Name: t.name, Scopes: t.scopes}, nil
+		}
+	}
+	return Principal{}, errors.New("unknown token")
+}
+
+// claims is the payload of a signed token
+type claims struct {
+	Subject   string  `json:"sub"`
+	Scopes    []Scope `json:"scopes"`
+	ExpiresAt int64   `json:"exp,omitempty"`
+}
+
+// Sign mints a token signed with key, valid for ttl (zero for no expiry)
+func Sign(key HMACKey, subject string, scopes []Scope, ttl time.Duration) (string, error) {
+	c := claims{Subject: subject, Scopes: scopes}
+	if ttl > 0 {
+		c.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	signed := key.ID + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + signature(key.Secret, signed), nil
+}
+
+func signature(secret, signed string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(signed))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func verifySigned(key HMACKey, parts []string) (Principal, error) {
+	expected := signature(key.Secret, parts[0]+"."+parts[1])
+	if !hmac.Equal([]byte(expected), []byte(parts[2])) {
+		return Principal{}, errors.New("bad token signature")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return Principal{}, errors.New("malformed token payload")
+	}
+	var c claims
+	if err := json.Unmarshal(payload, &c); err != nil {
+		return Principal{}, errors.New("malformed token payload")
+	}
+	if c.ExpiresAt != 0 && time.Now().Unix() >= c.ExpiresAt {
+		return Principal{}, errors.New("token expired")
+	}
+
+	// A token can not claim more than its key allows
+	allowed := Principal{Scopes: key.Scopes}
+	p := Principal{Name: key.ID + ":" + c.Subject}
+	for _, scope := range c.Scopes {
+		if allowed.Has(scope) {
+			p.Scopes = append(p.Scopes, scope)
+		}
+	}
+	return p, nil
+}
+
+// TokenFromRequest returns the bearer token of the Authorization header, or
+// the access_token query parameter browsers use for WebSocket and <img> URLs
+func TokenFromRequest(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); header != "" {
+		if token, ok := strings.CutPrefix(header, "Bearer "); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return r.URL.Query().Get("access_token")
+}
+
+// OriginAllowed reports whether a browser page from origin may call the
+// API. Requests without an Origin header are not from a browser page.
+func OriginAllowed(origin string) bool {
+	s := active()
+	if origin == "" || s == nil || len(s.origins) == 0 {
+		return true
+	}
+	return s.origins[strings.TrimRight(origin, "/")]
+}
+
+// CheckOrigin is the WebSocket upgrader's origin check
+func CheckOrigin(r *http.Request) bool {
+	return OriginAllowed(r.Header.Get("Origin"))
+}
+
+type principalKey struct{}
+
+// FromContext returns the principal of the request, anonymous with every
+// scope while auth is disabled
+func FromContext(ctx context.Context) Principal {
+	if p, ok := ctx.Value(principalKey{}).(Principal); ok {
+		return p
+	}
+	if !Enabled() {
+		return anonymous
+	}
+	return Principal{}
+}
+
+// Failure is why a request was refused, with the HTTP status to answer
+type Failure struct {
+	Status int
+	Scope  Scope // set for a missing scope
+	Err    error
+}
+
+func (f *Failure) Error() string { return f.Err.Error() }
+
+// Authorize checks the origin, token and scope of a request and returns the
+// request carrying the principal
+func Authorize(r *http.Request, scope Scope) (*http.Request, *Failure) {
+	if !Enabled() {
+		return r, nil
+	}
+	if !OriginAllowed(r.Header.Get("Origin")) {
+		logger.Warn("origin not allowed", "origin", r.Header.Get("Origin"), "path", r.URL.Path, "remote", r.RemoteAddr)
+		return nil, &Failure{Status: http.StatusForbidden, Err: errors.New("origin not allowed")}
+	}
+	p, err := Authenticate(TokenFromRequest(r))
+	if err != nil {
+		logger.Warn("unauthenticated request", "path", r.URL.Path, "remote", r.RemoteAddr, "error", err)
+		return nil, &Failure{Status: http.StatusUnauthorized, Err: err}
+	}
+	if !p.Has(scope) {
+		logger.Warn("insufficient scope", "path", r.URL.Path, "principal", p.Name, "scope", scope)
+		return nil, &Failure{Status: http.StatusForbidden, Scope: scope, Err: fmt.Errorf("token lacks the %q scope", scope)}
+	}
+	return r.WithContext(context.WithValue(r.Context(), principalKey{}, p)), nil
+}
+
+// SetChallenge sets the WWW-Authenticate header a failure calls for
+func (f *Failure) SetChallenge(w http.ResponseWriter) {
+	switch {
+	case f.Status == http.StatusUnauthorized:
+		w.Header().Set("WWW-Authenticate", `Bearer realm="useless-agent"`)
+	case f.Scope != "":
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer realm="useless-agent", error="insufficient_scope", scope="%s"`, f.Scope))
+	}
+}
+
+// Require wraps a handler so it only runs for callers holding scope
+func Require(scope Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, failure := Authorize(r, scope)
+		if failure != nil {
+			failure.SetChallenge(w)
+			http.Error(w, http.StatusText(failure.Status)+": "+failure.Error(), failure.Status)
+			return
+		}
+		next(w, r)
+	}
+}
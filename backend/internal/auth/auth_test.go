@@ -0,0 +1,192 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+var testKey = HMACKey{ID: "ci", Secret: "0123456789abcdef", Scopes: []Scope{ScopeView, ScopeSubmit}}
+
+// useConfig enables auth with cfg for one test
+func useConfig(t *testing.T, cfg *Config) {
+	t.Helper()
+	if cfg != nil {
+		if err := cfg.Validate(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	Use(cfg)
+	t.Cleanup(func() { Use(nil) })
+}
+
+// signedToken signs claims as Sign does, for claims Sign can not make
+func signedToken(key HMACKey, c claims) string {
+	payload, _ := json.Marshal(c)
+	signed := key.ID + "." + base64.RawURLEncoding.EncodeToString(payload)
+	return signed + "." + signature(key.Secret, signed)
+}
+
+func TestConfigValidate(t *testing.T) {
+	digest := sha256.Sum256([]byte("secret"))
+	tests := []struct {
+		name    string
+		cfg     Config
+		wantErr string
+	}{
+		{"plain token", Config{Tokens: []StaticToken{{Name: "ui", Token: "secret", Scopes: []Scope{ScopeView}}}}, ""},
+		{"hashed token", Config{Tokens: []StaticToken{{Name: "ui", SHA256: hex.EncodeToString(digest[:]), Scopes: []Scope{ScopeView}}}}, ""},
+		{"key only", Config{HMACKeys: []HMACKey{testKey}}, ""},
+		{"empty", Config{Origins: []string{"http://localhost"}}, "no tokens or hmacKeys"},
+		{"token and sha256", Config{Tokens: []StaticToken{{Name: "ui", Token: "a", SHA256: hex.EncodeToString(digest[:]), Scopes: []Scope{ScopeView}}}}, "exactly one of token and sha256"},
+		{"no secret", Config{Tokens: []StaticToken{{Name: "ui", Scopes: []Scope{ScopeView}}}}, "exactly one of token and sha256"},
+		{"short sha256", Config{Tokens: []StaticToken{{Name: "ui", SHA256: "abcd", Scopes: []Scope{ScopeView}}}}, "64 hex digits"},
+		{"no scopes", Config{Tokens: []StaticToken{{Name: "ui", Token: "a"}}}, "at least one scope"},
+		{"unknown scope", Config{Tokens: []StaticToken{{Name: "ui", Token: "a", Scopes: []Scope{"admin"}}}}, `unknown scope "admin"`},
+		{"key id with a dot", Config{HMACKeys: []HMACKey{{ID: "a.b", Secret: testKey.Secret, Scopes: testKey.Scopes}}}, "can not contain '.'"},
+		{"duplicate key id", Config{HMACKeys: []HMACKey{testKey, testKey}}, `duplicate id "ci"`},
+		{"short secret", Config{HMACKeys: []HMACKey{{ID: "ci", Secret: "short", Scopes: testKey.Scopes}}}, "at least 16 characters"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.cfg.Validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	digest := sha256.Sum256([]byte("hashed-secret"))
+	useConfig(t, &Config{
+		Tokens: []StaticToken{
+			{Name: "ui", Token: "plain-secret", Scopes: []Scope{ScopeView}},
+			{Name: "ops", SHA256: hex.EncodeToString(digest[:]), Scopes: []Scope{ScopeView, ScopeInput}},
+			// Static tokens may look like signed ones
+			{Name: "dotted", Token: "a.b.c", Scopes: []Scope{ScopeSubmit}},
+		},
+		HMACKeys: []HMACKey{testKey},
+	})
+
+	signed, err := Sign(testKey, "runner", []Scope{ScopeSubmit}, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	greedy, _ := Sign(testKey, "runner", []Scope{ScopeSubmit, ScopeInput}, 0)
+	otherKey := HMACKey{ID: "ci", Secret: "fedcba9876543210", Scopes: testKey.Scopes}
+	forged, _ := Sign(otherKey, "runner", []Scope{ScopeSubmit}, 0)
+	expired := signedToken(testKey, claims{Subject: "runner", Scopes: []Scope{ScopeView}, ExpiresAt: time.Now().Add(-time.Minute).Unix()})
+	parts := strings.Split(signed, ".")
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"runner","scopes":["view","submit"]}`)) + "." + parts[2]
+
+	tests := []struct {
+		name    string
+		token   string
+		want    Principal
+		wantErr string
+	}{
+		{"plain token", "plain-secret", Principal{Name: "ui", Scopes: []Scope{ScopeView}}, ""},
+		{"hashed token", "hashed-secret", Principal{Name: "ops", Scopes: []Scope{ScopeView, ScopeInput}}, ""},
+		{"dotted static token", "a.b.c", Principal{Name: "dotted", Scopes: []Scope{ScopeSubmit}}, ""},
+		{"signed token", signed, Principal{Name: "ci:runner", Scopes: []Scope{ScopeSubmit}}, ""},
+		{"scopes the key lacks are dropped", greedy, Principal{Name: "ci:runner", Scopes: []Scope{ScopeSubmit}}, ""},
+		{"missing", "", Principal{}, "missing token"},
+		{"unknown", "guess", Principal{}, "unknown token"},
+		{"signed with another secret", forged, Principal{}, "bad token signature"},
+		{"tampered payload", tampered, Principal{}, "bad token signature"},
+		{"expired", expired, Principal{}, "token expired"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Authenticate(tt.token)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("principal %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAuthDisabled(t *testing.T) {
+	useConfig(t, nil)
+	p, err := Authenticate("")
+	if err != nil || !p.Has(ScopeView) || !p.Has(ScopeSubmit) || !p.Has(ScopeInput) {
+		t.Errorf("disabled auth gave %+v, %v", p, err)
+	}
+	if !OriginAllowed("http://evil.example") {
+		t.Error("origin refused while auth is disabled")
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	useConfig(t, &Config{
+		Origins: []string{"http://localhost:3000/", "null"},
+		Tokens:  []StaticToken{{Name: "ui", Token: "view-secret", Scopes: []Scope{ScopeView}}},
+	})
+	tests := []struct {
+		name      string
+		scope     Scope
+		header    string
+		query     string
+		origin    string
+		status    int
+		challenge string
+	}{
+		{name: "bearer token", scope: ScopeView, header: "Bearer view-secret", status: http.StatusOK},
+		{name: "query token", scope: ScopeView, query: "?access_token=view-secret", status: http.StatusOK},
+		{name: "allowed origin", scope: ScopeView, header: "Bearer view-secret", origin: "http://localhost:3000", status: http.StatusOK},
+		{name: "file origin", scope: ScopeView, header: "Bearer view-secret", origin: "null", status: http.StatusOK},
+		{name: "other origin", scope: ScopeView, header: "Bearer view-secret", origin: "http://evil.example", status: http.StatusForbidden},
+		{name: "no token", scope: ScopeView, status: http.StatusUnauthorized, challenge: `Bearer realm="useless-agent"`},
+		{name: "not a bearer header", scope: ScopeView, header: "Basic dmlldy1zZWNyZXQ=", status: http.StatusUnauthorized, challenge: `Bearer realm="useless-agent"`},
+		{name: "missing scope", scope: ScopeInput, header: "Bearer view-secret", status: http.StatusForbidden, challenge: `Bearer realm="useless-agent", error="insufficient_scope", scope="input"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var principal Principal
+			handler := Require(tt.scope, func(w http.ResponseWriter, r *http.Request) {
+				principal = FromContext(r.Context())
+			})
+			r := httptest.NewRequest(http.MethodGet, "/api/v2/tasks"+tt.query, nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			w := httptest.NewRecorder()
+			handler(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body.String())
+			}
+			if got := w.Header().Get("WWW-Authenticate"); got != tt.challenge {
+				t.Errorf("challenge %q, want %q", got, tt.challenge)
+			}
+			if tt.status == http.StatusOK && principal.Name != "ui" {
+				t.Errorf("handler saw principal %+v", principal)
+			}
+		})
+	}
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"embed"
 	"flag"
+	"time"
 )
 
 //go:embed assets/fonts/JetBrainsMono-Regular.ttf
@@ -20,10 +21,51 @@ var (
 	Wonb     = flag.Bool("whiteonblack", false, "white text on a black background")
 
 	// LLM Configuration
-	Provider = flag.String("provider", "deepseek", "LLM provider to use (deepseek, zai)")
+	Provider = flag.String("provider", "deepseek", "LLM provider to use (deepseek, zai, replay)")
 	APIKey   = flag.String("key", "", "LLM API key")
 	Model    = flag.String("model", "", "LLM model name")
-	BaseURL  = flag.String("base-url", "", "LLM base URL (optional, uses provider default if not specified)")
+	BaseURL  = flag.String("base-url", "", "LLM base URL (optional, uses provider default if not specified; trajectory bundle path for replay)")
+
+	// Logging
+	LogLevel          = flag.String("log-level", "info", "default log level (debug, info, warn, error)")
+	LogLevels         = flag.String("log-levels", "", "per-component log levels, e.g. llm=debug,ocr=warn")
+	LogFile           = flag.String("log-file", "", "JSON log file, rotated by size (empty disables)")
+	LogFileMaxSize    = flag.Int("log-file-max-size", 50, "size in MB at which the log file is rotated")
+	LogFileMaxBackups = flag.Int("log-file-max-backups", 5, "number of rotated log files to keep")
+
+	// Serving
+	TLSCert              = flag.String("tls-cert", "", "PEM certificate file, serves HTTPS together with -tls-key")
+	TLSKey               = flag.String("tls-key", "", "PEM private key file of -tls-cert")
+	TLSSelfSigned        = flag.Bool("tls-self-signed", false, "serve HTTPS with a generated self-signed certificate, written to -tls-cert/-tls-key if given and missing")
+	TLSClientCA          = flag.String("tls-client-ca", "", "PEM CA bundle, clients must present a certificate signed by it (mTLS)")
+	BasePath             = flag.String("base-path", "", "path prefix of every route, e.g. /agent when served behind a reverse proxy")
+	TrustedProxies       = flag.String("trusted-proxies", "", "comma separated IPs or CIDRs whose X-Forwarded-For/-Proto/-Host headers are honored")
+	ShutdownTimeout      = flag.Duration("shutdown-timeout", 15*time.Second, "how long to wait for requests and the running task on SIGINT/SIGTERM")
+	WSScreenshotInterval = flag.Duration("ws-screenshot-interval", time.Second, "how often WebSocket clients subscribed to screenshots get one")
+	StreamFPS            = flag.Int("stream-fps", 5, "default frames per second of /stream.mjpeg and /stream (a viewer may ask for up to 30)")
+	StreamQuality        = flag.Int("stream-quality", 70, "default JPEG quality (1-100) of /stream.mjpeg and /stream")
+	OperatorIdleTimeout  = flag.Duration("operator-idle-timeout", time.Minute, "an operator using remote input loses control after this long without input, and the task resumes")
+
+	// API authentication
+	AuthConfig = flag.String("auth-config", "", "JSON file with API tokens, scopes and allowed origins (empty allows everyone)")
+
+	// Tracing
+	TraceOTLPEndpoint = flag.String("trace-otlp-endpoint", "", "OTLP/HTTP collector to export spans to, e.g. http://127.0.0.1:4318 (empty disables)")
+	TraceFile         = flag.String("trace-file", "", "file to append spans to as OTLP JSON lines (empty disables)")
+	TraceServiceName  = flag.String("trace-service-name", "useless-agent", "service.name resource attribute of exported spans")
+
+	// Trajectory recording
+	TrajectoryDir  = flag.String("trajectory-dir", "", "directory for per-task trajectory bundles, e.g. trajectories (empty disables recording)")
+	TrajectoryKeep = flag.Int("trajectory-keep", 50, "how many bundles to keep in --trajectory-dir, the oldest are deleted when a task starts (0 keeps all)")
+
+	// Skills
+	SkillsDir     = flag.String("skills-dir", "skills", "directory of skills recorded from operator input (empty disables skills)")
+	MacroDir      = flag.String("macro-dir", "", "directory of action sequences that completed subtasks, offered to the model for similar ones, e.g. macros; typed text is kept as is (empty disables)")
+	MacroExamples = flag.Int("macro-examples", 2, "how many similar past subtasks to show the model as examples (0 disables)")
+	TemplatesDir  = flag.String("templates-dir", "templates", "directory of task templates, <name>.json each (empty disables templates)")
+
+	// Scheduling
+	SchedulesFile = flag.String("schedules-file", "schedules.json", "file the task schedules are kept in across restarts (empty keeps them in memory only)")
 )
 
 // LLMConfig holds the LLM configuration
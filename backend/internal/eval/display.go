@@ -0,0 +1,98 @@
+package eval
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// How long to wait for Xvfb to accept connections
+const displayStartTimeout = 10 * time.Second
+
+// Display is a private Xvfb server with a window manager
+type Display struct {
+	Name string
+	xvfb *exec.Cmd
+	wm   *exec.Cmd
+}
+
+// StartDisplay launches Xvfb on the given display (e.g. ":99") and, if wm is
+// not empty, a window manager on it. The caller must Stop the display.
+func StartDisplay(name, screen, wm string) (*Display, error) {
+	if !strings.HasPrefix(name, ":") {
+		return nil, fmt.Errorf("display must look like :99, got %q", name)
+	}
+	socket := "/tmp/.X11-unix/X" + strings.TrimPrefix(name, ":")
+	if _, err := os.Stat(socket); err == nil {
+		return nil, fmt.Errorf("display %s is already in use", name)
+	}
+
+	d := &Display{Name: name}
+	d.xvfb = exec.Command("Xvfb", name, "-screen", "0", screen, "-nolisten", "tcp")
+	d.xvfb.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := d.xvfb.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start Xvfb: %w", err)
+	}
+
+	deadline := time.Now().Add(displayStartTimeout)
+	for {
+		if _, err := os.Stat(socket); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			d.Stop()
+			return nil, fmt.Errorf("Xvfb did not start on %s within %s", name, displayStartTimeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	logger.Info("Xvfb running", "display", name, "screen", screen)
+
+	if wm != "" {
+		d.wm = exec.Command(wm)
+		d.wm.Env = d.Env()
+		d.wm.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+		if err := d.wm.Start(); err != nil {
+			// Scenarios still run, windows just have no decorations
+			logger.Warn("failed to start window manager, continuing without", "wm", wm, "error", err)
+			d.wm = nil
+		} else {
+			time.Sleep(500 * time.Millisecond)
+		}
+	}
+	return d, nil
+}
+
+// Env returns the process environment pointing to this display
+func (d *Display) Env() []string {
+	return append(os.Environ(), "DISPLAY="+d.Name)
+}
+
+// Run executes a shell script on the display in its own process group and
+// returns the group so background programs it started can be killed later
+func (d *Display) Run(script string) (*exec.Cmd, error) {
+	cmd := exec.Command("sh", "-c", script)
+	cmd.Env = d.Env()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return cmd, nil
+}
+
+// Stop terminates the window manager and Xvfb
+func (d *Display) Stop() {
+	killGroup(d.wm)
+	killGroup(d.xvfb)
+}
+
+// killGroup kills a command and everything it started
+func killGroup(cmd *exec.Cmd) {
+	if cmd == nil || cmd.Process == nil {
+		return
+	}
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	cmd.Wait()
+}
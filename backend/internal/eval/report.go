@@ -0,0 +1,139 @@
+package eval
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"time"
+
+	"useless-agent/internal/task"
+)
+
+// Report summarizes an eval run
+type Report struct {
+	StartedAt   time.Time `json:"startedAt"`
+	DurationMs  int64     `json:"durationMs"`
+	Total       int       `json:"total"`
+	Passed      int       `json:"passed"`
+	SuccessRate float64   `json:"successRate"`
+	Iterations  int       `json:"iterations"`
+	Tokens      int       `json:"tokens"`
+	Scenarios   []Result  `json:"scenarios"`
+}
+
+// NewReport aggregates scenario results
+func NewReport(startedAt time.Time, results []Result) *Report {
+	r := &Report{
+		StartedAt:  startedAt,
+		DurationMs: time.Since(startedAt).Milliseconds(),
+		Total:      len(results),
+		Scenarios:  results,
+	}
+	for _, res := range results {
+		if res.Success {
+			r.Passed++
+		}
+		r.Iterations += res.Iterations
+		r.Tokens += res.Tokens
+	}
+	if r.Total > 0 {
+		r.SuccessRate = float64(r.Passed) / float64(r.Total)
+	}
+	return r
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(path string) error {
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o644)
+}
+
+type junitTestSuites struct {
+	XMLName xml.Name     `xml:"testsuites"`
+	Suites  []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string          `xml:"name,attr"`
+	Tests     int             `xml:"tests,attr"`
+	Failures  int             `xml:"failures,attr"`
+	Errors    int             `xml:"errors,attr"`
+	Time      string          `xml:"time,attr"`
+	Timestamp string          `xml:"timestamp,attr"`
+	Cases     []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	Error     *junitFailure `xml:"error,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+	Text    string `xml:",chardata"`
+}
+
+// WriteJUnit writes the report as JUnit XML for CI systems
+func (r *Report) WriteJUnit(path string) error {
+	suite := junitSuite{
+		Name:      "useless-agent-eval",
+		Tests:     r.Total,
+		Time:      seconds(r.DurationMs),
+		Timestamp: r.StartedAt.Format("2006-01-02T15:04:05"),
+	}
+	for _, res := range r.Scenarios {
+		tc := junitTestCase{
+			Name:      res.Name,
+			Classname: "eval",
+			Time:      seconds(res.DurationMs),
+			SystemOut: fmt.Sprintf("task=%s status=%s iterations=%d tokens=%d trajectory=%s", res.TaskID, res.TaskStatus, res.Iterations, res.Tokens, res.Trajectory),
+		}
+		switch {
+		case res.Success:
+		case res.TaskID == "":
+			// The scenario never reached the agent (setup or replay bundle failed)
+			suite.Errors++
+			tc.Error = &junitFailure{Message: res.Error, Text: res.Error}
+		default:
+			suite.Failures++
+			tc.Failure = &junitFailure{Message: failureMessage(res), Text: checkDetails(res)}
+		}
+		suite.Cases = append(suite.Cases, tc)
+	}
+
+	data, err := xml.MarshalIndent(junitTestSuites{Suites: []junitSuite{suite}}, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, append([]byte(xml.Header), data...), 0o644)
+}
+
+func failureMessage(res Result) string {
+	switch {
+	case res.TimedOut:
+		return "timed out"
+	case res.Error != "":
+		return res.Error
+	}
+	return "success check failed (task status " + res.TaskStatus + ")"
+}
+
+func checkDetails(res Result) string {
+	text := task.SummarizeCheckResults(res.Checks)
+	if res.Error != "" {
+		text += "\n" + res.Error
+	}
+	return text
+}
+
+func seconds(ms int64) string {
+	return fmt.Sprintf("%.3f", float64(ms)/1000)
+}
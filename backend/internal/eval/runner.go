@@ -0,0 +1,183 @@
+package eval
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/ocr"
+	"useless-agent/internal/screenshot"
+	"useless-agent/internal/task"
+	"useless-agent/internal/token"
+	"useless-agent/internal/trajectory"
+	"useless-agent/pkg/x11"
+)
+
+var logger = logging.For("eval")
+
+// Time given to the desktop to settle after setup and before the check
+const settleDelay = 1 * time.Second
+
+// Result is the outcome of one scenario
+type Result struct {
+	Name       string             `json:"name"`
+	Success    bool               `json:"success"`
+	TaskID     string             `json:"taskId,omitempty"`
+	TaskStatus string             `json:"taskStatus,omitempty"`
+	TimedOut   bool               `json:"timedOut,omitempty"`
+	Iterations int                `json:"iterations"`
+	Tokens     int                `json:"tokens"`
+	DurationMs int64              `json:"durationMs"`
+	Checks     []task.CheckResult `json:"checks,omitempty"`
+	CommandOK  *bool              `json:"commandOk,omitempty"`
+	Error      string             `json:"error,omitempty"`
+	Trajectory string             `json:"trajectory,omitempty"`
+}
+
+// Run executes every scenario on the display, one after another
+func Run(display *Display, scenarios []Scenario) []Result {
+	results := make([]Result, 0, len(scenarios))
+	for i := range scenarios {
+		logger.Info("running scenario", "scenario", scenarios[i].Name, "index", i+1, "total", len(scenarios))
+		result := runScenario(display, &scenarios[i])
+		logger.Info("scenario finished", "scenario", result.Name, "success", result.Success, "status", result.TaskStatus,
+			"iterations", result.Iterations, "tokens", result.Tokens, "durationMs", result.DurationMs, "error", result.Error)
+		results = append(results, result)
+	}
+	return results
+}
+
+func runScenario(display *Display, s *Scenario) (result Result) {
+	result = Result{Name: s.Name}
+	started := time.Now()
+	defer func() {
+		result.DurationMs = time.Since(started).Milliseconds()
+	}()
+
+	// Programs started by setup belong to the scenario and die with it
+	var setup *exec.Cmd
+	defer func() {
+		killGroup(setup)
+		if s.Teardown != "" {
+			if cmd, err := display.Run(s.Teardown); err == nil {
+				cmd.Wait()
+				killGroup(cmd)
+			}
+		}
+	}()
+
+	if s.Setup != "" {
+		var err error
+		if setup, err = display.Run(s.Setup); err != nil {
+			result.Error = fmt.Sprintf("setup failed to start: %v", err)
+			return result
+		}
+		if err := setup.Wait(); err != nil {
+			result.Error = fmt.Sprintf("setup failed: %v", err)
+			return result
+		}
+		time.Sleep(settleDelay)
+	}
+
+	t := task.CreateTask(s.Goal, task.TaskOptions{})
+	result.TaskID = t.ID
+	if s.Replay != "" {
+		exchanges, err := llm.LoadRecordedExchanges(s.Replay)
+		if err != nil {
+			result.Error = fmt.Sprintf("failed to load replay bundle: %v", err)
+			return result
+		}
+		t.Context = llm.WithClient(t.Context, llm.NewReplayClient(exchanges))
+	}
+
+	tokensBefore := token.GetTotalTokens()
+	task.UpdateTaskStatus(t.ID, "in-progress", t.Message)
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		task.ExecuteTask(t)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(s.timeout()):
+		logger.Warn("scenario timed out, canceling task", "scenario", s.Name, "timeout", s.timeout(), "taskId", t.ID)
+		result.TimedOut = true
+		task.CancelTask(t.ID)
+		<-done
+	}
+	result.Tokens = token.GetTotalTokens() - tokensBefore
+
+	if final, ok := task.GetTask(t.ID); ok {
+		result.TaskStatus = final.Status
+	}
+	result.Iterations, result.Trajectory = trajectoryStats(t.ID)
+
+	time.Sleep(settleDelay)
+	passed, err := runCheck(display, s.Check, &result)
+	if err != nil {
+		result.Error = err.Error()
+	}
+	result.Success = passed && !result.TimedOut
+	return result
+}
+
+// trajectoryStats reads the iteration count from the recorded bundle
+func trajectoryStats(taskID string) (int, string) {
+	dir, err := trajectory.BundleDir(taskID)
+	if err != nil || !trajectory.Exists(taskID) {
+		return 0, ""
+	}
+	bundle, err := trajectory.OpenBundle(dir)
+	if err != nil {
+		return 0, ""
+	}
+	defer bundle.Close()
+	return len(bundle.Manifest.Iterations), dir
+}
+
+// runCheck evaluates the deterministic success criterion against the final desktop
+func runCheck(display *Display, check Check, result *Result) (bool, error) {
+	passed := true
+
+	if len(check.Postconditions) > 0 {
+		checkCtx := task.CheckContext{X11WindowsJSON: "[]"}
+		img, err := screenshot.CaptureX11Screenshot()
+		if err != nil {
+			return false, fmt.Errorf("failed to capture screenshot for check: %w", err)
+		}
+		checkCtx.OCR = ocr.OCR(screenshot.ConvertToGrayscale(img))
+		if windows, err := x11.GetX11WindowsWithDisplay(*config.Display); err == nil {
+			checkCtx.X11WindowsJSON = windows
+		}
+
+		result.Checks = task.EvaluatePostconditions(check.Postconditions, checkCtx)
+		passed = task.AllPassed(result.Checks)
+	}
+
+	if check.Command != "" {
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		cmd := exec.CommandContext(ctx, "sh", "-c", check.Command)
+		cmd.Env = display.Env()
+		out, err := cmd.CombinedOutput()
+		ok := err == nil
+		result.CommandOK = &ok
+		if !ok {
+			passed = false
+			return passed, fmt.Errorf("check command failed: %v: %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return passed, nil
+}
+
+// TrajectoryRoot returns the directory bundles of an eval run are written to
+func TrajectoryRoot(outDir string) string {
+	return filepath.Join(outDir, "trajectories")
+}
@@ -0,0 +1,145 @@
+package eval
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"useless-agent/internal/task"
+)
+
+// Default time limit of a scenario without an explicit timeout
+const defaultScenarioTimeout = 5 * time.Minute
+
+// Scenario is one declarative evaluation case
+type Scenario struct {
+	Name     string   `json:"name"`
+	Setup    string   `json:"setup,omitempty"`    // shell script run on the private display before the goal
+	Teardown string   `json:"teardown,omitempty"` // shell script run after the check
+	Goal     string   `json:"goal"`
+	Check    Check    `json:"check"`
+	Timeout  Duration `json:"timeout,omitempty"`
+	// Replay is a trajectory bundle whose recorded LLM responses answer the
+	// agent, so the scenario runs without network access
+	Replay string `json:"replay,omitempty"`
+}
+
+// Check is the deterministic success criterion of a scenario. Every given
+// postcondition must pass and the command, if any, must exit with status 0.
+type Check struct {
+	Postconditions []task.Postcondition `json:"postconditions,omitempty"`
+	Command        string               `json:"command,omitempty"`
+}
+
+// Duration is a time.Duration read from strings like "90s" or "3m"
+type Duration time.Duration
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string like \"90s\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// timeout returns the scenario time limit
+func (s *Scenario) timeout() time.Duration {
+	if s.Timeout <= 0 {
+		return defaultScenarioTimeout
+	}
+	return time.Duration(s.Timeout)
+}
+
+// LoadScenarios reads scenarios from a JSON file (one scenario or an array)
+// or from every *.json file of a directory
+func LoadScenarios(path string) ([]Scenario, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		if files, err = filepath.Glob(filepath.Join(path, "*.json")); err != nil {
+			return nil, err
+		}
+		sort.Strings(files)
+	}
+
+	var scenarios []Scenario
+	for _, file := range files {
+		loaded, err := loadScenarioFile(file)
+		if err != nil {
+			return nil, err
+		}
+		scenarios = append(scenarios, loaded...)
+	}
+	if len(scenarios) == 0 {
+		return nil, fmt.Errorf("no scenarios found in %s", path)
+	}
+
+	names := make(map[string]bool)
+	for i := range scenarios {
+		if err := scenarios[i].validate(); err != nil {
+			return nil, fmt.Errorf("scenario %d (%s): %w", i+1, scenarios[i].Name, err)
+		}
+		if names[scenarios[i].Name] {
+			return nil, fmt.Errorf("duplicate scenario name %q", scenarios[i].Name)
+		}
+		names[scenarios[i].Name] = true
+	}
+	return scenarios, nil
+}
+
+func loadScenarioFile(file string) ([]Scenario, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var scenarios []Scenario
+	if strings.HasPrefix(strings.TrimSpace(string(data)), "[") {
+		err = json.Unmarshal(data, &scenarios)
+	} else {
+		var single Scenario
+		err = json.Unmarshal(data, &single)
+		scenarios = []Scenario{single}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", file, err)
+	}
+
+	// Replay bundles are relative to the scenario file
+	for i := range scenarios {
+		if scenarios[i].Replay != "" && !filepath.IsAbs(scenarios[i].Replay) {
+			scenarios[i].Replay = filepath.Join(filepath.Dir(file), scenarios[i].Replay)
+		}
+	}
+	return scenarios, nil
+}
+
+func (s *Scenario) validate() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.Goal == "" {
+		return fmt.Errorf("goal is required")
+	}
+	if len(s.Check.Postconditions) == 0 && s.Check.Command == "" {
+		return fmt.Errorf("check needs postconditions or a command")
+	}
+	return task.ValidatePostconditions(s.Check.Postconditions)
+}
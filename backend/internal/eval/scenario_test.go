@@ -0,0 +1,51 @@
+package eval
+
+import (
+	"encoding/json"
+	"testing"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/llm"
+)
+
+func TestSampleScenarios(t *testing.T) {
+	scenarios, err := LoadScenarios("../../eval/scenarios")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replays := 0
+	for _, s := range scenarios {
+		if s.Replay == "" {
+			continue
+		}
+		replays++
+
+		exchanges, err := llm.LoadRecordedExchanges(s.Replay)
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name, err)
+		}
+		kinds := make(map[string]int)
+		for _, ex := range exchanges {
+			kinds[ex.Kind]++
+			if ex.Kind != llm.KindActions {
+				continue
+			}
+			var actions []action.Action
+			if err := json.Unmarshal([]byte(ex.Response), &actions); err != nil {
+				t.Errorf("%s: recorded actions do not parse: %v", s.Name, err)
+			}
+			for _, a := range actions {
+				if err := action.Validate(&a); err != nil {
+					t.Errorf("%s: recorded action %q: %v", s.Name, a.Action, err)
+				}
+			}
+		}
+		if kinds[llm.KindBreakdown] == 0 || kinds[llm.KindActions] == 0 {
+			t.Errorf("%s: bundle has no breakdown or actions response: %v", s.Name, kinds)
+		}
+	}
+	if replays == 0 {
+		t.Error("no sample scenario replays a recorded bundle")
+	}
+}
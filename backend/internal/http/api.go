@@ -0,0 +1,423 @@
+package http
+
+import (
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/task"
+	"useless-agent/internal/template"
+	"useless-agent/internal/websocket"
+)
+
+// REST API v2: JSON in and out, errors as {"error": {...}}, documented by
+// the embedded OpenAPI document. Routes are registered by RegisterAPIv2.
+
+//go:embed openapi.json
+var openAPIDocument []byte
+
+// APIError is the body of every v2 error response
+type APIError struct {
+	Status  int    `json:"status"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// CreateTaskRequest is the body of POST /api/v2/tasks
+type CreateTaskRequest struct {
+	Goal           string                 `json:"goal"`
+	Postconditions []task.Postcondition   `json:"postconditions,omitempty"`
+	Verification   string                 `json:"verification,omitempty"`
+	Script         *task.Script           `json:"script,omitempty"`
+	Template       string                 `json:"template,omitempty"`
+	Params         map[string]interface{} `json:"params,omitempty"` // of the template or script
+	Model          string                 `json:"model,omitempty"`
+	Budget         task.Budget            `json:"budget,omitempty"`
+	Hints          []string               `json:"hints,omitempty"`
+	Priority       int                    `json:"priority,omitempty"` // higher runs first
+	RunAfter       []string               `json:"runAfter,omitempty"` // tasks that must complete first
+	Held           bool                   `json:"held,omitempty"`     // wait in the queue until released
+
+	templateName string            // the template the request was rendered from
+	scriptParams map[string]string // the params of a script as text
+	scheduleID   string            // the schedule the task is a run of
+}
+
+var taskStatuses = map[string]bool{"in-the-queue": true, "in-progress": true, "completed": true, "canceled": true, "broken": true}
+
+// RegisterAPIv2 adds the v2 routes to mux, each checked for its scope
+func RegisterAPIv2(mux *http.ServeMux) {
+	mux.HandleFunc("GET /api/v2/openapi.json", OpenAPIHandler)
+	mux.HandleFunc("POST /api/v2/tasks", apiRequire(auth.ScopeSubmit, CreateTaskHandler))
+	mux.HandleFunc("GET /api/v2/tasks", apiRequire(auth.ScopeView, ListTasksHandler))
+	mux.HandleFunc("GET /api/v2/tasks/{id}", apiRequire(auth.ScopeView, GetTaskHandler))
+	mux.HandleFunc("DELETE /api/v2/tasks/{id}", apiRequire(auth.ScopeSubmit, CancelTaskHandler))
+	mux.HandleFunc("GET /api/v2/tasks/{id}/events", apiRequire(auth.ScopeView, TaskEventsHandler))
+	mux.HandleFunc("GET /api/v2/queue", apiRequire(auth.ScopeView, QueueHandler))
+	mux.HandleFunc("PATCH /api/v2/queue/{id}", apiRequire(auth.ScopeSubmit, UpdateQueueHandler))
+	mux.HandleFunc("GET /api/v2/input", apiRequire(auth.ScopeView, InputControlHandler))
+	mux.HandleFunc("POST /api/v2/input", apiRequire(auth.ScopeInput, InputHandler))
+	mux.HandleFunc("POST /api/v2/input/control", apiRequire(auth.ScopeInput, TakeControlHandler))
+	mux.HandleFunc("DELETE /api/v2/input/control", apiRequire(auth.ScopeInput, ReleaseControlHandler))
+	mux.HandleFunc("GET /api/v2/skills", apiRequire(auth.ScopeView, ListSkillsHandler))
+	mux.HandleFunc("GET /api/v2/skills/recording", apiRequire(auth.ScopeInput, RecordingHandler))
+	mux.HandleFunc("POST /api/v2/skills/recording", apiRequire(auth.ScopeInput, StartRecordingHandler))
+	mux.HandleFunc("POST /api/v2/skills/recording/stop", apiRequire(auth.ScopeInput, StopRecordingHandler))
+	mux.HandleFunc("DELETE /api/v2/skills/recording", apiRequire(auth.ScopeInput, CancelRecordingHandler))
+	mux.HandleFunc("GET /api/v2/skills/{name}", apiRequire(auth.ScopeView, GetSkillHandler))
+	mux.HandleFunc("GET /api/v2/skills/{name}/{which}", apiRequire(auth.ScopeView, SkillScreenshotHandler))
+	mux.HandleFunc("DELETE /api/v2/skills/{name}", apiRequire(auth.ScopeInput, DeleteSkillHandler))
+	mux.HandleFunc("GET /api/v2/templates", apiRequire(auth.ScopeView, ListTemplatesHandler))
+	mux.HandleFunc("GET /api/v2/templates/{name}", apiRequire(auth.ScopeView, GetTemplateHandler))
+	mux.HandleFunc("GET /api/v2/macros", apiRequire(auth.ScopeView, ListMacrosHandler))
+	mux.HandleFunc("GET /api/v2/macros/{id}", apiRequire(auth.ScopeView, GetMacroHandler))
+	mux.HandleFunc("DELETE /api/v2/macros/{id}", apiRequire(auth.ScopeSubmit, DeleteMacroHandler))
+	mux.HandleFunc("GET /api/v2/schedules", apiRequire(auth.ScopeView, ListSchedulesHandler))
+	mux.HandleFunc("POST /api/v2/schedules", apiRequire(auth.ScopeSubmit, CreateScheduleHandler))
+	mux.HandleFunc("GET /api/v2/schedules/{id}", apiRequire(auth.ScopeView, GetScheduleHandler))
+	mux.HandleFunc("PUT /api/v2/schedules/{id}", apiRequire(auth.ScopeSubmit, UpdateScheduleHandler))
+	mux.HandleFunc("DELETE /api/v2/schedules/{id}", apiRequire(auth.ScopeSubmit, DeleteScheduleHandler))
+	mux.HandleFunc("POST /api/v2/schedules/{id}/enable", apiRequire(auth.ScopeSubmit, EnableScheduleHandler))
+	mux.HandleFunc("POST /api/v2/schedules/{id}/disable", apiRequire(auth.ScopeSubmit, DisableScheduleHandler))
+	mux.HandleFunc("/api/v2/", func(w http.ResponseWriter, r *http.Request) {
+		writeAPIError(w, http.StatusNotFound, "not_found", fmt.Sprintf("no route for %s %s", r.Method, r.URL.Path))
+	})
+}
+
+// apiRequire is auth.Require with v2 error bodies
+func apiRequire(scope auth.Scope, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r, failure := auth.Authorize(r, scope)
+		if failure != nil {
+			failure.SetChallenge(w)
+			code := "forbidden"
+			if failure.Status == http.StatusUnauthorized {
+				code = "unauthorized"
+			}
+			writeAPIError(w, failure.Status, code, failure.Error())
+			return
+		}
+		next(w, r)
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logger.Error("failed to encode API response", "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "internal", "failed to encode response")
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+func writeAPIError(w http.ResponseWriter, status int, code, message string) {
+	body, _ := json.Marshal(map[string]APIError{"error": {Status: status, Code: code, Message: message}})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// OpenAPIHandler serves the OpenAPI document of the v2 API
+func OpenAPIHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPIDocument)
+}
+
+// CreateTaskHandler queues a task
+func CreateTaskHandler(w http.ResponseWriter, r *http.Request) {
+	var req CreateTaskRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if err := validateTaskRequest(&req); err != nil {
+		status, code := taskRequestErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+
+	newTask := queueTask(req)
+	logger.Info("created task via API", "taskId", newTask.ID, "principal", auth.FromContext(r.Context()).Name)
+
+	detail, _ := task.GetTaskDetail(newTask.ID)
+	w.Header().Set("Location", "tasks/"+newTask.ID)
+	writeJSON(w, http.StatusCreated, detail.TaskSummary)
+}
+
+// ListTasksHandler lists tasks, filtered by ?status= and ?since=<RFC 3339>
+func ListTasksHandler(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	if status != "" && !taskStatuses[status] {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "status must be one of in-the-queue, in-progress, completed, canceled, broken")
+		return
+	}
+	var since time.Time
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		if since, err = time.Parse(time.RFC3339Nano, s); err != nil {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "since must be an RFC 3339 time, e.g. 2006-01-02T15:04:05Z")
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"tasks": task.ListTasks(status, since)})
+}
+
+// GetTaskHandler returns a task with its subtasks, actions, verdicts, timings and tokens
+func GetTaskHandler(w http.ResponseWriter, r *http.Request) {
+	detail, ok := task.GetTaskDetail(r.PathValue("id"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "task_not_found", "no task "+r.PathValue("id"))
+		return
+	}
+	writeJSON(w, http.StatusOK, detail)
+}
+
+// CancelTaskHandler cancels a queued or running task
+func CancelTaskHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	if _, ok := task.GetTask(taskID); !ok {
+		writeAPIError(w, http.StatusNotFound, "task_not_found", "no task "+taskID)
+		return
+	}
+	if !task.CancelTask(taskID) {
+		detail, _ := task.GetTaskDetail(taskID)
+		writeAPIError(w, http.StatusConflict, "task_finished", "task is already "+detail.Status)
+		return
+	}
+	logger.Info("canceled task via API", "taskId", taskID, "principal", auth.FromContext(r.Context()).Name)
+
+	detail, _ := task.GetTaskDetail(taskID)
+	writeJSON(w, http.StatusOK, detail.TaskSummary)
+}
+
+// TaskEventsHandler returns the events of a task after ?since=<seq>, or
+// streams them as server-sent events until the task has finished when the
+// client accepts text/event-stream
+func TaskEventsHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	since := 0
+	if s := r.URL.Query().Get("since"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			writeAPIError(w, http.StatusBadRequest, "invalid_request", "since must be an event sequence number")
+			return
+		}
+		since = n
+	} else if s := r.Header.Get("Last-Event-ID"); s != "" {
+		since, _ = strconv.Atoi(s)
+	}
+
+	events, _, finished, ok := task.GetTaskEvents(taskID, since)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "task_not_found", "no task "+taskID)
+		return
+	}
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		if events == nil {
+			events = []task.TaskEvent{}
+		}
+		next := since
+		if len(events) > 0 {
+			next = events[len(events)-1].Seq
+		}
+		writeJSON(w, http.StatusOK, map[string]interface{}{"events": events, "next": next, "finished": finished})
+		return
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeAPIError(w, http.StatusNotAcceptable, "streaming_unsupported", "the connection can not stream events")
+		return
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	for {
+		events, changed, finished, _ := task.GetTaskEvents(taskID, since)
+		for _, e := range events {
+			data, err := json.Marshal(e)
+			if err != nil {
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Seq, e.Type, data)
+			since = e.Seq
+		}
+		flusher.Flush()
+		if finished {
+			fmt.Fprint(w, "event: end\ndata: {}\n\n")
+			flusher.Flush()
+			return
+		}
+
+		select {
+		case <-changed:
+		case <-r.Context().Done():
+			return
+		case <-time.After(15 * time.Second):
+			// Comment line, keeps proxies from closing an idle stream
+			fmt.Fprint(w, ": keep-alive\n\n")
+		}
+	}
+}
+
+// validateTaskOptions checks the optional settings of a new task
+func validateTaskOptions(postconditions []task.Postcondition, verification string) error {
+	if err := task.ValidatePostconditions(postconditions); err != nil {
+		return fmt.Errorf("invalid postconditions: %w", err)
+	}
+	switch verification {
+	case "", task.VerificationAuto, task.VerificationChecksOnly:
+		return nil
+	default:
+		return errors.New(`verification must be "auto" or "checksOnly"`)
+	}
+}
+
+// validateTaskRequest checks a new task and fills it in from its template;
+// a script task's goal defaults to the script's name
+func validateTaskRequest(req *CreateTaskRequest) error {
+	if req.Template != "" {
+		if err := renderTemplate(req); err != nil {
+			return err
+		}
+	} else if req.Script != nil {
+		params, err := scriptParams(req.Params)
+		if err != nil {
+			return err
+		}
+		req.scriptParams = params
+	}
+	if req.Script != nil {
+		if err := task.ValidateScript(req.Script); err != nil {
+			return fmt.Errorf("invalid script: %w", err)
+		}
+		if _, err := task.BindScriptParams(req.Script, req.scriptParams); err != nil {
+			return fmt.Errorf("invalid params: %w", err)
+		}
+		if len(req.Postconditions) > 0 {
+			return errors.New("a script checks its results with assert steps, not postconditions")
+		}
+		if strings.TrimSpace(req.Goal) == "" {
+			req.Goal = req.Script.Name
+		}
+		if strings.TrimSpace(req.Goal) == "" {
+			req.Goal = "Run script"
+		}
+	} else if len(req.Params) > 0 && req.Template == "" {
+		return errors.New("params are only used with a template or a script")
+	}
+	if strings.TrimSpace(req.Goal) == "" {
+		return errors.New("goal is required")
+	}
+	if err := task.ValidateBudget(req.Budget); err != nil {
+		return fmt.Errorf("invalid budget: %w", err)
+	}
+	if err := task.ValidateRunAfter(req.RunAfter); err != nil {
+		return err
+	}
+	return validateTaskOptions(req.Postconditions, req.Verification)
+}
+
+// renderTemplate fills in a request from its template; settings the
+// request gives itself win, its postconditions and hints are added
+func renderTemplate(req *CreateTaskRequest) error {
+	if req.Goal != "" || req.Script != nil {
+		return errors.New("give a template, a goal or a script, not more than one")
+	}
+	t, err := template.Get(req.Template)
+	if err != nil {
+		return err
+	}
+	rendered, err := t.Render(req.Params)
+	if err != nil {
+		return fmt.Errorf("template %s: %w", t.Name, err)
+	}
+	req.templateName = t.Name
+	req.Goal = rendered.Goal
+	req.Script = rendered.Script
+	req.scriptParams = rendered.ScriptParams
+	req.Postconditions = append(rendered.Postconditions, req.Postconditions...)
+	req.Hints = append(rendered.Hints, req.Hints...)
+	if req.Verification == "" {
+		req.Verification = rendered.Verification
+	}
+	if req.Model == "" {
+		req.Model = rendered.Model
+	}
+	if req.Budget == (task.Budget{}) {
+		req.Budget = rendered.Budget
+	}
+	return nil
+}
+
+// scriptParams returns the params of a script task as text
+func scriptParams(params map[string]interface{}) (map[string]string, error) {
+	values := make(map[string]string, len(params))
+	for name, v := range params {
+		switch v := v.(type) {
+		case string:
+			values[name] = v
+		case float64:
+			values[name] = strconv.FormatFloat(v, 'f', -1, 64)
+		case bool:
+			values[name] = strconv.FormatBool(v)
+		default:
+			return nil, fmt.Errorf("param %q must be a string, number or boolean", name)
+		}
+	}
+	return values, nil
+}
+
+// taskRequestErrorStatus maps an error of validateTaskRequest to a status
+// and error code
+func taskRequestErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, template.ErrNotFound):
+		return http.StatusNotFound, "template_not_found"
+	case errors.Is(err, template.ErrDisabled):
+		return http.StatusServiceUnavailable, "templates_disabled"
+	}
+	return http.StatusBadRequest, "invalid_request"
+}
+
+// queueTask creates a task, announces it on the WebSocket and queues it
+func queueTask(req CreateTaskRequest) *task.Task {
+	newTask := createTask(req)
+	task.EnqueueTask(newTask)
+	return newTask
+}
+
+// createTask creates the task of a validated request and announces it on
+// the WebSocket, without queueing it
+func createTask(req CreateTaskRequest) *task.Task {
+	newTask := task.CreateTask(req.Goal, task.TaskOptions{
+		Postconditions: req.Postconditions,
+		Verification:   req.Verification,
+		Script:         req.Script,
+		ScriptParams:   req.scriptParams,
+		Template:       req.templateName,
+		Model:          req.Model,
+		Hints:          req.Hints,
+		Budget:         req.Budget,
+		ScheduleID:     req.scheduleID,
+		Priority:       req.Priority,
+		RunAfter:       req.RunAfter,
+		Held:           req.Held,
+	})
+
+	// Send immediate WebSocket update with the task ID and queued status
+	websocket.SendTaskUpdate(newTask.ID, newTask.Status, newTask.Message)
+	return newTask
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/screenshot"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+// RegisterWebSocketCommands lets WebSocket clients submit, cancel, pause
+// and resume tasks, answer user-assist requests, send remote input and
+// record it as skills, and gives the screenshots topic its source
+func RegisterWebSocketCommands() {
+	websocket.HandleCommand("submit", auth.ScopeSubmit, submitCommand)
+	websocket.HandleCommand("cancel", auth.ScopeSubmit, cancelCommand)
+	websocket.HandleCommand("pause", auth.ScopeSubmit, pauseCommand)
+	websocket.HandleCommand("resume", auth.ScopeSubmit, resumeCommand)
+	websocket.HandleCommand("userAssist", auth.ScopeSubmit, userAssistCommand)
+	websocket.HandleCommand("input", auth.ScopeInput, inputCommand)
+	websocket.HandleCommand("takeControl", auth.ScopeInput, takeControlCommand)
+	websocket.HandleCommand("releaseControl", auth.ScopeInput, releaseControlCommand)
+	websocket.HandleCommand("startRecording", auth.ScopeInput, startRecordingCommand)
+	websocket.HandleCommand("stopRecording", auth.ScopeInput, stopRecordingCommand)
+
+	websocket.SetScreenshotSource(func() ([]byte, string, error) {
+		img, err := screenshot.CaptureX11Screenshot()
+		if err != nil {
+			return nil, "", err
+		}
+		png, err := screenshot.EncodeToPNG(img)
+		return png, "image/png", err
+	})
+}
+
+type taskParams struct {
+	TaskID string `json:"taskId"`
+}
+
+func commandError(code, message string) error {
+	return &websocket.CommandError{Code: code, Message: message}
+}
+
+// submitCommand queues a task, params as the body of POST /api/v2/tasks
+func submitCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req CreateTaskRequest
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if err := validateTaskRequest(&req); err != nil {
+		if _, code := taskRequestErrorStatus(err); code != "invalid_request" {
+			return nil, commandError(code, err.Error())
+		}
+		return nil, commandError("invalid_params", err.Error())
+	}
+
+	newTask := queueTask(req)
+	logger.Info("created task via websocket", "taskId", newTask.ID, "principal", auth.FromContext(ctx).Name)
+
+	detail, _ := task.GetTaskDetail(newTask.ID)
+	return detail.TaskSummary, nil
+}
+
+// taskCommand decodes {"taskId"} and checks that the task exists
+func taskCommand(params json.RawMessage) (string, error) {
+	var req taskParams
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return "", err
+	}
+	if req.TaskID == "" {
+		return "", commandError("invalid_params", "taskId is required")
+	}
+	if _, ok := task.GetTask(req.TaskID); !ok {
+		return "", commandError("task_not_found", "no task "+req.TaskID)
+	}
+	return req.TaskID, nil
+}
+
+func cancelCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	taskID, err := taskCommand(params)
+	if err != nil {
+		return nil, err
+	}
+	if !task.CancelTask(taskID) {
+		detail, _ := task.GetTaskDetail(taskID)
+		return nil, commandError("task_finished", "task is already "+detail.Status)
+	}
+	logger.Info("canceled task via websocket", "taskId", taskID, "principal", auth.FromContext(ctx).Name)
+
+	detail, _ := task.GetTaskDetail(taskID)
+	return detail.TaskSummary, nil
+}
+
+func pauseCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	taskID, err := taskCommand(params)
+	if err != nil {
+		return nil, err
+	}
+	if !task.PauseTask(taskID) {
+		detail, _ := task.GetTaskDetail(taskID)
+		return nil, commandError("task_finished", "task is already "+detail.Status)
+	}
+	return map[string]interface{}{"taskId": taskID, "paused": true}, nil
+}
+
+func resumeCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	taskID, err := taskCommand(params)
+	if err != nil {
+		return nil, err
+	}
+	if !task.ResumeTask(taskID) {
+		return nil, commandError("not_paused", "task is not paused")
+	}
+	return map[string]interface{}{"taskId": taskID, "paused": false}, nil
+}
+
+// userAssistCommand is POST /user-assist over the socket
+func userAssistCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req struct {
+		TaskID  string `json:"taskId"`
+		Message string `json:"message"`
+	}
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if req.TaskID == "" || req.Message == "" {
+		return nil, commandError("invalid_params", "taskId and message are required")
+	}
+	logger.Info("received user-assist message via websocket", "taskId", req.TaskID, logging.Secret("message", req.Message))
+
+	accepted := task.AddUserAssistMessage(req.TaskID, req.Message)
+	return map[string]interface{}{"taskId": req.TaskID, "accepted": accepted}, nil
+}
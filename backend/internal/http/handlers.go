@@ -2,28 +2,32 @@ package http
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	stdimage "image"
 	"image/color"
 	"image/draw"
 	"image/png"
-	"log"
+	"log/slog"
 	"net/http"
 	"strconv"
 
+	"useless-agent/internal/auth"
 	"useless-agent/internal/config"
 	"useless-agent/internal/image"
+	"useless-agent/internal/logging"
 	"useless-agent/internal/screenshot"
 	"useless-agent/internal/task"
+	"useless-agent/internal/trajectory"
 	"useless-agent/internal/websocket"
 	"useless-agent/pkg/x11"
 )
 
+var logger = logging.For("http")
+
 // ScreenshotHandler handles screenshot requests
 func ScreenshotHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
 	img, err := screenshot.CaptureX11Screenshot()
 	if err != nil {
 		http.Error(w, "Failed to capture screenshot: "+err.Error(), http.StatusInternalServerError)
@@ -46,8 +50,10 @@ func ScreenshotHandler(w http.ResponseWriter, r *http.Request) {
 // LLMInputHandler handles LLM input requests
 func LLMInputHandler(w http.ResponseWriter, r *http.Request) {
 	type PostMessage struct {
-		Text      string `json:"text"`
-		SessionID string `json:"sessionId,omitempty"`
+		Text           string               `json:"text"`
+		SessionID      string               `json:"sessionId,omitempty"`
+		Postconditions []task.Postcondition `json:"postconditions,omitempty"`
+		Verification   string               `json:"verification,omitempty"`
 	}
 	type ConfirmationMessage struct {
 		ReceivedText string `json:"Received llm input text,omitempty"`
@@ -58,11 +64,13 @@ func LLMInputHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&receivedMessage)
 	if err != nil {
-		fmt.Println(err)
+		logger.Warn("failed to decode message", "error", err)
 	}
 
-	log.Println("Received message:", receivedMessage.Text)
-	log.Println("Received sessionID:", receivedMessage.SessionID)
+	if err := validateTaskOptions(receivedMessage.Postconditions, receivedMessage.Verification); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	acknowledgment.ReceivedText = receivedMessage.Text
 
@@ -73,20 +81,11 @@ func LLMInputHandler(w http.ResponseWriter, r *http.Request) {
 	sessionID := receivedMessage.SessionID
 	if sessionID == "" {
 		sessionID = "default"
-		log.Println("No sessionID provided, using default")
 	}
 
-	// Create a new task for this request
-	newTask := task.CreateTask(receivedMessage.Text)
-	newTask.Status = "in-the-queue" // Start with queued status
-
-	log.Printf("Created task %s with message: %s", newTask.ID, receivedMessage.Text)
-
-	// Send immediate WebSocket update with the task ID and queued status
-	websocket.SendTaskUpdate(newTask.ID, newTask.Status, newTask.Message)
-
-	// Enqueue the task
-	task.EnqueueTask(newTask)
+	// Create a new task for this request and queue it
+	newTask := queueTask(CreateTaskRequest{Goal: receivedMessage.Text, Postconditions: receivedMessage.Postconditions, Verification: receivedMessage.Verification})
+	logger.Info("created task", "taskId", newTask.ID, "sessionId", sessionID, logging.Secret("message", receivedMessage.Text))
 
 	// Return immediate JSON response
 	var response []map[string]interface{}
@@ -159,7 +158,7 @@ func UserAssistHandler(w http.ResponseWriter, r *http.Request) {
 
 	err := json.NewDecoder(r.Body).Decode(&request)
 	if err != nil {
-		log.Printf("Error decoding user-assist request: %v", err)
+		logger.Warn("failed to decode user-assist request", "error", err)
 		http.Error(w, "Invalid JSON request", http.StatusBadRequest)
 		return
 	}
@@ -169,7 +168,7 @@ func UserAssistHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Received user-assist message for task %s: %s", request.TaskID, request.Message)
+	logger.Info("received user-assist message", "taskId", request.TaskID, logging.Secret("message", request.Message))
 
 	// Add user-assist message to the task
 	accepted := task.AddUserAssistMessage(request.TaskID, request.Message)
@@ -195,13 +194,21 @@ func PingHandler(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// CORSMiddleware adds CORS headers to responses
+// CORSMiddleware adds CORS headers to responses. With an origin allowlist
+// only listed origins are echoed back, otherwise any origin may call.
 func CORSMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Set CORS headers
-		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, OPTIONS")
-		w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+		origin := r.Header.Get("Origin")
+		switch {
+		case !auth.Enabled():
+			w.Header().Set("Access-Control-Allow-Origin", "*")
+		case origin != "" && auth.OriginAllowed(origin):
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+		}
+		w.Header().Add("Vary", "Origin")
+		w.Header().Set("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+		w.Header().Set("Access-Control-Allow-Headers", "Content-Type, Authorization, Last-Event-ID")
 
 		// Handle preflight (OPTIONS) requests
 		if r.Method == http.MethodOptions {
@@ -283,7 +290,7 @@ func Video2Handler(w http.ResponseWriter, r *http.Request) {
 					image.DrawBoundingBox(drawImg, x1+1, y1+1, x1+15, y1+15, borderColor)
 					_, err = DrawText(drawImg, x1+2, y1+2, []string{strconv.Itoa(bbCounter)})
 					if err != nil {
-						fmt.Println(err)
+						logger.Warn("failed to draw bounding box id", "error", err)
 					}
 					bbCounter++
 				}
@@ -310,49 +317,39 @@ func abs(x, y int) int {
 func DrawText(img interface{}, offsetX, offsetY int, text []string) (interface{}, error) {
 	// This is a placeholder implementation
 	// The actual implementation would be moved from main.go
-	log.Printf("Drawing text at (%d, %d): %v", offsetX, offsetY, text)
+	logger.Debug("drawing text", "x", offsetX, "y", offsetY, "text", text)
 	return img, nil
 }
 
 // GetX11WindowsData gets X11 windows data
 func GetX11WindowsData() (string, error) {
-	log.Printf("=== GETTING X11 WINDOWS DATA ===")
-
 	// Get display from config - always pass the display value, even if it's default ":0"
 	display := *config.Display
-	log.Printf("Using display from config: %s", display)
 
 	x11WindowsJSON, err := x11.GetX11WindowsWithDisplay(display)
 	if err != nil {
-		log.Printf("Failed to get X11 windows data: %v", err)
+		logger.Error("failed to get X11 windows data", "display", display, "error", err)
 		return "[]", err
 	}
 
 	// Log the retrieved data for debugging
-	log.Printf("X11 windows data retrieved successfully:")
-	log.Printf("Raw JSON data: %s", x11WindowsJSON)
-
-	// Parse and log structured data for better readability
-	var x11WindowsData x11.X11WindowInfo
-	if parseErr := json.Unmarshal([]byte(x11WindowsJSON), &x11WindowsData); parseErr == nil {
-		log.Printf("Parsed X11 windows data - Total windows: %d", len(x11WindowsData.Windows))
-		for i, window := range x11WindowsData.Windows {
-			log.Printf("Window %d: ID=%d, Title='%s', Class='%s', Position=(%d,%d), Size=%dx%d, Visible=%t",
-				i+1, window.ID, window.Title, window.Class, window.Position.X, window.Position.Y,
-				window.Size.Width, window.Size.Height, window.Visible)
+	if logger.Enabled(context.Background(), slog.LevelDebug) {
+		var x11WindowsData x11.X11WindowInfo
+		if parseErr := json.Unmarshal([]byte(x11WindowsJSON), &x11WindowsData); parseErr == nil {
+			for i, window := range x11WindowsData.Windows {
+				logger.Debug("X11 window", "index", i+1, "id", window.ID, "title", window.Title, "class", window.Class,
+					"x", window.Position.X, "y", window.Position.Y, "width", window.Size.Width, "height", window.Size.Height,
+					"visible", window.Visible)
+			}
+		} else {
+			logger.Debug("failed to parse X11 windows JSON for logging", "error", parseErr)
 		}
-	} else {
-		log.Printf("Failed to parse X11 windows JSON for logging: %v", parseErr)
 	}
-
-	log.Printf("=== X11 WINDOWS DATA END ===")
 	return x11WindowsJSON, nil
 }
 
 // ExecutionStateHandler handles requests for current execution engine state
 func ExecutionStateHandler(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-
 	// Get current execution state
 	state := task.GetExecutionState()
 
@@ -365,3 +362,23 @@ func ExecutionStateHandler(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(jsonBytes)
 }
+
+// TrajectoryHandler serves the recorded trajectory bundle of a task as a zip archive
+func TrajectoryHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.URL.Query().Get("taskId")
+	if taskID == "" {
+		http.Error(w, "taskId parameter is required", http.StatusBadRequest)
+		return
+	}
+	if !trajectory.Exists(taskID) {
+		http.Error(w, "No trajectory recorded for task "+taskID, http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", "trajectory-"+taskID+".zip"))
+	if err := trajectory.WriteZip(taskID, w); err != nil {
+		// Headers are already sent, the client gets a truncated archive
+		logger.Error("failed to stream trajectory", "taskId", taskID, "error", err)
+	}
+}
@@ -0,0 +1,136 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/auth"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+// maxInputs bounds one remote-input request
+const maxInputs = 100
+
+// InputRequest is the body of POST /api/v2/input and the params of the
+// input WebSocket command
+type InputRequest struct {
+	Inputs []action.Input `json:"inputs"`
+}
+
+// InputControlResponse tells who has control, nil control meaning nobody
+type InputControlResponse struct {
+	Control *task.OperatorControl `json:"control"`
+}
+
+func controlResponse() InputControlResponse {
+	if c, ok := task.CurrentControl(); ok {
+		return InputControlResponse{Control: &c}
+	}
+	return InputControlResponse{}
+}
+
+func validateInputs(inputs []action.Input) error {
+	if len(inputs) == 0 {
+		return errors.New("inputs are required")
+	}
+	if len(inputs) > maxInputs {
+		return fmt.Errorf("at most %d inputs per request", maxInputs)
+	}
+	for i, in := range inputs {
+		if err := action.ValidateInput(in); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// InputControlHandler reports who has control of the keyboard and mouse
+func InputControlHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, controlResponse())
+}
+
+// InputHandler applies operator input, taking control from the agent
+func InputHandler(w http.ResponseWriter, r *http.Request) {
+	var req InputRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if err := validateInputs(req.Inputs); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+
+	principal := auth.FromContext(r.Context()).Name
+	c, err := task.OperatorInput(principal, req.Inputs)
+	if errors.Is(err, task.ErrControlHeld) {
+		writeAPIError(w, http.StatusConflict, "control_held", err.Error())
+		return
+	}
+	if err != nil {
+		logger.Warn("operator input failed", "principal", principal, "error", err)
+		writeAPIError(w, http.StatusInternalServerError, "input_failed", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, InputControlResponse{Control: &c})
+}
+
+// TakeControlHandler takes control before any input
+func TakeControlHandler(w http.ResponseWriter, r *http.Request) {
+	c, err := task.TakeControl(auth.FromContext(r.Context()).Name)
+	if err != nil {
+		writeAPIError(w, http.StatusConflict, "control_held", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, InputControlResponse{Control: &c})
+}
+
+// ReleaseControlHandler gives control back to the agent
+func ReleaseControlHandler(w http.ResponseWriter, r *http.Request) {
+	if _, err := task.ReleaseControl(auth.FromContext(r.Context()).Name); err != nil {
+		writeAPIError(w, http.StatusConflict, "control_held", err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, controlResponse())
+}
+
+// inputCommand is POST /api/v2/input over the socket
+func inputCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req InputRequest
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if err := validateInputs(req.Inputs); err != nil {
+		return nil, commandError("invalid_params", err.Error())
+	}
+	c, err := task.OperatorInput(auth.FromContext(ctx).Name, req.Inputs)
+	if errors.Is(err, task.ErrControlHeld) {
+		return nil, commandError("control_held", err.Error())
+	}
+	if err != nil {
+		return nil, commandError("input_failed", err.Error())
+	}
+	return InputControlResponse{Control: &c}, nil
+}
+
+func takeControlCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	c, err := task.TakeControl(auth.FromContext(ctx).Name)
+	if err != nil {
+		return nil, commandError("control_held", err.Error())
+	}
+	return InputControlResponse{Control: &c}, nil
+}
+
+func releaseControlCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	if _, err := task.ReleaseControl(auth.FromContext(ctx).Name); err != nil {
+		return nil, commandError("control_held", err.Error())
+	}
+	return controlResponse(), nil
+}
@@ -0,0 +1,41 @@
+package http
+
+import (
+	"errors"
+	"net/http"
+
+	"useless-agent/internal/macro"
+)
+
+// ListMacrosHandler lists the action sequences kept from completed subtasks
+func ListMacrosHandler(w http.ResponseWriter, r *http.Request) {
+	macros := macro.List()
+	if macros == nil {
+		macros = []macro.Macro{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"macros": macros})
+}
+
+// GetMacroHandler returns a macro with its actions
+func GetMacroHandler(w http.ResponseWriter, r *http.Request) {
+	m, ok := macro.Get(r.PathValue("id"))
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "macro_not_found", macro.ErrNotFound.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, m)
+}
+
+// DeleteMacroHandler forgets a macro, e.g. one that completed its subtask by
+// accident
+func DeleteMacroHandler(w http.ResponseWriter, r *http.Request) {
+	if err := macro.Delete(r.PathValue("id")); err != nil {
+		if errors.Is(err, macro.ErrNotFound) {
+			writeAPIError(w, http.StatusNotFound, "macro_not_found", err.Error())
+			return
+		}
+		writeAPIError(w, http.StatusInternalServerError, "internal", err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,55 @@
+package http
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+// QueueHandler returns the queued tasks in the order they run, each with
+// why it is there, and why the last task was started
+func QueueHandler(w http.ResponseWriter, r *http.Request) {
+	state := task.GetExecutionState()
+	queue := state.Queue
+	if queue == nil {
+		queue = []task.QueueEntry{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"running":      state.RunningTask,
+		"queue":        queue,
+		"lastDecision": state.LastDecision,
+	})
+}
+
+// UpdateQueueHandler changes the priority or position of a queued task, or
+// holds or releases it
+func UpdateQueueHandler(w http.ResponseWriter, r *http.Request) {
+	taskID := r.PathValue("id")
+	var update task.QueueUpdate
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&update); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	if _, ok := task.GetTask(taskID); !ok {
+		writeAPIError(w, http.StatusNotFound, "task_not_found", "no task "+taskID)
+		return
+	}
+	entry, err := task.UpdateQueuedTask(taskID, update)
+	if errors.Is(err, task.ErrNotQueued) {
+		writeAPIError(w, http.StatusConflict, "task_not_queued", taskID+" is not in the queue")
+		return
+	}
+	if err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+		return
+	}
+	logger.Info("updated queued task via API", "taskId", taskID, "position", entry.Position, "priority", entry.Priority, "held", entry.Held, "principal", auth.FromContext(r.Context()).Name)
+	websocket.SendExecutionEngineUpdate("queueUpdate", map[string]interface{}{"taskId": taskID, "queue": task.GetQueue()})
+	writeJSON(w, http.StatusOK, entry)
+}
@@ -0,0 +1,178 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+// ScheduleRequest is the body of POST /api/v2/schedules and PUT
+// /api/v2/schedules/{id}
+type ScheduleRequest struct {
+	Name          string          `json:"name,omitempty"`
+	Cron          string          `json:"cron,omitempty"`
+	At            *time.Time      `json:"at,omitempty"`
+	Timezone      string          `json:"timezone,omitempty"`
+	Request       json.RawMessage `json:"request"` // a CreateTaskRequest
+	Enabled       *bool           `json:"enabled,omitempty"`
+	MissedRuns    string          `json:"missedRuns,omitempty"`
+	SkipIfRunning bool            `json:"skipIfRunning,omitempty"`
+}
+
+// decodeTaskRequest decodes and validates the task request of a schedule
+func decodeTaskRequest(data json.RawMessage) (CreateTaskRequest, error) {
+	var req CreateTaskRequest
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		return req, fmt.Errorf("request: %w", err)
+	}
+	if err := validateTaskRequest(&req); err != nil {
+		return req, fmt.Errorf("request: %w", err)
+	}
+	return req, nil
+}
+
+// RunScheduledRequest creates the task of a schedule's request for the
+// scheduler to queue, see task.SetScheduleRunner
+func RunScheduledRequest(scheduleID string, data json.RawMessage) (*task.Task, error) {
+	req, err := decodeTaskRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	req.scheduleID = scheduleID
+	return createTask(req), nil
+}
+
+// decodeSchedule reads and checks the body of a schedule request
+func decodeSchedule(w http.ResponseWriter, r *http.Request) (task.Schedule, bool) {
+	var req ScheduleRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return task.Schedule{}, false
+	}
+	if len(req.Request) == 0 {
+		writeAPIError(w, http.StatusBadRequest, "invalid_request", "request is required")
+		return task.Schedule{}, false
+	}
+	if _, err := decodeTaskRequest(req.Request); err != nil {
+		status, code := taskRequestErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return task.Schedule{}, false
+	}
+	return task.Schedule{
+		Name:          req.Name,
+		Cron:          req.Cron,
+		At:            req.At,
+		Timezone:      req.Timezone,
+		Request:       req.Request,
+		Enabled:       req.Enabled == nil || *req.Enabled,
+		MissedRuns:    req.MissedRuns,
+		SkipIfRunning: req.SkipIfRunning,
+	}, true
+}
+
+// writeScheduleError writes an error of the task scheduler
+func writeScheduleError(w http.ResponseWriter, id string, err error) {
+	if errors.Is(err, task.ErrScheduleNotFound) {
+		writeAPIError(w, http.StatusNotFound, "schedule_not_found", "no schedule "+id)
+		return
+	}
+	writeAPIError(w, http.StatusBadRequest, "invalid_request", err.Error())
+}
+
+// ListSchedulesHandler lists the schedules
+func ListSchedulesHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{"schedules": task.ListSchedules()})
+}
+
+// GetScheduleHandler returns a schedule
+func GetScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := task.GetSchedule(r.PathValue("id"))
+	if !ok {
+		writeScheduleError(w, r.PathValue("id"), task.ErrScheduleNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// CreateScheduleHandler adds a schedule
+func CreateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	s, ok := decodeSchedule(w, r)
+	if !ok {
+		return
+	}
+	created, err := task.CreateSchedule(s)
+	if err != nil {
+		writeScheduleError(w, "", err)
+		return
+	}
+	logger.Info("created schedule via API", "scheduleId", created.ID, "principal", auth.FromContext(r.Context()).Name)
+	websocket.SendExecutionEngineUpdate("scheduleUpdate", created)
+	w.Header().Set("Location", "schedules/"+created.ID)
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// UpdateScheduleHandler replaces the definition of a schedule
+func UpdateScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if _, ok := task.GetSchedule(id); !ok {
+		writeScheduleError(w, id, task.ErrScheduleNotFound)
+		return
+	}
+	s, ok := decodeSchedule(w, r)
+	if !ok {
+		return
+	}
+	updated, err := task.UpdateSchedule(id, s)
+	if err != nil {
+		writeScheduleError(w, id, err)
+		return
+	}
+	logger.Info("updated schedule via API", "scheduleId", id, "principal", auth.FromContext(r.Context()).Name)
+	websocket.SendExecutionEngineUpdate("scheduleUpdate", updated)
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// DeleteScheduleHandler removes a schedule
+func DeleteScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if err := task.DeleteSchedule(id); err != nil {
+		writeScheduleError(w, id, err)
+		return
+	}
+	logger.Info("deleted schedule via API", "scheduleId", id, "principal", auth.FromContext(r.Context()).Name)
+	websocket.SendExecutionEngineUpdate("scheduleUpdate", map[string]interface{}{"id": id, "deleted": true})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// EnableScheduleHandler enables a schedule
+func EnableScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	setScheduleEnabled(w, r, true)
+}
+
+// DisableScheduleHandler disables a schedule
+func DisableScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	setScheduleEnabled(w, r, false)
+}
+
+func setScheduleEnabled(w http.ResponseWriter, r *http.Request, enabled bool) {
+	id := r.PathValue("id")
+	s, err := task.SetScheduleEnabled(id, enabled)
+	if err != nil {
+		writeScheduleError(w, id, err)
+		return
+	}
+	logger.Info("switched schedule via API", "scheduleId", id, "enabled", enabled, "principal", auth.FromContext(r.Context()).Name)
+	websocket.SendExecutionEngineUpdate("scheduleUpdate", s)
+	writeJSON(w, http.StatusOK, s)
+}
@@ -0,0 +1,182 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/skill"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+// StartRecordingRequest is the body of POST /api/v2/skills/recording
+type StartRecordingRequest struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+}
+
+// StopRecordingRequest is the body of POST /api/v2/skills/recording/stop
+type StopRecordingRequest struct {
+	Parameters []skill.Param `json:"parameters,omitempty"`
+}
+
+// helpedTask is the task an operator is helping: the one running when they
+// took control, or the running one
+func helpedTask() string {
+	if c, ok := task.CurrentControl(); ok && c.TaskID != "" {
+		return c.TaskID
+	}
+	if running := task.ListTasks("in-progress", time.Time{}); len(running) > 0 {
+		return running[0].ID
+	}
+	return ""
+}
+
+func skillErrorStatus(err error) (int, string) {
+	switch {
+	case errors.Is(err, skill.ErrNotFound):
+		return http.StatusNotFound, "skill_not_found"
+	case errors.Is(err, skill.ErrNotRecording):
+		return http.StatusConflict, "not_recording"
+	case errors.Is(err, skill.ErrDisabled):
+		return http.StatusServiceUnavailable, "skills_disabled"
+	}
+	return http.StatusBadRequest, "invalid_request"
+}
+
+// ListSkillsHandler lists the recorded skills
+func ListSkillsHandler(w http.ResponseWriter, r *http.Request) {
+	skills := skill.List()
+	if skills == nil {
+		skills = []skill.Skill{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"skills": skills})
+}
+
+// GetSkillHandler returns a skill with its steps
+func GetSkillHandler(w http.ResponseWriter, r *http.Request) {
+	s, err := skill.Get(r.PathValue("name"))
+	if err != nil {
+		status, code := skillErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, s)
+}
+
+// SkillScreenshotHandler serves the screen a skill starts from or ends on
+func SkillScreenshotHandler(w http.ResponseWriter, r *http.Request) {
+	path, err := skill.ScreenshotPath(r.PathValue("name"), r.PathValue("which"))
+	if err != nil {
+		status, code := skillErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		writeAPIError(w, http.StatusNotFound, "not_found", "no screenshot recorded")
+		return
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(data)
+}
+
+// DeleteSkillHandler removes a skill
+func DeleteSkillHandler(w http.ResponseWriter, r *http.Request) {
+	if err := skill.Delete(r.PathValue("name")); err != nil {
+		status, code := skillErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// RecordingHandler reports the caller's recording in progress
+func RecordingHandler(w http.ResponseWriter, r *http.Request) {
+	rec, ok := skill.Current(auth.FromContext(r.Context()).Name)
+	if !ok {
+		writeAPIError(w, http.StatusNotFound, "not_recording", skill.ErrNotRecording.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, rec)
+}
+
+// StartRecordingHandler starts recording the caller's remote input as a skill
+func StartRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	var req StartRecordingRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	rec, err := skill.Start(auth.FromContext(r.Context()).Name, req.Name, req.Description, helpedTask())
+	if err != nil {
+		status, code := skillErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, rec)
+}
+
+// StopRecordingHandler saves the caller's recording as a skill
+func StopRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	var req StopRecordingRequest
+	decoder := json.NewDecoder(r.Body)
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&req); err != nil && err != io.EOF {
+		writeAPIError(w, http.StatusBadRequest, "invalid_json", err.Error())
+		return
+	}
+	s, err := skill.Stop(auth.FromContext(r.Context()).Name, req.Parameters)
+	if err != nil {
+		status, code := skillErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusCreated, s)
+}
+
+// CancelRecordingHandler discards the caller's recording
+func CancelRecordingHandler(w http.ResponseWriter, r *http.Request) {
+	if !skill.Cancel(auth.FromContext(r.Context()).Name) {
+		writeAPIError(w, http.StatusNotFound, "not_recording", skill.ErrNotRecording.Error())
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func skillCommandError(err error) error {
+	_, code := skillErrorStatus(err)
+	return commandError(code, err.Error())
+}
+
+func startRecordingCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req StartRecordingRequest
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	rec, err := skill.Start(auth.FromContext(ctx).Name, req.Name, req.Description, helpedTask())
+	if err != nil {
+		return nil, skillCommandError(err)
+	}
+	return rec, nil
+}
+
+func stopRecordingCommand(ctx context.Context, params json.RawMessage) (interface{}, error) {
+	var req StopRecordingRequest
+	if err := websocket.DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	s, err := skill.Stop(auth.FromContext(ctx).Name, req.Parameters)
+	if err != nil {
+		return nil, skillCommandError(err)
+	}
+	return s, nil
+}
@@ -0,0 +1,27 @@
+package http
+
+import (
+	"net/http"
+
+	"useless-agent/internal/template"
+)
+
+// ListTemplatesHandler lists the task templates of the catalogue
+func ListTemplatesHandler(w http.ResponseWriter, r *http.Request) {
+	templates := template.List()
+	if templates == nil {
+		templates = []template.Template{}
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"templates": templates})
+}
+
+// GetTemplateHandler returns a task template
+func GetTemplateHandler(w http.ResponseWriter, r *http.Request) {
+	t, err := template.Get(r.PathValue("name"))
+	if err != nil {
+		status, code := taskRequestErrorStatus(err)
+		writeAPIError(w, status, code, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, t)
+}
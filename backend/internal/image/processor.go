@@ -6,10 +6,13 @@ import (
 	"image"
 	"image/color"
 	"image/draw"
-	"log"
 	"sort"
+
+	"useless-agent/internal/logging"
 )
 
+var logger = logging.For("image")
+
 // ColorCount represents a color and its count
 type ColorCount struct {
 	Color      color.RGBA `json:"color"`
@@ -70,7 +73,7 @@ func DominantColorsToJSONString(colors []ColorCount) string {
 
 	jsonBytes, err := json.Marshal(response)
 	if err != nil {
-		log.Println("[DominantColorsToJSONString] Failed to encode JSON")
+		logger.Error("failed to encode dominant colors", "error", err)
 		return ""
 	}
 	return string(jsonBytes)
@@ -161,13 +164,11 @@ func FindBoundingBoxes(img image.Image) []BoundingBox {
 func BoundingBoxArrayToJSONString(bbArray []BoundingBox) string {
 	jsonBytes, err := json.MarshalIndent(bbArray, "", "    ")
 	if err != nil {
-		log.Printf("Error marshaling bounding box array to JSON: %v", err)
+		logger.Error("failed to marshal bounding boxes", "error", err)
 		return "[]" // Return empty array string on error
 	}
 
-	log.Println("FindBoundingBoxes function, bounding boxes:")
-	log.Println(string(jsonBytes))
-	log.Println("AT THE TOP IS LLM-CONTEXT-BB BOUNDING BOXES.")
+	logger.Debug("bounding boxes", "count", len(bbArray), "json", string(jsonBytes))
 
 	return string(jsonBytes)
 }
@@ -6,15 +6,17 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"sort"
 	"strconv"
+	"strings"
 	"time"
 
 	"useless-agent/internal/action"
 	"useless-agent/internal/config"
-	"useless-agent/internal/mouse"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
 	"useless-agent/internal/token"
+	"useless-agent/pkg/x11"
 )
 
 var (
@@ -31,12 +33,13 @@ func InitializeLLM() error {
 	// Register available providers
 	providerRegistry["deepseek"] = NewDeepSeekProvider()
 	providerRegistry["zai"] = NewZAIProvider()
+	providerRegistry["replay"] = NewReplayProvider()
 
 	// Get configuration
 	cfg := config.GetLLMConfig()
 
-	// Validate configuration
-	if cfg.APIKey == "" {
+	// Validate configuration, replay serves recorded responses and needs no key
+	if cfg.APIKey == "" && cfg.Provider != "replay" {
 		return fmt.Errorf("LLM API key is required")
 	}
 
@@ -48,6 +51,8 @@ func InitializeLLM() error {
 			model = "deepseek-chat"
 		case "zai":
 			model = "glm-4.6"
+		case "replay":
+			model = "replay"
 		default:
 			return fmt.Errorf("unknown LLM provider: %s", cfg.Provider)
 		}
@@ -88,47 +93,45 @@ func InitializeLLM() error {
 	return nil
 }
 
+var logger = logging.For("llm")
+
 // GetLLMClient returns the current LLM client
 func GetLLMClient() Client {
 	return llmClient
 }
 
 // SendMessageToLLM sends a message to the LLM and returns actions to execute
-func SendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrContext string, ocrDelta string, prevExecutedCommands string, iteration int64, prevCursorPosJSONString string, allWindowsJSONString string, x11WindowsData string, colorsDistribution string) (actionsToExecute []action.Action, actionsJSONStringReturn string, err error) {
+func SendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrContext string, ocrDelta string, prevExecutedCommands string, iteration int64, prevCursorPosJSONString string, cursorPosition string, allWindowsJSONString string, x11WindowsData string, colorsDistribution string) (actionsToExecute []action.Action, actionsJSONStringReturn string, err error) {
 	// Check if context is nil, use background context if it is
 	if ctx == nil {
-		log.Println("Warning: nil context provided to sendMessageToLLM, using background context")
+		logger.Warn("nil context provided to SendMessageToLLM, using background context")
 		ctx = context.Background()
 	}
 
 	// Initialize LLM if not already done
-	if llmClient == nil {
+	if clientFor(ctx) == nil {
 		if err := InitializeLLM(); err != nil {
-			log.Printf("Failed to initialize LLM: %v", err)
+			logger.ErrorContext(ctx, "failed to initialize LLM", "error", err)
 			return []action.Action{}, "", errors.New("Failed to initialize LLM")
 		}
 	}
 
-	cursorPosition, _ := mouse.GetCursorPositionJSON()
+	client := clientFor(ctx)
 	iterationString := strconv.FormatInt(iteration, 10)
 
-	log.Println("====================================================")
-	log.Println("===================LLM INPUT========================")
-	log.Println("====================================================")
-	log.Println("prompt:", prompt)
-	log.Println("cursorPosition:", cursorPosition)
-	log.Println("ocrContext:", ocrContext)
-	log.Println("ocrDelta:", ocrDelta)
-	log.Println("allWindowsJSONString:", allWindowsJSONString)
-	log.Println("prevExecutedCommands:", prevExecutedCommands)
-	log.Println("iteration:", iterationString)
-	log.Println("====================================================")
-	log.Println("=================LLM INPUT END=====================")
-	log.Println("====================================================")
+	// The inputs contain screen text and the user's goal, they stay out of the log channel
+	logger.DebugContext(ctx, "actions LLM input",
+		logging.Secret("prompt", prompt),
+		"cursorPosition", cursorPosition,
+		logging.Secret("ocrContext", ocrContext),
+		logging.Secret("ocrDelta", ocrDelta),
+		logging.Secret("windows", allWindowsJSONString),
+		logging.Secret("prevExecutedCommands", prevExecutedCommands),
+	)
 
 	// Get configuration for model
 	cfg := config.GetLLMConfig()
-	model := cfg.Model
+	model := modelFor(ctx, cfg.Model)
 	if model == "" {
 		switch cfg.Provider {
 		case "deepseek":
@@ -197,21 +200,16 @@ you can use 'keyTap' action:
   "action": "keyTap",
   "keyTapString": "enter"
 }
-'keyTapString' string value can be:
-    "backspace"
-	"delete"
-	"enter"
-	"tab"
-	"esc"
-	"escape"
-	"up"
-	"down"
-	"right"
-	"left"
-	"home"
-	"end"
-	"pageup"
-	"pagedown"
+'keyTapString' string value can be a single character (letters, digits, punctuation, also non-latin like "ä" or "ж") or one of the key names:
+    ` + keyNamesForPrompt() + `
+to press a key combination at once use 'hotkey' action, keys are pressed in order and always released automatically in reverse order:
+{
+  "actionSequenceID": 8,
+  "action": "hotkey",
+  "keys": ["ctrl", "alt", "t"]
+}
+always prefer 'hotkey' over separate 'keyDown'/'keyUp' actions for shortcuts.
+'printString' types any unicode text (for example German or Cyrillic) using the active keyboard layout.
 you can use 'dragSmooth' action:
 {
   "action": "dragSmooth",
@@ -247,7 +245,7 @@ you can use 'repeat' action to repeat previous range of action(next example repe
   "repeatTimes": 3
 }
 use 'repeat' action always when you need to do repetitive identical task, for example to close N windows.
-If you want to click on some UI element, better to click a little bit 'inside' of it, because if cursor moved to the border of element, it could ignore actions.
+` + skillsForPrompt(ctx) + examplesForPrompt(ctx) + hintsForPrompt(ctx) + `If you want to click on some UI element, better to click a little bit 'inside' of it, because if cursor moved to the border of element, it could ignore actions.
 You not allowed to produce useless actions.
 Every iteration analizy ocrDelta data to understand if task is completed, if and only if it's completed issue stop iteration action.
 json with actions need to be clean, WITHOUT ANY COMMENTS.
@@ -261,11 +259,10 @@ Again, you current task is:
 	}
 
 	// Estimate tokens
-	estimate := llmClient.EstimateTokensFromMessages(messages)
-	fmt.Printf("Estimated total tokens[main llm input func][input]: %d\n", estimate.EstimatedTokens)
+	estimate := client.EstimateTokensFromMessages(messages)
+	logger.DebugContext(ctx, "estimated input tokens", "call", KindActions, "tokens", estimate.EstimatedTokens)
 	token.AddTokensAndSendUpdate(estimate.EstimatedTokens)
-
-	fmt.Println("\nCreating streaming chat completion...")
+	observeTokens(ctx, KindActions, estimate.EstimatedTokens)
 
 	// Create streaming request
 	req := &ChatCompletionRequest{
@@ -278,41 +275,49 @@ Again, you current task is:
 		JSONMode:        true,
 	}
 
-	stream, err := llmClient.CreateChatCompletionStream(ctx, req)
+	// Report the exchange (including parse failures) to a trajectory recorder, if any
+	startedAt := time.Now()
+	callCtx, span := startCall(ctx, KindActions, req, estimate.EstimatedTokens)
+	var fullResponseMessage string
+	defer func() {
+		notifyExchange(ctx, KindActions, req, fullResponseMessage, err, startedAt, estimate.EstimatedTokens)
+		span.SetAttributes("llm.actions", len(actionsToExecute))
+		finishCall(span, KindActions, startedAt, fullResponseMessage, err)
+	}()
+
+	stream, err := client.CreateChatCompletionStream(withCallKind(callCtx, KindActions), req)
 	if err != nil {
 		// Check if the error is due to context cancellation
 		if ctx.Err() == context.Canceled {
-			log.Printf("LLM request canceled during stream creation")
+			logger.InfoContext(ctx, "LLM request canceled during stream creation")
 			return []action.Action{}, "", errors.New("LLM request canceled by user")
 		}
-		log.Printf("Failed to create LLM stream: %v", err)
+		logger.ErrorContext(ctx, "failed to create LLM stream", "error", err)
 		return []action.Action{}, "", errors.New("Failed to send message to LLM, error.")
 	}
 
 	// Additional nil check for stream to prevent panic
 	if stream == nil {
-		log.Printf("LLM stream is nil after creation")
+		logger.ErrorContext(ctx, "LLM stream is nil after creation")
 		return []action.Action{}, "", errors.New("Failed to create LLM stream: stream is nil")
 	}
 
 	defer func() {
 		if stream != nil {
 			if closeErr := stream.Close(); closeErr != nil {
-				log.Printf("Error closing stream: %v", closeErr)
+				logger.WarnContext(ctx, "failed to close LLM stream", "error", closeErr)
 			}
 		}
 	}()
 
-	fmt.Print("\nStreaming response: ")
-
-	var fullResponseMessage string
 	var chunkCount int = 0
+	firstToken := true
 
 	for {
 		// Check for task cancellation during streaming
 		select {
 		case <-ctx.Done():
-			log.Printf("LLM stream canceled for task")
+			logger.InfoContext(ctx, "LLM stream canceled")
 			return []action.Action{}, "", errors.New("LLM request canceled by user")
 		default:
 			// Continue streaming
@@ -324,15 +329,14 @@ Again, you current task is:
 		if err != nil {
 			if err == io.EOF {
 				// Stream completed normally - break out of loop
-				fmt.Println("\n=== STREAM COMPLETED ===")
 				break
 			}
 			// Check if the error is due to context cancellation
 			if ctx.Err() == context.Canceled {
-				log.Printf("LLM stream canceled during receive")
+				logger.InfoContext(ctx, "LLM stream canceled during receive")
 				return []action.Action{}, "", errors.New("LLM request canceled by user")
 			}
-			log.Printf("Error receiving from LLM stream: %v", err)
+			logger.ErrorContext(ctx, "failed to receive from LLM stream", "error", err)
 			return []action.Action{}, "", errors.New("Failed to receive response from LLM")
 		}
 
@@ -347,14 +351,15 @@ Again, you current task is:
 			// Always process the delta content, even if it's empty
 			// This ensures proper stream termination detection
 			fullResponseMessage += response.Choices[0].Delta.Content
-			if response.Choices[0].Delta.Content != "" {
-				fmt.Print(response.Choices[0].Delta.Content)
+			if firstToken && response.Choices[0].Delta.Content != "" {
+				firstToken = false
+				metrics.LLMTimeToFirstTokenSeconds.With(KindActions).ObserveSince(startedAt)
+				span.SetAttributes("llm.timeToFirstTokenMs", time.Since(startedAt).Milliseconds())
 			}
 		}
 	}
 
-	// Extract JSON
-	fmt.Println("\nFULL RESPONSE MESSAGE:", fullResponseMessage)
+	logger.DebugContext(ctx, "actions LLM response", "chunks", chunkCount, logging.Secret("response", fullResponseMessage))
 
 	// Parse JSON into a slice of Action objects ------------------
 	var actions []action.Action
@@ -362,11 +367,11 @@ Again, you current task is:
 	// Try to parse the full response as JSON array
 	err = json.Unmarshal([]byte(fullResponseMessage), &actions)
 	if err != nil {
-		fmt.Println("Error parsing JSON as array:", err)
+		logger.DebugContext(ctx, "actions response is not a JSON array, extracting JSON", "error", err)
 
 		// Try to extract JSON from the response using the same function as subtasks
 		jsonStrings := extractJSONFromMarkdown(fullResponseMessage)
-		log.Println("Extracted JSON strings:", jsonStrings)
+		logger.DebugContext(ctx, "extracted JSON from actions response", "count", len(jsonStrings))
 
 		if len(jsonStrings) > 0 {
 			// Try to parse each JSON string
@@ -375,21 +380,21 @@ Again, you current task is:
 				err = json.Unmarshal([]byte(jsonString), &singleAction)
 				if err == nil {
 					actions = append(actions, singleAction)
-					log.Println("Successfully parsed single action:", singleAction.Action)
+					logger.DebugContext(ctx, "parsed single action", "action", singleAction.Action)
 				} else {
 					// Try to parse as array
 					var actionArray []action.Action
 					err = json.Unmarshal([]byte(jsonString), &actionArray)
 					if err == nil {
 						actions = append(actions, actionArray...)
-						log.Println("Successfully parsed action array with", len(actionArray), "actions")
+						logger.DebugContext(ctx, "parsed action array", "actions", len(actionArray))
 					}
 				}
 			}
 		}
 
 		if len(actions) == 0 {
-			fmt.Println("No valid actions found in response, error:", err)
+			logger.WarnContext(ctx, "no valid actions found in LLM response", "error", err)
 			return []action.Action{}, "", fmt.Errorf("failed to parse any valid actions from LLM response: %v", err)
 		}
 	}
@@ -403,10 +408,14 @@ Again, you current task is:
 		return actions[i].ActionSequenceID < actions[j].ActionSequenceID
 	})
 
-	log.Printf("Successfully parsed %d actions from LLM response", len(actions))
-	for i, action := range actions {
-		log.Printf("Action %d: %s, Parameters: %+v", i+1, action.Action, action.Parameters)
-	}
+	logger.InfoContext(ctx, "parsed actions from LLM response", "count", len(actions), "actions", actionsJSONStringReturn)
 
 	return actions, actionsJSONStringReturn, nil
 }
+
+// keyNamesForPrompt lists the named keys the action executor understands
+func keyNamesForPrompt() string {
+	names := x11.KnownKeyNames()
+	sort.Strings(names)
+	return strings.Join(names, ", ")
+}
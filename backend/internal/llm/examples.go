@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"useless-agent/internal/action"
+)
+
+// maxExampleActions bounds the actions shown for one example
+const maxExampleActions = 30
+
+// Example is an action sequence that completed a similar subtask before,
+// shown to the model by SendMessageToLLM as a few-shot example
+type Example struct {
+	ID      string
+	Goal    string
+	Actions []action.Action
+}
+
+type examplesKey struct{}
+
+// WithExamples attaches few-shot examples to the actions call made with ctx
+func WithExamples(ctx context.Context, examples []Example) context.Context {
+	return context.WithValue(ctx, examplesKey{}, examples)
+}
+
+// examplesForPrompt describes the examples of ctx and the runMacro action
+// that replays one, empty if there are none
+func examplesForPrompt(ctx context.Context) string {
+	examples, _ := ctx.Value(examplesKey{}).([]Example)
+	if len(examples) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("these action sequences completed similar subtasks before, adapt them to the current screen rather than copying coordinates blindly:\n")
+	for _, e := range examples {
+		actions := e.Actions
+		if len(actions) > maxExampleActions {
+			actions = actions[:maxExampleActions]
+		}
+		data, err := json.Marshal(actions)
+		if err != nil {
+			continue
+		}
+		fmt.Fprintf(&b, "- id %s, subtask %q: %s\n", e.ID, e.Goal, data)
+	}
+	b.WriteString(`if the screen is in the same state as when an example started, you can replay its actions as they are with 'runMacro', the result is verified as usual:
+{
+  "actionSequenceID": 1,
+  "action": "runMacro",
+  "name": "` + examples[0].ID + `"
+}
+`)
+	return b.String()
+}
@@ -0,0 +1,89 @@
+package llm
+
+import (
+	"context"
+	"time"
+)
+
+// LLM call kinds, used to label recorded exchanges
+const (
+	KindActions      = "actions"
+	KindOCRDelta     = "ocrDeltaSummary"
+	KindBreakdown    = "breakdown"
+	KindReplan       = "replan"
+	KindVerification = "verification"
+)
+
+// Exchange is one request/response pair sent to the LLM
+type Exchange struct {
+	Kind      string                 `json:"kind"`
+	Request   *ChatCompletionRequest `json:"request"`
+	Response  string                 `json:"response"`
+	Error     string                 `json:"error,omitempty"`
+	StartedAt time.Time              `json:"startedAt"`
+	Duration  time.Duration          `json:"duration"`
+
+	// EstimatedTokens is the prompt size estimate counted for the call
+	EstimatedTokens int `json:"estimatedTokens,omitempty"`
+}
+
+// ExchangeObserver receives every exchange made with a context carrying it
+type ExchangeObserver func(Exchange)
+
+type exchangeObserverKey struct{}
+
+// WithExchangeObserver returns a context whose LLM calls are reported to observer
+func WithExchangeObserver(ctx context.Context, observer ExchangeObserver) context.Context {
+	return context.WithValue(ctx, exchangeObserverKey{}, observer)
+}
+
+// notifyExchange reports an exchange to the observer carried by ctx, if any
+func notifyExchange(ctx context.Context, kind string, req *ChatCompletionRequest, response string, err error, startedAt time.Time, estimatedTokens int) {
+	observer, ok := ctx.Value(exchangeObserverKey{}).(ExchangeObserver)
+	if !ok || observer == nil {
+		return
+	}
+
+	ex := Exchange{
+		Kind:      kind,
+		Request:   req,
+		Response:  response,
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt),
+
+		EstimatedTokens: estimatedTokens,
+	}
+	if err != nil {
+		ex.Error = err.Error()
+	}
+	observer(ex)
+}
+
+type callKindKey struct{}
+
+// withCallKind labels the LLM call made with ctx, so a replay client can
+// answer with a recorded response of the same kind
+func withCallKind(ctx context.Context, kind string) context.Context {
+	return context.WithValue(ctx, callKindKey{}, kind)
+}
+
+func callKind(ctx context.Context) string {
+	kind, _ := ctx.Value(callKindKey{}).(string)
+	return kind
+}
+
+type clientKey struct{}
+
+// WithClient returns a context whose LLM calls use client instead of the
+// global one, e.g. a ReplayClient for offline replay
+func WithClient(ctx context.Context, client Client) context.Context {
+	return context.WithValue(ctx, clientKey{}, client)
+}
+
+// clientFor returns the client carried by ctx, or the global client
+func clientFor(ctx context.Context) Client {
+	if client, ok := ctx.Value(clientKey{}).(Client); ok && client != nil {
+		return client
+	}
+	return GetLLMClient()
+}
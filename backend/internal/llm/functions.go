@@ -4,17 +4,19 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"log"
 	"strconv"
 	"strings"
+	"time"
 
 	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
 )
 
 // SubTask represents a subtask in the goal breakdown (local copy to avoid import cycle)
 type SubTask struct {
-	Id          int    `json:"id"`
-	Description string `json:"description"`
+	Id             int             `json:"id"`
+	Description    string          `json:"description"`
+	Postconditions json.RawMessage `json:"postconditions,omitempty"` // decoded by the task package
 }
 
 // Verdict represents goal achievement verdict
@@ -25,11 +27,11 @@ type Verdict struct {
 }
 
 // GetOCRDeltaAbstractDescription gets an abstract description of OCR changes
-func GetOCRDeltaAbstractDescription(ocrDelta string, addTokensAndSendUpdate func(int)) (abstractDescription string, err error) {
+func GetOCRDeltaAbstractDescription(ctx context.Context, ocrDelta string, addTokensAndSendUpdate func(int)) (abstractDescription string, err error) {
 	// Get LLM client
-	client := GetLLMClient()
+	client := clientFor(ctx)
 	if client == nil {
-		log.Printf("LLM client not initialized")
+		logger.ErrorContext(ctx, "LLM client not initialized")
 		return "", fmt.Errorf("LLM client not initialized")
 	}
 
@@ -101,12 +103,13 @@ func GetOCRDeltaAbstractDescription(ocrDelta string, addTokensAndSendUpdate func
 	}
 
 	estimate := client.EstimateTokensFromMessages(messages)
-	fmt.Printf("Estimated total tokens[getOCRDeltaAbstractDescription][input]: %d\n", estimate.EstimatedTokens)
+	logger.DebugContext(ctx, "estimated input tokens", "call", KindOCRDelta, "tokens", estimate.EstimatedTokens)
 	addTokensAndSendUpdate(estimate.EstimatedTokens)
+	observeTokens(ctx, KindOCRDelta, estimate.EstimatedTokens)
 
 	// Get configuration for model
 	cfg := config.GetLLMConfig()
-	model := cfg.Model
+	model := modelFor(ctx, cfg.Model)
 	if model == "" {
 		switch cfg.Provider {
 		case "deepseek":
@@ -116,38 +119,35 @@ func GetOCRDeltaAbstractDescription(ocrDelta string, addTokensAndSendUpdate func
 		}
 	}
 
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		&ChatCompletionRequest{
-			Model:       model,
-			Temperature: 1.0,
-			MaxTokens:   8192,
-			Messages:    messages,
-			Stream:      false,
-			JSONMode:    true,
-		},
-	)
+	req := &ChatCompletionRequest{
+		Model:       model,
+		Temperature: 1.0,
+		MaxTokens:   8192,
+		Messages:    messages,
+		Stream:      false,
+		JSONMode:    true,
+	}
+	startedAt := time.Now()
+	callCtx, span := startCall(ctx, KindOCRDelta, req, estimate.EstimatedTokens)
+	resp, err := client.CreateChatCompletion(withCallKind(callCtx, KindOCRDelta), req)
+	notifyExchange(ctx, KindOCRDelta, req, responseContent(resp), err, startedAt, estimate.EstimatedTokens)
+	finishCall(span, KindOCRDelta, startedAt, responseContent(resp), err)
 	if err != nil {
-		log.Printf("Failed to create LLM completion for OCR delta abstract: %v", err)
+		logger.ErrorContext(ctx, "LLM completion for OCR delta summary failed", "error", err)
 		return "", err
 	}
 
-	fmt.Println(resp.Choices[0].Message.Content)
-
 	deltaJSONString := resp.Choices[0].Message.Content
-
-	fmt.Println("ocr abstract Delta:")
-	fmt.Println(deltaJSONString)
-	fmt.Println("ocr abstract Delta end:")
+	logger.DebugContext(ctx, "OCR delta summary", logging.Secret("summary", deltaJSONString))
 	return deltaJSONString, nil
 }
 
 // BreakGoalIntoSubtasks breaks down a goal into smaller subtasks
-func BreakGoalIntoSubtasks(goal string, addTokensAndSendUpdate func(int)) ([]SubTask, error) {
+func BreakGoalIntoSubtasks(ctx context.Context, goal string, addTokensAndSendUpdate func(int)) ([]SubTask, error) {
 	// Get LLM client
-	client := GetLLMClient()
+	client := clientFor(ctx)
 	if client == nil {
-		log.Printf("LLM client not initialized")
+		logger.ErrorContext(ctx, "LLM client not initialized")
 		return nil, fmt.Errorf("LLM client not initialized")
 	}
 
@@ -158,17 +158,18 @@ func BreakGoalIntoSubtasks(goal string, addTokensAndSendUpdate func(int)) ([]Sub
 		},
 		{
 			Role:    RoleUser,
-			Content: ` Break down user provided goal into primitive tasks which program can execute and easily verify. Do not break very simple goal into tasks(example of simple goal:"press alt + F4 hotkeys"). Context: Linux desktop, xfce4, X11. IMPORTANT: You must return a JSON ARRAY, not a single object. Example: [{"id": 1, "description": "click on applications menu button"}, {"id": 2, "description": "click on 'web browser' submenu or something similar, there could be 'internet'->'firefox' submenus"}, {"id": 3, "description": "move cursor to the middle of Firefox header"}, {"id": 4, "description": "drag firefox window by header and move it to the left side of the screen"}]. User provided goal is: ` + goal,
+			Content: ` Break down user provided goal into primitive tasks which program can execute and easily verify. Do not break very simple goal into tasks(example of simple goal:"press alt + F4 hotkeys"). Context: Linux desktop, xfce4, X11. IMPORTANT: You must return a JSON ARRAY, not a single object. Example: [{"id": 1, "description": "click on applications menu button"}, {"id": 2, "description": "click on 'web browser' submenu or something similar, there could be 'internet'->'firefox' submenus"}, {"id": 3, "description": "move cursor to the middle of Firefox header"}, {"id": 4, "description": "drag firefox window by header and move it to the left side of the screen"}]. If the outcome of a task can be verified by the program without looking at the screen, you may add an optional "postconditions" array to it, each element is one of: {"type": "windowVisible", "windowClass": "firefox"}, {"type": "textPresent", "text": "Saved"}, {"type": "fileExists", "path": "/tmp/report.pdf"}, {"type": "processRunning", "process": "xfce4-terminal"}, {"type": "clipboardMatches", "pattern": "^https://"}. Only add postconditions you are sure about. User provided goal is: ` + goal + hintsForPrompt(ctx),
 		},
 	}
 
 	estimate := client.EstimateTokensFromMessages(messages)
-	fmt.Printf("Estimated total tokens[breakGoalIntoSubtasks][input]: %d\n", estimate.EstimatedTokens)
+	logger.DebugContext(ctx, "estimated input tokens", "call", KindBreakdown, "tokens", estimate.EstimatedTokens)
 	addTokensAndSendUpdate(estimate.EstimatedTokens)
+	observeTokens(ctx, KindBreakdown, estimate.EstimatedTokens)
 
 	// Get configuration for model
 	cfg := config.GetLLMConfig()
-	model := cfg.Model
+	model := modelFor(ctx, cfg.Model)
 	if model == "" {
 		switch cfg.Provider {
 		case "deepseek":
@@ -178,25 +179,112 @@ func BreakGoalIntoSubtasks(goal string, addTokensAndSendUpdate func(int)) ([]Sub
 		}
 	}
 
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		&ChatCompletionRequest{
-			Model:       model,
-			Temperature: 0.5,
-			MaxTokens:   2000,
-			Messages:    messages,
-			Stream:      false,
-			JSONMode:    true,
+	req := &ChatCompletionRequest{
+		Model:       model,
+		Temperature: 0.5,
+		MaxTokens:   2000,
+		Messages:    messages,
+		Stream:      false,
+		JSONMode:    true,
+	}
+	startedAt := time.Now()
+	callCtx, span := startCall(ctx, KindBreakdown, req, estimate.EstimatedTokens)
+	resp, err := client.CreateChatCompletion(withCallKind(callCtx, KindBreakdown), req)
+	notifyExchange(ctx, KindBreakdown, req, responseContent(resp), err, startedAt, estimate.EstimatedTokens)
+	finishCall(span, KindBreakdown, startedAt, responseContent(resp), err)
+	if err != nil {
+		logger.ErrorContext(ctx, "LLM completion for subtask breakdown failed", "error", err)
+		return nil, err
+	}
+
+	logger.DebugContext(ctx, "subtask breakdown response", logging.Secret("response", resp.Choices[0].Message.Content))
+	subtasks, err := parseSubtasks(resp.Choices[0].Message.Content)
+	if err != nil {
+		// As a fallback, return a single subtask with original goal
+		logger.WarnContext(ctx, "failed to parse subtasks, using the goal as the only subtask", "error", err)
+		subtasks = []SubTask{{Id: 1, Description: goal}}
+	}
+
+	logger.InfoContext(ctx, "broke goal into subtasks", "count", len(subtasks), "subtasks", subtaskDescriptions(subtasks))
+	return subtasks, nil
+}
+
+// ReplanSubtasks asks the planner for a revised list of remaining subtasks
+// after the current one stalled. completed holds the subtasks already done,
+// remaining starts with the stalled subtask.
+func ReplanSubtasks(ctx context.Context, goal string, completed []SubTask, remaining []SubTask, stallReason string, ocrJSONString string, x11WindowsData string, addTokensAndSendUpdate func(int)) ([]SubTask, error) {
+	// Get LLM client
+	client := clientFor(ctx)
+	if client == nil {
+		logger.ErrorContext(ctx, "LLM client not initialized")
+		return nil, fmt.Errorf("LLM client not initialized")
+	}
+
+	completedJSON, _ := json.Marshal(completed)
+	remainingJSON, _ := json.Marshal(remaining)
+
+	messages := []Message{
+		{
+			Role:    RoleSystem,
+			Content: "You are a helpful assistant. " + ` Output only valid JSON. You MUST return a JSON ARRAY of objects with this exact structure: [{"id": int, "description": string}]. Do NOT return a single object, always return an array even if there's only one task.`,
 		},
-	)
+		{
+			Role:    RoleUser,
+			Content: ` You are re-planning a linux desktop task (xfce4, X11) which got stuck. The original user goal is: ` + goal + ` Already completed tasks: ` + string(completedJSON) + ` Remaining plan, the first task is the one that is stuck: ` + string(remainingJSON) + ` Why it is considered stuck: ` + stallReason + ` Current OCR data of the screen: ` + ocrJSONString + ` X11 API-detected windows: ` + x11WindowsData + ` Produce a revised list of the REMAINING primitive tasks needed to reach the original goal from the current screen state. Do not repeat the completed tasks. Do not repeat the stuck task in the same form, find a different way to achieve it (for example hotkeys instead of menus, a terminal command instead of a GUI, or smaller steps). Each task must be easy for a program to execute and verify.`,
+		},
+	}
+
+	estimate := client.EstimateTokensFromMessages(messages)
+	logger.DebugContext(ctx, "estimated input tokens", "call", KindReplan, "tokens", estimate.EstimatedTokens)
+	addTokensAndSendUpdate(estimate.EstimatedTokens)
+	observeTokens(ctx, KindReplan, estimate.EstimatedTokens)
+
+	// Get configuration for model
+	cfg := config.GetLLMConfig()
+	model := modelFor(ctx, cfg.Model)
+	if model == "" {
+		switch cfg.Provider {
+		case "deepseek":
+			model = "deepseek-chat"
+		case "zai":
+			model = "glm-4.6"
+		}
+	}
+
+	req := &ChatCompletionRequest{
+		Model:       model,
+		Temperature: 0.7,
+		MaxTokens:   2000,
+		Messages:    messages,
+		Stream:      false,
+		JSONMode:    true,
+	}
+	startedAt := time.Now()
+	callCtx, span := startCall(ctx, KindReplan, req, estimate.EstimatedTokens)
+	resp, err := client.CreateChatCompletion(withCallKind(callCtx, KindReplan), req)
+	notifyExchange(ctx, KindReplan, req, responseContent(resp), err, startedAt, estimate.EstimatedTokens)
+	finishCall(span, KindReplan, startedAt, responseContent(resp), err)
 	if err != nil {
-		log.Printf("Failed to create LLM completion for subtask breakdown: %v", err)
+		logger.ErrorContext(ctx, "LLM completion for replanning failed", "error", err)
 		return nil, err
 	}
 
-	log.Println("\n\nresp(must be json):", resp)
-	jsonStrings := extractJSONFromMarkdown(resp.Choices[0].Message.Content)
-	log.Println("\n\njsonStrings:", jsonStrings)
+	subtasks, err := parseSubtasks(resp.Choices[0].Message.Content)
+	if err != nil {
+		return nil, err
+	}
+	if len(subtasks) == 0 {
+		return nil, fmt.Errorf("planner returned an empty plan")
+	}
+
+	logger.InfoContext(ctx, "replanned remaining subtasks", "count", len(subtasks), "subtasks", subtaskDescriptions(subtasks))
+	return subtasks, nil
+}
+
+// parseSubtasks extracts a subtask list from a planner response, accepting
+// markdown fenced JSON, stray text around the JSON and a single object
+func parseSubtasks(content string) ([]SubTask, error) {
+	jsonStrings := extractJSONFromMarkdown(content)
 
 	// Use the first valid JSON string found, don't join multiple JSON objects
 	var s string
@@ -204,58 +292,47 @@ func BreakGoalIntoSubtasks(goal string, addTokensAndSendUpdate func(int)) ([]Sub
 		s = jsonStrings[0] // Use the first valid JSON string
 	} else {
 		// If no JSON found in markdown, try the raw response
-		s = resp.Choices[0].Message.Content
+		s = content
 	}
 
-	log.Println("Raw JSON string before processing:", s)
-
-	subtasks := make([]SubTask, 0, 10_000)
+	var subtasks []SubTask
 
 	// First, try to unmarshal as an array directly
-	err = json.Unmarshal([]byte(s), &subtasks)
-	if err != nil {
-		log.Println("failed to unmarshal subtasks from byte string to struct:", err)
-
-		// Try to clean JSON string by removing any non-JSON content
-		cleanedJSON := cleanJSONString(s)
-		log.Println("Cleaned JSON string:", cleanedJSON)
-
-		if cleanedJSON != "" {
-			err = json.Unmarshal([]byte(cleanedJSON), &subtasks)
-			if err != nil {
-				log.Println("failed to unmarshal cleaned subtasks:", err)
-
-				// Try to handle case where LLM returned a single object instead of array
-				var singleSubtask SubTask
-				err = json.Unmarshal([]byte(cleanedJSON), &singleSubtask)
-				if err == nil {
-					// If single object unmarshaling works, wrap it in an array
-					subtasks = []SubTask{singleSubtask}
-					log.Println("Successfully converted single object to array")
-				} else {
-					log.Println("failed to unmarshal as single object too:", err)
-					// As a fallback, return a single subtask with original goal
-					subtasks = []SubTask{{Id: 1, Description: goal}}
-					log.Println("Using fallback subtask with original goal")
-				}
-			}
-		} else {
-			// As a fallback, return a single subtask with original goal
-			subtasks = []SubTask{{Id: 1, Description: goal}}
-			log.Println("Using fallback subtask with original goal (no valid JSON)")
+	err := json.Unmarshal([]byte(s), &subtasks)
+	if err == nil {
+		return subtasks, nil
+	}
+	logger.Debug("planner response is not a subtask array", "error", err)
+
+	// extractJSONFromMarkdown only finds the first object of an unfenced
+	// array, so retry on the full response
+	if arrayStart, arrayEnd := strings.Index(content, "["), strings.LastIndex(content, "]"); arrayStart != -1 && arrayEnd > arrayStart {
+		if err = json.Unmarshal([]byte(content[arrayStart:arrayEnd+1]), &subtasks); err == nil {
+			return subtasks, nil
 		}
 	}
 
-	log.Printf("Successfully parsed %d subtasks: %+v\n", len(subtasks), subtasks)
-	return subtasks, nil
+	// Try to clean JSON string by removing any non-JSON content
+	cleanedJSON := cleanJSONString(s)
+	if cleanedJSON == "" {
+		return nil, fmt.Errorf("no valid JSON in planner response")
+	}
+
+	// Try to handle case where LLM returned a single object instead of array
+	var singleSubtask SubTask
+	if err = json.Unmarshal([]byte(cleanedJSON), &singleSubtask); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal subtasks: %w", err)
+	}
+	logger.Debug("planner returned a single subtask object")
+	return []SubTask{singleSubtask}, nil
 }
 
 // IsGoalAchieved checks if the goal has been achieved
-func IsGoalAchieved(goal string, bboxes string, ocrJSONString string, ocrDelta string, ocrDeltaAbstract string, prevActionsJSONString string, iteration int64, prevCursorPositionJSONString string, allWindowsJSONString string, currentCursorPosition string, ocrDataNearTheCursor string, colorsDistributionBeforeAction string, colorsDistribution string, addTokensAndSendUpdate func(int)) (bool, string, string) {
+func IsGoalAchieved(ctx context.Context, goal string, bboxes string, ocrJSONString string, ocrDelta string, ocrDeltaAbstract string, prevActionsJSONString string, iteration int64, prevCursorPositionJSONString string, allWindowsJSONString string, currentCursorPosition string, ocrDataNearTheCursor string, colorsDistributionBeforeAction string, colorsDistribution string, postconditionResults string, addTokensAndSendUpdate func(int)) (bool, string, string) {
 	// Get LLM client
-	client := GetLLMClient()
+	client := clientFor(ctx)
 	if client == nil {
-		log.Printf("LLM client not initialized")
+		logger.ErrorContext(ctx, "LLM client not initialized")
 		return false, "LLM client not initialized", ""
 	}
 
@@ -266,17 +343,18 @@ func IsGoalAchieved(goal string, bboxes string, ocrJSONString string, ocrDelta s
 		},
 		{
 			Role:    RoleUser,
-			Content: "Let's say you using linux desktop, xfce4, X11, your goal is: " + goal + ", here current state of the desktop(what you see): " + " OCR delta: " + ocrDelta + " Bounding boxes: " + bboxes + " OCR data: " + ocrJSONString + " Summary of OCR delta: " + ocrDeltaAbstract + " Previous top 10 colors on the screen: " + colorsDistributionBeforeAction + " Current top 10 colors on the screen: " + colorsDistribution + " Previous iteration cursor position: " + prevCursorPositionJSONString + " Current cursor position: " + currentCursorPosition + " And here is OCR data near the cursor(bounding box is full window width but starts 23 pixels above the cursor and ends 23 pixels below the cursor): " + ocrDataNearTheCursor + " Detected windows: " + allWindowsJSONString + " Previous actions: " + prevActionsJSONString + " Current iteration: " + strconv.FormatInt(iteration, 10) + " Very important: analize ocr data, ocr delta and ocr abstract delta, those data mosly like will show you if goal was acomplished because they will contain new text data that appeared on the screen or removed from the screen. You can not ignore evidence from ocr input data, especially from abstract ocr delta. You goal as a reviwer not to find evidence that action mentions in the task was executed, but that this action leads to the desiared outcome, and if that's true, then the task is completed. For example when task was to click on some submenu, you should focus if data shows that application you wanted to start by doing that is started or not. And do not complicate easy tasks which have very high chance of success, like clicking a mouse button is almost always 100 percent success. Let's assume that OCR and other input data is relieble. Did you acomplished the task?",
+			Content: "Let's say you using linux desktop, xfce4, X11, your goal is: " + goal + ", here current state of the desktop(what you see): " + " OCR delta: " + ocrDelta + " Bounding boxes: " + bboxes + " OCR data: " + ocrJSONString + " Summary of OCR delta: " + ocrDeltaAbstract + " Previous top 10 colors on the screen: " + colorsDistributionBeforeAction + " Current top 10 colors on the screen: " + colorsDistribution + " Previous iteration cursor position: " + prevCursorPositionJSONString + " Current cursor position: " + currentCursorPosition + " And here is OCR data near the cursor(bounding box is full window width but starts 23 pixels above the cursor and ends 23 pixels below the cursor): " + ocrDataNearTheCursor + " Detected windows: " + allWindowsJSONString + " Previous actions: " + prevActionsJSONString + postconditionResultsForPrompt(postconditionResults) + " Current iteration: " + strconv.FormatInt(iteration, 10) + " Very important: analize ocr data, ocr delta and ocr abstract delta, those data mosly like will show you if goal was acomplished because they will contain new text data that appeared on the screen or removed from the screen. You can not ignore evidence from ocr input data, especially from abstract ocr delta. You goal as a reviwer not to find evidence that action mentions in the task was executed, but that this action leads to the desiared outcome, and if that's true, then the task is completed. For example when task was to click on some submenu, you should focus if data shows that application you wanted to start by doing that is started or not. And do not complicate easy tasks which have very high chance of success, like clicking a mouse button is almost always 100 percent success. Let's assume that OCR and other input data is relieble. Did you acomplished the task?",
 		},
 	}
 
 	estimate := client.EstimateTokensFromMessages(messages)
-	fmt.Printf("Estimated total tokens[isGoalAchieved][input]: %d\n", estimate.EstimatedTokens)
+	logger.DebugContext(ctx, "estimated input tokens", "call", KindVerification, "tokens", estimate.EstimatedTokens)
 	addTokensAndSendUpdate(estimate.EstimatedTokens)
+	observeTokens(ctx, KindVerification, estimate.EstimatedTokens)
 
 	// Get configuration for model
 	cfg := config.GetLLMConfig()
-	model := cfg.Model
+	model := modelFor(ctx, cfg.Model)
 	if model == "" {
 		switch cfg.Provider {
 		case "deepseek":
@@ -286,39 +364,63 @@ func IsGoalAchieved(goal string, bboxes string, ocrJSONString string, ocrDelta s
 		}
 	}
 
-	resp, err := client.CreateChatCompletion(
-		context.Background(),
-		&ChatCompletionRequest{
-			Model:       model,
-			Temperature: 0.3,
-			MaxTokens:   2000,
-			Messages:    messages,
-			Stream:      false,
-			JSONMode:    true,
-		},
-	)
+	req := &ChatCompletionRequest{
+		Model:       model,
+		Temperature: 0.3,
+		MaxTokens:   2000,
+		Messages:    messages,
+		Stream:      false,
+		JSONMode:    true,
+	}
+	startedAt := time.Now()
+	callCtx, span := startCall(ctx, KindVerification, req, estimate.EstimatedTokens)
+	resp, err := client.CreateChatCompletion(withCallKind(callCtx, KindVerification), req)
+	notifyExchange(ctx, KindVerification, req, responseContent(resp), err, startedAt, estimate.EstimatedTokens)
+	finishCall(span, KindVerification, startedAt, responseContent(resp), err)
 	if err != nil {
-		log.Printf("Failed to create LLM completion for goal achievement check: %v", err)
+		logger.ErrorContext(ctx, "LLM completion for goal verification failed", "error", err)
 		return false, "Failed to create LLM completion", ""
 	}
 
 	jsonStrings := extractJSONFromMarkdown(resp.Choices[0].Message.Content)
-	log.Println("\n\njsonStrings:", jsonStrings)
 	s := strings.Join(jsonStrings[:], ",")
 
 	data := Verdict{}
 	err = json.Unmarshal([]byte(s), &data)
 	if err != nil {
-		log.Println("failed to unmarshal verdict from byte string to struct:", err)
+		logger.WarnContext(ctx, "failed to parse verdict", "error", err, logging.Secret("response", resp.Choices[0].Message.Content))
 	}
-
-	fmt.Println("is goal achieved function result string:", s)
+	logger.InfoContext(ctx, "verification verdict", "achieved", data.IsGoalAchieved, "description", data.Description)
 
 	return data.IsGoalAchieved, data.Description, data.NewPrompt
 }
 
 // Helper functions
 
+// subtaskDescriptions lists subtask descriptions for logging
+func subtaskDescriptions(subtasks []SubTask) []string {
+	descriptions := make([]string, len(subtasks))
+	for i, st := range subtasks {
+		descriptions[i] = st.Description
+	}
+	return descriptions
+}
+
+// responseContent returns the text of the first choice, or "" for an empty response
+func responseContent(resp *ChatCompletionResponse) string {
+	if resp == nil || len(resp.Choices) == 0 {
+		return ""
+	}
+	return resp.Choices[0].Message.Content
+}
+
+func postconditionResultsForPrompt(results string) string {
+	if results == "" {
+		return ""
+	}
+	return " Deterministic postcondition checks computed by the program (these are reliable, treat them as facts): " + results
+}
+
 func extractJSONFromMarkdown(content string) []string {
 	var jsonStrings []string
 	lines := strings.Split(content, "\n")
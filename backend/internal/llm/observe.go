@@ -0,0 +1,52 @@
+package llm
+
+import (
+	"context"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/metrics"
+	"useless-agent/internal/tracing"
+)
+
+// startCall starts the span of an LLM call, the client gets the returned
+// context so its work nests under the call
+func startCall(ctx context.Context, kind string, req *ChatCompletionRequest, estimatedTokens int) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, "llm."+kind,
+		"llm.kind", kind,
+		"llm.model", req.Model,
+		"llm.stream", req.Stream,
+		"llm.estimatedPromptTokens", estimatedTokens,
+	)
+}
+
+// finishCall records the duration and result of an LLM call and ends its span
+func finishCall(span *tracing.Span, kind string, startedAt time.Time, response string, err error) {
+	result := "ok"
+	if err != nil {
+		result = "error"
+	}
+	metrics.LLMRequestSeconds.With(kind, result).ObserveSince(startedAt)
+
+	span.SetAttributes("llm.responseChars", len(response), "llm.result", result)
+	span.RecordError(err)
+	span.End()
+}
+
+// observeTokens counts the estimated prompt tokens of a call under the
+// configured provider and model
+func observeTokens(ctx context.Context, kind string, tokens int) {
+	cfg := config.GetLLMConfig()
+	model := modelFor(ctx, cfg.Model)
+	if model == "" {
+		switch cfg.Provider {
+		case "deepseek":
+			model = "deepseek-chat"
+		case "zai":
+			model = "glm-4.6"
+		default:
+			model = cfg.Provider
+		}
+	}
+	metrics.LLMTokens.With(cfg.Provider, model, kind).Add(float64(tokens))
+}
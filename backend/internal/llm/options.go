@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"context"
+	"strings"
+
+	"useless-agent/internal/skill"
+)
+
+type modelKey struct{}
+
+// WithModel returns a context whose LLM calls ask for model instead of the
+// configured one, e.g. the model a task template names
+func WithModel(ctx context.Context, model string) context.Context {
+	return context.WithValue(ctx, modelKey{}, model)
+}
+
+// modelFor returns the model carried by ctx, or configured
+func modelFor(ctx context.Context, configured string) string {
+	if model, _ := ctx.Value(modelKey{}).(string); model != "" {
+		return model
+	}
+	return configured
+}
+
+type hintsKey struct{}
+
+// WithHints attaches an operator's hints about a task to the planning and
+// actions prompts made with ctx
+func WithHints(ctx context.Context, hints []string) context.Context {
+	return context.WithValue(ctx, hintsKey{}, hints)
+}
+
+// hintsForPrompt lists the hints of ctx, empty if there are none
+func hintsForPrompt(ctx context.Context) string {
+	hints, _ := ctx.Value(hintsKey{}).([]string)
+	if len(hints) == 0 {
+		return ""
+	}
+	return "\nHints from the operator about this task:\n- " + strings.Join(hints, "\n- ") + "\n"
+}
+
+type skillsKey struct{}
+
+// WithSkillsSection fixes the skills part of the actions prompts made with
+// ctx, so a replay shows the model the skills stored when it was recorded
+func WithSkillsSection(ctx context.Context, section string) context.Context {
+	return context.WithValue(ctx, skillsKey{}, section)
+}
+
+// skillsForPrompt returns the skills section of ctx, or the one of the
+// skills stored now
+func skillsForPrompt(ctx context.Context) string {
+	if section, ok := ctx.Value(skillsKey{}).(string); ok {
+		return section
+	}
+	return skill.PromptSection()
+}
@@ -0,0 +1,230 @@
+package llm
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// ReplayProvider serves LLM responses recorded in a trajectory bundle instead
+// of calling a model. BaseURL is the path of the bundle directory or zip.
+type ReplayProvider struct{}
+
+func NewReplayProvider() *ReplayProvider {
+	return &ReplayProvider{}
+}
+
+func (p *ReplayProvider) Name() string {
+	return "replay"
+}
+
+func (p *ReplayProvider) CreateClient(config ProviderConfig) (Client, error) {
+	if config.BaseURL == "" {
+		return nil, fmt.Errorf("replay provider needs a trajectory bundle path")
+	}
+	exchanges, err := LoadRecordedExchanges(config.BaseURL)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplayClient(exchanges), nil
+}
+
+// ReplayClient answers each call with the next unused recorded response of
+// the same kind, so the replayed run diverging from the recording (e.g. one
+// verification less) does not shift the responses of other call kinds
+type ReplayClient struct {
+	exchanges []Exchange
+	used      []bool
+	mutex     sync.Mutex
+}
+
+// NewReplayClient creates a client serving the given exchanges in order
+func NewReplayClient(exchanges []Exchange) *ReplayClient {
+	return &ReplayClient{
+		exchanges: exchanges,
+		used:      make([]bool, len(exchanges)),
+	}
+}
+
+// LoadRecordedExchanges reads the llm/*.json files of a trajectory bundle,
+// which is either a directory or a zip archive, in recording order
+func LoadRecordedExchanges(bundlePath string) ([]Exchange, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trajectory bundle: %w", err)
+	}
+
+	files := make(map[string]func() (io.ReadCloser, error))
+	if info.IsDir() {
+		matches, err := filepath.Glob(filepath.Join(bundlePath, "llm", "*.json"))
+		if err != nil {
+			return nil, err
+		}
+		for _, m := range matches {
+			name := m
+			files[filepath.Base(m)] = func() (io.ReadCloser, error) { return os.Open(name) }
+		}
+		return readExchanges(files)
+	}
+
+	zr, err := zip.OpenReader(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trajectory zip: %w", err)
+	}
+	defer zr.Close()
+	for _, f := range zr.File {
+		if path.Base(path.Dir(f.Name)) == "llm" && path.Ext(f.Name) == ".json" {
+			files[path.Base(f.Name)] = f.Open
+		}
+	}
+	return readExchanges(files)
+}
+
+// readExchanges decodes exchange files; their NNNN- prefix gives the call order
+func readExchanges(files map[string]func() (io.ReadCloser, error)) ([]Exchange, error) {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	exchanges := make([]Exchange, 0, len(names))
+	for _, name := range names {
+		rc, err := files[name]()
+		if err != nil {
+			return nil, err
+		}
+		var ex Exchange
+		err = json.NewDecoder(rc).Decode(&ex)
+		rc.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode recorded exchange %s: %w", name, err)
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
+
+func (c *ReplayClient) Close() error {
+	return nil
+}
+
+// next returns the next unused recorded response for the call kind in ctx
+func (c *ReplayClient) next(ctx context.Context) (Exchange, error) {
+	kind := callKind(ctx)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for i, ex := range c.exchanges {
+		if c.used[i] || (kind != "" && ex.Kind != kind) {
+			continue
+		}
+		c.used[i] = true
+		return ex, nil
+	}
+	return Exchange{}, fmt.Errorf("replay: no recorded %q response left", kind)
+}
+
+// Remaining returns the number of recorded responses not served yet
+func (c *ReplayClient) Remaining() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	n := 0
+	for _, used := range c.used {
+		if !used {
+			n++
+		}
+	}
+	return n
+}
+
+func (c *ReplayClient) CreateChatCompletion(ctx context.Context, req *ChatCompletionRequest) (*ChatCompletionResponse, error) {
+	ex, err := c.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if ex.Error != "" {
+		return nil, fmt.Errorf("replay: recorded error: %s", ex.Error)
+	}
+
+	result := &ChatCompletionResponse{}
+	result.Choices = append(result.Choices, struct {
+		Message struct {
+			Content string `json:"content"`
+		} `json:"message"`
+	}{
+		Message: struct {
+			Content string `json:"content"`
+		}{
+			Content: ex.Response,
+		},
+	})
+	return result, nil
+}
+
+func (c *ReplayClient) CreateChatCompletionStream(ctx context.Context, req *ChatCompletionRequest) (ChatCompletionStream, error) {
+	ex, err := c.next(ctx)
+	if err != nil {
+		return nil, err
+	}
+	// A recorded stream may have failed after delivering a partial response,
+	// replay the content and let the caller's parser decide
+	if ex.Error != "" && ex.Response == "" {
+		return nil, fmt.Errorf("replay: recorded error: %s", ex.Error)
+	}
+	return &ReplayStream{content: ex.Response}, nil
+}
+
+func (c *ReplayClient) EstimateTokensFromMessages(messages []Message) *TokenEstimate {
+	totalChars := 0
+	for _, msg := range messages {
+		totalChars += len(msg.Content)
+	}
+
+	estimatedTokens := totalChars / 4
+	if estimatedTokens < 1 {
+		estimatedTokens = 1
+	}
+
+	return &TokenEstimate{
+		EstimatedTokens: estimatedTokens,
+	}
+}
+
+// ReplayStream delivers a recorded response as a single chunk
+type ReplayStream struct {
+	content string
+	done    bool
+}
+
+func (s *ReplayStream) Recv() (*ChatCompletionStreamResponse, error) {
+	if s.done {
+		return nil, io.EOF
+	}
+	s.done = true
+
+	result := &ChatCompletionStreamResponse{}
+	result.Choices = append(result.Choices, struct {
+		Delta struct {
+			Content string `json:"content"`
+		} `json:"delta"`
+	}{
+		Delta: struct {
+			Content string `json:"content"`
+		}{
+			Content: s.content,
+		},
+	})
+	return result, nil
+}
+
+func (s *ReplayStream) Close() error {
+	return nil
+}
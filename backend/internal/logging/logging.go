@@ -0,0 +1,439 @@
+// Package logging is the structured logger of the agent. Every package gets a
+// component logger with For; records carry the task, subtask and iteration
+// from the context and go to the console, an optional rotating JSON file and
+// to subscribers such as the WebSocket log channel.
+package logging
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/config"
+)
+
+// Options configures the sinks and levels
+type Options struct {
+	// Level applies to components without an entry in ComponentLevels
+	Level           slog.Level
+	ComponentLevels map[string]slog.Level
+	// Console receives human-readable records, os.Stdout (looked up at every
+	// write) if nil
+	Console io.Writer
+	// File, if set, receives JSON records and is rotated at MaxSizeMB
+	File       string
+	MaxSizeMB  int
+	MaxBackups int
+}
+
+// state is the active configuration, replaced as a whole by Setup
+type state struct {
+	level           slog.Level
+	componentLevels map[string]slog.Level
+	console         slog.Handler
+	file            slog.Handler
+	fileWriter      *rotatingFile
+}
+
+var (
+	current     *state
+	stateMutex  sync.RWMutex
+	subscribers = make(map[int]func(Entry))
+	nextSubID   int
+	subMutex    sync.RWMutex
+)
+
+func init() {
+	current = newState(Options{Level: slog.LevelInfo}, nil)
+	slog.SetDefault(slog.New(&handler{}))
+}
+
+func newState(opts Options, file *rotatingFile) *state {
+	console := opts.Console
+	if console == nil {
+		console = stdout{}
+	}
+	// Levels are decided by handler.Enabled, the sinks accept everything
+	s := &state{
+		level:           opts.Level,
+		componentLevels: opts.ComponentLevels,
+		console:         slog.NewTextHandler(console, &slog.HandlerOptions{Level: slog.LevelDebug - 4}),
+		fileWriter:      file,
+	}
+	if file != nil {
+		s.file = slog.NewJSONHandler(file, &slog.HandlerOptions{Level: slog.LevelDebug - 4})
+	}
+	return s
+}
+
+// Setup replaces the sinks and levels, closing a previously opened log file
+func Setup(opts Options) error {
+	var file *rotatingFile
+	if opts.File != "" {
+		var err error
+		file, err = openRotatingFile(opts.File, int64(opts.MaxSizeMB)*1024*1024, opts.MaxBackups)
+		if err != nil {
+			return err
+		}
+	}
+
+	stateMutex.Lock()
+	previous := current
+	current = newState(opts, file)
+	stateMutex.Unlock()
+
+	if previous.fileWriter != nil {
+		previous.fileWriter.Close()
+	}
+	return nil
+}
+
+// OptionsFromFlags builds the options from the -log-* command line flags
+func OptionsFromFlags() (Options, error) {
+	level, err := ParseLevel(*config.LogLevel)
+	if err != nil {
+		return Options{}, err
+	}
+	componentLevels, err := ParseComponentLevels(*config.LogLevels)
+	if err != nil {
+		return Options{}, err
+	}
+	return Options{
+		Level:           level,
+		ComponentLevels: componentLevels,
+		File:            *config.LogFile,
+		MaxSizeMB:       *config.LogFileMaxSize,
+		MaxBackups:      *config.LogFileMaxBackups,
+	}, nil
+}
+
+// Close flushes and closes the log file, console logging continues
+func Close() {
+	stateMutex.Lock()
+	previous := current
+	current = &state{level: previous.level, componentLevels: previous.componentLevels, console: previous.console}
+	stateMutex.Unlock()
+
+	if previous.fileWriter != nil {
+		previous.fileWriter.Close()
+	}
+}
+
+// ParseLevel parses "debug", "info", "warn" or "error"
+func ParseLevel(text string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(text))); err != nil {
+		return 0, fmt.Errorf("invalid log level %q", text)
+	}
+	return level, nil
+}
+
+// ParseComponentLevels parses a "llm=debug,ocr=warn" list
+func ParseComponentLevels(spec string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		component, levelText, ok := strings.Cut(item, "=")
+		if !ok || strings.TrimSpace(component) == "" {
+			return nil, fmt.Errorf("invalid component level %q, want component=level", item)
+		}
+		level, err := ParseLevel(levelText)
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.TrimSpace(component)] = level
+	}
+	return levels, nil
+}
+
+func active() *state {
+	stateMutex.RLock()
+	defer stateMutex.RUnlock()
+	return current
+}
+
+func (s *state) levelFor(component string) slog.Level {
+	if level, ok := s.componentLevels[component]; ok {
+		return level
+	}
+	return s.level
+}
+
+// For returns the logger of a component. It can be created at package
+// initialization, the configuration is looked up when a record is logged.
+func For(component string) *slog.Logger {
+	return slog.New(&handler{component: component})
+}
+
+// Context keys of the correlation fields
+type contextKey int
+
+const (
+	taskKey contextKey = iota
+	subtaskKey
+	iterationKey
+)
+
+// WithTask tags records logged with ctx with a task ID
+func WithTask(ctx context.Context, taskID string) context.Context {
+	return context.WithValue(ctx, taskKey, taskID)
+}
+
+// WithSubtask tags records logged with ctx with a subtask ID
+func WithSubtask(ctx context.Context, subtaskID int) context.Context {
+	return context.WithValue(ctx, subtaskKey, subtaskID)
+}
+
+// WithIteration tags records logged with ctx with an iteration number
+func WithIteration(ctx context.Context, iteration int64) context.Context {
+	return context.WithValue(ctx, iterationKey, iteration)
+}
+
+// TaskID returns the task ID carried by ctx, or ""
+func TaskID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	id, _ := ctx.Value(taskKey).(string)
+	return id
+}
+
+// secret is a value written to the console and the log file but withheld
+// from subscribers, e.g. full LLM prompts that every viewer of the log
+// channel would otherwise see
+type secret string
+
+func (s secret) LogValue() slog.Value {
+	return slog.StringValue(string(s))
+}
+
+// Secret returns an attribute that only local sinks show in full
+func Secret(key, value string) slog.Attr {
+	return slog.Any(key, secret(value))
+}
+
+// handler adds the component and context fields and fans records out to
+// the active sinks
+type handler struct {
+	component string
+	attrs     []slog.Attr
+	group     string
+}
+
+func (h *handler) Enabled(_ context.Context, level slog.Level) bool {
+	return level >= active().levelFor(h.component)
+}
+
+func (h *handler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	clone := *h
+	clone.attrs = append(append([]slog.Attr{}, h.attrs...), h.qualify(attrs)...)
+	return &clone
+}
+
+func (h *handler) WithGroup(name string) slog.Handler {
+	clone := *h
+	if clone.group != "" {
+		name = clone.group + "." + name
+	}
+	clone.group = name
+	return &clone
+}
+
+// qualify prefixes attribute keys with the handler's group
+func (h *handler) qualify(attrs []slog.Attr) []slog.Attr {
+	if h.group == "" {
+		return attrs
+	}
+	qualified := make([]slog.Attr, len(attrs))
+	for i, a := range attrs {
+		qualified[i] = slog.Attr{Key: h.group + "." + a.Key, Value: a.Value}
+	}
+	return qualified
+}
+
+func (h *handler) Handle(ctx context.Context, r slog.Record) error {
+	s := active()
+
+	record := slog.NewRecord(r.Time, r.Level, r.Message, r.PC)
+	entry := Entry{
+		Time:      r.Time,
+		Level:     r.Level.String(),
+		Component: h.component,
+		Message:   r.Message,
+	}
+	if h.component != "" {
+		record.AddAttrs(slog.String("component", h.component))
+	}
+	if ctx != nil {
+		if id, ok := ctx.Value(taskKey).(string); ok {
+			record.AddAttrs(slog.String("taskId", id))
+			entry.TaskID = id
+		}
+		if id, ok := ctx.Value(subtaskKey).(int); ok {
+			record.AddAttrs(slog.Int("subtaskId", id))
+			entry.SubtaskID = &id
+		}
+		if n, ok := ctx.Value(iterationKey).(int64); ok {
+			record.AddAttrs(slog.Int64("iteration", n))
+			entry.Iteration = n
+		}
+	}
+	record.AddAttrs(h.attrs...)
+	var attrs []slog.Attr
+	r.Attrs(func(a slog.Attr) bool {
+		attrs = append(attrs, a)
+		return true
+	})
+	attrs = h.qualify(attrs)
+	record.AddAttrs(attrs...)
+
+	// Code without a task context may name the task in an attribute
+	if entry.TaskID == "" {
+		for _, a := range append(append([]slog.Attr{}, h.attrs...), attrs...) {
+			if a.Key == "taskId" && a.Value.Kind() == slog.KindString {
+				entry.TaskID = a.Value.String()
+			}
+		}
+	}
+
+	var firstErr error
+	for _, sink := range []slog.Handler{s.console, s.file} {
+		if sink == nil {
+			continue
+		}
+		if err := sink.Handle(ctx, record.Clone()); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	if hasSubscribers() {
+		entry.Attrs = publicAttrs(append(append([]slog.Attr{}, h.attrs...), attrs...))
+		publish(entry)
+	}
+	return firstErr
+}
+
+// stdout writes to the current os.Stdout, so commands that silence stdout
+// also silence console logging
+type stdout struct{}
+
+func (stdout) Write(p []byte) (int, error) {
+	return os.Stdout.Write(p)
+}
+
+// Entry is a log record as delivered to subscribers, with secrets redacted
+type Entry struct {
+	Time      time.Time              `json:"time"`
+	Level     string                 `json:"level"`
+	Component string                 `json:"component,omitempty"`
+	Message   string                 `json:"msg"`
+	TaskID    string                 `json:"taskId,omitempty"`
+	SubtaskID *int                   `json:"subtaskId,omitempty"`
+	Iteration int64                  `json:"iteration,omitempty"`
+	Attrs     map[string]interface{} `json:"attrs,omitempty"`
+}
+
+// Line renders the entry as a single human-readable line
+func (e Entry) Line() string {
+	var sb strings.Builder
+	sb.WriteString(e.Time.Format("2006/01/02 15:04:05"))
+	sb.WriteString(" " + e.Level)
+	if e.Component != "" {
+		sb.WriteString(" [" + e.Component + "]")
+	}
+	sb.WriteString(" " + e.Message)
+	for _, key := range sortedKeys(e.Attrs) {
+		fmt.Fprintf(&sb, " %s=%v", key, e.Attrs[key])
+	}
+	return sb.String()
+}
+
+// SlogLevel returns the entry's level, info if it can not be parsed
+func (e Entry) SlogLevel() slog.Level {
+	level, err := ParseLevel(e.Level)
+	if err != nil {
+		return slog.LevelInfo
+	}
+	return level
+}
+
+// Subscribe calls fn with every record logged from now on that passes the
+// component levels, until the returned function is called. fn must not block.
+func Subscribe(fn func(Entry)) (unsubscribe func()) {
+	subMutex.Lock()
+	defer subMutex.Unlock()
+	id := nextSubID
+	nextSubID++
+	subscribers[id] = fn
+	return func() {
+		subMutex.Lock()
+		defer subMutex.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+func hasSubscribers() bool {
+	subMutex.RLock()
+	defer subMutex.RUnlock()
+	return len(subscribers) > 0
+}
+
+func publish(e Entry) {
+	subMutex.RLock()
+	fns := make([]func(Entry), 0, len(subscribers))
+	for _, fn := range subscribers {
+		fns = append(fns, fn)
+	}
+	subMutex.RUnlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+}
+
+// publicAttrs resolves attributes for subscribers, redacting secrets
+func publicAttrs(attrs []slog.Attr) map[string]interface{} {
+	if len(attrs) == 0 {
+		return nil
+	}
+	result := make(map[string]interface{}, len(attrs))
+	for _, a := range attrs {
+		if a.Value.Kind() == slog.KindLogValuer {
+			if s, ok := a.Value.Any().(secret); ok {
+				result[a.Key] = fmt.Sprintf("[redacted, %d bytes]", len(s))
+				continue
+			}
+		}
+		v := a.Value.Resolve()
+		switch v.Kind() {
+		case slog.KindGroup:
+			result[a.Key] = publicAttrs(v.Group())
+		case slog.KindAny:
+			if err, ok := v.Any().(error); ok {
+				result[a.Key] = err.Error()
+			} else {
+				result[a.Key] = v.Any()
+			}
+		default:
+			result[a.Key] = v.Any()
+		}
+	}
+	return result
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,149 @@
+package macro
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/ocr"
+)
+
+// A macro matches a subtask by the words of their goals, and of the screens
+// they start from: the same goal on a different screen usually needs
+// different actions.
+const (
+	goalWeight   = 0.7
+	screenWeight = 0.3
+	minGoalScore = 0.5  // of goal similarity alone
+	minScore     = 0.45 // of the weighted score
+	maxScreen    = 200  // screen words kept per macro
+)
+
+var stopwords = map[string]bool{
+	"a": true, "an": true, "the": true, "to": true, "and": true, "of": true,
+	"in": true, "on": true, "for": true, "with": true, "it": true, "is": true,
+	"then": true, "into": true, "from": true, "by": true, "at": true, "as": true,
+}
+
+// Match is a macro found for a subtask
+type Match struct {
+	Macro
+	Score float64 `json:"score"`
+}
+
+// tokens returns the distinct lowercase words of text, without stopwords
+func tokens(text string) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, word := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}) {
+		if stopwords[word] || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+	}
+	return words
+}
+
+// Words returns the distinct words OCR found on a screen, the form Record
+// and Find compare screens in
+func Words(boxes []ocr.TesseractBoundingBox) []string {
+	var words []string
+	seen := make(map[string]bool)
+	for _, box := range boxes {
+		for _, word := range tokens(box.Text) {
+			if len(word) < 2 || seen[word] {
+				continue
+			}
+			seen[word] = true
+			words = append(words, word)
+			if len(words) == maxScreen {
+				return words
+			}
+		}
+	}
+	return words
+}
+
+// dice is the Dice coefficient of two word sets
+func dice(a, b []string) float64 {
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+	set := make(map[string]bool, len(a))
+	for _, word := range a {
+		set[word] = true
+	}
+	common := 0
+	for _, word := range b {
+		if set[word] {
+			common++
+		}
+	}
+	return 2 * float64(common) / float64(len(a)+len(b))
+}
+
+// Find returns up to k macros for goal on a screen showing screen, best
+// first
+func Find(goal string, screen []string, k int) []Match {
+	if !Enabled() || k <= 0 {
+		return nil
+	}
+	goalWords := tokens(goal)
+
+	storeMutex.Lock()
+	load()
+	var matches []Match
+	for _, m := range macros {
+		goalScore := dice(goalWords, tokens(m.Goal))
+		if goalScore < minGoalScore {
+			continue
+		}
+		score := goalWeight*goalScore + screenWeight*dice(screen, m.Screen)
+		if m.Replays > 0 {
+			score *= 1 - 0.5*float64(m.ReplayFailures)/float64(m.Replays)
+		}
+		if score < minScore {
+			continue
+		}
+		copied := *m
+		copied.Actions = append([]action.Action(nil), m.Actions...)
+		matches = append(matches, Match{Macro: copied, Score: score})
+	}
+	storeMutex.Unlock()
+
+	sort.Slice(matches, func(i, j int) bool {
+		if matches[i].Score != matches[j].Score {
+			return matches[i].Score > matches[j].Score
+		}
+		return matches[i].Seen > matches[j].Seen
+	})
+	if len(matches) > k {
+		matches = matches[:k]
+	}
+	return matches
+}
+
+// List returns all macros, most recorded first
+func List() []Macro {
+	if !Enabled() {
+		return nil
+	}
+	storeMutex.Lock()
+	load()
+	list := make([]Macro, 0, len(macros))
+	for _, m := range macros {
+		list = append(list, *m)
+	}
+	storeMutex.Unlock()
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Seen != list[j].Seen {
+			return list[i].Seen > list[j].Seen
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
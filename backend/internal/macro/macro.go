@@ -0,0 +1,189 @@
+// Package macro keeps the action sequences that completed subtasks, so a
+// similar subtask later starts from a worked example instead of from
+// scratch. A macro is recorded when a subtask is verified complete without
+// operator help, found again by the words of its goal and of the screen it
+// started from, and either shown to the model as an example or replayed as
+// is with the runMacro action, whose outcome is counted.
+package macro
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
+)
+
+var logger = logging.For("macro")
+
+// Macro is the action sequence that completed a subtask
+type Macro struct {
+	ID         string          `json:"id"`
+	Goal       string          `json:"goal"`
+	Screen     []string        `json:"screen"` // words on the screen the subtask started from
+	Actions    []action.Action `json:"actions"`
+	Iterations int             `json:"iterations"` // it took to complete the subtask
+	TaskID     string          `json:"taskId"`
+	RecordedAt time.Time       `json:"recordedAt"`
+
+	Seen           int `json:"seen"` // times the same sequence completed the same goal
+	Replays        int `json:"replays"`
+	ReplayFailures int `json:"replayFailures"`
+}
+
+var (
+	storeMutex sync.Mutex
+	macros     map[string]*Macro // by ID, nil until loaded
+)
+
+// load reads the macro directory once, with storeMutex held
+func load() {
+	if macros != nil {
+		return
+	}
+	macros = make(map[string]*Macro)
+	entries, err := os.ReadDir(*config.MacroDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to read macros", "dir", *config.MacroDir, "error", err)
+		}
+		return
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(*config.MacroDir, entry.Name()))
+		if err != nil {
+			logger.Warn("failed to read macro", "file", entry.Name(), "error", err)
+			continue
+		}
+		var m Macro
+		if err := json.Unmarshal(data, &m); err != nil || m.ID == "" {
+			logger.Warn("skipping malformed macro", "file", entry.Name(), "error", err)
+			continue
+		}
+		macros[m.ID] = &m
+	}
+	logger.Info("loaded macros", "count", len(macros))
+}
+
+// save writes a macro, with storeMutex held
+func save(m *Macro) {
+	if err := os.MkdirAll(*config.MacroDir, 0o755); err != nil {
+		logger.Warn("failed to save macro", "id", m.ID, "error", err)
+		return
+	}
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		logger.Warn("failed to encode macro", "id", m.ID, "error", err)
+		return
+	}
+	path := filepath.Join(*config.MacroDir, m.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		logger.Warn("failed to save macro", "id", m.ID, "error", err)
+	}
+}
+
+// Enabled reports whether macros are kept
+func Enabled() bool {
+	return *config.MacroDir != ""
+}
+
+// macroID identifies a goal and action sequence
+func macroID(goal string, actions []action.Action) string {
+	data, _ := json.Marshal(actions)
+	sum := sha256.Sum256(append([]byte(strings.Join(tokens(goal), " ")+"\n"), data...))
+	return hex.EncodeToString(sum[:6])
+}
+
+// Record remembers the actions that completed goal, starting from a screen
+// showing screen. Recording the same goal and actions again counts them.
+func Record(goal string, screen []string, actions []action.Action, iterations int, taskID string) {
+	if !Enabled() {
+		return
+	}
+	kept := make([]action.Action, 0, len(actions))
+	for _, a := range actions {
+		if a.Action == "stopIteration" || a.Action == "runMacro" {
+			continue
+		}
+		a.Execute = nil
+		kept = append(kept, a)
+	}
+	if len(kept) == 0 {
+		return
+	}
+
+	id := macroID(goal, kept)
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	load()
+	m, ok := macros[id]
+	if ok {
+		m.Seen++
+	} else {
+		m = &Macro{
+			ID:         id,
+			Goal:       goal,
+			Screen:     screen,
+			Actions:    kept,
+			Iterations: iterations,
+			TaskID:     taskID,
+			RecordedAt: time.Now(),
+			Seen:       1,
+		}
+		macros[id] = m
+	}
+	save(m)
+	logger.Info("recorded macro", "id", id, "goal", goal, "actions", len(kept), "seen", m.Seen)
+}
+
+// Get returns a copy of a macro
+func Get(id string) (Macro, bool) {
+	if !Enabled() {
+		return Macro{}, false
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	load()
+	m, ok := macros[id]
+	if !ok {
+		return Macro{}, false
+	}
+	copied := *m
+	copied.Actions = append([]action.Action(nil), m.Actions...)
+	return copied, true
+}
+
+// ReplayOutcome counts whether replaying a macro completed its subtask;
+// macros that keep failing rank lower
+func ReplayOutcome(id string, completed bool) {
+	if !Enabled() {
+		return
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	load()
+	m, ok := macros[id]
+	if !ok {
+		return
+	}
+	m.Replays++
+	if !completed {
+		m.ReplayFailures++
+	}
+	save(m)
+	logger.Info("macro replayed", "id", id, "completed", completed, "replays", m.Replays, "failures", m.ReplayFailures)
+}
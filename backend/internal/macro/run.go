@@ -0,0 +1,102 @@
+package macro
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/config"
+	"useless-agent/internal/tracing"
+)
+
+// ErrNotFound means there is no macro with the ID
+var ErrNotFound = errors.New("macro not found")
+
+func init() {
+	action.RegisterAction("runMacro", runMacroExecution, validateRunMacro)
+}
+
+// Delete forgets a macro
+func Delete(id string) error {
+	if !Enabled() {
+		return ErrNotFound
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	load()
+	if _, ok := macros[id]; !ok {
+		return ErrNotFound
+	}
+	delete(macros, id)
+	err := os.Remove(filepath.Join(*config.MacroDir, id+".json"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Executed returns what action i of batch did as plain actions: a repeat
+// becomes the actions it repeats, a runMacro the actions of its macro. The
+// result can be replayed outside of batch.
+func Executed(batch []action.Action, i int) []action.Action {
+	a := batch[i]
+	switch a.Action {
+	case "repeat":
+		if len(a.ActionsRange) < 2 {
+			return nil
+		}
+		start, end := max(a.ActionsRange[0]-1, 0), min(a.ActionsRange[1], len(batch))
+		var actions []action.Action
+		for round := 0; round < a.RepeatTimes; round++ {
+			for j := start; j < end; j++ {
+				if batch[j].Action != "repeat" {
+					actions = append(actions, batch[j])
+				}
+			}
+		}
+		return actions
+	case "runMacro":
+		m, ok := Get(a.Name)
+		if !ok {
+			return nil
+		}
+		return m.Actions
+	case "stopIteration", "nop":
+		return nil
+	}
+	return []action.Action{a}
+}
+
+func validateRunMacro(a *action.Action) error {
+	if _, ok := Get(a.Name); !ok {
+		return fmt.Errorf("macro %q: %w", a.Name, ErrNotFound)
+	}
+	return nil
+}
+
+func runMacroExecution(ctx context.Context, a *action.Action, params ...interface{}) {
+	m, ok := Get(a.Name)
+	if !ok {
+		logger.WarnContext(ctx, "macro not found", "id", a.Name)
+		return
+	}
+	logger.InfoContext(ctx, "running macro", "id", m.ID, "goal", m.Goal, "actions", len(m.Actions))
+	actions := m.Actions
+	for i := range actions {
+		if ctx.Err() != nil {
+			return
+		}
+		action.SetExecuteFunction(&actions[i])
+		if err := action.Validate(&actions[i]); err != nil {
+			logger.WarnContext(ctx, "skipping invalid macro action", "id", m.ID, "index", i, "error", err)
+			continue
+		}
+		spanCtx, span := tracing.Start(ctx, "action."+actions[i].Action,
+			"action.name", actions[i].Action, "action.index", i, "action.macro", m.ID)
+		actions[i].Execute(spanCtx, &actions[i], &actions)
+		span.End()
+	}
+}
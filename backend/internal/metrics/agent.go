@@ -0,0 +1,36 @@
+package metrics
+
+// Metrics of the agent loop. Gauges owned by other packages (queue length,
+// WebSocket clients) are registered there with NewGaugeFunc.
+var (
+	CaptureSeconds = NewHistogramVec("agent_screenshot_capture_seconds",
+		"Time to capture a screenshot.", DefaultBuckets)
+
+	OCRSeconds = NewHistogramVec("agent_ocr_seconds",
+		"Time to run tesseract on a screenshot.", DefaultBuckets)
+
+	BoundingBoxSeconds = NewHistogramVec("agent_bbox_detection_seconds",
+		"Time to detect bounding boxes on a screenshot.", DefaultBuckets)
+
+	LLMRequestSeconds = NewHistogramVec("agent_llm_request_seconds",
+		"Duration of LLM calls by call kind and result (ok, error).", LLMBuckets, "kind", "result")
+
+	LLMTimeToFirstTokenSeconds = NewHistogramVec("agent_llm_time_to_first_token_seconds",
+		"Time from sending a streaming LLM request to its first content chunk.", LLMBuckets, "kind")
+
+	LLMTokens = NewCounterVec("agent_llm_tokens_total",
+		"Estimated prompt tokens sent to the LLM.", "provider", "model", "kind")
+
+	ActionSeconds = NewHistogramVec("agent_action_seconds",
+		"Time to execute one action by action name.", DefaultBuckets, "action")
+
+	SubtaskIterations = NewHistogramVec("agent_subtask_iterations",
+		"Act-verify iterations spent on a subtask by how it ended (completed, replanned, limit, aborted).",
+		[]float64{1, 2, 3, 5, 8, 13, 21, 40}, "result")
+
+	TaskOutcomes = NewCounterVec("agent_tasks_total",
+		"Tasks that finished, by final status.", "status")
+
+	TaskSeconds = NewHistogramVec("agent_task_seconds",
+		"Wall time of executed tasks by final status.", []float64{5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600}, "status")
+)
@@ -0,0 +1,318 @@
+// Package metrics keeps counters, histograms and gauges of the agent and
+// serves them in the Prometheus text exposition format
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Bucket upper bounds in seconds for fast local work (capture, OCR, actions)
+var DefaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+// Bucket upper bounds in seconds for LLM round trips
+var LLMBuckets = []float64{0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+// collector is anything that can write itself to the exposition
+type collector interface {
+	metricName() string
+	write(w *bufio.Writer)
+}
+
+var (
+	registry      []collector
+	registryNames = make(map[string]bool)
+	registryMutex sync.Mutex
+)
+
+func register(c collector) {
+	registryMutex.Lock()
+	defer registryMutex.Unlock()
+	if registryNames[c.metricName()] {
+		panic("metrics: duplicate metric " + c.metricName())
+	}
+	registryNames[c.metricName()] = true
+	registry = append(registry, c)
+}
+
+// labelSet holds the values of one child of a vector
+type labelSet struct {
+	key    string
+	values []string
+}
+
+func newLabelSet(names []string, values []string) labelSet {
+	if len(values) != len(names) {
+		panic(fmt.Sprintf("metrics: got %d label values for %d labels", len(values), len(names)))
+	}
+	return labelSet{key: strings.Join(values, "\xff"), values: append([]string(nil), values...)}
+}
+
+// format renders {a="x",b="y"}, with extra appended as the last pair
+func (l labelSet) format(names []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(l.values[i]))
+		b.WriteByte('"')
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName)
+		b.WriteString(`="`)
+		b.WriteString(extraValue)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string {
+	return labelEscaper.Replace(v)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func writeHeader(w *bufio.Writer, name, help, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, strings.ReplaceAll(help, "\n", " "), name, kind)
+}
+
+// CounterVec is a set of counters partitioned by label values
+type CounterVec struct {
+	name   string
+	help   string
+	labels []string
+
+	mutex    sync.Mutex
+	children map[string]*Counter
+}
+
+// Counter only goes up
+type Counter struct {
+	labels labelSet
+
+	mutex sync.Mutex
+	value float64
+}
+
+// NewCounterVec registers a counter with the given label names
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{name: name, help: help, labels: labels, children: make(map[string]*Counter)}
+	register(c)
+	return c
+}
+
+// With returns the counter for the label values, in label order
+func (v *CounterVec) With(values ...string) *Counter {
+	set := newLabelSet(v.labels, values)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	c, ok := v.children[set.key]
+	if !ok {
+		c = &Counter{labels: set}
+		v.children[set.key] = c
+	}
+	return c
+}
+
+// Inc adds one
+func (c *Counter) Inc() {
+	c.Add(1)
+}
+
+// Add adds delta, negative values are ignored
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
+	}
+	c.mutex.Lock()
+	c.value += delta
+	c.mutex.Unlock()
+}
+
+func (v *CounterVec) metricName() string {
+	return v.name
+}
+
+func (v *CounterVec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, "counter")
+	for _, c := range v.sortedChildren() {
+		c.mutex.Lock()
+		value := c.value
+		c.mutex.Unlock()
+		fmt.Fprintf(w, "%s%s %s\n", v.name, c.labels.format(v.labels, "", ""), formatFloat(value))
+	}
+}
+
+func (v *CounterVec) sortedChildren() []*Counter {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	children := make([]*Counter, 0, len(v.children))
+	for _, c := range v.children {
+		children = append(children, c)
+	}
+	sort.Slice(children, func(i, j int) bool { return children[i].labels.key < children[j].labels.key })
+	return children
+}
+
+// HistogramVec is a set of histograms partitioned by label values
+type HistogramVec struct {
+	name    string
+	help    string
+	labels  []string
+	buckets []float64
+
+	mutex    sync.Mutex
+	children map[string]*Histogram
+}
+
+// Histogram counts observations into cumulative buckets
+type Histogram struct {
+	labels  labelSet
+	buckets []float64
+
+	mutex  sync.Mutex
+	counts []uint64
+	sum    float64
+	count  uint64
+}
+
+// NewHistogramVec registers a histogram with the given bucket upper bounds
+// and label names
+func NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	sorted := append([]float64(nil), buckets...)
+	sort.Float64s(sorted)
+	h := &HistogramVec{name: name, help: help, labels: labels, buckets: sorted, children: make(map[string]*Histogram)}
+	register(h)
+	return h
+}
+
+// With returns the histogram for the label values, in label order
+func (v *HistogramVec) With(values ...string) *Histogram {
+	set := newLabelSet(v.labels, values)
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	h, ok := v.children[set.key]
+	if !ok {
+		h = &Histogram{labels: set, buckets: v.buckets, counts: make([]uint64, len(v.buckets))}
+		v.children[set.key] = h
+	}
+	return h
+}
+
+// Observe adds one value
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if i := sort.SearchFloat64s(h.buckets, value); i < len(h.counts) {
+		h.counts[i]++
+	}
+	h.sum += value
+	h.count++
+}
+
+// ObserveSince adds the seconds elapsed since start
+func (h *Histogram) ObserveSince(start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+func (v *HistogramVec) metricName() string {
+	return v.name
+}
+
+func (v *HistogramVec) write(w *bufio.Writer) {
+	writeHeader(w, v.name, v.help, "histogram")
+	v.mutex.Lock()
+	children := make([]*Histogram, 0, len(v.children))
+	for _, h := range v.children {
+		children = append(children, h)
+	}
+	v.mutex.Unlock()
+	sort.Slice(children, func(i, j int) bool { return children[i].labels.key < children[j].labels.key })
+
+	for _, h := range children {
+		h.mutex.Lock()
+		var cumulative uint64
+		for i, upper := range h.buckets {
+			cumulative += h.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, h.labels.format(v.labels, "le", formatFloat(upper)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", v.name, h.labels.format(v.labels, "le", "+Inf"), h.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", v.name, h.labels.format(v.labels, "", ""), formatFloat(h.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", v.name, h.labels.format(v.labels, "", ""), h.count)
+		h.mutex.Unlock()
+	}
+}
+
+// GaugeFunc is a gauge read from a function at scrape time
+type GaugeFunc struct {
+	name string
+	help string
+	fn   func() float64
+}
+
+// NewGaugeFunc registers a gauge whose value is fn() when scraped
+func NewGaugeFunc(name, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name: name, help: help, fn: fn}
+	register(g)
+	return g
+}
+
+func (g *GaugeFunc) metricName() string {
+	return g.name
+}
+
+func (g *GaugeFunc) write(w *bufio.Writer) {
+	writeHeader(w, g.name, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.name, formatFloat(g.fn()))
+}
+
+// WriteText writes every registered metric in the text exposition format
+func WriteText(out io.Writer) error {
+	registryMutex.Lock()
+	collectors := append([]collector(nil), registry...)
+	registryMutex.Unlock()
+	sort.Slice(collectors, func(i, j int) bool { return collectors[i].metricName() < collectors[j].metricName() })
+
+	w := bufio.NewWriter(out)
+	for _, c := range collectors {
+		c.write(w)
+	}
+	return w.Flush()
+}
+
+// Handler serves the metrics to a Prometheus scraper
+func Handler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := WriteText(w); err != nil {
+		http.Error(w, "Failed to write metrics", http.StatusInternalServerError)
+	}
+}
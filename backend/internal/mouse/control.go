@@ -2,14 +2,18 @@ package mouse
 
 import (
 	"encoding/json"
-	"log"
 	"net/http"
 	"strconv"
 	"sync"
 
 	"github.com/go-vgo/robotgo"
+
+	"useless-agent/internal/logging"
+	"useless-agent/internal/screen"
 )
 
+var logger = logging.For("mouse")
+
 var mouseMutex sync.Mutex
 
 // Coordinate represents mouse coordinates
@@ -18,27 +22,33 @@ type Coordinate struct {
 	Y int `json:"y"`
 }
 
+// location returns the pointer position from the installed screen backend,
+// or from the X display
+func location() (int, int) {
+	if b := screen.Current(); b != nil {
+		return b.CursorPosition()
+	}
+	return robotgo.Location()
+}
+
 // GetCursorPosition gets the current cursor position
 func GetCursorPosition() (int, int) {
-	x, y := robotgo.Location()
-	log.Printf("getCursorPosition, current mouse position [%d,%d]", x, y)
-	return x, y
+	return location()
 }
 
 // GetCursorPositionJSON gets the current cursor position as JSON
 func GetCursorPositionJSON() (string, error) {
-	x, y := robotgo.Location()
-	log.Printf("getCursorPositionJSON, current mouse position [%d,%d]", x, y)
+	x, y := location()
 
 	var cursorCoord Coordinate
 	cursorCoord.X = x
 	cursorCoord.Y = y
 	jsonData, err := json.Marshal(cursorCoord)
 	if err != nil {
-		log.Println("failed to json.Marshal cursor position.")
+		logger.Error("failed to marshal cursor position", "error", err)
 		return "", nil
 	}
-	log.Printf("getCursorPositionJSON, current mouse position json string: %s", string(jsonData))
+	logger.Debug("cursor position", "x", x, "y", y)
 
 	return string(jsonData), nil
 }
@@ -54,6 +64,11 @@ func MouseInputHandler(w http.ResponseWriter, r *http.Request) {
 	mouseMutex.Lock()
 	func() {
 		defer mouseMutex.Unlock()
+		if b := screen.Current(); b != nil {
+			cx, cy := b.CursorPosition()
+			b.MoveMouse(cx+x, cy+y)
+			return
+		}
 		robotgo.MoveSmoothRelative(x, y)
 	}()
 
@@ -73,7 +88,12 @@ func MouseInputHandler(w http.ResponseWriter, r *http.Request) {
 
 // MouseClickHandler handles mouse click HTTP requests
 func MouseClickHandler(w http.ResponseWriter, r *http.Request) {
-	robotgo.Click()
+	if b := screen.Current(); b != nil {
+		b.MouseDown("left")
+		b.MouseUp("left")
+	} else {
+		robotgo.Click()
+	}
 
 	var response []map[string]interface{}
 	response = append(response, map[string]interface{}{
@@ -5,13 +5,16 @@ import (
 	"fmt"
 	"image"
 	"image/png"
-	"log"
 	"os"
 	"sort"
 
 	"github.com/otiai10/gosseract/v2"
+
+	"useless-agent/internal/logging"
 )
 
+var logger = logging.For("ocr")
+
 // GetTesseractBoundingBoxes extracts bounding boxes from an image using gosseract/v2
 func GetTesseractBoundingBoxes(img image.Image) ([]TesseractBoundingBox, error) {
 	tmpFile, err := os.CreateTemp("/dev/shm", "ocr_image_*.png")
@@ -75,7 +78,7 @@ func OCR(img image.Image) (result []TesseractBoundingBox) {
 	// Get bounding boxes
 	boxes, err := GetTesseractBoundingBoxes(img)
 	if err != nil {
-		log.Printf("failed to get bounding boxes: %v", err)
+		logger.Error("failed to get bounding boxes", "error", err)
 		return []TesseractBoundingBox{} // Return empty result instead of fatal
 	}
 	return boxes
@@ -86,7 +89,7 @@ func OCRtoJSONString(data []TesseractBoundingBox) (result string) {
 	// Convert bounding boxes to JSON
 	jsonOutput, err := TesseractBoundingBoxesToJSON(data)
 	if err != nil {
-		log.Printf("failed to convert bounding boxes to JSON: %v", err)
+		logger.Error("failed to convert bounding boxes to JSON", "error", err)
 		return "[]" // Return empty JSON array instead of fatal
 	}
 	return string(jsonOutput)
@@ -306,13 +309,13 @@ func GetOCRDelta(oldJSONstring string, newJSONstring string) (ocrDelta Delta, er
 	var oldData, newData []TesseractBoundingBox
 	err = json.Unmarshal([]byte(oldJSONstring), &oldData)
 	if err != nil {
-		fmt.Println("Error unmarshaling old JSON:", err)
+		logger.Error("failed to unmarshal old OCR JSON", "error", err)
 		return Delta{}, err
 	}
 
 	err = json.Unmarshal([]byte(newJSONstring), &newData)
 	if err != nil {
-		fmt.Println("Error unmarshaling new JSON:", err)
+		logger.Error("failed to unmarshal new OCR JSON", "error", err)
 		return Delta{}, err
 	}
 
@@ -324,12 +327,10 @@ func GetOCRDelta(oldJSONstring string, newJSONstring string) (ocrDelta Delta, er
 func GetOCRDeltaJSONString(data Delta) (ocrDelta string, err error) {
 	deltaJSON, err := json.MarshalIndent(data, "", "  ")
 	if err != nil {
-		fmt.Println("Error marshaling delta to JSON:", err)
+		logger.Error("failed to marshal OCR delta", "error", err)
 		return "", err
 	}
 	deltaJSONString := string(deltaJSON)
-	fmt.Println("raw ocr Delta len:", len(deltaJSONString))
-	fmt.Println("raw ocr Delta:")
-	fmt.Println(deltaJSONString)
+	logger.Debug("raw OCR delta", "length", len(deltaJSONString), "delta", deltaJSONString)
 	return deltaJSONString, nil
 }
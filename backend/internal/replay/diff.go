@@ -0,0 +1,110 @@
+package replay
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Above this many cells the LCS table is skipped and the changed range is
+// shown as a single removal and addition
+const maxDiffCells = 4_000_000
+
+// Diff returns a diff of two prompts, or "" if they are equal. Prompts are
+// mostly a few very long lines, so they are split at sentence and JSON
+// element boundaries and a changed OCR word shows up as a small hunk.
+func Diff(original, replayed string) string {
+	if original == replayed {
+		return ""
+	}
+	a, b := segments(original), segments(replayed)
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	a, b = a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "@@ segment %d, %d unchanged before, %d unchanged after @@\n", prefix+1, prefix, suffix)
+
+	if len(a)*len(b) > maxDiffCells {
+		for _, s := range a {
+			sb.WriteString("- " + s + "\n")
+		}
+		for _, s := range b {
+			sb.WriteString("+ " + s + "\n")
+		}
+		return sb.String()
+	}
+
+	// lcs[i][j] is the LCS length of a[i:] and b[j:]
+	lcs := make([][]int32, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int32, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else {
+				lcs[i][j] = max32(lcs[i+1][j], lcs[i][j+1])
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && a[i] == b[j]:
+			sb.WriteString("  " + a[i] + "\n")
+			i++
+			j++
+		case j < len(b) && (i == len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			sb.WriteString("+ " + b[j] + "\n")
+			j++
+		default:
+			sb.WriteString("- " + a[i] + "\n")
+			i++
+		}
+	}
+	return sb.String()
+}
+
+// segments splits text after newlines, sentence ends and closing JSON
+// objects or arrays followed by a comma
+func segments(text string) []string {
+	var result []string
+	start := 0
+	for i := 0; i < len(text); i++ {
+		end := -1
+		switch {
+		case text[i] == '\n':
+			end = i + 1
+		case i+1 < len(text) && text[i+1] == ' ' && text[i] == '.':
+			end = i + 2
+		case i+1 < len(text) && text[i+1] == ',' && (text[i] == '}' || text[i] == ']'):
+			end = i + 2
+		}
+		if end < 0 {
+			continue
+		}
+		result = append(result, strings.TrimRight(text[start:end], "\n"))
+		start = end
+		i = end - 1
+	}
+	if start < len(text) {
+		result = append(result, text[start:])
+	}
+	return result
+}
+
+func max32(a, b int32) int32 {
+	if a > b {
+		return a
+	}
+	return b
+}
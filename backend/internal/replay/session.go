@@ -0,0 +1,317 @@
+package replay
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/ocr"
+	"useless-agent/internal/task"
+	"useless-agent/internal/trajectory"
+)
+
+// Session steps through a recorded trajectory, re-running perception, prompt
+// building, parsing and verification of every iteration against the stored
+// screenshots. LLM calls are answered with the responses recorded in the same
+// iteration, so no X server and no network access is needed.
+type Session struct {
+	bundle *trajectory.Bundle
+	index  int
+	// OCR of the last screen seen, the base of the next iteration's delta
+	prevOCRJSON string
+}
+
+// Call is one LLM call of a replayed iteration next to its recorded original
+type Call struct {
+	Kind            string                     `json:"kind"`
+	Request         *llm.ChatCompletionRequest `json:"request"`
+	OriginalRequest *llm.ChatCompletionRequest `json:"originalRequest,omitempty"`
+	Response        string                     `json:"response"`
+	Error           string                     `json:"error,omitempty"`
+	PromptChanged   bool                       `json:"promptChanged"`
+	PromptDiff      string                     `json:"promptDiff,omitempty"`
+}
+
+// Verdict is the outcome of the verification step
+type Verdict struct {
+	Completed   bool               `json:"completed"`
+	Description string             `json:"description"`
+	NextPrompt  string             `json:"nextPrompt"`
+	Source      string             `json:"source"`
+	Checks      []task.CheckResult `json:"checks,omitempty"`
+}
+
+// Step is the result of replaying one iteration
+type Step struct {
+	Iteration       int64           `json:"iteration"`
+	SubtaskID       int             `json:"subtaskId"`
+	Subtask         string          `json:"subtask"`
+	Calls           []Call          `json:"calls"`
+	Actions         []action.Action `json:"actions"`
+	OriginalActions []action.Action `json:"originalActions"`
+	ActionsError    string          `json:"actionsError,omitempty"`
+	ActionsChanged  bool            `json:"actionsChanged"`
+	Verdict         *Verdict        `json:"verdict,omitempty"`
+	OriginalVerdict *Verdict        `json:"originalVerdict,omitempty"`
+	VerdictChanged  bool            `json:"verdictChanged"`
+}
+
+// actInputs mirrors the act_inputs file written by the executor
+type actInputs struct {
+	Prompt     string   `json:"prompt"`
+	PromptLog  string   `json:"promptLog"`
+	PrevCursor string   `json:"prevCursor"`
+	Cursor     string   `json:"cursor"`
+	Model      string   `json:"model"`
+	Hints      []string `json:"hints"`
+	// Skills is nil in bundles recorded before it was, the live skills are used then
+	Skills *string `json:"skills"`
+}
+
+// verifyInputs mirrors the verify_inputs file written by the executor
+type verifyInputs struct {
+	Cursor  string `json:"cursor"`
+	CursorY int    `json:"cursorY"`
+}
+
+// Open starts a replay session over a bundle directory or zip archive
+func Open(bundlePath string) (*Session, error) {
+	bundle, err := trajectory.OpenBundle(bundlePath)
+	if err != nil {
+		return nil, err
+	}
+	return &Session{bundle: bundle}, nil
+}
+
+// Close releases the bundle
+func (s *Session) Close() error {
+	return s.bundle.Close()
+}
+
+// Manifest returns the manifest of the replayed bundle
+func (s *Session) Manifest() *trajectory.Manifest {
+	return &s.bundle.Manifest
+}
+
+// Len returns the number of recorded iterations
+func (s *Session) Len() int {
+	return len(s.bundle.Manifest.Iterations)
+}
+
+// Position returns the index of the iteration Next will replay
+func (s *Session) Position() int {
+	return s.index
+}
+
+// Seek moves to the iteration with the given index. The OCR delta base is
+// rebuilt from the previous iteration's final screenshot on the next step.
+func (s *Session) Seek(index int) error {
+	if index < 0 || index >= s.Len() {
+		return fmt.Errorf("iteration index %d out of range [0,%d)", index, s.Len())
+	}
+	s.index = index
+	s.prevOCRJSON = ""
+	return nil
+}
+
+// Next replays the next iteration; it returns io.EOF after the last one
+func (s *Session) Next() (*Step, error) {
+	if s.index >= s.Len() {
+		return nil, io.EOF
+	}
+	it := s.bundle.Manifest.Iterations[s.index]
+	if s.prevOCRJSON == "" && s.index > 0 {
+		if err := s.restoreDeltaBase(s.bundle.Manifest.Iterations[s.index-1]); err != nil {
+			return nil, err
+		}
+	}
+	s.index++
+	return s.replayIteration(it)
+}
+
+// restoreDeltaBase recomputes the OCR of the last screen seen before an iteration
+func (s *Session) restoreDeltaBase(prev *trajectory.Iteration) error {
+	name := "after"
+	if _, ok := prev.Files[name]; !ok {
+		name = "before"
+	}
+	img, err := s.bundle.IterationImage(prev, name)
+	if err != nil {
+		return err
+	}
+	p, err := task.Perceive(context.Background(), img, nil)
+	if err != nil {
+		return err
+	}
+	s.prevOCRJSON = p.OCRJSON
+	return nil
+}
+
+func (s *Session) replayIteration(it *trajectory.Iteration) (*Step, error) {
+	step := &Step{Iteration: it.Number, SubtaskID: it.SubtaskID, Subtask: it.Subtask}
+
+	original, err := s.bundle.IterationExchanges(it)
+	if err != nil {
+		return nil, err
+	}
+	var replayed []llm.Exchange
+	ctx := llm.WithClient(context.Background(), llm.NewReplayClient(original))
+	ctx = llm.WithExchangeObserver(ctx, func(ex llm.Exchange) {
+		replayed = append(replayed, ex)
+	})
+	defer func() {
+		step.Calls = compareCalls(replayed, original)
+	}()
+
+	var inputs actInputs
+	if ok, err := s.bundle.IterationJSON(it, "act_inputs", &inputs); err != nil {
+		return nil, err
+	} else if !ok {
+		return nil, fmt.Errorf("iteration %d has no recorded act inputs", it.Number)
+	}
+	var examples []llm.Example
+	if _, err := s.bundle.IterationJSON(it, "examples", &examples); err != nil {
+		return nil, err
+	}
+	// The prompt is built from the same inputs as the recorded one
+	ctx = llm.WithHints(llm.WithModel(ctx, inputs.Model), inputs.Hints)
+	actCtx := llm.WithExamples(ctx, examples)
+	if inputs.Skills != nil {
+		actCtx = llm.WithSkillsSection(actCtx, *inputs.Skills)
+	}
+	var x11Windows json.RawMessage
+	if _, err := s.bundle.IterationJSON(it, "windows_x11", &x11Windows); err != nil {
+		return nil, err
+	}
+	if _, err := s.bundle.IterationJSON(it, "actions", &step.OriginalActions); err != nil {
+		return nil, err
+	}
+
+	// Perception and act, as in the first half of the executor iteration
+	before, err := s.bundle.IterationImage(it, "before")
+	if err != nil {
+		return nil, err
+	}
+	perceivedBefore, err := task.Perceive(ctx, before, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	var textChangesSummary string
+	if it.Number > 1 && s.prevOCRJSON != "" {
+		textChangesJSON := ocrDeltaJSON(s.prevOCRJSON, perceivedBefore.OCRJSON)
+		textChangesSummary, _ = llm.GetOCRDeltaAbstractDescription(ctx, textChangesJSON, noTokens)
+	}
+	s.prevOCRJSON = perceivedBefore.OCRJSON
+
+	actions, _, err := llm.SendMessageToLLM(actCtx, inputs.Prompt, perceivedBefore.BoundingBoxesJSON, perceivedBefore.OCRJSON, textChangesSummary, inputs.PromptLog, it.Number, inputs.PrevCursor, inputs.Cursor, perceivedBefore.DetectedWindowsJSON, string(x11Windows), perceivedBefore.ColorsDistribution)
+	if err != nil {
+		// The executor records no actions for a failed call either
+		step.ActionsError = err.Error()
+		actions = nil
+	}
+	step.Actions = actions
+	step.ActionsChanged = !sameJSON(step.Actions, step.OriginalActions)
+
+	// The run stopped (canceled, broken) before the second half of the iteration
+	after, err := s.bundle.IterationImage(it, "after")
+	if err != nil {
+		return step, nil
+	}
+	perceivedAfter, err := task.Perceive(ctx, after, nil)
+	if err != nil {
+		return nil, err
+	}
+	textChangesJSON := ocrDeltaJSON(s.prevOCRJSON, perceivedAfter.OCRJSON)
+	textChangesSummary, _ = llm.GetOCRDeltaAbstractDescription(ctx, textChangesJSON, noTokens)
+	s.prevOCRJSON = perceivedAfter.OCRJSON
+
+	var recorded Verdict
+	if ok, err := s.bundle.IterationJSON(it, "verdict", &recorded); err != nil {
+		return nil, err
+	} else if !ok {
+		return step, nil
+	}
+	step.OriginalVerdict = &recorded
+
+	// Postconditions inspect the live system, so their recorded results are
+	// reused; only verdicts that came from the LLM are recomputed
+	if recorded.Source != "llm" {
+		step.Verdict = &recorded
+		return step, nil
+	}
+
+	var vin verifyInputs
+	if _, err := s.bundle.IterationJSON(it, "verify_inputs", &vin); err != nil {
+		return nil, err
+	}
+	completed, description, nextPrompt := llm.IsGoalAchieved(ctx, it.Subtask, perceivedAfter.BoundingBoxesJSON, perceivedAfter.OCRJSON, textChangesJSON, textChangesSummary, inputs.PromptLog, it.Number, inputs.PrevCursor, perceivedAfter.DetectedWindowsJSON, vin.Cursor, perceivedAfter.OCRNearCursor(vin.CursorY), perceivedBefore.ColorsDistribution, perceivedAfter.ColorsDistribution, task.SummarizeCheckResults(recorded.Checks), noTokens)
+	step.Verdict = &Verdict{
+		Completed:   completed,
+		Description: description,
+		NextPrompt:  nextPrompt,
+		Source:      "llm",
+		Checks:      recorded.Checks,
+	}
+	step.VerdictChanged = step.Verdict.Completed != recorded.Completed || step.Verdict.NextPrompt != recorded.NextPrompt
+	return step, nil
+}
+
+// compareCalls pairs every replayed call with the recorded call of the same
+// kind and occurrence
+func compareCalls(replayed, original []llm.Exchange) []Call {
+	calls := make([]Call, 0, len(replayed))
+	seen := make(map[string]int)
+	for _, ex := range replayed {
+		call := Call{Kind: ex.Kind, Request: ex.Request, Response: ex.Response, Error: ex.Error}
+
+		n := seen[ex.Kind]
+		seen[ex.Kind]++
+		for _, orig := range original {
+			if orig.Kind != ex.Kind {
+				continue
+			}
+			if n > 0 {
+				n--
+				continue
+			}
+			call.OriginalRequest = orig.Request
+			break
+		}
+
+		call.PromptDiff = Diff(RenderRequest(call.OriginalRequest), RenderRequest(call.Request))
+		call.PromptChanged = call.PromptDiff != ""
+		calls = append(calls, call)
+	}
+	return calls
+}
+
+// RenderRequest renders the messages of a request as plain text
+func RenderRequest(req *llm.ChatCompletionRequest) string {
+	if req == nil {
+		return ""
+	}
+	var text string
+	for _, m := range req.Messages {
+		text += "[" + m.Role + "]\n" + m.Content + "\n"
+	}
+	return text
+}
+
+func ocrDeltaJSON(previousOCRJSON, ocrJSON string) string {
+	delta, _ := ocr.GetOCRDelta(previousOCRJSON, ocrJSON)
+	deltaJSON, _ := ocr.GetOCRDeltaJSONString(delta)
+	return deltaJSON
+}
+
+func sameJSON(a, b interface{}) bool {
+	aj, errA := json.Marshal(a)
+	bj, errB := json.Marshal(b)
+	return errA == nil && errB == nil && string(aj) == string(bj)
+}
+
+// noTokens discards token estimates, a replay spends no tokens
+func noTokens(int) {}
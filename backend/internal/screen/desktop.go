@@ -0,0 +1,696 @@
+package screen
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/color"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/image/font"
+
+	"useless-agent/pkg/x11"
+)
+
+// Height of the synthetic title bar, the one x11.DetectWindowButtons assumes
+const titleBarHeight = 32
+
+// Desktop is a synthetic in-memory desktop: a set of stacked windows with
+// title bars, labels, buttons and a text area, and a pointer. It implements
+// Backend, so the agent's clicks and keystrokes mutate the model and the next
+// capture shows the result, without an X server.
+type Desktop struct {
+	mutex  sync.Mutex
+	width  int
+	height int
+	face   font.Face
+
+	// Bottom to top, the last window is drawn over the others
+	windows []*Window
+	focused *Window
+	nextID  uint32
+
+	cursorX int
+	cursorY int
+
+	// What the left button went down on, decides what its release does
+	press     pressTarget
+	modifiers map[string]bool
+	hotkeys   map[string]func()
+
+	events []Event
+}
+
+// Window is a top-level window of a synthetic desktop
+type Window struct {
+	desktop *Desktop
+
+	ID    uint32
+	Title string
+	Class string
+
+	x, y, width, height int
+	// Geometry before maximizing, restored by the next maximize click
+	restore   *image.Rectangle
+	minimized bool
+	closed    bool
+	scrollY   int
+
+	labels  []label
+	buttons []*Button
+	// Text typed while the window had the focus
+	text []rune
+}
+
+// Button is a push button inside a window's client area
+type Button struct {
+	window  *Window
+	Label   string
+	x, y    int
+	width   int
+	height  int
+	clicks  int
+	onClick func()
+}
+
+// Event is one input event received by a synthetic desktop, with the
+// pointer position at the time
+type Event struct {
+	Type   string `json:"type"` // move, down, up, scroll, key, type, click, hotkey
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Delta  int    `json:"delta,omitempty"`
+	Button string `json:"button,omitempty"`
+	Key    string `json:"key,omitempty"`
+	Text   string `json:"text,omitempty"`
+	Window string `json:"window,omitempty"`
+	Target string `json:"target,omitempty"`
+}
+
+type label struct {
+	x, y int
+	text string
+}
+
+type pressTarget struct {
+	window *Window
+	// Title bar button type ("close", "minimize", ...) or "title" for a drag
+	part   string
+	button *Button
+	// Pointer offset from the window origin, for dragging by the title bar
+	dx, dy int
+}
+
+// NewDesktop creates an empty synthetic desktop of the given size with the
+// pointer in its center
+func NewDesktop(width, height int) (*Desktop, error) {
+	face, err := newFace(DefaultFontSize)
+	if err != nil {
+		return nil, err
+	}
+	return &Desktop{
+		width:     width,
+		height:    height,
+		face:      face,
+		nextID:    0x1000001,
+		cursorX:   width / 2,
+		cursorY:   height / 2,
+		modifiers: make(map[string]bool),
+		hotkeys:   make(map[string]func()),
+	}, nil
+}
+
+// AddWindow opens a window on top of the others and focuses it. The
+// rectangle includes the title bar.
+func (d *Desktop) AddWindow(title string, x, y, width, height int) *Window {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	w := &Window{
+		desktop: d,
+		ID:      d.nextID,
+		Title:   title,
+		Class:   strings.ToLower(strings.Fields(title + " window")[0]),
+		x:       x,
+		y:       y,
+		width:   width,
+		height:  height,
+	}
+	d.nextID += 0x100000
+	d.windows = append(d.windows, w)
+	d.focused = w
+	return w
+}
+
+// OnHotkey registers fn to run when a combo such as "ctrl+alt+t" is pressed,
+// e.g. to open a terminal window
+func (d *Desktop) OnHotkey(combo string, fn func()) error {
+	keys, err := x11.ParseKeyCombo(combo)
+	if err != nil {
+		return err
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.hotkeys[normalizeCombo(keys)] = fn
+	return nil
+}
+
+// Windows returns the open windows, bottom to top
+func (d *Desktop) Windows() []*Window {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]*Window(nil), d.windows...)
+}
+
+// FindWindow returns the topmost open window with the given title
+func (d *Desktop) FindWindow(title string) *Window {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for i := len(d.windows) - 1; i >= 0; i-- {
+		if d.windows[i].Title == title {
+			return d.windows[i]
+		}
+	}
+	return nil
+}
+
+// Focused returns the window receiving keyboard input, or nil
+func (d *Desktop) Focused() *Window {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.focused
+}
+
+// Events returns the input received so far
+func (d *Desktop) Events() []Event {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return append([]Event(nil), d.events...)
+}
+
+// AddLabel draws static text at a position relative to the client area
+func (w *Window) AddLabel(x, y int, text string) {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	w.labels = append(w.labels, label{x: x, y: y, text: text})
+}
+
+// AddButton places a button sized to its label at a position relative to the
+// client area. onClick, if not nil, runs after a complete click on it and may
+// change the desktop, e.g. open a dialog.
+func (w *Window) AddButton(text string, x, y int, onClick func()) *Button {
+	d := w.desktop
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	metrics := d.face.Metrics()
+	b := &Button{
+		window:  w,
+		Label:   text,
+		x:       x,
+		y:       y,
+		width:   font.MeasureString(d.face, text).Ceil() + 2*buttonPadding,
+		height:  metrics.Height.Ceil() + buttonPadding,
+		onClick: onClick,
+	}
+	w.buttons = append(w.buttons, b)
+	return b
+}
+
+// Text returns what was typed into the window
+func (w *Window) Text() string {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	return string(w.text)
+}
+
+// SetText replaces the window's typed text
+func (w *Window) SetText(text string) {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	w.text = []rune(text)
+}
+
+// Bounds returns the window rectangle including its title bar
+func (w *Window) Bounds() image.Rectangle {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	return w.bounds()
+}
+
+// Minimized reports whether the window was minimized
+func (w *Window) Minimized() bool {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	return w.minimized
+}
+
+// Closed reports whether the window was closed
+func (w *Window) Closed() bool {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	return w.closed
+}
+
+// Close closes the window
+func (w *Window) Close() {
+	w.desktop.mutex.Lock()
+	defer w.desktop.mutex.Unlock()
+	w.desktop.closeWindow(w)
+}
+
+// Clicks returns how many times the button was clicked
+func (b *Button) Clicks() int {
+	b.window.desktop.mutex.Lock()
+	defer b.window.desktop.mutex.Unlock()
+	return b.clicks
+}
+
+// Center returns the screen position of the middle of the button
+func (b *Button) Center() image.Point {
+	b.window.desktop.mutex.Lock()
+	defer b.window.desktop.mutex.Unlock()
+	r := b.bounds()
+	return image.Pt((r.Min.X+r.Max.X)/2, (r.Min.Y+r.Max.Y)/2)
+}
+
+func (w *Window) bounds() image.Rectangle {
+	return image.Rect(w.x, w.y, w.x+w.width, w.y+w.height)
+}
+
+func (w *Window) clientBounds() image.Rectangle {
+	return image.Rect(w.x, w.y+titleBarHeight, w.x+w.width, w.y+w.height)
+}
+
+func (w *Window) titleButtons() []x11.WindowButton {
+	return x11.DetectWindowButtons(x11.WindowPosition{X: w.x, Y: w.y}, x11.WindowSize{Width: w.width, Height: w.height})
+}
+
+// bounds of a button in screen coordinates, following the window's scroll
+func (b *Button) bounds() image.Rectangle {
+	client := b.window.clientBounds()
+	x := client.Min.X + b.x
+	y := client.Min.Y + b.y - b.window.scrollY
+	return image.Rect(x, y, x+b.width, y+b.height)
+}
+
+// Backend implementation
+
+// CursorPosition returns the pointer position
+func (d *Desktop) CursorPosition() (int, int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	return d.cursorX, d.cursorY
+}
+
+// WindowsJSON describes the visible windows like x11.GetX11WindowsWithDisplay
+func (d *Desktop) WindowsJSON() (string, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	windows := []x11.X11Window{}
+	for _, w := range d.windows {
+		if w.minimized {
+			continue
+		}
+		states := []string{}
+		if w == d.focused {
+			states = append(states, "_NET_WM_STATE_FOCUSED")
+		}
+		if w.restore != nil {
+			states = append(states, "_NET_WM_STATE_MAXIMIZED_VERT", "_NET_WM_STATE_MAXIMIZED_HORZ")
+		}
+		windows = append(windows, x11.X11Window{
+			ID:         w.ID,
+			Title:      w.Title,
+			Class:      w.Class,
+			Name:       w.Class,
+			Position:   x11.WindowPosition{X: w.x, Y: w.y},
+			Size:       x11.WindowSize{Width: w.width, Height: w.height},
+			Buttons:    w.titleButtons(),
+			Visible:    true,
+			State:      states,
+			WindowType: "_NET_WM_WINDOW_TYPE_NORMAL",
+		})
+	}
+
+	data, err := json.MarshalIndent(x11.X11WindowInfo{Windows: windows}, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal window data to JSON: %w", err)
+	}
+	return string(data), nil
+}
+
+// MoveMouse moves the pointer, dragging a window held by its title bar
+func (d *Desktop) MoveMouse(x, y int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	d.cursorX = clamp(x, 0, d.width-1)
+	d.cursorY = clamp(y, 0, d.height-1)
+	if p := d.press; p.window != nil && p.part == "title" && p.window.restore == nil {
+		p.window.x = d.cursorX - p.dx
+		p.window.y = d.cursorY - p.dy
+	}
+	d.record(Event{Type: "move"})
+}
+
+// MouseDown presses a button; the left button raises and focuses the window
+// under the pointer
+func (d *Desktop) MouseDown(button string) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	w := d.windowAt(d.cursorX, d.cursorY)
+	d.record(Event{Type: "down", Button: button, Window: titleOf(w)})
+	if button != "left" {
+		return
+	}
+
+	d.press = pressTarget{}
+	if w == nil {
+		d.focused = nil
+		return
+	}
+	d.raise(w)
+	d.press = d.partAt(w, d.cursorX, d.cursorY)
+}
+
+// MouseUp releases a button. A left release over the part the button went
+// down on completes a click on it.
+func (d *Desktop) MouseUp(button string) {
+	d.mutex.Lock()
+	w := d.windowAt(d.cursorX, d.cursorY)
+	d.record(Event{Type: "up", Button: button, Window: titleOf(w)})
+	if button != "left" {
+		d.mutex.Unlock()
+		return
+	}
+
+	press := d.press
+	d.press = pressTarget{}
+	var onClick func()
+	if press.window != nil && press.window == w && press.part != "title" {
+		release := d.partAt(w, d.cursorX, d.cursorY)
+		if release.part == press.part && release.button == press.button {
+			onClick = d.click(press)
+		}
+	}
+	d.mutex.Unlock()
+
+	// Outside the lock, the callback may change the desktop
+	if onClick != nil {
+		onClick()
+	}
+}
+
+// Scroll scrolls the content of the window under the pointer
+func (d *Desktop) Scroll(dy int) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	w := d.windowAt(d.cursorX, d.cursorY)
+	d.record(Event{Type: "scroll", Delta: dy, Window: titleOf(w)})
+	if w == nil {
+		return
+	}
+	w.scrollY = max(0, w.scrollY+dy*d.face.Metrics().Height.Ceil())
+}
+
+// KeyDown presses a key. Printable keys type into the focused window unless
+// ctrl, alt or super is held, in which case the combo runs a registered
+// hotkey; alt+f4 closes the focused window.
+func (d *Desktop) KeyDown(name string) error {
+	keysym, ok := x11.KeyNameToKeysym(name)
+	if !ok {
+		return fmt.Errorf("unknown key name %q", name)
+	}
+
+	d.mutex.Lock()
+	key := strings.ToLower(name)
+	d.record(Event{Type: "key", Key: key})
+	if x11.IsModifierKey(name) {
+		d.modifiers[modifierName(key)] = true
+		d.mutex.Unlock()
+		return nil
+	}
+
+	if d.modifiers["ctrl"] || d.modifiers["alt"] || d.modifiers["super"] {
+		combo := d.heldCombo(key)
+		d.record(Event{Type: "hotkey", Key: combo, Window: titleOf(d.focused)})
+		fn := d.hotkeys[combo]
+		if fn == nil && combo == "alt+f4" && d.focused != nil {
+			d.closeWindow(d.focused)
+		}
+		d.mutex.Unlock()
+		if fn != nil {
+			fn()
+		}
+		return nil
+	}
+
+	defer d.mutex.Unlock()
+	switch key {
+	case "enter", "return":
+		d.typeRune('\n')
+	case "backspace":
+		d.typeRune('\b')
+	case "tab":
+		d.typeRune('\t')
+	default:
+		if keysym >= 0x20 && keysym < 0x100 {
+			r := rune(keysym)
+			if d.modifiers["shift"] {
+				r = []rune(strings.ToUpper(string(r)))[0]
+			}
+			d.typeRune(r)
+		}
+	}
+	return nil
+}
+
+// KeyUp releases a key
+func (d *Desktop) KeyUp(name string) error {
+	if _, ok := x11.KeyNameToKeysym(name); !ok {
+		return fmt.Errorf("unknown key name %q", name)
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if x11.IsModifierKey(name) {
+		delete(d.modifiers, modifierName(strings.ToLower(name)))
+	}
+	return nil
+}
+
+// TypeString types text into the focused window; there is no real typing
+// delay, the synthetic desktop does not need one
+func (d *Desktop) TypeString(text string, delay time.Duration) error {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.record(Event{Type: "type", Text: text, Window: titleOf(d.focused)})
+	for _, r := range text {
+		d.typeRune(r)
+	}
+	return nil
+}
+
+// Model helpers, called with the mutex held
+
+func (d *Desktop) record(e Event) {
+	e.X, e.Y = d.cursorX, d.cursorY
+	d.events = append(d.events, e)
+}
+
+// windowAt returns the topmost visible window containing a point
+func (d *Desktop) windowAt(x, y int) *Window {
+	for i := len(d.windows) - 1; i >= 0; i-- {
+		w := d.windows[i]
+		if !w.minimized && image.Pt(x, y).In(w.bounds()) {
+			return w
+		}
+	}
+	return nil
+}
+
+// partAt returns what of a window is under a point
+func (d *Desktop) partAt(w *Window, x, y int) pressTarget {
+	p := image.Pt(x, y)
+	target := pressTarget{window: w, dx: x - w.x, dy: y - w.y}
+	for _, tb := range w.titleButtons() {
+		r := image.Rect(tb.Position.X, tb.Position.Y, tb.Position.X+tb.Size.Width, tb.Position.Y+tb.Size.Height)
+		if p.In(r) {
+			target.part = tb.Type
+			return target
+		}
+	}
+	if y < w.y+titleBarHeight {
+		target.part = "title"
+		return target
+	}
+	for _, b := range w.buttons {
+		if p.In(b.bounds().Intersect(w.clientBounds())) {
+			target.part = "button"
+			target.button = b
+			return target
+		}
+	}
+	target.part = "client"
+	return target
+}
+
+// click applies a completed click and returns the callback to run, if any
+func (d *Desktop) click(p pressTarget) func() {
+	w := p.window
+	d.record(Event{Type: "click", Button: "left", Window: w.Title, Target: targetName(p)})
+	switch p.part {
+	case "close":
+		d.closeWindow(w)
+	case "minimize":
+		w.minimized = true
+		d.focusTop()
+	case "maximize":
+		if w.restore != nil {
+			w.x, w.y, w.width, w.height = w.restore.Min.X, w.restore.Min.Y, w.restore.Dx(), w.restore.Dy()
+			w.restore = nil
+		} else {
+			r := w.bounds()
+			w.restore = &r
+			w.x, w.y, w.width, w.height = 0, 0, d.width, d.height
+		}
+	case "roll-up":
+		// Shading is not modelled, the click is only recorded
+	case "button":
+		p.button.clicks++
+		return p.button.onClick
+	}
+	return nil
+}
+
+func (d *Desktop) raise(w *Window) {
+	for i, other := range d.windows {
+		if other == w {
+			d.windows = append(append(d.windows[:i:i], d.windows[i+1:]...), w)
+			break
+		}
+	}
+	d.focused = w
+}
+
+func (d *Desktop) closeWindow(w *Window) {
+	for i, other := range d.windows {
+		if other == w {
+			d.windows = append(d.windows[:i:i], d.windows[i+1:]...)
+			break
+		}
+	}
+	w.closed = true
+	if d.focused == w {
+		d.focusTop()
+	}
+}
+
+// focusTop focuses the topmost visible window
+func (d *Desktop) focusTop() {
+	d.focused = nil
+	for i := len(d.windows) - 1; i >= 0; i-- {
+		if !d.windows[i].minimized {
+			d.focused = d.windows[i]
+			return
+		}
+	}
+}
+
+func (d *Desktop) typeRune(r rune) {
+	w := d.focused
+	if w == nil {
+		return
+	}
+	if r == '\b' {
+		if len(w.text) > 0 {
+			w.text = w.text[:len(w.text)-1]
+		}
+		return
+	}
+	w.text = append(w.text, r)
+}
+
+// heldCombo names the held modifiers and a key as "ctrl+alt+t"
+func (d *Desktop) heldCombo(key string) string {
+	keys := []string{key}
+	for m := range d.modifiers {
+		keys = append(keys, m)
+	}
+	return normalizeCombo(keys)
+}
+
+// normalizeCombo orders modifiers canonically so "alt+ctrl+t" and
+// "ctrl+alt+t" match the same hotkey
+func normalizeCombo(keys []string) string {
+	order := map[string]int{"ctrl": 0, "alt": 1, "shift": 2, "super": 3}
+	var mods, rest []string
+	for _, k := range keys {
+		k = strings.ToLower(k)
+		if x11.IsModifierKey(k) {
+			mods = append(mods, modifierName(k))
+		} else {
+			rest = append(rest, k)
+		}
+	}
+	sort.Slice(mods, func(i, j int) bool { return order[mods[i]] < order[mods[j]] })
+	return strings.Join(append(mods, rest...), "+")
+}
+
+// modifierName maps modifier aliases such as "lctrl" or "control" to one name
+func modifierName(key string) string {
+	switch {
+	case strings.Contains(key, "ctrl"), strings.Contains(key, "control"):
+		return "ctrl"
+	case strings.Contains(key, "shift"):
+		return "shift"
+	case strings.Contains(key, "alt"):
+		return "alt"
+	case strings.Contains(key, "super"), key == "win", key == "cmd", key == "meta":
+		return "super"
+	}
+	return key
+}
+
+func targetName(p pressTarget) string {
+	if p.button != nil {
+		return p.button.Label
+	}
+	return p.part
+}
+
+func titleOf(w *Window) string {
+	if w == nil {
+		return ""
+	}
+	return w.Title
+}
+
+func clamp(v, lo, hi int) int {
+	return max(lo, min(v, hi))
+}
+
+// Colors of the synthetic theme
+var (
+	desktopColor        = color.RGBA{R: 0x3a, G: 0x6e, B: 0xa5, A: 0xff}
+	frameColor          = color.RGBA{R: 0x30, G: 0x30, B: 0x30, A: 0xff}
+	titleFocusedColor   = color.RGBA{R: 0x2f, G: 0x34, B: 0x3f, A: 0xff}
+	titleUnfocusedColor = color.RGBA{R: 0x6b, G: 0x70, B: 0x78, A: 0xff}
+	clientColor         = color.RGBA{R: 0xff, G: 0xff, B: 0xff, A: 0xff}
+	textColor           = color.RGBA{R: 0x10, G: 0x10, B: 0x10, A: 0xff}
+	titleTextColor      = color.RGBA{R: 0xf0, G: 0xf0, B: 0xf0, A: 0xff}
+	buttonColor         = color.RGBA{R: 0xdd, G: 0xdd, B: 0xdd, A: 0xff}
+	cursorColor         = color.RGBA{R: 0xff, G: 0x00, B: 0x00, A: 0xff}
+	titleButtonColors   = map[string]color.RGBA{
+		"close":    {R: 0xe0, G: 0x4a, B: 0x3f, A: 0xff},
+		"maximize": {R: 0x4c, G: 0xb0, B: 0x50, A: 0xff},
+		"minimize": {R: 0xe8, G: 0xb9, B: 0x3a, A: 0xff},
+		"roll-up":  {R: 0x9e, G: 0x9e, B: 0x9e, A: 0xff},
+	}
+)
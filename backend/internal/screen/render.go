@@ -0,0 +1,149 @@
+package screen
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"sync"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+
+	"useless-agent/internal/config"
+)
+
+// DefaultFontSize is the pixel size of synthetic text, large enough for
+// tesseract to read it reliably
+const DefaultFontSize = 16
+
+// Space between a button's label and its border
+const buttonPadding = 8
+
+// Space between the client area border and its content
+const clientPadding = 8
+
+var (
+	jetBrainsMono     *sfnt.Font
+	jetBrainsMonoErr  error
+	jetBrainsMonoOnce sync.Once
+)
+
+// newFace returns a JetBrains Mono face of the given pixel size, the font
+// embedded in the binary
+func newFace(size float64) (font.Face, error) {
+	jetBrainsMonoOnce.Do(func() {
+		data, err := config.Fonts.ReadFile("assets/fonts/JetBrainsMono-Regular.ttf")
+		if err != nil {
+			jetBrainsMonoErr = fmt.Errorf("failed to read embedded font: %w", err)
+			return
+		}
+		jetBrainsMono, jetBrainsMonoErr = opentype.Parse(data)
+	})
+	if jetBrainsMonoErr != nil {
+		return nil, jetBrainsMonoErr
+	}
+	return opentype.NewFace(jetBrainsMono, &opentype.FaceOptions{
+		Size:    size,
+		DPI:     72,
+		Hinting: font.HintingFull,
+	})
+}
+
+// Capture renders the desktop and draws the pointer as a red cross, like
+// screenshot.CaptureX11Screenshot does
+func (d *Desktop) Capture() (image.Image, error) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	img := image.NewRGBA(image.Rect(0, 0, d.width, d.height))
+	fill(img, img.Bounds(), desktopColor)
+	for _, w := range d.windows {
+		if !w.minimized {
+			d.drawWindow(img, w)
+		}
+	}
+
+	const cursorSize = 10
+	for delta := -cursorSize; delta <= cursorSize; delta++ {
+		img.Set(d.cursorX+delta, d.cursorY, cursorColor)
+		img.Set(d.cursorX, d.cursorY+delta, cursorColor)
+	}
+	return img, nil
+}
+
+func (d *Desktop) drawWindow(img *image.RGBA, w *Window) {
+	bounds := w.bounds()
+	fill(img, bounds, frameColor)
+
+	title := bounds.Inset(1)
+	title.Max.Y = bounds.Min.Y + titleBarHeight
+	titleColor := titleUnfocusedColor
+	if w == d.focused {
+		titleColor = titleFocusedColor
+	}
+	fill(img, title, titleColor)
+
+	buttons := w.titleButtons()
+	titleClip := title
+	if len(buttons) > 0 {
+		titleClip.Max.X = buttons[len(buttons)-1].Position.X
+	}
+	lineHeight := d.face.Metrics().Height.Ceil()
+	d.drawText(img, titleClip, bounds.Min.X+clientPadding, bounds.Min.Y+(titleBarHeight-lineHeight)/2, w.Title, titleTextColor)
+	for _, b := range buttons {
+		r := image.Rect(b.Position.X, b.Position.Y, b.Position.X+b.Size.Width, b.Position.Y+b.Size.Height)
+		fill(img, r, titleButtonColors[b.Type])
+	}
+
+	client := w.clientBounds().Inset(1)
+	client.Min.Y = title.Max.Y
+	fill(img, client, clientColor)
+
+	origin := client.Min.Sub(image.Pt(0, w.scrollY))
+	bottom := 0
+	for _, l := range w.labels {
+		d.drawText(img, client, origin.X+l.x, origin.Y+l.y, l.text, textColor)
+		bottom = max(bottom, l.y+lineHeight*(strings.Count(l.text, "\n")+1))
+	}
+	for _, b := range w.buttons {
+		r := b.bounds()
+		fill(img, r.Intersect(client), frameColor)
+		fill(img, r.Inset(1).Intersect(client), buttonColor)
+		d.drawText(img, client, r.Min.X+buttonPadding, r.Min.Y+buttonPadding/2, b.Label, textColor)
+		bottom = max(bottom, b.y+b.height)
+	}
+
+	// Typed text goes below the static content, like an editor or a terminal
+	// under a toolbar
+	textY := clientPadding
+	if bottom > 0 {
+		textY = bottom + lineHeight/2
+	}
+	d.drawText(img, client, origin.X+clientPadding, origin.Y+textY, string(w.text), textColor)
+}
+
+// drawText draws multi-line text with its top-left corner at x, y, clipped
+func (d *Desktop) drawText(img *image.RGBA, clip image.Rectangle, x, y int, text string, c color.RGBA) {
+	dst, ok := img.SubImage(clip).(*image.RGBA)
+	if !ok || dst.Bounds().Empty() {
+		return
+	}
+	metrics := d.face.Metrics()
+	drawer := font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(c),
+		Face: d.face,
+	}
+	for i, line := range strings.Split(text, "\n") {
+		drawer.Dot = fixed.P(x, y+metrics.Ascent.Ceil()+i*metrics.Height.Ceil())
+		drawer.DrawString(strings.ReplaceAll(line, "\t", "    "))
+	}
+}
+
+func fill(img *image.RGBA, r image.Rectangle, c color.RGBA) {
+	draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+}
@@ -0,0 +1,52 @@
+// Package screen abstracts the desktop the agent looks at and drives. By
+// default nothing is installed and callers use the real X11 display; a
+// Backend installed with Use (e.g. a synthetic Desktop) replaces screenshot
+// capture, cursor and window queries and all mouse and keyboard input.
+package screen
+
+import (
+	"image"
+	"sync"
+	"time"
+)
+
+// Backend is a desktop that can be captured and receives input. Key names
+// are the ones accepted by x11.KeyNameToKeysym, buttons are "left", "middle"
+// and "right".
+type Backend interface {
+	// Capture returns a screenshot with the cursor drawn in
+	Capture() (image.Image, error)
+	// CursorPosition returns the pointer position in screen coordinates
+	CursorPosition() (int, int)
+	// WindowsJSON returns the top-level windows in the x11.X11WindowInfo format
+	WindowsJSON() (string, error)
+
+	MoveMouse(x, y int)
+	MouseDown(button string)
+	MouseUp(button string)
+	// Scroll scrolls the content under the pointer, positive dy scrolls down
+	Scroll(dy int)
+
+	KeyDown(name string) error
+	KeyUp(name string) error
+	TypeString(text string, delay time.Duration) error
+}
+
+var (
+	current      Backend
+	currentMutex sync.RWMutex
+)
+
+// Use installs b as the desktop, nil restores the real X11 display
+func Use(b Backend) {
+	currentMutex.Lock()
+	defer currentMutex.Unlock()
+	current = b
+}
+
+// Current returns the installed backend, or nil when the real X11 display is used
+func Current() Backend {
+	currentMutex.RLock()
+	defer currentMutex.RUnlock()
+	return current
+}
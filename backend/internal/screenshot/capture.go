@@ -7,6 +7,7 @@ import (
 	"image/png"
 	"os"
 	"useless-agent/internal/config"
+	"useless-agent/internal/screen"
 
 	"github.com/BurntSushi/xgb"
 	"github.com/BurntSushi/xgb/xproto"
@@ -22,8 +23,13 @@ func SuppressXGBLogs() error {
 	return nil
 }
 
-// CaptureX11Screenshot captures an X11 screenshot
+// CaptureX11Screenshot captures an X11 screenshot, or the installed screen
+// backend's desktop
 func CaptureX11Screenshot() (image.Image, error) {
+	if b := screen.Current(); b != nil {
+		return b.Capture()
+	}
+
 	// Set DISPLAY environment variable if specified
 	if display := *config.Display; display != "" {
 		os.Setenv("DISPLAY", display)
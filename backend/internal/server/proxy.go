@@ -0,0 +1,102 @@
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// parseTrustedProxies accepts single IPs and CIDRs
+func parseTrustedProxies(specs []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, spec := range specs {
+		if !strings.Contains(spec, "/") {
+			ip := net.ParseIP(spec)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", spec)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 32
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(spec)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %w", spec, err)
+		}
+		nets = append(nets, n)
+	}
+	return nets, nil
+}
+
+func trusted(proxies []*net.IPNet, addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		host = addr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil {
+		return false
+	}
+	for _, n := range proxies {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// forwarded applies the X-Forwarded-For, -Proto and -Host headers of
+// requests coming from a trusted proxy, so RemoteAddr is the client (as
+// logged by auth), Host is what the client asked for and URL.Scheme says
+// whether the client used TLS. Headers from anyone else are ignored.
+func forwarded(proxies []*net.IPNet, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !trusted(proxies, r.RemoteAddr) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		r = r.Clone(r.Context())
+		if client := forwardedClient(proxies, r.Header.Values("X-Forwarded-For")); client != "" {
+			r.RemoteAddr = net.JoinHostPort(client, "0")
+		}
+		if proto := firstValue(r.Header.Get("X-Forwarded-Proto")); proto == "http" || proto == "https" {
+			r.URL.Scheme = proto
+		}
+		if host := firstValue(r.Header.Get("X-Forwarded-Host")); host != "" {
+			r.Host = host
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// forwardedClient walks X-Forwarded-For from the right, skipping our own
+// proxies, so a client can not pose as another by sending the header itself
+func forwardedClient(proxies []*net.IPNet, values []string) string {
+	var hops []string
+	for _, v := range values {
+		for _, hop := range strings.Split(v, ",") {
+			if hop = strings.TrimSpace(hop); hop != "" {
+				hops = append(hops, hop)
+			}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		if net.ParseIP(hops[i]) == nil {
+			return ""
+		}
+		if i == 0 || !trusted(proxies, hops[i]) {
+			return hops[i]
+		}
+	}
+	return ""
+}
+
+func firstValue(header string) string {
+	first, _, _ := strings.Cut(header, ",")
+	return strings.ToLower(strings.TrimSpace(first))
+}
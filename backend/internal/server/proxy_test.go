@@ -0,0 +1,121 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	tests := []struct {
+		name      string
+		specs     []string
+		contains  []string
+		excludes  []string
+		wantError bool
+	}{
+		{name: "bare IPv4 is a single host", specs: []string{"10.0.0.1"}, contains: []string{"10.0.0.1", "10.0.0.1:443"}, excludes: []string{"10.0.0.2"}},
+		{name: "bare IPv6 is a single host", specs: []string{"fd00::1"}, contains: []string{"fd00::1", "[fd00::1]:443"}, excludes: []string{"fd00::2"}},
+		{name: "CIDR covers its range", specs: []string{"10.0.0.0/24"}, contains: []string{"10.0.0.1", "10.0.0.254"}, excludes: []string{"10.0.1.1", "not-an-ip"}},
+		{name: "several specs", specs: []string{"127.0.0.1", "192.168.0.0/16"}, contains: []string{"127.0.0.1", "192.168.7.7"}, excludes: []string{"127.0.0.2"}},
+		{name: "none", specs: nil, excludes: []string{"127.0.0.1"}},
+		{name: "hostname", specs: []string{"proxy.local"}, wantError: true},
+		{name: "bad CIDR", specs: []string{"10.0.0.0/33"}, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			proxies, err := parseTrustedProxies(tt.specs)
+			if tt.wantError {
+				if err == nil {
+					t.Fatalf("parseTrustedProxies(%v) accepted invalid specs", tt.specs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, addr := range tt.contains {
+				if !trusted(proxies, addr) {
+					t.Errorf("%s is not trusted", addr)
+				}
+			}
+			for _, addr := range tt.excludes {
+				if trusted(proxies, addr) {
+					t.Errorf("%s is trusted", addr)
+				}
+			}
+		})
+	}
+}
+
+func TestForwardedClient(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.1", "172.16.0.0/12"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		values []string
+		want   string
+	}{
+		{name: "single client", values: []string{"203.0.113.7"}, want: "203.0.113.7"},
+		{name: "our proxies are skipped", values: []string{"203.0.113.7, 172.16.4.2, 10.0.0.1"}, want: "203.0.113.7"},
+		{name: "spoofed leftmost hop is ignored", values: []string{"1.1.1.1, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "spoofed hop behind our proxy is ignored", values: []string{"1.1.1.1, 203.0.113.7, 10.0.0.1"}, want: "203.0.113.7"},
+		{name: "header split over several lines", values: []string{"1.1.1.1", "203.0.113.7, 10.0.0.1"}, want: "203.0.113.7"},
+		{name: "all trusted gives the leftmost", values: []string{"172.16.0.9, 10.0.0.1"}, want: "172.16.0.9"},
+		{name: "IPv6 client", values: []string{"2001:db8::1, 10.0.0.1"}, want: "2001:db8::1"},
+		{name: "empty hops are skipped", values: []string{" , 203.0.113.7 ,"}, want: "203.0.113.7"},
+		{name: "non-IP hop", values: []string{"unknown, 10.0.0.1"}, want: ""},
+		{name: "hop with a port", values: []string{"203.0.113.7:5000"}, want: ""},
+		{name: "non-IP hop left of the client is not reached", values: []string{"garbage, 203.0.113.7"}, want: "203.0.113.7"},
+		{name: "no header", values: nil, want: ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := forwardedClient(proxies, tt.values); got != tt.want {
+				t.Errorf("forwardedClient(%q) = %q, want %q", tt.values, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestForwarded(t *testing.T) {
+	proxies, err := parseTrustedProxies([]string{"10.0.0.1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		remoteAddr string
+		wantAddr   string
+		wantScheme string
+		wantHost   string
+	}{
+		{name: "trusted proxy", remoteAddr: "10.0.0.1:4000", wantAddr: "203.0.113.7:0", wantScheme: "https", wantHost: "agent.example.com"},
+		{name: "untrusted peer", remoteAddr: "198.51.100.3:4000", wantAddr: "198.51.100.3:4000", wantScheme: "", wantHost: "backend:8080"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got *http.Request
+			handler := forwarded(proxies, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { got = r }))
+
+			r := httptest.NewRequest(http.MethodGet, "/health", nil)
+			r.Host = "backend:8080"
+			r.RemoteAddr = tt.remoteAddr
+			r.Header.Set("X-Forwarded-For", "203.0.113.7")
+			r.Header.Set("X-Forwarded-Proto", "HTTPS, http")
+			r.Header.Set("X-Forwarded-Host", "agent.example.com")
+			handler.ServeHTTP(httptest.NewRecorder(), r)
+
+			if got.RemoteAddr != tt.wantAddr || got.URL.Scheme != tt.wantScheme || got.Host != tt.wantHost {
+				t.Errorf("got RemoteAddr %q, scheme %q, host %q; want %q, %q, %q", got.RemoteAddr, got.URL.Scheme, got.Host, tt.wantAddr, tt.wantScheme, tt.wantHost)
+			}
+		})
+	}
+}
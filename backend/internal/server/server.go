@@ -0,0 +1,199 @@
+// Package server serves the HTTP API: plain or TLS (optionally mutual),
+// under a base path, behind trusted reverse proxies, with a graceful
+// shutdown that cancels the tasks and closes the WebSocket clients.
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/task"
+	"useless-agent/internal/websocket"
+)
+
+var logger = logging.For("server")
+
+// Options configure how the API is served
+type Options struct {
+	Addr string
+
+	// BasePath prefixes every route, "" or "/" serves at the root
+	BasePath string
+
+	// TLS is off unless a certificate is given or SelfSigned is set
+	CertFile   string
+	KeyFile    string
+	SelfSigned bool
+	ClientCA   string // PEM bundle for mTLS, needs TLS
+
+	// TrustedProxies are the IPs and CIDRs whose X-Forwarded-* headers are used
+	TrustedProxies []string
+
+	ShutdownTimeout time.Duration
+}
+
+// OptionsFromFlags builds the options from the serving command line flags
+func OptionsFromFlags() Options {
+	var proxies []string
+	for _, p := range strings.Split(*config.TrustedProxies, ",") {
+		if p = strings.TrimSpace(p); p != "" {
+			proxies = append(proxies, p)
+		}
+	}
+	return Options{
+		Addr:            net.JoinHostPort(*config.BindIP, strconv.Itoa(*config.BindPORT)),
+		BasePath:        *config.BasePath,
+		CertFile:        *config.TLSCert,
+		KeyFile:         *config.TLSKey,
+		SelfSigned:      *config.TLSSelfSigned,
+		ClientCA:        *config.TLSClientCA,
+		TrustedProxies:  proxies,
+		ShutdownTimeout: *config.ShutdownTimeout,
+	}
+}
+
+// TLSEnabled reports whether the options serve HTTPS
+func (o Options) TLSEnabled() bool {
+	return o.SelfSigned || o.CertFile != "" || o.KeyFile != ""
+}
+
+// URL is the address the server can be reached at, for the startup log
+func (o Options) URL() string {
+	scheme := "http"
+	if o.TLSEnabled() {
+		scheme = "https"
+	}
+	return scheme + "://" + o.Addr + normalizeBasePath(o.BasePath) + "/"
+}
+
+// Run serves handler until SIGINT or SIGTERM, then shuts down gracefully
+func Run(opts Options, handler http.Handler) error {
+	handler, err := wrap(opts, handler)
+	if err != nil {
+		return err
+	}
+
+	// Long-lived requests (event streams) watch their context, which is
+	// canceled when shutdown begins so they do not hold it up
+	baseCtx, cancelRequests := context.WithCancel(context.Background())
+	defer cancelRequests()
+	srv := &http.Server{
+		Addr:              opts.Addr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelWarn), // TLS handshake failures
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+	}
+	srv.RegisterOnShutdown(cancelRequests)
+	if opts.TLSEnabled() {
+		if srv.TLSConfig, err = tlsConfig(opts); err != nil {
+			return err
+		}
+	} else if opts.ClientCA != "" {
+		return errors.New("-tls-client-ca needs TLS, set -tls-cert/-tls-key or -tls-self-signed")
+	}
+
+	listener, err := net.Listen("tcp", opts.Addr)
+	if err != nil {
+		return err
+	}
+	if srv.TLSConfig != nil {
+		listener = tls.NewListener(listener, srv.TLSConfig)
+	}
+	logger.Info("Server running on "+opts.URL(), "tls", srv.TLSConfig != nil, "mtls", opts.ClientCA != "", "trustedProxies", opts.TrustedProxies)
+
+	serveErr := make(chan error, 1)
+	go func() { serveErr <- srv.Serve(listener) }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	defer signal.Stop(signals)
+
+	select {
+	case err := <-serveErr:
+		return err
+	case sig := <-signals:
+		logger.Info("shutting down", "signal", sig.String(), "timeout", opts.ShutdownTimeout)
+	}
+
+	return shutdown(srv, opts.ShutdownTimeout)
+}
+
+// shutdown stops accepting connections and waits for the requests in
+// flight, stops the scheduler, cancels every task and waits for the
+// running one to stop, then closes the WebSocket clients, which by then
+// got the final task updates.
+// A second signal is not needed: everything is bounded by timeout.
+func shutdown(srv *http.Server, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var errs []error
+	if err := srv.Shutdown(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("http shutdown: %w", err))
+	}
+
+	task.StopScheduler()
+	if canceled := task.CancelAll("Task canceled, the server is shutting down"); canceled > 0 {
+		logger.Info("canceled tasks", "count", canceled)
+	}
+	if err := task.WaitIdle(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("running task did not stop: %w", err))
+	}
+
+	websocket.CloseAll("server shutting down")
+	return errors.Join(errs...)
+}
+
+func wrap(opts Options, handler http.Handler) (http.Handler, error) {
+	proxies, err := parseTrustedProxies(opts.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	handler = withBasePath(normalizeBasePath(opts.BasePath), handler)
+	if len(proxies) > 0 {
+		handler = forwarded(proxies, handler)
+	}
+	return handler, nil
+}
+
+// normalizeBasePath turns "agent/", "/agent" and "/agent/" into "/agent"
+// and "/" into ""
+func normalizeBasePath(p string) string {
+	p = strings.Trim(p, "/")
+	if p == "" {
+		return ""
+	}
+	return "/" + p
+}
+
+// withBasePath serves handler below prefix only, with the prefix removed
+func withBasePath(prefix string, handler http.Handler) http.Handler {
+	if prefix == "" {
+		return handler
+	}
+	stripped := http.StripPrefix(prefix, handler)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == prefix:
+			http.Redirect(w, r, prefix+"/", http.StatusMovedPermanently)
+		case strings.HasPrefix(r.URL.Path, prefix+"/"):
+			stripped.ServeHTTP(w, r)
+		default:
+			http.NotFound(w, r)
+		}
+	})
+}
@@ -0,0 +1,65 @@
+package server
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestNormalizeBasePath(t *testing.T) {
+	tests := map[string]string{
+		"":              "",
+		"/":             "",
+		"//":            "",
+		"agent":         "/agent",
+		"/agent":        "/agent",
+		"agent/":        "/agent",
+		"/agent/":       "/agent",
+		"/tools/agent/": "/tools/agent",
+	}
+	for in, want := range tests {
+		if got := normalizeBasePath(in); got != want {
+			t.Errorf("normalizeBasePath(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestWithBasePath(t *testing.T) {
+	echo := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.URL.Path))
+	})
+
+	tests := []struct {
+		name       string
+		prefix     string
+		path       string
+		wantStatus int
+		wantBody   string
+		wantTarget string
+	}{
+		{name: "no prefix serves everything", prefix: "", path: "/health", wantStatus: http.StatusOK, wantBody: "/health"},
+		{name: "root below the prefix", prefix: "/agent", path: "/agent/", wantStatus: http.StatusOK, wantBody: "/"},
+		{name: "prefix is stripped", prefix: "/agent", path: "/agent/api/v2/tasks", wantStatus: http.StatusOK, wantBody: "/api/v2/tasks"},
+		{name: "bare prefix redirects", prefix: "/agent", path: "/agent", wantStatus: http.StatusMovedPermanently, wantTarget: "/agent/"},
+		{name: "outside the prefix", prefix: "/agent", path: "/health", wantStatus: http.StatusNotFound},
+		{name: "prefix of a longer segment", prefix: "/agent", path: "/agents/", wantStatus: http.StatusNotFound},
+		{name: "root outside the prefix", prefix: "/agent", path: "/", wantStatus: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			withBasePath(tt.prefix, echo).ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.path, nil))
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("status %d, want %d", w.Code, tt.wantStatus)
+			}
+			if tt.wantBody != "" && w.Body.String() != tt.wantBody {
+				t.Errorf("handler saw path %q, want %q", w.Body.String(), tt.wantBody)
+			}
+			if location := w.Header().Get("Location"); location != tt.wantTarget {
+				t.Errorf("redirect to %q, want %q", location, tt.wantTarget)
+			}
+		})
+	}
+}
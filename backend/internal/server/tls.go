@@ -0,0 +1,145 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// selfSignedValidity is how long a generated certificate is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+func tlsConfig(opts Options) (*tls.Config, error) {
+	cert, err := loadCertificate(opts)
+	if err != nil {
+		return nil, err
+	}
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if opts.ClientCA != "" {
+		bundle, err := os.ReadFile(opts.ClientCA)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client CA: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no certificates in client CA %s", opts.ClientCA)
+		}
+		cfg.ClientCAs = pool
+		cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return cfg, nil
+}
+
+// loadCertificate reads the configured certificate, or generates a
+// self-signed one and saves it where the certificate was expected so the
+// next start presents the same one to clients that pinned it
+func loadCertificate(opts Options) (tls.Certificate, error) {
+	if (opts.CertFile == "") != (opts.KeyFile == "") {
+		return tls.Certificate{}, errors.New("-tls-cert and -tls-key must be given together")
+	}
+	if opts.CertFile != "" {
+		_, err := os.Stat(opts.CertFile)
+		if err == nil || !opts.SelfSigned {
+			cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+			if err != nil {
+				return tls.Certificate{}, fmt.Errorf("failed to load TLS certificate: %w", err)
+			}
+			return cert, nil
+		}
+	}
+
+	certPEM, keyPEM, err := generateSelfSigned(opts.Addr)
+	if err != nil {
+		return tls.Certificate{}, fmt.Errorf("failed to generate self-signed certificate: %w", err)
+	}
+	if opts.CertFile != "" {
+		if err := writePEM(opts.CertFile, certPEM, 0644); err != nil {
+			return tls.Certificate{}, err
+		}
+		if err := writePEM(opts.KeyFile, keyPEM, 0600); err != nil {
+			return tls.Certificate{}, err
+		}
+	}
+
+	cert, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	fingerprint := sha256.Sum256(cert.Certificate[0])
+	logger.Warn("serving a self-signed certificate, verify its fingerprint on first connect",
+		"sha256", hex.EncodeToString(fingerprint[:]), "saved", opts.CertFile)
+	return cert, nil
+}
+
+// generateSelfSigned makes an ECDSA P-256 certificate for localhost, the
+// host name and the bind address
+func generateSelfSigned(addr string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{CommonName: "useless-agent", Organization: []string{"useless-agent self-signed"}},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if hostname, err := os.Hostname(); err == nil && hostname != "" && hostname != "localhost" {
+		template.DNSNames = append(template.DNSNames, hostname)
+	}
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		}
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+	return certPEM, keyPEM, nil
+}
+
+func writePEM(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create certificate directory: %w", err)
+	}
+	if err := os.WriteFile(path, data, perm); err != nil {
+		return fmt.Errorf("failed to save self-signed certificate: %w", err)
+	}
+	return nil
+}
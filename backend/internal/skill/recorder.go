@@ -0,0 +1,243 @@
+package skill
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/ocr"
+	"useless-agent/internal/screenshot"
+)
+
+// A recording collects what one operator sends to the remote-input API
+// between Start and Stop. Moves in a row are merged, and the time between
+// inputs is kept, capped, so a replay gives the UI time to react.
+
+const (
+	maxStepDelay   = 3 * time.Second
+	maxContextText = 100 // OCR words kept per screenshot
+)
+
+// ErrNotRecording means the operator has no recording in progress
+var ErrNotRecording = errors.New("no recording in progress")
+
+// Recording is the state of a recording in progress
+type Recording struct {
+	Name        string    `json:"name"`
+	Description string    `json:"description"`
+	Principal   string    `json:"principal"`
+	TaskID      string    `json:"taskId,omitempty"`
+	StartedAt   time.Time `json:"startedAt"`
+	Steps       int       `json:"steps"`
+}
+
+type recording struct {
+	Recording
+	steps      []Step
+	lastInput  time.Time
+	before     image.Image
+	beforeText []string
+}
+
+var (
+	recordingMutex sync.Mutex
+	recordings     = make(map[string]*recording) // by principal
+)
+
+// Start begins recording the remote input of principal as skill name,
+// capturing the screen it starts from. taskID is the task being helped, if
+// any.
+func Start(principal, name, description, taskID string) (Recording, error) {
+	if _, err := skillDir(name); err != nil {
+		return Recording{}, err
+	}
+	if strings.TrimSpace(description) == "" {
+		return Recording{}, fmt.Errorf("description is required, the model picks skills by it")
+	}
+	recordingMutex.Lock()
+	if r, ok := recordings[principal]; ok {
+		recordingMutex.Unlock()
+		return Recording{}, fmt.Errorf("already recording %s", r.Name)
+	}
+	r := &recording{Recording: Recording{
+		Name:        name,
+		Description: description,
+		Principal:   principal,
+		TaskID:      taskID,
+		StartedAt:   time.Now(),
+	}}
+	recordings[principal] = r
+	recordingMutex.Unlock()
+
+	// Capture outside the lock, inputs arriving meanwhile are recorded
+	before, text := captureContext()
+	recordingMutex.Lock()
+	r.before, r.beforeText = before, text
+	recordingMutex.Unlock()
+
+	logger.Info("recording skill", "name", name, "principal", principal)
+	return r.Recording, nil
+}
+
+// Observe records applied remote inputs of principal if they are recording,
+// registered with task.OnOperatorInput
+func Observe(principal string, inputs []action.Input) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	r, ok := recordings[principal]
+	if !ok {
+		return
+	}
+	now := time.Now()
+	delay := time.Duration(0)
+	if !r.lastInput.IsZero() {
+		delay = min(now.Sub(r.lastInput), maxStepDelay)
+	}
+	r.lastInput = now
+	for _, in := range inputs {
+		r.add(Step{Input: in, DelayMs: int(delay / time.Millisecond)})
+		delay = 0
+	}
+	r.Steps = len(r.steps)
+}
+
+// add appends a step, merging it into the previous one if both are moves
+func (r *recording) add(step Step) {
+	if n := len(r.steps); n > 0 {
+		last := &r.steps[n-1]
+		switch {
+		case step.Type == "move" && last.Type == "move":
+			last.X, last.Y = step.X, step.Y
+			last.DelayMs += step.DelayMs
+			return
+		case step.Type == "moveRelative" && last.Type == "moveRelative":
+			last.DX += step.DX
+			last.DY += step.DY
+			last.DelayMs += step.DelayMs
+			return
+		}
+	}
+	r.steps = append(r.steps, step)
+}
+
+// Current returns the recording of principal, false if there is none
+func Current(principal string) (Recording, bool) {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	if r, ok := recordings[principal]; ok {
+		return r.Recording, true
+	}
+	return Recording{}, false
+}
+
+// Cancel discards the recording of principal
+func Cancel(principal string) bool {
+	recordingMutex.Lock()
+	defer recordingMutex.Unlock()
+	_, ok := recordings[principal]
+	delete(recordings, principal)
+	return ok
+}
+
+// Stop ends the recording of principal and saves it as a skill; on error
+// the recording goes on. Each parameter's default is a value the operator
+// typed; where it occurs in the recorded text and keys it is replaced by
+// {{name}}.
+func Stop(principal string, params []Param) (*Skill, error) {
+	recordingMutex.Lock()
+	r, ok := recordings[principal]
+	var steps []Step
+	var before image.Image
+	var beforeText []string
+	if ok {
+		steps = append(steps, r.steps...)
+		before, beforeText = r.before, r.beforeText
+	}
+	recordingMutex.Unlock()
+	if !ok {
+		return nil, ErrNotRecording
+	}
+
+	s := &Skill{
+		Name:        r.Name,
+		Description: r.Description,
+		Parameters:  params,
+		Steps:       steps,
+		RecordedBy:  principal,
+		RecordedAt:  r.StartedAt,
+		TaskID:      r.TaskID,
+		BeforeText:  beforeText,
+	}
+	for _, p := range params {
+		if p.Default == "" {
+			return nil, fmt.Errorf("parameter %q needs the demonstrated value as default", p.Name)
+		}
+		found := false
+		for i := range s.Steps {
+			step := &s.Steps[i]
+			ref := "{{" + p.Name + "}}"
+			if strings.Contains(step.Text, p.Default) {
+				step.Text = strings.ReplaceAll(step.Text, p.Default, ref)
+				found = true
+			}
+			if step.Key == p.Default {
+				step.Key = ref
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("parameter %q: %q was not typed during the recording", p.Name, p.Default)
+		}
+	}
+
+	after, afterText := captureContext()
+	seen := make(map[string]bool, len(beforeText))
+	for _, word := range beforeText {
+		seen[word] = true
+	}
+	for _, word := range afterText {
+		if !seen[word] {
+			s.AfterText = append(s.AfterText, word)
+			seen[word] = true
+		}
+	}
+
+	if err := Save(s, before, after); err != nil {
+		return nil, err
+	}
+	recordingMutex.Lock()
+	if recordings[principal] == r {
+		delete(recordings, principal)
+	}
+	recordingMutex.Unlock()
+	logger.Info("recorded skill", "name", s.Name, "steps", len(s.Steps), "principal", principal)
+	return s, nil
+}
+
+// captureContext takes a screenshot and its OCR words, nil if the screen
+// can not be captured
+func captureContext() (image.Image, []string) {
+	img, err := screenshot.CaptureX11Screenshot()
+	if err != nil {
+		logger.Warn("failed to capture skill context", "error", err)
+		return nil, nil
+	}
+	var words []string
+	seen := make(map[string]bool)
+	for _, box := range ocr.OCR(screenshot.ConvertToGrayscale(img)) {
+		word := strings.TrimSpace(box.Text)
+		if len(word) < 2 || seen[word] {
+			continue
+		}
+		seen[word] = true
+		words = append(words, word)
+		if len(words) == maxContextText {
+			break
+		}
+	}
+	return img, words
+}
@@ -0,0 +1,112 @@
+package skill
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"useless-agent/internal/action"
+)
+
+// maxPromptSkills bounds how many skills are listed in the prompt
+const maxPromptSkills = 30
+
+func init() {
+	action.RegisterAction("runSkill", runSkillExecution, validateRunSkill)
+}
+
+// Run replays a skill with its parameters. Keys and buttons it leaves down
+// are released at the end.
+func Run(ctx context.Context, name string, values map[string]string) error {
+	s, err := Get(name)
+	if err != nil {
+		return err
+	}
+	steps, err := s.Render(values)
+	if err != nil {
+		return err
+	}
+	defer action.ReleaseOperatorInputs()
+
+	for i, step := range steps {
+		if step.DelayMs > 0 {
+			select {
+			case <-time.After(time.Duration(step.DelayMs) * time.Millisecond):
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		if err := action.ApplyInput(step.Input); err != nil {
+			return fmt.Errorf("skill %s: step %d: %w", name, i, err)
+		}
+	}
+	return nil
+}
+
+// runSkillParams returns the "parameters" of a runSkill action as strings
+func runSkillParams(a *action.Action) (map[string]string, error) {
+	if a.Parameters == nil {
+		return nil, nil
+	}
+	raw, ok := a.Parameters.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("parameters must be an object")
+	}
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		values[k] = fmt.Sprint(v)
+	}
+	return values, nil
+}
+
+func validateRunSkill(a *action.Action) error {
+	s, err := Get(a.Name)
+	if err != nil {
+		return fmt.Errorf("skill %q: %w", a.Name, err)
+	}
+	values, err := runSkillParams(a)
+	if err != nil {
+		return err
+	}
+	_, err = s.Render(values)
+	return err
+}
+
+func runSkillExecution(ctx context.Context, a *action.Action, params ...interface{}) {
+	values, _ := runSkillParams(a)
+	logger.InfoContext(ctx, "running skill", "name", a.Name, "parameters", values)
+	if err := Run(ctx, a.Name, values); err != nil {
+		logger.WarnContext(ctx, "skill failed", "name", a.Name, "error", err)
+	}
+}
+
+// PromptSection describes runSkill and the stored skills for the actions
+// prompt, empty if there are none
+func PromptSection() string {
+	skills := List()
+	if len(skills) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString(`you can use 'runSkill' action to run a skill, a sequence of inputs an operator demonstrated, with its parameters:
+{
+  "actionSequenceID": 12,
+  "action": "runSkill",
+  "name": "` + skills[0].Name + `",
+  "parameters": {}
+}
+prefer a skill over doing its steps yourself when the screen matches where it starts. Available skills, as name(parameters): description:
+`)
+	for i, s := range skills {
+		if i == maxPromptSkills {
+			break
+		}
+		fmt.Fprintf(&b, "- %s: %s", s.signature(), s.Description)
+		if len(s.BeforeText) > 0 {
+			fmt.Fprintf(&b, " (starts from a screen showing %s)", strings.Join(s.BeforeText[:min(len(s.BeforeText), 8)], " "))
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
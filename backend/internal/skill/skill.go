@@ -0,0 +1,307 @@
+// Package skill keeps named input sequences an operator demonstrated, so
+// the agent can run them as one runSkill action. A skill is recorded from
+// the remote-input API between Start and Stop, with OCR context of the
+// screen before and after, and stored as <skills-dir>/<name>/skill.json
+// next to before.png and after.png.
+package skill
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
+)
+
+var logger = logging.For("skill")
+
+const skillFile = "skill.json"
+
+// Param is a parameter of a skill, written {{name}} in the text and keys of
+// its steps
+type Param struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	// Default is used when a run does not give the parameter; when stopping
+	// a recording it is the demonstrated value the parameter replaces
+	Default string `json:"default,omitempty"`
+}
+
+// Step is one demonstrated input
+type Step struct {
+	action.Input
+	DelayMs int `json:"delayMs,omitempty"` // pause before the step, as demonstrated
+}
+
+// Skill is a recorded, named input sequence
+type Skill struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description"`
+	Parameters  []Param `json:"parameters,omitempty"`
+	Steps       []Step  `json:"steps"`
+
+	RecordedBy string    `json:"recordedBy,omitempty"`
+	RecordedAt time.Time `json:"recordedAt"`
+	TaskID     string    `json:"taskId,omitempty"` // the task the operator helped, if any
+
+	// OCR words on the screen before the demonstration, and the words that
+	// appeared during it
+	BeforeText []string `json:"beforeText,omitempty"`
+	AfterText  []string `json:"afterText,omitempty"`
+}
+
+var (
+	validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	paramRef  = regexp.MustCompile(`\{\{\s*([a-zA-Z0-9_]+)\s*\}\}`)
+
+	// reservedNames are path segments of the API under /api/v2/skills/
+	reservedNames = map[string]bool{"recording": true}
+
+	// ErrNotFound means there is no skill of that name
+	ErrNotFound = errors.New("no such skill")
+	// ErrDisabled means -skills-dir is empty
+	ErrDisabled = errors.New("skills are disabled")
+
+	storeMutex sync.Mutex
+)
+
+// ValidName reports whether name can name a skill: lowercase letters,
+// digits and dashes, as in open-xfce-terminal, but not "recording", which
+// the API routes to the recorder
+func ValidName(name string) bool {
+	return validName.MatchString(name) && !reservedNames[name]
+}
+
+func skillDir(name string) (string, error) {
+	if *config.SkillsDir == "" {
+		return "", ErrDisabled
+	}
+	if !ValidName(name) {
+		return "", fmt.Errorf("invalid skill name %q", name)
+	}
+	return filepath.Join(*config.SkillsDir, name), nil
+}
+
+// List returns the stored skills sorted by name
+func List() []Skill {
+	if *config.SkillsDir == "" {
+		return nil
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+
+	entries, err := os.ReadDir(*config.SkillsDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to list skills", "dir", *config.SkillsDir, "error", err)
+		}
+		return nil
+	}
+	skills := make([]Skill, 0, len(entries))
+	for _, entry := range entries {
+		if !entry.IsDir() || !ValidName(entry.Name()) {
+			continue
+		}
+		s, err := load(entry.Name())
+		if err != nil {
+			logger.Warn("skipping unreadable skill", "name", entry.Name(), "error", err)
+			continue
+		}
+		skills = append(skills, *s)
+	}
+	sort.Slice(skills, func(i, j int) bool { return skills[i].Name < skills[j].Name })
+	return skills
+}
+
+// Get loads a skill
+func Get(name string) (*Skill, error) {
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	return load(name)
+}
+
+func load(name string) (*Skill, error) {
+	dir, err := skillDir(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(dir, skillFile))
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var s Skill
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("skill %s: %w", name, err)
+	}
+	return &s, nil
+}
+
+// Save stores a skill and its before and after screenshots, either may be
+// nil, replacing a skill of the same name
+func Save(s *Skill, before, after image.Image) error {
+	if err := s.validate(); err != nil {
+		return err
+	}
+	dir, err := skillDir(s.Name)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	for name, img := range map[string]image.Image{"before.png": before, "after.png": after} {
+		if img == nil {
+			continue
+		}
+		if err := savePNG(filepath.Join(dir, name), img); err != nil {
+			logger.Warn("failed to save skill screenshot", "name", s.Name, "file", name, "error", err)
+		}
+	}
+	return os.WriteFile(filepath.Join(dir, skillFile), data, 0o644)
+}
+
+// Delete removes a skill
+func Delete(name string) error {
+	dir, err := skillDir(name)
+	if err != nil {
+		return err
+	}
+	storeMutex.Lock()
+	defer storeMutex.Unlock()
+	if _, err := os.Stat(filepath.Join(dir, skillFile)); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+	return os.RemoveAll(dir)
+}
+
+// ScreenshotPath returns the file of the before or after screenshot
+func ScreenshotPath(name, which string) (string, error) {
+	if which != "before" && which != "after" {
+		return "", fmt.Errorf("no %s screenshot", which)
+	}
+	dir, err := skillDir(name)
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, which+".png"), nil
+}
+
+func savePNG(path string, img image.Image) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	return encoder.Encode(f, img)
+}
+
+// validate checks the name, that every {{param}} is declared and that the
+// steps are valid inputs once rendered with the defaults
+func (s *Skill) validate() error {
+	if !ValidName(s.Name) {
+		return fmt.Errorf("invalid skill name %q", s.Name)
+	}
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("skill %s has no steps", s.Name)
+	}
+	declared := make(map[string]bool, len(s.Parameters))
+	for _, p := range s.Parameters {
+		if p.Name == "" || declared[p.Name] {
+			return fmt.Errorf("skill %s: parameter names must be unique and not empty", s.Name)
+		}
+		declared[p.Name] = true
+	}
+	for i, step := range s.Steps {
+		for _, text := range append([]string{step.Key, step.Text}, step.Keys...) {
+			for _, match := range paramRef.FindAllStringSubmatch(text, -1) {
+				if !declared[match[1]] {
+					return fmt.Errorf("skill %s: step %d uses undeclared parameter %q", s.Name, i, match[1])
+				}
+			}
+		}
+	}
+	// Any value will do for the parameters without a default
+	sample := make(map[string]string)
+	for _, p := range s.Parameters {
+		if p.Default == "" {
+			sample[p.Name] = "x"
+		}
+	}
+	_, err := s.Render(sample)
+	return err
+}
+
+// Render returns the steps of the skill with its parameters filled in from
+// values or their defaults; a parameter without default must be given
+func (s *Skill) Render(values map[string]string) ([]Step, error) {
+	filled := make(map[string]string, len(s.Parameters))
+	for _, p := range s.Parameters {
+		v, ok := values[p.Name]
+		if !ok {
+			if p.Default == "" {
+				return nil, fmt.Errorf("skill %s needs parameter %q", s.Name, p.Name)
+			}
+			v = p.Default
+		}
+		filled[p.Name] = v
+	}
+	for name := range values {
+		if _, ok := filled[name]; !ok {
+			return nil, fmt.Errorf("skill %s has no parameter %q", s.Name, name)
+		}
+	}
+
+	fill := func(text string) string {
+		return paramRef.ReplaceAllStringFunc(text, func(ref string) string {
+			return filled[paramRef.FindStringSubmatch(ref)[1]]
+		})
+	}
+	steps := make([]Step, len(s.Steps))
+	for i, step := range s.Steps {
+		step.Key = fill(step.Key)
+		step.Text = fill(step.Text)
+		if len(step.Keys) > 0 {
+			keys := make([]string, len(step.Keys))
+			for j, key := range step.Keys {
+				keys[j] = fill(key)
+			}
+			step.Keys = keys
+		}
+		if err := action.ValidateInput(step.Input); err != nil {
+			return nil, fmt.Errorf("skill %s: step %d: %w", s.Name, i, err)
+		}
+		steps[i] = step
+	}
+	return steps, nil
+}
+
+// signature is how the skill is shown to the model, name(param, ...)
+func (s *Skill) signature() string {
+	names := make([]string, len(s.Parameters))
+	for i, p := range s.Parameters {
+		names[i] = p.Name
+	}
+	return s.Name + "(" + strings.Join(names, ", ") + ")"
+}
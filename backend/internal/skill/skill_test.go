@@ -0,0 +1,205 @@
+package skill
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"useless-agent/internal/action"
+	"useless-agent/internal/config"
+)
+
+func useSkillsDir(t *testing.T) {
+	t.Helper()
+	dir, display := *config.SkillsDir, *config.Display
+	// No X server: the recorder's screenshots are left out
+	*config.SkillsDir, *config.Display = t.TempDir(), ":999"
+	t.Cleanup(func() { *config.SkillsDir, *config.Display = dir, display })
+}
+
+func step(in action.Input) Step { return Step{Input: in} }
+
+func TestValidName(t *testing.T) {
+	for name, want := range map[string]bool{
+		"open-xfce-terminal":    true,
+		"a":                     true,
+		"2fa":                   true,
+		"":                      false,
+		"-x":                    false,
+		"Open":                  false,
+		"a_b":                   false,
+		"../x":                  false,
+		"recording":             false, // routed to the recorder
+		strings.Repeat("a", 65): false,
+	} {
+		if got := ValidName(name); got != want {
+			t.Errorf("ValidName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestRender(t *testing.T) {
+	s := &Skill{
+		Name:       "login",
+		Parameters: []Param{{Name: "user"}, {Name: "confirm", Default: "enter"}},
+		Steps: []Step{
+			step(action.Input{Type: "click", Button: "left"}),
+			step(action.Input{Type: "text", Text: "user: {{user}}, again {{ user }}"}),
+			step(action.Input{Type: "keyTap", Key: "{{confirm}}"}),
+			step(action.Input{Type: "keyTap", Keys: []string{"ctrl", "{{confirm}}"}}),
+		},
+	}
+	tests := []struct {
+		name    string
+		values  map[string]string
+		text    string
+		key     string
+		keys    []string
+		wantErr string
+	}{
+		{name: "default", values: map[string]string{"user": "ann"}, text: "user: ann, again ann", key: "enter", keys: []string{"ctrl", "enter"}},
+		{name: "given", values: map[string]string{"user": "bob", "confirm": "tab"}, text: "user: bob, again bob", key: "tab", keys: []string{"ctrl", "tab"}},
+		{name: "missing parameter", values: nil, wantErr: `needs parameter "user"`},
+		{name: "unknown parameter", values: map[string]string{"user": "ann", "pass": "x"}, wantErr: `has no parameter "pass"`},
+		{name: "rendered input is checked", values: map[string]string{"user": "ann", "confirm": "nokey"}, wantErr: "step 2: invalid key combo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			steps, err := s.Render(tt.values)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if steps[1].Text != tt.text || steps[2].Key != tt.key || !reflect.DeepEqual(steps[3].Keys, tt.keys) {
+				t.Errorf("rendered text %q key %q keys %q, want %q %q %q", steps[1].Text, steps[2].Key, steps[3].Keys, tt.text, tt.key, tt.keys)
+			}
+		})
+	}
+	// The skill itself keeps its references
+	if s.Steps[1].Text != "user: {{user}}, again {{ user }}" || s.Steps[3].Keys[1] != "{{confirm}}" {
+		t.Errorf("Render changed the skill: %+v", s.Steps)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	text := func(s string) []Step { return []Step{step(action.Input{Type: "text", Text: s})} }
+	tests := []struct {
+		name    string
+		skill   Skill
+		wantErr string
+	}{
+		{"plain", Skill{Name: "type-hello", Steps: text("hello")}, ""},
+		{"required parameter", Skill{Name: "greet", Parameters: []Param{{Name: "who"}}, Steps: text("hi {{who}}")}, ""},
+		{"bad name", Skill{Name: "Greet", Steps: text("hi")}, "invalid skill name"},
+		{"no steps", Skill{Name: "greet"}, "has no steps"},
+		{"unnamed parameter", Skill{Name: "greet", Parameters: []Param{{}}, Steps: text("hi")}, "must be unique and not empty"},
+		{"duplicate parameter", Skill{Name: "greet", Parameters: []Param{{Name: "a"}, {Name: "a"}}, Steps: text("hi")}, "must be unique"},
+		{"undeclared in text", Skill{Name: "greet", Steps: text("hi {{who}}")}, `step 0 uses undeclared parameter "who"`},
+		{"undeclared in keys", Skill{Name: "greet", Steps: []Step{step(action.Input{Type: "keyTap", Keys: []string{"ctrl", "{{k}}"}})}}, `undeclared parameter "k"`},
+		{"invalid step", Skill{Name: "greet", Steps: []Step{step(action.Input{Type: "fly"})}}, `unknown input type "fly"`},
+		{"invalid default", Skill{Name: "greet", Parameters: []Param{{Name: "k", Default: "nokey"}}, Steps: []Step{step(action.Input{Type: "keyTap", Key: "{{k}}"})}}, "invalid key combo"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.skill.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRecordAndStop(t *testing.T) {
+	useSkillsDir(t)
+	x, y := 10, 20
+	inputs := []action.Input{
+		{Type: "move", X: &x, Y: &y},
+		{Type: "moveRelative", DX: 5},
+		{Type: "moveRelative", DY: -2},
+		{Type: "click", Button: "left"},
+		{Type: "text", Text: "ssh admin@example.org"},
+		{Type: "keyTap", Key: "enter"},
+	}
+
+	tests := []struct {
+		name    string
+		params  []Param
+		text    string
+		key     string
+		wantErr string
+	}{
+		{name: "no parameters", text: "ssh admin@example.org", key: "enter"},
+		{name: "parameters replace typed values", params: []Param{{Name: "user", Default: "admin"}, {Name: "host", Default: "example.org"}}, text: "ssh {{user}}@{{host}}", key: "enter"},
+		{name: "a key as parameter", params: []Param{{Name: "confirm", Default: "enter"}}, text: "ssh admin@example.org", key: "{{confirm}}"},
+		{name: "parameter without value", params: []Param{{Name: "user"}}, wantErr: `"user" needs the demonstrated value`},
+		{name: "value never typed", params: []Param{{Name: "user", Default: "root"}}, wantErr: `"root" was not typed`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			const principal = "operator"
+			if _, err := Start(principal, "ssh-login", "log in over ssh", ""); err != nil {
+				t.Fatal(err)
+			}
+			defer Cancel(principal)
+			if _, err := Start(principal, "other", "another", ""); err == nil {
+				t.Error("a second recording started")
+			}
+			Observe(principal, inputs)
+			Observe("someone-else", inputs)
+			if r, _ := Current(principal); r.Steps != 5 {
+				t.Errorf("%d steps recorded, want 5 with the relative moves merged", r.Steps)
+			}
+
+			s, err := Stop(principal, tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				if _, ok := Current(principal); !ok {
+					t.Error("a failed stop ended the recording")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := Current(principal); ok {
+				t.Error("still recording after stop")
+			}
+			if s.Steps[1].DX != 5 || s.Steps[1].DY != -2 {
+				t.Errorf("merged move %+v", s.Steps[1].Input)
+			}
+			if s.Steps[3].Text != tt.text || s.Steps[4].Key != tt.key {
+				t.Errorf("text %q key %q, want %q %q", s.Steps[3].Text, s.Steps[4].Key, tt.text, tt.key)
+			}
+
+			// Stored, and the demonstrated values render back
+			stored, err := Get("ssh-login")
+			if err != nil {
+				t.Fatal(err)
+			}
+			steps, err := stored.Render(nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if steps[3].Text != "ssh admin@example.org" || steps[4].Key != "enter" {
+				t.Errorf("rendered with defaults: text %q key %q", steps[3].Text, steps[4].Key)
+			}
+		})
+	}
+
+	if _, err := Stop("nobody", nil); err != ErrNotRecording {
+		t.Errorf("stop without a recording: %v", err)
+	}
+}
@@ -0,0 +1,179 @@
+// Package stream pushes the screen to viewers as MJPEG or binary WebSocket
+// frames. One capture loop runs while anyone watches, at the rate of the
+// fastest viewer; each viewer encodes at its own rate and quality what
+// changed since its last frame.
+package stream
+
+import (
+	"image"
+	"image/draw"
+	"sync"
+	"time"
+
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
+	"useless-agent/internal/screenshot"
+)
+
+var logger = logging.For("stream")
+
+var (
+	captureSeconds = metrics.NewHistogramVec("agent_stream_capture_seconds", "Time to capture and diff a frame for the stream viewers.", metrics.DefaultBuckets)
+	sentBytes      = metrics.NewCounterVec("agent_stream_bytes_total", "Encoded bytes sent to stream viewers.", "transport")
+)
+
+func init() {
+	metrics.NewGaugeFunc("agent_stream_viewers", "Connected stream viewers.", func() float64 {
+		hubMutex.Lock()
+		defer hubMutex.Unlock()
+		return float64(len(viewers))
+	})
+}
+
+// maxFPS caps what a viewer may ask for; capturing is not free
+const maxFPS = 30
+
+// viewer is one client of the capture loop
+type viewer struct {
+	interval time.Duration // between captures this viewer wants
+
+	mutex  sync.Mutex
+	latest *image.RGBA
+	dirty  []image.Rectangle // changed since the viewer's last frame
+	full   bool              // everything changed
+	ready  chan struct{}     // signaled when there is something new
+}
+
+var (
+	hubMutex sync.Mutex
+	viewers  = make(map[*viewer]bool)
+	running  bool
+)
+
+// join adds a viewer at fps frames per second and starts the capture loop
+// if it is the first
+func join(fps int) *viewer {
+	v := &viewer{
+		interval: time.Second / time.Duration(fps),
+		full:     true,
+		ready:    make(chan struct{}, 1),
+	}
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+	viewers[v] = true
+	if !running {
+		running = true
+		go captureLoop()
+	}
+	return v
+}
+
+func (v *viewer) leave() {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+	delete(viewers, v)
+}
+
+func (v *viewer) setFPS(fps int) {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+	v.interval = time.Second / time.Duration(fps)
+}
+
+// update hands the viewer a new frame and what changed in it, nil dirty
+// meaning everything
+func (v *viewer) update(img *image.RGBA, dirty []image.Rectangle) {
+	v.mutex.Lock()
+	v.latest = img
+	if dirty == nil {
+		v.full = true
+	} else if !v.full {
+		v.dirty = append(v.dirty, dirty...)
+	}
+	v.mutex.Unlock()
+
+	select {
+	case v.ready <- struct{}{}:
+	default:
+	}
+}
+
+// take returns the latest frame and what changed since the last take
+func (v *viewer) take() (img *image.RGBA, dirty []image.Rectangle, full bool) {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	img, dirty, full = v.latest, v.dirty, v.full
+	v.dirty, v.full = nil, false
+	return img, dirty, full
+}
+
+// requestKeyframe makes the next frame of the viewer a full one
+func (v *viewer) requestKeyframe() {
+	v.mutex.Lock()
+	v.full = true
+	v.mutex.Unlock()
+	select {
+	case v.ready <- struct{}{}:
+	default:
+	}
+}
+
+// captureLoop captures at the rate of the fastest viewer until the last
+// one leaves
+func captureLoop() {
+	var previous *image.RGBA
+	failing := false
+	for {
+		hubMutex.Lock()
+		if len(viewers) == 0 {
+			running = false
+			hubMutex.Unlock()
+			return
+		}
+		interval := time.Duration(0)
+		current := make([]*viewer, 0, len(viewers))
+		for v := range viewers {
+			if interval == 0 || v.interval < interval {
+				interval = v.interval
+			}
+			current = append(current, v)
+		}
+		hubMutex.Unlock()
+
+		start := time.Now()
+		img, err := screenshot.CaptureX11Screenshot()
+		if err != nil {
+			if !failing {
+				logger.Warn("failed to capture frame for stream viewers", "error", err)
+			}
+			failing = true
+			time.Sleep(time.Second)
+			continue
+		}
+		failing = false
+
+		frame := toRGBA(img)
+		var dirty []image.Rectangle
+		if previous != nil && previous.Rect == frame.Rect {
+			dirty = changedTiles(previous, frame)
+		}
+		captureSeconds.With().ObserveSince(start)
+		for _, v := range current {
+			v.update(frame, dirty)
+		}
+		previous = frame
+
+		if wait := interval - time.Since(start); wait > 0 {
+			time.Sleep(wait)
+		}
+	}
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+	if rgba, ok := img.(*image.RGBA); ok {
+		return rgba
+	}
+	rgba := image.NewRGBA(img.Bounds())
+	draw.Draw(rgba, rgba.Bounds(), img, img.Bounds().Min, draw.Src)
+	return rgba
+}
@@ -0,0 +1,81 @@
+package stream
+
+import (
+	"bytes"
+	"image"
+)
+
+// tileSize is the granularity of change detection in pixels
+const tileSize = 32
+
+// changedTiles compares two frames of the same size tile by tile and
+// returns the changed areas, runs of tiles merged into rectangles; empty,
+// not nil, when nothing changed
+func changedTiles(a, b *image.RGBA) []image.Rectangle {
+	bounds := b.Rect
+	rects := []image.Rectangle{}
+	for y0 := bounds.Min.Y; y0 < bounds.Max.Y; y0 += tileSize {
+		y1 := min(y0+tileSize, bounds.Max.Y)
+		runStart := -1
+		for x0 := bounds.Min.X; x0 < bounds.Max.X; x0 += tileSize {
+			x1 := min(x0+tileSize, bounds.Max.X)
+			if tileChanged(a, b, x0, y0, x1, y1) {
+				if runStart < 0 {
+					runStart = x0
+				}
+				continue
+			}
+			if runStart >= 0 {
+				rects = append(rects, image.Rect(runStart, y0, x0, y1))
+				runStart = -1
+			}
+		}
+		if runStart >= 0 {
+			rects = append(rects, image.Rect(runStart, y0, bounds.Max.X, y1))
+		}
+	}
+	return mergeRects(rects)
+}
+
+func tileChanged(a, b *image.RGBA, x0, y0, x1, y1 int) bool {
+	for y := y0; y < y1; y++ {
+		i := b.PixOffset(x0, y)
+		j := i + (x1-x0)*4
+		if !bytes.Equal(a.Pix[i:j], b.Pix[i:j]) {
+			return true
+		}
+	}
+	return false
+}
+
+// mergeRects unions overlapping or touching rectangles until none are left,
+// so a patch is never sent twice
+func mergeRects(rects []image.Rectangle) []image.Rectangle {
+	for merged := true; merged; {
+		merged = false
+		for i := 0; i < len(rects); i++ {
+			for j := i + 1; j < len(rects); j++ {
+				if touches(rects[i], rects[j]) {
+					rects[i] = rects[i].Union(rects[j])
+					rects = append(rects[:j], rects[j+1:]...)
+					merged = true
+					j--
+				}
+			}
+		}
+	}
+	return rects
+}
+
+// touches reports whether two rectangles overlap or share an edge
+func touches(a, b image.Rectangle) bool {
+	return a.Min.X <= b.Max.X && b.Min.X <= a.Max.X && a.Min.Y <= b.Max.Y && b.Min.Y <= a.Max.Y
+}
+
+func area(rects []image.Rectangle) int {
+	total := 0
+	for _, r := range rects {
+		total += r.Dx() * r.Dy()
+	}
+	return total
+}
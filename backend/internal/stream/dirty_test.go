@@ -0,0 +1,68 @@
+package stream
+
+import (
+	"image"
+	"image/color"
+	"reflect"
+	"testing"
+)
+
+func TestChangedTiles(t *testing.T) {
+	// 100x70 has tiles of 32, 32, 32 and 4 pixels across and 32, 32 and 6 down
+	tests := []struct {
+		name    string
+		changed []image.Point
+		want    []image.Rectangle
+	}{
+		{name: "equal frames", changed: nil, want: []image.Rectangle{}},
+		{name: "narrow edge tile", changed: []image.Point{{99, 10}}, want: []image.Rectangle{image.Rect(96, 0, 100, 32)}},
+		{name: "narrow corner tile", changed: []image.Point{{99, 69}}, want: []image.Rectangle{image.Rect(96, 64, 100, 70)}},
+		{name: "run reaching the narrow edge tile", changed: []image.Point{{70, 0}, {98, 0}}, want: []image.Rectangle{image.Rect(64, 0, 100, 32)}},
+		{name: "neighbouring tiles form one run", changed: []image.Point{{0, 0}, {40, 0}}, want: []image.Rectangle{image.Rect(0, 0, 64, 32)}},
+		{name: "separated runs stay apart", changed: []image.Point{{0, 0}, {70, 0}}, want: []image.Rectangle{image.Rect(0, 0, 32, 32), image.Rect(64, 0, 96, 32)}},
+		{name: "runs touching across rows merge", changed: []image.Point{{0, 0}, {0, 40}}, want: []image.Rectangle{image.Rect(0, 0, 32, 64)}},
+		{name: "runs touching at a corner merge", changed: []image.Point{{0, 0}, {40, 40}}, want: []image.Rectangle{image.Rect(0, 0, 64, 64)}},
+		{name: "runs two rows apart stay apart", changed: []image.Point{{0, 0}, {0, 65}}, want: []image.Rectangle{image.Rect(0, 0, 32, 32), image.Rect(0, 64, 32, 70)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := image.NewRGBA(image.Rect(0, 0, 100, 70))
+			b := image.NewRGBA(a.Rect)
+			for _, p := range tt.changed {
+				b.Set(p.X, p.Y, color.White)
+			}
+
+			got := changedTiles(a, b)
+			if got == nil {
+				t.Fatal("changedTiles returned nil")
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("changedTiles = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeRects(t *testing.T) {
+	tests := []struct {
+		name  string
+		rects []image.Rectangle
+		want  []image.Rectangle
+	}{
+		{name: "none", rects: []image.Rectangle{}, want: []image.Rectangle{}},
+		{name: "overlapping", rects: []image.Rectangle{image.Rect(0, 0, 20, 20), image.Rect(10, 10, 30, 30)}, want: []image.Rectangle{image.Rect(0, 0, 30, 30)}},
+		{name: "sharing an edge", rects: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(10, 0, 20, 10)}, want: []image.Rectangle{image.Rect(0, 0, 20, 10)}},
+		{name: "one pixel apart", rects: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(11, 0, 20, 10)}, want: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(11, 0, 20, 10)}},
+		{name: "joined by a later one", rects: []image.Rectangle{image.Rect(0, 0, 10, 10), image.Rect(20, 0, 30, 10), image.Rect(10, 0, 20, 10)}, want: []image.Rectangle{image.Rect(0, 0, 30, 10)}},
+		{name: "union reaches an earlier one", rects: []image.Rectangle{image.Rect(0, 40, 10, 50), image.Rect(0, 0, 10, 10), image.Rect(0, 10, 10, 40)}, want: []image.Rectangle{image.Rect(0, 0, 10, 50)}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeRects(tt.rects); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeRects = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
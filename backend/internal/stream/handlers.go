@@ -0,0 +1,257 @@
+package stream
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/config"
+)
+
+const (
+	// keyframeInterval bounds how long JPEG artifacts at patch edges last
+	keyframeInterval = 30 * time.Second
+
+	// A change bigger than this is sent as a whole frame
+	maxPatches       = 32
+	maxPatchFraction = 0.5
+
+	// Binary WebSocket frames start with this header, big-endian:
+	// kind (0 keyframe, 1 patch), screen width and height, then x, y,
+	// width and height of the JPEG that follows
+	headerSize = 13
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:   1024,
+	WriteBufferSize:  64 << 10,
+	CheckOrigin:      auth.CheckOrigin,
+	HandshakeTimeout: 10 * time.Second,
+}
+
+// options are what a viewer asked for
+type options struct {
+	fps     int
+	quality int
+}
+
+// parseOptions reads ?fps= and ?quality=, defaulting to -stream-fps and
+// -stream-quality
+func parseOptions(r *http.Request) (options, error) {
+	opts := options{fps: *config.StreamFPS, quality: *config.StreamQuality}
+	if s := r.URL.Query().Get("fps"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > maxFPS {
+			return opts, fmt.Errorf("fps must be 1 to %d", maxFPS)
+		}
+		opts.fps = n
+	}
+	if s := r.URL.Query().Get("quality"); s != "" {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 1 || n > 100 {
+			return opts, fmt.Errorf("quality must be 1 to 100")
+		}
+		opts.quality = n
+	}
+	opts.fps = max(1, min(opts.fps, maxFPS))
+	opts.quality = max(1, min(opts.quality, 100))
+	return opts, nil
+}
+
+func encodeJPEG(img image.Image, quality int) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// period is the viewer's time between frames
+func (v *viewer) period() time.Duration {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+	return v.interval
+}
+
+// next waits until the viewer has something new and its frame interval has
+// passed since last, then takes it; nil means ctx is done
+func (v *viewer) next(ctx context.Context, last time.Time) (*image.RGBA, []image.Rectangle, bool) {
+	for {
+		select {
+		case <-v.ready:
+		case <-ctx.Done():
+			return nil, nil, false
+		}
+		if wait := v.period() - time.Since(last); wait > 0 {
+			select {
+			case <-time.After(wait):
+			case <-ctx.Done():
+				return nil, nil, false
+			}
+		}
+		if img, dirty, full := v.take(); img != nil {
+			return img, dirty, full
+		}
+	}
+}
+
+// MJPEGHandler streams the screen as multipart JPEG frames, which an <img>
+// shows as video. Frames are only sent when the screen changed.
+func MJPEGHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "the connection can not stream", http.StatusNotAcceptable)
+		return
+	}
+
+	v := join(opts.fps)
+	defer v.leave()
+
+	w.Header().Set("Content-Type", "multipart/x-mixed-replace; boundary=frame")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	var last time.Time
+	for {
+		img, dirty, full := v.next(r.Context(), last)
+		if img == nil {
+			return
+		}
+		if !full && len(dirty) == 0 {
+			continue
+		}
+		data, err := encodeJPEG(img, opts.quality)
+		if err != nil {
+			logger.Warn("failed to encode stream frame", "error", err)
+			continue
+		}
+		last = time.Now()
+
+		_, err = fmt.Fprintf(w, "--frame\r\nContent-Type: image/jpeg\r\nContent-Length: %d\r\n\r\n", len(data))
+		if err == nil {
+			_, err = w.Write(append(data, "\r\n"...))
+		}
+		if err != nil {
+			return
+		}
+		flusher.Flush()
+		sentBytes.With("mjpeg").Add(float64(len(data)))
+	}
+}
+
+// settings is what a WebSocket viewer may send to change its stream
+type settings struct {
+	FPS      int  `json:"fps"`
+	Quality  int  `json:"quality"`
+	Keyframe bool `json:"keyframe"`
+}
+
+// WSHandler streams the screen as binary WebSocket messages: a keyframe
+// with the whole screen, then patches of the areas that changed. A client
+// may send {"fps": n, "quality": n} to change the stream and
+// {"keyframe": true} to get a whole frame.
+func WSHandler(w http.ResponseWriter, r *http.Request) {
+	opts, err := parseOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		logger.Warn("stream websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	v := join(opts.fps)
+	defer v.leave()
+	var quality atomic.Int32
+	quality.Store(int32(opts.quality))
+
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go func() {
+		defer cancel()
+		conn.SetReadLimit(4096)
+		for {
+			_, message, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var s settings
+			if json.Unmarshal(message, &s) != nil {
+				continue
+			}
+			if s.FPS >= 1 && s.FPS <= maxFPS {
+				v.setFPS(s.FPS)
+			}
+			if s.Quality >= 1 && s.Quality <= 100 {
+				quality.Store(int32(s.Quality))
+			}
+			if s.Keyframe {
+				v.requestKeyframe()
+			}
+		}
+	}()
+
+	var last, lastKeyframe time.Time
+	for {
+		img, dirty, full := v.next(ctx, last)
+		if img == nil {
+			return
+		}
+		dirty = mergeRects(dirty)
+		screen := img.Rect
+		full = full || time.Since(lastKeyframe) > keyframeInterval || len(dirty) > maxPatches ||
+			float64(area(dirty)) > maxPatchFraction*float64(screen.Dx()*screen.Dy())
+		if full {
+			dirty = []image.Rectangle{screen}
+			lastKeyframe = time.Now()
+		} else if len(dirty) == 0 {
+			continue
+		}
+		last = time.Now()
+
+		for _, rect := range dirty {
+			data, err := encodeJPEG(img.SubImage(rect), int(quality.Load()))
+			if err != nil {
+				logger.Warn("failed to encode stream frame", "error", err)
+				continue
+			}
+			message := make([]byte, headerSize, headerSize+len(data))
+			if !full {
+				message[0] = 1
+			}
+			binary.BigEndian.PutUint16(message[1:], uint16(screen.Dx()))
+			binary.BigEndian.PutUint16(message[3:], uint16(screen.Dy()))
+			binary.BigEndian.PutUint16(message[5:], uint16(rect.Min.X-screen.Min.X))
+			binary.BigEndian.PutUint16(message[7:], uint16(rect.Min.Y-screen.Min.Y))
+			binary.BigEndian.PutUint16(message[9:], uint16(rect.Dx()))
+			binary.BigEndian.PutUint16(message[11:], uint16(rect.Dy()))
+			message = append(message, data...)
+
+			conn.SetWriteDeadline(time.Now().Add(5 * time.Second))
+			if err := conn.WriteMessage(websocket.BinaryMessage, message); err != nil {
+				logger.Debug("stream websocket write failed", "error", err)
+				return
+			}
+			sentBytes.With("websocket").Add(float64(len(message)))
+		}
+	}
+}
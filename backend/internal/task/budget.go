@@ -0,0 +1,56 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// defaultMaxIterations is the iterations a task may take without a budget
+const defaultMaxIterations = 40
+
+// Budget bounds what a task may spend. A task that runs out of time or
+// tokens breaks; one that runs out of iterations ends its remaining
+// subtasks as "limit", like a task without a budget after 40 iterations.
+type Budget struct {
+	MaxIterations int    `json:"maxIterations,omitempty"`
+	MaxDuration   string `json:"maxDuration,omitempty"` // e.g. "10m"
+	MaxTokens     int    `json:"maxTokens,omitempty"`   // estimated prompt tokens
+}
+
+// ValidateBudget checks a budget before a task is queued
+func ValidateBudget(b Budget) error {
+	if b.MaxIterations < 0 || b.MaxTokens < 0 {
+		return errors.New("maxIterations and maxTokens can not be negative")
+	}
+	if b.MaxDuration != "" {
+		d, err := time.ParseDuration(b.MaxDuration)
+		if err != nil {
+			return fmt.Errorf("invalid maxDuration: %w", err)
+		}
+		if d <= 0 {
+			return errors.New("maxDuration must be positive")
+		}
+	}
+	return nil
+}
+
+// iterations returns the iterations the task may take
+func (b Budget) iterations() int64 {
+	if b.MaxIterations > 0 {
+		return int64(b.MaxIterations)
+	}
+	return defaultMaxIterations
+}
+
+// exceeded says why a task started at startedAt that used tokens is over
+// budget, empty if it is not
+func (b Budget) exceeded(startedAt time.Time, tokens int) string {
+	if d, err := time.ParseDuration(b.MaxDuration); err == nil && d > 0 && time.Since(startedAt) > d {
+		return fmt.Sprintf("Time budget of %s exceeded", d)
+	}
+	if b.MaxTokens > 0 && tokens > b.MaxTokens {
+		return fmt.Sprintf("Token budget of %d exceeded (%d estimated)", b.MaxTokens, tokens)
+	}
+	return ""
+}
@@ -0,0 +1,317 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"image"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/ocr"
+	"useless-agent/pkg/x11"
+)
+
+// Postcondition types
+const (
+	CheckWindowVisible    = "windowVisible"
+	CheckTextPresent      = "textPresent"
+	CheckFileExists       = "fileExists"
+	CheckProcessRunning   = "processRunning"
+	CheckClipboardMatches = "clipboardMatches"
+)
+
+// Verification modes
+const (
+	// VerificationAuto settles a subtask with postconditions when they all
+	// pass and asks the LLM verifier (with the check results) otherwise
+	VerificationAuto = "auto"
+	// VerificationChecksOnly never calls the LLM verifier for subtasks that
+	// have postconditions
+	VerificationChecksOnly = "checksOnly"
+)
+
+// Timeout for external helpers such as xclip
+const checkCommandTimeout = 2 * time.Second
+
+// CheckContext is the screen state postconditions are evaluated against
+type CheckContext struct {
+	OCR            []ocr.TesseractBoundingBox
+	X11WindowsJSON string
+}
+
+// CheckResult is the outcome of a single postcondition
+type CheckResult struct {
+	Postcondition Postcondition `json:"postcondition"`
+	Passed        bool          `json:"passed"`
+	Detail        string        `json:"detail"`
+}
+
+// ValidatePostconditions checks that postconditions are well-formed before a task is queued
+func ValidatePostconditions(conditions []Postcondition) error {
+	for i, c := range conditions {
+		var err error
+		switch c.Type {
+		case CheckWindowVisible:
+			if c.WindowClass == "" && c.Title == "" {
+				err = fmt.Errorf("windowClass or title is required")
+			}
+		case CheckTextPresent:
+			if c.Text == "" {
+				err = fmt.Errorf("text is required")
+			}
+		case CheckFileExists:
+			if c.Path == "" {
+				err = fmt.Errorf("path is required")
+			}
+		case CheckProcessRunning:
+			if c.Process == "" {
+				err = fmt.Errorf("process is required")
+			}
+		case CheckClipboardMatches:
+			if c.Pattern == "" {
+				err = fmt.Errorf("pattern is required")
+			} else if _, reErr := regexp.Compile(c.Pattern); reErr != nil {
+				err = fmt.Errorf("invalid pattern: %w", reErr)
+			}
+		default:
+			err = fmt.Errorf("unknown type %q", c.Type)
+		}
+		if err != nil {
+			return fmt.Errorf("postcondition %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
+
+// EvaluatePostconditions runs every postcondition against the given state
+func EvaluatePostconditions(conditions []Postcondition, checkCtx CheckContext) []CheckResult {
+	results := make([]CheckResult, 0, len(conditions))
+	for _, c := range conditions {
+		passed, detail := evaluatePostcondition(c, checkCtx)
+		results = append(results, CheckResult{Postcondition: c, Passed: passed, Detail: detail})
+	}
+	return results
+}
+
+// AllPassed reports whether there is at least one result and every result passed
+func AllPassed(results []CheckResult) bool {
+	if len(results) == 0 {
+		return false
+	}
+	for _, r := range results {
+		if !r.Passed {
+			return false
+		}
+	}
+	return true
+}
+
+// SummarizeCheckResults renders results as a short text for logs and the LLM verifier
+func SummarizeCheckResults(results []CheckResult) string {
+	var sb strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			sb.WriteString("; ")
+		}
+		status := "FAILED"
+		if r.Passed {
+			status = "PASSED"
+		}
+		fmt.Fprintf(&sb, "%s %s: %s", status, r.Postcondition.Type, r.Detail)
+	}
+	return sb.String()
+}
+
+func evaluatePostcondition(c Postcondition, checkCtx CheckContext) (bool, string) {
+	switch c.Type {
+	case CheckWindowVisible:
+		return checkWindowVisible(c, checkCtx.X11WindowsJSON)
+	case CheckTextPresent:
+		return checkTextPresent(c, checkCtx.OCR)
+	case CheckFileExists:
+		return checkFileExists(c.Path)
+	case CheckProcessRunning:
+		return checkProcessRunning(c.Process)
+	case CheckClipboardMatches:
+		return checkClipboardMatches(c.Pattern)
+	}
+	return false, fmt.Sprintf("unknown postcondition type %q", c.Type)
+}
+
+func checkWindowVisible(c Postcondition, x11WindowsJSON string) (bool, string) {
+	var info x11.X11WindowInfo
+	if err := json.Unmarshal([]byte(x11WindowsJSON), &info); err != nil {
+		return false, fmt.Sprintf("failed to parse X11 windows data: %v", err)
+	}
+
+	for _, w := range info.Windows {
+		if !w.Visible {
+			continue
+		}
+		if c.WindowClass != "" && !containsFold(w.Class, c.WindowClass) && !containsFold(w.Name, c.WindowClass) {
+			continue
+		}
+		if c.Title != "" && !containsFold(w.Title, c.Title) {
+			continue
+		}
+		return true, fmt.Sprintf("window %q (class %q) is visible", w.Title, w.Class)
+	}
+	return false, fmt.Sprintf("no visible window with class %q and title %q", c.WindowClass, c.Title)
+}
+
+func checkTextPresent(c Postcondition, ocrResults []ocr.TesseractBoundingBox) (bool, string) {
+	// OCR returns single words, so join the words inside the region in
+	// reading order and search the phrase in that text
+	var words []ocr.TesseractBoundingBox
+	for _, box := range ocrResults {
+		if c.Region != nil {
+			bb := image.Rect(box.BoundingBox.XMin, box.BoundingBox.YMin, box.BoundingBox.XMax, box.BoundingBox.YMax)
+			region := image.Rect(c.Region.XMin, c.Region.YMin, c.Region.XMax, c.Region.YMax)
+			if !bb.In(region) {
+				continue
+			}
+		}
+		words = append(words, box)
+	}
+	sort.SliceStable(words, func(i, j int) bool {
+		if abs(words[i].BoundingBox.YMin-words[j].BoundingBox.YMin) > 5 {
+			return words[i].BoundingBox.YMin < words[j].BoundingBox.YMin
+		}
+		return words[i].BoundingBox.XMin < words[j].BoundingBox.XMin
+	})
+
+	texts := make([]string, len(words))
+	for i, w := range words {
+		texts[i] = w.Text
+	}
+	screenText := strings.Join(texts, " ")
+
+	where := "on screen"
+	if c.Region != nil {
+		where = fmt.Sprintf("in region [%d,%d,%d,%d]", c.Region.XMin, c.Region.YMin, c.Region.XMax, c.Region.YMax)
+	}
+	if containsFold(screenText, c.Text) {
+		return true, fmt.Sprintf("text %q found %s", c.Text, where)
+	}
+	return false, fmt.Sprintf("text %q not found %s", c.Text, where)
+}
+
+func checkFileExists(path string) (bool, string) {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return false, fmt.Sprintf("file %s does not exist", path)
+	}
+	return true, fmt.Sprintf("file %s exists (%d bytes)", path, info.Size())
+}
+
+// checkProcessRunning looks for the process among those started on the
+// agent's display, other sessions of the host do not count
+func checkProcessRunning(name string) (bool, string) {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return false, fmt.Sprintf("failed to list processes: %v", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.Trim(entry.Name(), "0123456789") != "" {
+			continue
+		}
+		if !processMatches(entry.Name(), name) {
+			continue
+		}
+		if sameDisplay(processDisplay(entry.Name()), *config.Display) {
+			return true, fmt.Sprintf("process %s is running on display %s (pid %s)", name, *config.Display, entry.Name())
+		}
+	}
+	return false, fmt.Sprintf("process %s is not running on display %s", name, *config.Display)
+}
+
+func processMatches(pid, name string) bool {
+	comm, err := os.ReadFile(filepath.Join("/proc", pid, "comm"))
+	if err == nil && strings.TrimSpace(string(comm)) == name {
+		return true
+	}
+	cmdline, err := os.ReadFile(filepath.Join("/proc", pid, "cmdline"))
+	if err == nil && len(cmdline) > 0 {
+		argv0 := strings.SplitN(string(cmdline), "\x00", 2)[0]
+		return filepath.Base(argv0) == name
+	}
+	return false
+}
+
+// processDisplay returns the DISPLAY a process was started with, empty if
+// it has none or its environment can not be read
+func processDisplay(pid string) string {
+	environ, err := os.ReadFile(filepath.Join("/proc", pid, "environ"))
+	if err != nil {
+		return ""
+	}
+	for _, kv := range strings.Split(string(environ), "\x00") {
+		if display, ok := strings.CutPrefix(kv, "DISPLAY="); ok {
+			return display
+		}
+	}
+	return ""
+}
+
+// sameDisplay tells whether two DISPLAY values name the same X display,
+// ignoring the screen number
+func sameDisplay(a, b string) bool {
+	trimScreen := func(display string) string {
+		colon := strings.LastIndex(display, ":")
+		if colon < 0 {
+			return display
+		}
+		if dot := strings.Index(display[colon:], "."); dot >= 0 {
+			display = display[:colon+dot]
+		}
+		return strings.TrimPrefix(display, "localhost")
+	}
+	return a != "" && b != "" && trimScreen(a) == trimScreen(b)
+}
+
+func checkClipboardMatches(pattern string) (bool, string) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return false, fmt.Sprintf("invalid pattern: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), checkCommandTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "xclip", "-o", "-selection", "clipboard")
+	cmd.Env = append(os.Environ(), "DISPLAY="+*config.Display)
+	out, err := cmd.Output()
+	if err != nil {
+		return false, fmt.Sprintf("failed to read clipboard: %v", err)
+	}
+
+	if re.Match(out) {
+		return true, fmt.Sprintf("clipboard matches %q", pattern)
+	}
+	return false, fmt.Sprintf("clipboard does not match %q", pattern)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+func abs(a int) int {
+	if a < 0 {
+		return -a
+	}
+	return a
+}
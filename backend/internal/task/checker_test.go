@@ -0,0 +1,163 @@
+package task
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"useless-agent/internal/ocr"
+)
+
+func word(text string, xMin, yMin int) ocr.TesseractBoundingBox {
+	return ocr.TesseractBoundingBox{Text: text, BoundingBox: ocr.Box{XMin: xMin, YMin: yMin, XMax: xMin + 40, YMax: yMin + 12}}
+}
+
+func TestValidatePostconditions(t *testing.T) {
+	tests := []struct {
+		name       string
+		conditions []Postcondition
+		wantErr    string
+	}{
+		{"none", nil, ""},
+		{"valid", []Postcondition{
+			{Type: CheckWindowVisible, Title: "Terminal"},
+			{Type: CheckTextPresent, Text: "Saved"},
+			{Type: CheckFileExists, Path: "~/a.txt"},
+			{Type: CheckProcessRunning, Process: "xterm"},
+			{Type: CheckClipboardMatches, Pattern: "^[0-9]+$"},
+		}, ""},
+		{"window without class or title", []Postcondition{{Type: CheckWindowVisible}}, "postcondition 1: windowClass or title is required"},
+		{"text missing", []Postcondition{{Type: CheckFileExists, Path: "/tmp"}, {Type: CheckTextPresent}}, "postcondition 2: text is required"},
+		{"path missing", []Postcondition{{Type: CheckFileExists}}, "path is required"},
+		{"process missing", []Postcondition{{Type: CheckProcessRunning}}, "process is required"},
+		{"pattern missing", []Postcondition{{Type: CheckClipboardMatches}}, "pattern is required"},
+		{"bad pattern", []Postcondition{{Type: CheckClipboardMatches, Pattern: "("}}, "invalid pattern"},
+		{"unknown type", []Postcondition{{Type: "soundPlaying"}}, `unknown type "soundPlaying"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidatePostconditions(tt.conditions)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestEvaluatePostconditions(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "report.txt")
+	if err := os.WriteFile(file, []byte("done"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	windows := `{"windows":[
+		{"title":"Untitled - Editor","class":"gedit","name":"gedit","visible":true},
+		{"title":"Hidden Terminal","class":"xterm","name":"xterm","visible":false}
+	]}`
+	checkCtx := CheckContext{
+		X11WindowsJSON: windows,
+		OCR: []ocr.TesseractBoundingBox{
+			// out of reading order, and on two lines
+			word("saved", 150, 100),
+			word("File", 100, 102),
+			word("Cancel", 100, 300),
+		},
+	}
+
+	tests := []struct {
+		name      string
+		condition Postcondition
+		ctx       CheckContext
+		want      bool
+	}{
+		{"window by title", Postcondition{Type: CheckWindowVisible, Title: "untitled"}, checkCtx, true},
+		{"window by class", Postcondition{Type: CheckWindowVisible, WindowClass: "GEDIT"}, checkCtx, true},
+		{"window class and title must both match", Postcondition{Type: CheckWindowVisible, WindowClass: "gedit", Title: "Terminal"}, checkCtx, false},
+		{"window not visible", Postcondition{Type: CheckWindowVisible, WindowClass: "xterm"}, checkCtx, false},
+		{"window data unreadable", Postcondition{Type: CheckWindowVisible, Title: "Editor"}, CheckContext{X11WindowsJSON: "not json"}, false},
+		{"phrase in reading order", Postcondition{Type: CheckTextPresent, Text: "file SAVED"}, checkCtx, true},
+		{"phrase across lines", Postcondition{Type: CheckTextPresent, Text: "saved Cancel"}, checkCtx, true},
+		{"phrase missing", Postcondition{Type: CheckTextPresent, Text: "Error"}, checkCtx, false},
+		{"text in region", Postcondition{Type: CheckTextPresent, Text: "Cancel", Region: &Region{XMin: 0, YMin: 250, XMax: 400, YMax: 400}}, checkCtx, true},
+		{"text outside region", Postcondition{Type: CheckTextPresent, Text: "File", Region: &Region{XMin: 0, YMin: 250, XMax: 400, YMax: 400}}, checkCtx, false},
+		{"file exists", Postcondition{Type: CheckFileExists, Path: file}, checkCtx, true},
+		{"file missing", Postcondition{Type: CheckFileExists, Path: filepath.Join(dir, "missing.txt")}, checkCtx, false},
+		{"unknown type", Postcondition{Type: "soundPlaying"}, checkCtx, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results := EvaluatePostconditions([]Postcondition{tt.condition}, tt.ctx)
+			if len(results) != 1 {
+				t.Fatalf("got %d results, want 1", len(results))
+			}
+			if results[0].Passed != tt.want {
+				t.Errorf("passed = %v, want %v (%s)", results[0].Passed, tt.want, results[0].Detail)
+			}
+			if results[0].Detail == "" {
+				t.Error("result has no detail")
+			}
+		})
+	}
+}
+
+func TestAllPassed(t *testing.T) {
+	pass := CheckResult{Passed: true}
+	fail := CheckResult{Passed: false}
+	tests := []struct {
+		name    string
+		results []CheckResult
+		want    bool
+	}{
+		{"no results", nil, false},
+		{"all passed", []CheckResult{pass, pass}, true},
+		{"one failed", []CheckResult{pass, fail}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := AllPassed(tt.results); got != tt.want {
+				t.Errorf("AllPassed = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummarizeCheckResults(t *testing.T) {
+	results := []CheckResult{
+		{Postcondition: Postcondition{Type: CheckFileExists}, Passed: true, Detail: "file a exists"},
+		{Postcondition: Postcondition{Type: CheckTextPresent}, Passed: false, Detail: "text b not found"},
+	}
+	want := "PASSED fileExists: file a exists; FAILED textPresent: text b not found"
+	if got := SummarizeCheckResults(results); got != want {
+		t.Errorf("SummarizeCheckResults = %q, want %q", got, want)
+	}
+}
+
+func TestSameDisplay(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want bool
+	}{
+		{":0", ":0", true},
+		{":0.0", ":0", true},
+		{":1", ":0", false},
+		{":10", ":1", false},
+		{"localhost:2", ":2", true},
+		{"host:2.1", "host:2", true},
+		{"host:2", ":2", false},
+		{"", ":0", false},
+		{"", "", false},
+	}
+	for _, tt := range tests {
+		if got := sameDisplay(tt.a, tt.b); got != tt.want {
+			t.Errorf("sameDisplay(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
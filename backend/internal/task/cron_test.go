@@ -0,0 +1,113 @@
+package task
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseCron(t *testing.T) {
+	bits := func(values ...int) uint64 {
+		var b uint64
+		for _, v := range values {
+			b |= 1 << uint(v)
+		}
+		return b
+	}
+	tests := []struct {
+		expr    string
+		minute  uint64
+		hour    uint64
+		dow     uint64
+		wantErr string
+	}{
+		{expr: "@daily", minute: bits(0), hour: bits(0), dow: bits(0, 1, 2, 3, 4, 5, 6, 7)},
+		{expr: "@HOURLY", minute: bits(0), hour: bits(0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 21, 22, 23)},
+		{expr: "*/20 9-17/4 * * mon-fri", minute: bits(0, 20, 40), hour: bits(9, 13, 17), dow: bits(1, 2, 3, 4, 5)},
+		{expr: "5,10 0 * * 7", minute: bits(5, 10), hour: bits(0), dow: bits(0, 7)},
+		{expr: "50/5 0 * * SUN", minute: bits(50, 55), hour: bits(0), dow: bits(0)},
+		{expr: "* * * *", wantErr: "needs 5 fields"},
+		{expr: "60 * * * *", wantErr: "minute: 60 is not in 0-59"},
+		{expr: "0 0 0 * *", wantErr: "day of month: 0 is not in 1-31"},
+		{expr: "0 0 * foo *", wantErr: `month: invalid value "foo"`},
+		{expr: "5-1 * * * *", wantErr: "runs backwards"},
+		{expr: "*/0 * * * *", wantErr: "invalid step"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if c.minute != tt.minute || c.hour != tt.hour {
+				t.Errorf("minute %b hour %b, want %b %b", c.minute, c.hour, tt.minute, tt.hour)
+			}
+			if tt.dow != 0 && c.dow != tt.dow {
+				t.Errorf("day of week %b, want %b", c.dow, tt.dow)
+			}
+		})
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("no time zone data:", err)
+	}
+	at := func(loc *time.Location, year int, month time.Month, day, hour, minute int) time.Time {
+		return time.Date(year, month, day, hour, minute, 0, 0, loc)
+	}
+	utc := func(year int, month time.Month, day, hour, minute int) time.Time {
+		return at(time.UTC, year, month, day, hour, minute)
+	}
+	// The second 01:10 of the night New York falls back, in EST
+	secondTen := time.Date(2026, time.November, 1, 6, 10, 0, 0, time.UTC).In(newYork)
+
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{"next step", "*/15 * * * *", utc(2026, 10, 18, 10, 7), utc(2026, 10, 18, 10, 15)},
+		{"a match is after from", "30 10 * * *", utc(2026, 10, 18, 10, 30), utc(2026, 10, 19, 10, 30)},
+		{"seconds are dropped", "30 10 * * *", utc(2026, 10, 18, 10, 29).Add(30 * time.Second), utc(2026, 10, 18, 10, 30)},
+		{"next year", "@yearly", utc(2026, 10, 18, 10, 0), utc(2027, 1, 1, 0, 0)},
+		{"leap day", "0 0 29 2 *", utc(2026, 10, 18, 10, 0), utc(2028, 2, 29, 0, 0)},
+		{"no such day", "0 0 30 2 *", utc(2026, 10, 18, 10, 0), time.Time{}},
+		// Sunday October 18 2026; November 15 is a Sunday, the 16th a Monday
+		{"day of week", "0 9 * * mon", utc(2026, 10, 18, 10, 0), utc(2026, 10, 19, 9, 0)},
+		{"day of month or day of week", "0 9 15 * mon", utc(2026, 11, 10, 10, 0), utc(2026, 11, 15, 9, 0)},
+		{"day of week or day of month", "0 9 15 * mon", utc(2026, 11, 15, 10, 0), utc(2026, 11, 16, 9, 0)},
+		{"a day field starting with * is and", "0 9 */2 * mon", utc(2026, 11, 10, 10, 0), utc(2026, 11, 23, 9, 0)},
+		{"in the location of from", "0 9 * * *", at(newYork, 2026, 10, 18, 10, 0), at(newYork, 2026, 10, 19, 9, 0)},
+		// New York springs forward on March 8 2026, 02:00 EST is 03:00 EDT
+		{"skipped hour", "30 2 * * *", at(newYork, 2026, 3, 7, 10, 0), at(newYork, 2026, 3, 9, 2, 30)},
+		{"hour after the gap", "0 3 * * *", at(newYork, 2026, 3, 7, 10, 0), at(newYork, 2026, 3, 8, 3, 0)},
+		// and falls back on November 1 2026, 02:00 EDT is 01:00 EST
+		{"repeated hour runs once", "30 1 * * *", at(newYork, 2026, 10, 31, 10, 0), time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC)},
+		{"not again in the repeated hour", "30 1 * * *", time.Date(2026, time.November, 1, 5, 30, 0, 0, time.UTC).In(newYork), at(newYork, 2026, 11, 2, 1, 30)},
+		{"from the repeated hour", "15 1 * * *", secondTen, time.Date(2026, time.November, 1, 6, 15, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, err := parseCron(tt.expr)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := c.next(tt.from)
+			if !got.Equal(tt.want) {
+				t.Fatalf("next(%q, %v) = %v, want %v", tt.expr, tt.from, got, tt.want)
+			}
+			if !got.IsZero() && (!got.After(tt.from) || got.Location() != tt.from.Location()) {
+				t.Errorf("next(%q, %v) = %v, not after from in its location", tt.expr, tt.from, got)
+			}
+		})
+	}
+}
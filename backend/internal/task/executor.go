@@ -3,31 +3,78 @@ package task
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
-	"image/draw"
-	"log"
 	"time"
 
-	"internal/vision"
 	actionpkg "useless-agent/internal/action"
 	"useless-agent/internal/config"
 	imagepkg "useless-agent/internal/image"
 	"useless-agent/internal/llm"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
 	"useless-agent/internal/mouse"
 	"useless-agent/internal/ocr"
+	"useless-agent/internal/screen"
 	"useless-agent/internal/screenshot"
+	"useless-agent/internal/skill"
 	"useless-agent/internal/token"
+	"useless-agent/internal/tracing"
+	"useless-agent/internal/trajectory"
 	"useless-agent/pkg/x11"
 )
 
 // ExecuteTask executes a task with the complete AGILoop implementation
 func ExecuteTask(task *Task) {
-	log.Printf("=== EXECUTING TASK %s ===", task.ID)
-	log.Printf("Task message: %s", task.Message)
+	// Record everything the agent sees and decides, so the run can be
+	// inspected and replayed later
+	recorder := trajectory.NewRecorder(task.ID, task.Message)
+	hist := task.history
+	observe := func(ex llm.Exchange) {
+		recorder.RecordLLMExchange(ex)
+		hist.observeExchange(ex)
+	}
+	taskCtx := logging.WithTask(withHistory(llm.WithExchangeObserver(task.Context, observe), hist), task.ID)
+	taskCtx = llm.WithHints(llm.WithModel(taskCtx, task.Model), task.Hints)
+	taskCtx, taskSpan := tracing.Start(taskCtx, "task", "task.id", task.ID, "task.goal", task.Message)
+	var rec *trajectory.Iteration
+	var subtaskSpan, iterationSpan *tracing.Span
+
+	logger.InfoContext(taskCtx, "executing task", "goal", task.Message)
+	startedAt := time.Now()
+
+	// Iterations spent on the current subtask, -1 while none is running
+	subtaskIterations := -1
+
+	// Whatever way the task ends (completion, cancel, error or panic), never
+	// leave keys or mouse buttons pressed on the desktop
+	defer hist.close()
+	defer clearPause(task.ID)
+	defer func() {
+		iterationSpan.End()
+		if subtaskIterations >= 0 {
+			metrics.SubtaskIterations.With("aborted").Observe(float64(subtaskIterations))
+			subtaskSpan.SetAttributes("subtask.result", "aborted", "subtask.iterations", subtaskIterations)
+			hist.endSubtask("aborted")
+		}
+		subtaskSpan.End()
+		if r := recover(); r != nil {
+			logger.ErrorContext(taskCtx, "task panicked", "panic", r)
+			actionpkg.ReleaseAll()
+			UpdateTaskStatus(task.ID, "broken", fmt.Sprintf("Task execution panicked: %v", r))
+			CleanupUserAssistMessages(task.ID)
+			recorder.Finish("broken", fmt.Sprintf("panic: %v", r))
+			observeTaskOutcome(taskSpan, "broken", fmt.Sprintf("panic: %v", r), startedAt)
+			return
+		}
+		actionpkg.ReleaseAll()
+		if t, ok := GetTask(task.ID); ok {
+			recorder.Finish(t.Status, t.Message)
+			observeTaskOutcome(taskSpan, t.Status, t.Message, startedAt)
+		}
+	}()
 
-	var prevActionsJSONString string
-	log.Println("prevActionsJSONString:", prevActionsJSONString)
 	var iteration int64 = 1
 	prompt := task.Message
 	goal := prompt
@@ -37,18 +84,34 @@ func ExecuteTask(task *Task) {
 	var promptLogJSONString string
 	promptLogBytes, err := json.Marshal(promptLog)
 	if err != nil {
-		log.Println("failed to marshal promptLog to JSON String:", err)
+		logger.ErrorContext(taskCtx, "failed to marshal prompt log", "error", err)
 	}
 	promptLogJSONString = string(promptLogBytes)
 	prevCursorPositionJSONString, _ := getCursorPositionJSON()
 
 	var subtasks []SubTask
-	subtasks, err = breakGoalIntoSubtasks(goal)
-	if err != nil {
-		log.Println("Failed to break down goal into subtasks.")
-		subtasks = nil
-		subtasks = append(subtasks, SubTask{Id: 0, Description: goal})
+	var script *scriptRun
+	if task.Script != nil {
+		// A script is not planned, its llm steps become subtasks as it reaches them
+		script, err = newScriptRun(task)
+		if err == nil {
+			subtasks, err = script.next(taskCtx)
+		}
+		if err != nil {
+			failScript(taskCtx, task, err)
+			return
+		}
+	} else {
+		subtasks, err = breakGoalIntoSubtasks(taskCtx, goal)
+		if err != nil {
+			logger.WarnContext(taskCtx, "failed to break down goal into subtasks, using the goal as the only subtask", "error", err)
+			subtasks = nil
+			subtasks = append(subtasks, SubTask{Id: 0, Description: goal})
+		}
 	}
+	recorder.SetSubtasks(subtasks)
+	hist.setPlan(subtasks, 0)
+	taskSpan.SetAttributes("task.subtasks", len(subtasks))
 
 	// Send initial subtasks to frontend
 	for _, subtask := range subtasks {
@@ -65,16 +128,20 @@ func ExecuteTask(task *Task) {
 	// CRITICAL FIX: Check if task has no subtasks and complete immediately
 	// This handles cases where tasks complete too fast without going through normal execution loop
 	if len(subtasks) == 0 {
-		log.Printf("Task %s has no subtasks, completing immediately", task.ID)
+		logger.InfoContext(taskCtx, "task has no subtasks, completing immediately")
+		message := "Task completed successfully - no actions needed"
+		if script != nil {
+			message = "Script completed without llm steps"
+		}
 
 		// Update task status to completed
-		UpdateTaskStatus(task.ID, "completed", "Task completed successfully - no actions needed")
+		UpdateTaskStatus(task.ID, "completed", message)
 
 		// Send task completion to execution engine
 		BroadcastExecutionEngineUpdate("taskUpdate", map[string]interface{}{
 			"taskId":  task.ID,
 			"status":  "completed",
-			"message": "Task completed successfully - no actions needed",
+			"message": message,
 		})
 
 		// CRITICAL FIX: Send completion event to frontend
@@ -89,72 +156,116 @@ func ExecuteTask(task *Task) {
 		return
 	}
 
-	for _, subtask := range subtasks {
+	var stagnation stagnationDetector
+	var stagnationWarning string
+	jitterNextBatch := false
+	replans := 0
+
+	for subtaskIndex := 0; subtaskIndex < len(subtasks); subtaskIndex++ {
+		subtask := subtasks[subtaskIndex]
+		stagnation.reset()
+		subtaskIterations = 0
+		subtaskResult := "limit"
+		var subtaskCtx context.Context
+		subtaskCtx, subtaskSpan = tracing.Start(taskCtx, "subtask", "subtask.id", subtask.Id, "subtask.description", subtask.Description)
+		hist.beginSubtask(subtaskIndex, subtask)
+		trace := newSubtaskTrace(subtask.Description)
+
+		// Task-level postconditions are the natural checks for the last subtask
+		subtaskChecks := subtask.Postconditions
+		if subtaskIndex == len(subtasks)-1 {
+			subtaskChecks = append(append([]Postcondition{}, subtaskChecks...), task.Postconditions...)
+		}
+
 	SubTaskLoop:
 		for {
+			// Every record of this iteration carries the subtask and iteration
+			ctx := logging.WithIteration(logging.WithSubtask(subtaskCtx, subtask.Id), iteration)
+
+			// A paused task waits here, a cancel while paused is handled below
+			waitIfPaused(ctx, task)
+			waitForOperator(ctx, task)
+			seenInputs := operatorInputCount()
+
 			// Check for task cancellation at the start of each iteration
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled during execution", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "during execution")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with normal execution
 			}
 
-			if iteration > 40 {
+			if iteration > task.Budget.iterations() {
 				break SubTaskLoop
 			}
+			if reason := task.Budget.exceeded(startedAt, hist.estimatedTokens()); reason != "" {
+				logger.WarnContext(ctx, "task over budget", "reason", reason)
+				UpdateTaskStatus(task.ID, "broken", reason)
+				return
+			}
 
 			// Check for task cancellation before screenshot
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before screenshot capture", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before screenshot capture")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with screenshot
 			}
 
-			screenshotImg, err := screenshot.CaptureX11Screenshot()
+			rec = recorder.BeginIteration(iteration, subtask.Id, subtask.Description)
+			hist.beginIteration(iteration)
+			subtaskIterations++
+			ctx, iterationSpan = tracing.Start(ctx, "iteration", "iteration", iteration)
+			phase := beginPhase(ctx, rec, "capture")
+			screenshotImg, err := captureScreenshot()
+			phase.end()
 			originalScreenshot := screenshotImg
 			if err != nil {
-				log.Printf("Failed to capture screenshot for task %s: %s", task.ID, err.Error())
+				logger.ErrorContext(ctx, "failed to capture screenshot", "error", err)
 				UpdateTaskStatus(task.ID, "broken", "Failed to capture screenshot")
 				return
 			}
 
 			colorsDistribution := imagepkg.DominantColorsToJSONString(imagepkg.DominantColors(screenshotImg, 10))
-			log.Println("colorsDistribution before actions: ", colorsDistribution)
+			logger.DebugContext(ctx, "colors before actions", "colors", colorsDistribution)
 
 			// Check for task cancellation before grayscale conversion
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before grayscale conversion", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before grayscale conversion")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with grayscale conversion
 			}
 
+			rec.SaveScreenshot("before", screenshotImg)
 			grayscaleScreenshot := screenshot.ConvertToGrayscale(screenshotImg)
 
 			// Check for task cancellation before OCR
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before OCR processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before OCR processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with OCR
 			}
 
-			ocrResults := ocr.OCR(grayscaleScreenshot)
+			phase = beginPhase(ctx, rec, "ocr")
+			ocrResults := runOCR(grayscaleScreenshot)
+			phase.set("ocr.elements", len(ocrResults))
+			phase.end()
+			rec.SaveJSON("ocr_before", ocrResults)
 
 			// Check for task cancellation after OCR
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after OCR processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after OCR processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -164,62 +275,41 @@ func ExecuteTask(task *Task) {
 			// Get X11 windows data
 			x11WindowsData, err := getX11WindowsData()
 			if err != nil {
-				log.Printf("Failed to get X11 windows data, continuing with empty data: %v", err)
+				logger.WarnContext(ctx, "failed to get X11 windows data, continuing with empty data", "error", err)
 				x11WindowsData = "[]"
 			}
-			log.Printf("X11 windows data: %s", x11WindowsData)
-
-			var detectedWindowsJSON string = "["
-			for index, ocrElement := range ocrResults {
-				// Check for task cancellation during window detection loop
-				select {
-				case <-task.Context.Done():
-					log.Printf("Task %s canceled during window detection", task.ID)
-					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
-					return
-				default:
-					// Continue with current window detection
-				}
 
-				ocrElementBB := image.Rect(ocrElement.BoundingBox.XMin, ocrElement.BoundingBox.YMin, ocrElement.BoundingBox.XMax, ocrElement.BoundingBox.YMax)
-				windowsJSONString, err := vision.DetectWindow(grayscaleScreenshot, ocrElementBB, ocrElement.Text)
-				if err != nil {
-					log.Println("Failed to detect windows:", err)
-					continue
-				}
-				detectedWindowsJSON += windowsJSONString
-				if index != (len(ocrResults) - 1) {
-					detectedWindowsJSON += ","
-				}
+			phase = beginPhase(ctx, rec, "windowDetection")
+			detectedWindowsJSON, err := detectWindows(task.Context, grayscaleScreenshot, ocrResults)
+			phase.end()
+			if err != nil {
+				logger.InfoContext(ctx, "task canceled", "stage", "during window detection")
+				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
+				return
 			}
-			detectedWindowsJSON += "]"
-			log.Println("Detected windows json string data:", detectedWindowsJSON)
+			rec.SaveRawJSON("windows_x11", x11WindowsData)
+			rec.SaveRawJSON("windows_detected", detectedWindowsJSON)
 
 			// Keep X11 windows data separate from OCR-detected windows
 			// They have different JSON formats and should be sent separately to LLM
-			log.Printf("OCR-detected windows data: %s", detectedWindowsJSON)
-			log.Printf("X11 API-detected windows data: %s", x11WindowsData)
+			logger.DebugContext(ctx, "windows", "detected", detectedWindowsJSON, "x11", x11WindowsData)
 
 			// Check for task cancellation after window detection
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after window detection", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after window detection")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with OCR processing
 			}
 
-			ocrResultsJSON := ocr.OCRtoJSONString(ocrResults)
-			if len(ocrResultsJSON) > 10000 {
-				ocrDataMerged := ocr.MergeCloseText(ocrResults, 20, 40)
-				ocrResultsJSON = ocr.OCRtoJSONString(ocrDataMerged)
-			}
+			ocrResultsJSON := ocrPromptJSON(ocrResults)
 
 			// Check for task cancellation after OCR JSON processing
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after OCR JSON processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after OCR JSON processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -233,12 +323,12 @@ func ExecuteTask(task *Task) {
 			if iteration > 1 {
 				textChanges, err = ocr.GetOCRDelta(previousOCRText, ocrResultsJSON)
 				if err != nil {
-					log.Printf("Filed to get OCR Delta[iteration: %d]: %s", iteration, err)
+					logger.WarnContext(ctx, "failed to compute OCR delta", "error", err)
 				}
 				// Check for task cancellation after OCR delta calculation
 				select {
 				case <-task.Context.Done():
-					log.Printf("Task %s canceled after OCR delta calculation", task.ID)
+					logger.InfoContext(ctx, "task canceled", "stage", "after OCR delta calculation")
 					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 					return
 				default:
@@ -247,29 +337,32 @@ func ExecuteTask(task *Task) {
 
 				textChangesJSON, err = ocr.GetOCRDeltaJSONString(textChanges)
 				if err != nil {
-					log.Printf("Filed to get OCR Delta JSON string[iteration: %d]: %s", iteration, err)
+					logger.WarnContext(ctx, "failed to encode OCR delta", "error", err)
 				}
 				// Check for task cancellation after delta JSON string
 				select {
 				case <-task.Context.Done():
-					log.Printf("Task %s canceled after OCR delta JSON string", task.ID)
+					logger.InfoContext(ctx, "task canceled", "stage", "after OCR delta JSON string")
 					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 					return
 				default:
 					// Continue with abstract description
 				}
 
-				textChangesSummary, err = getOCRDeltaAbstractDescription(textChangesJSON)
+				phase = beginPhase(ctx, rec, "deltaSummary")
+				textChangesSummary, err = getOCRDeltaAbstractDescription(phase.ctx, textChangesJSON)
+				phase.end()
 				if err != nil {
-					log.Printf("Filed to get OCR Delta abstract description [iteration: %d]: %s", iteration, err)
+					logger.WarnContext(ctx, "failed to summarize OCR delta", "error", err)
 				}
+				rec.SaveJSON("delta_before", map[string]interface{}{"delta": textChanges, "summary": textChangesSummary})
 			}
 			previousOCRText = ocrResultsJSON
 
 			// Check for task cancellation after text changes processing
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after text changes processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after text changes processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -282,25 +375,56 @@ func ExecuteTask(task *Task) {
 			var nextPrompt string
 			var completionStatus string
 
-			log.Printf("iteration %d, original goal is: %s\n", iteration, goal)
-			log.Printf("iteration %d, current task is: %s\n", iteration, subtask.Description)
+			logger.InfoContext(ctx, "starting iteration", "goal", goal, "subtask", subtask.Description)
 
 			// Check for user-assist messages
 			userAssistMsg := GetUserAssistMessage(task.ID)
 			enhancedSubtaskDescription := subtask.Description
 			if userAssistMsg != nil {
-				log.Printf("Injecting user-assist message for task %s: %s", task.ID, userAssistMsg.Message)
+				logger.InfoContext(ctx, "injecting user-assist message", "message", userAssistMsg.Message)
+				hist.event("userAssist", map[string]string{"message": userAssistMsg.Message})
 				enhancedSubtaskDescription = subtask.Description + "\n\nHELPER MESSAGE FROM THE USER: " + userAssistMsg.Message
-				log.Printf("Enhanced subtask description with user-assist: %s", enhancedSubtaskDescription)
+			}
+			if stagnationWarning != "" {
+				enhancedSubtaskDescription += stagnationWarning
+				stagnationWarning = ""
 			}
 
 			promptLogBytes, err = json.Marshal(promptLog)
 			if err != nil {
-				log.Println("failed to marshal promptLog to JSON String:", err)
+				logger.ErrorContext(ctx, "failed to marshal prompt log", "error", err)
 			}
 			promptLogJSONString = string(promptLogBytes)
 
-			actions, actionsJSONString, err := sendMessageToLLM(task.Context, enhancedSubtaskDescription, boundingBoxesJSON, ocrResultsJSON, textChangesSummary, promptLogJSONString, iteration, prevCursorPositionJSONString, detectedWindowsJSON, x11WindowsData, colorsDistribution)
+			// Inputs that are not derived from the screenshot, needed to replay the iteration offline
+			cursorPositionJSONString, _ := getCursorPositionJSON()
+			skillsSection := skill.PromptSection()
+			rec.SaveJSON("act_inputs", map[string]interface{}{
+				"prompt":     enhancedSubtaskDescription,
+				"promptLog":  promptLogJSONString,
+				"prevCursor": prevCursorPositionJSONString,
+				"cursor":     cursorPositionJSONString,
+				"model":      task.Model,
+				"hints":      task.Hints,
+				"skills":     skillsSection,
+			})
+
+			// Past subtasks like this one, shown to the model as examples
+			examples := trace.examples(ocrResults)
+			if len(examples) > 0 {
+				rec.SaveJSON("examples", examples)
+				ids := make([]string, len(examples))
+				for i, e := range examples {
+					ids[i] = e.ID
+				}
+				hist.event("macroMatch", map[string]interface{}{"macros": ids})
+			}
+
+			phase = beginPhase(ctx, rec, "act")
+			actions, _, err := sendMessageToLLM(llm.WithSkillsSection(llm.WithExamples(phase.ctx, examples), skillsSection), enhancedSubtaskDescription, boundingBoxesJSON, ocrResultsJSON, textChangesSummary, promptLogJSONString, iteration, prevCursorPositionJSONString, cursorPositionJSONString, detectedWindowsJSON, x11WindowsData, colorsDistribution)
+
+			phase.end()
+			rec.SaveJSON("actions", actions)
 
 			// Send subtask update with actions
 			UpdateSubtask(task.ID, subtask.Id, subtask.Description, true, actions)
@@ -314,20 +438,19 @@ func ExecuteTask(task *Task) {
 			})
 
 			if err != nil {
-				log.Println("failed to send message to LLM:", err)
 				// Check if the error is due to task cancellation
-				if task.Context.Err() == context.Canceled {
-					log.Printf("Task %s was canceled by user during LLM communication", task.ID)
+				if ctx.Err() == context.Canceled {
+					logger.InfoContext(ctx, "task canceled", "stage", "during LLM communication")
 					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				} else {
-					log.Printf("Task %s failed due to LLM communication error: %v", task.ID, err)
+					logger.ErrorContext(ctx, "task failed due to LLM communication error", "error", err)
 					UpdateTaskStatus(task.ID, "broken", "Failed to communicate with LLM")
 				}
 				return
-			} else {
-				log.Println("successfully sent a message to LLM. Iteration:", iteration)
 			}
+			hist.setActions(actions)
 
+			phase = beginPhase(ctx, rec, "execute")
 			for i, action := range actions {
 				// Send action update
 				UpdateAction(task.ID, subtask.Id, i, action)
@@ -349,6 +472,18 @@ func ExecuteTask(task *Task) {
 				if action.KeyTapString != "" {
 					actionData["keyTapString"] = action.KeyTapString
 				}
+				if action.KeyString != "" {
+					actionData["keyString"] = action.KeyString
+				}
+				if len(action.Keys) > 0 {
+					actionData["keys"] = action.Keys
+				}
+				if action.Name != "" {
+					actionData["name"] = action.Name
+				}
+				if action.Parameters != nil {
+					actionData["parameters"] = action.Parameters
+				}
 				if action.Duration != 0 {
 					actionData["duration"] = action.Duration
 				}
@@ -359,32 +494,49 @@ func ExecuteTask(task *Task) {
 					"actionIndex": i,
 					"action":      actionData,
 				})
+				waitIfPaused(ctx, task)
+				waitForOperator(ctx, task)
+				if operatorInputCount() != seenInputs {
+					logger.InfoContext(ctx, "operator changed the screen, dropping the rest of the batch", "actionIndex", i)
+					for j := i; j < len(actions); j++ {
+						hist.actionDone(j, 0, "operator took control")
+					}
+					break
+				}
 				// Check for task cancellation before executing each action
 				select {
 				case <-task.Context.Done():
-					log.Printf("Task %s canceled before executing action %d", task.ID, i)
+					logger.InfoContext(ctx, "task canceled", "stage", "before executing action", "actionIndex", i)
 					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 					return
 				default:
 					// Continue with action execution
 				}
 
-				// Debug: Log action details before execution
-				log.Printf("Preparing to execute action %d: %s", i, actions[i].Action)
-				log.Printf("Action details: %+v", actions[i])
-
 				setExecuteFunction(&actions[i])
 				time.Sleep(100 * time.Millisecond)
 
 				// Check if Execute function is set
 				if actions[i].Execute == nil {
-					log.Printf("ERROR: Execute function is nil for action %d: %s", i, actions[i].Action)
+					logger.ErrorContext(ctx, "no execute function for action", "actionIndex", i, "action", actions[i].Action)
+					hist.actionDone(i, 0, "unknown action")
+					continue
+				}
+
+				// Skip actions with parameters that can never work, e.g. unknown key names
+				if err := actionpkg.Validate(&actions[i]); err != nil {
+					logger.WarnContext(ctx, "skipping invalid action", "actionIndex", i, "action", actions[i].Action, "error", err)
+					hist.actionDone(i, 0, err.Error())
 					continue
 				}
 
+				if jitterNextBatch {
+					jitterCoordinates(&actions[i])
+				}
+
+				logger.InfoContext(ctx, "executing action", "actionIndex", i, "action", actions[i].Action, "description", actions[i].Description)
 				if actions[i].Action == "stopIteration" {
-					log.Printf("Executing stopIteration action")
-					actions[i].Execute(&actions[i])
+					runAction(phase.ctx, actions, i)
 					// break SubTaskLoop
 					// stop executing actions but don't break a SubTaskLoop, because task completion needs to be verified.
 					break
@@ -395,32 +547,31 @@ func ExecuteTask(task *Task) {
 				//	time.Sleep(1 * time.Second)
 				//	break
 				//}
-				if actions[i].Action == "repeat" {
-					log.Printf("Executing repeat action")
-					actions[i].Execute(&actions[i], &actions)
-					fmt.Println()
-					continue
-				}
-
-				log.Printf("Executing regular action: %s", actions[i].Action)
-				actions[i].Execute(&actions[i])
-				fmt.Println()
+				runAction(phase.ctx, actions, i)
+				trace.executed(actions, i)
 			}
 
+			// A batch may end (stopIteration, skipped keyUp) with keys still down
+			actionpkg.ReleaseAll()
+			phase.end()
+			jitterNextBatch = false
+
 			// Check for task cancellation before second screenshot
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before second screenshot capture", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before second screenshot capture")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with screenshot
 			}
 
-			screenshotImg, err = screenshot.CaptureX11Screenshot()
+			phase = beginPhase(ctx, rec, "capture", "stage", "after")
+			screenshotImg, err = captureScreenshot()
+			phase.end()
 			originalScreenshot = screenshotImg
 			if err != nil {
-				log.Printf("Failed to capture screenshot for task %s: %s", task.ID, err.Error())
+				logger.ErrorContext(ctx, "failed to capture screenshot", "error", err)
 				UpdateTaskStatus(task.ID, "broken", "Failed to capture screenshot")
 				return
 			}
@@ -428,83 +579,68 @@ func ExecuteTask(task *Task) {
 			// Check for task cancellation before second grayscale conversion
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before second grayscale conversion", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before second grayscale conversion")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with grayscale conversion
 			}
 
+			rec.SaveScreenshot("after", screenshotImg)
 			grayscaleScreenshot = screenshot.ConvertToGrayscale(screenshotImg)
 
 			// Check for task cancellation before second OCR
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled before second OCR processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "before second OCR processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with OCR
 			}
 
-			ocrResults = ocr.OCR(grayscaleScreenshot)
+			phase = beginPhase(ctx, rec, "ocr", "stage", "after")
+			ocrResults = runOCR(grayscaleScreenshot)
+			phase.set("ocr.elements", len(ocrResults))
+			phase.end()
+			rec.SaveJSON("ocr_after", ocrResults)
 
 			// Check for task cancellation after second OCR
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second OCR processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second OCR processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with window detection
 			}
 
-			detectedWindowsJSON = "["
-			for index, ocrElement := range ocrResults {
-				// Check for task cancellation during second window detection loop
-				select {
-				case <-task.Context.Done():
-					log.Printf("Task %s canceled during second window detection", task.ID)
-					UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
-					return
-				default:
-					// Continue with current window detection
-				}
-
-				ocrElementBB := image.Rect(ocrElement.BoundingBox.XMin, ocrElement.BoundingBox.YMin, ocrElement.BoundingBox.XMax, ocrElement.BoundingBox.YMax)
-				windowsJSONString, err := vision.DetectWindow(grayscaleScreenshot, ocrElementBB, ocrElement.Text)
-				if err != nil {
-					log.Println("Failed to detect windows:", err)
-					continue
-				}
-				detectedWindowsJSON += windowsJSONString
-				if index != (len(ocrResults) - 1) {
-					detectedWindowsJSON += ","
-				}
+			phase = beginPhase(ctx, rec, "windowDetection", "stage", "after")
+			detectedWindowsJSON, err = detectWindows(task.Context, grayscaleScreenshot, ocrResults)
+			phase.end()
+			if err != nil {
+				logger.InfoContext(ctx, "task canceled", "stage", "during second window detection")
+				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
+				return
 			}
-			detectedWindowsJSON += "]"
-			log.Println("Detected windows json string data:", detectedWindowsJSON)
+			rec.SaveRawJSON("windows_detected_after", detectedWindowsJSON)
 
 			// Check for task cancellation after second window detection
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second window detection", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second window detection")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with OCR JSON processing
 			}
 
-			ocrResultsJSON = ocr.OCRtoJSONString(ocrResults)
-			if len(ocrResultsJSON) > 10000 {
-				ocrDataMerged := ocr.MergeCloseText(ocrResults, 20, 40)
-				ocrResultsJSON = ocr.OCRtoJSONString(ocrDataMerged)
-			}
+			ocrResultsJSON = ocrPromptJSON(ocrResults)
 
 			// Check for task cancellation after second OCR JSON processing
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second OCR JSON processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second OCR JSON processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -513,12 +649,12 @@ func ExecuteTask(task *Task) {
 
 			textChanges, err = ocr.GetOCRDelta(previousOCRText, ocrResultsJSON)
 			if err != nil {
-				log.Printf("Filed to get OCR Delta[iteration: %d]: %s", iteration, err)
+				logger.WarnContext(ctx, "failed to compute OCR delta", "error", err)
 			}
 			// Check for task cancellation after second OCR delta calculation
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second OCR delta calculation", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second OCR delta calculation")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -527,27 +663,30 @@ func ExecuteTask(task *Task) {
 
 			textChangesJSON, err = ocr.GetOCRDeltaJSONString(textChanges)
 			if err != nil {
-				log.Printf("Filed to get OCR Delta JSON string[iteration: %d]: %s", iteration, err)
+				logger.WarnContext(ctx, "failed to encode OCR delta", "error", err)
 			}
 			// Check for task cancellation after second delta JSON string
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second OCR delta JSON string", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second OCR delta JSON string")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with abstract description
 			}
 
-			textChangesSummary, err = getOCRDeltaAbstractDescription(textChangesJSON)
+			phase = beginPhase(ctx, rec, "deltaSummary")
+			textChangesSummary, err = getOCRDeltaAbstractDescription(phase.ctx, textChangesJSON)
+			phase.end()
 			if err != nil {
-				log.Printf("Filed to get OCR Delta abstract description [iteration: %d]: %s", iteration, err)
+				logger.WarnContext(ctx, "failed to summarize OCR delta", "error", err)
 			}
+			rec.SaveJSON("delta", map[string]interface{}{"delta": textChanges, "summary": textChangesSummary})
 
 			// Check for task cancellation after second text changes processing
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second text changes processing", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second text changes processing")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -561,7 +700,7 @@ func ExecuteTask(task *Task) {
 			// Check for task cancellation after second bounding boxes
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after second bounding boxes", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after second bounding boxes")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -573,7 +712,7 @@ func ExecuteTask(task *Task) {
 			// Check for task cancellation after cursor position
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after cursor position", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after cursor position")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -581,17 +720,16 @@ func ExecuteTask(task *Task) {
 			}
 
 			_, CursorY := getCursorPosition()
-			log.Println("image under the cursor bounding box[x,y,x2,y2]:", 0, max(0, CursorY-23), grayscaleScreenshot.Bounds().Max.X, min(CursorY+23, grayscaleScreenshot.Bounds().Max.Y))
-			rect := image.Rect(0, max(0, CursorY-23), grayscaleScreenshot.Bounds().Max.X, min(CursorY+23, grayscaleScreenshot.Bounds().Max.Y))
-			imgUnderCursor := image.NewGray(rect)
-			draw.Draw(imgUnderCursor, imgUnderCursor.Bounds(), grayscaleScreenshot, rect.Min, draw.Src)
-			ocrDataNearTheCursor := ocr.OCRtoJSONString(ocr.OCR(imgUnderCursor))
-			log.Println("OCR data near the cursor[46 pix height]:", ocrDataNearTheCursor)
+			ocrDataNearTheCursor := ocrNearCursor(grayscaleScreenshot, CursorY)
+			rec.SaveJSON("verify_inputs", map[string]interface{}{
+				"cursor":  currentCursorPosition,
+				"cursorY": CursorY,
+			})
 
 			// Check for task cancellation after OCR under cursor
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after OCR under cursor", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after OCR under cursor")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
@@ -600,38 +738,156 @@ func ExecuteTask(task *Task) {
 
 			colorsDistributionBeforeActions := colorsDistribution
 			colorsDistribution = imagepkg.DominantColorsToJSONString(imagepkg.DominantColors(screenshotImg, 10))
-			log.Println("colorsDistribution after actions: ", colorsDistribution)
+			logger.DebugContext(ctx, "colors after actions", "colors", colorsDistribution)
 
 			// Check for task cancellation after color distribution
 			select {
 			case <-task.Context.Done():
-				log.Printf("Task %s canceled after color distribution", task.ID)
+				logger.InfoContext(ctx, "task canceled", "stage", "after color distribution")
 				UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
 				return
 			default:
 				// Continue with goal achievement check
 			}
 
-			taskCompleted, completionStatus, nextPrompt = isGoalAchieved(subtask.Description, boundingBoxesJSON, ocrResultsJSON, textChangesJSON, textChangesSummary, promptLogJSONString, iteration, prevCursorPositionJSONString, detectedWindowsJSON, currentCursorPosition, ocrDataNearTheCursor, colorsDistributionBeforeActions, colorsDistribution)
-			log.Println("Verdict description:", completionStatus)
+			// Deterministic checks first: they are cheap and settle obvious cases
+			// without spending verifier tokens
+			phase = beginPhase(ctx, rec, "verify")
+			var subtaskResults []CheckResult
+			var checkSummary string
+			// Task postconditions are only in the checks of the last subtask: one
+			// that already held before the task started must not end it early
+			if len(subtaskChecks) > 0 {
+				checkCtx := CheckContext{OCR: ocrResults}
+				checkCtx.X11WindowsJSON, _ = getX11WindowsData()
+
+				subtaskResults = EvaluatePostconditions(subtaskChecks, checkCtx)
+				checkSummary = SummarizeCheckResults(subtaskResults)
+				broadcastCheckResults(task.ID, subtask.Id, subtaskResults)
+				logger.InfoContext(ctx, "postcondition results", "checks", checkSummary)
+			}
+
+			verdictSource := "checks"
+			switch {
+			case AllPassed(subtaskResults):
+				taskCompleted = true
+				completionStatus = "All postconditions passed: " + checkSummary
+			case len(subtaskChecks) > 0 && task.Verification == VerificationChecksOnly:
+				taskCompleted = false
+				completionStatus = "Postconditions not met: " + checkSummary
+				nextPrompt = subtask.Description + " (not done yet, failed checks: " + checkSummary + ")"
+			default:
+				verdictSource = "llm"
+				taskCompleted, completionStatus, nextPrompt = isGoalAchieved(phase.ctx, subtask.Description, boundingBoxesJSON, ocrResultsJSON, textChangesJSON, textChangesSummary, promptLogJSONString, iteration, prevCursorPositionJSONString, detectedWindowsJSON, currentCursorPosition, ocrDataNearTheCursor, colorsDistributionBeforeActions, colorsDistribution, checkSummary)
+			}
+			phase.set("verdict.completed", taskCompleted, "verdict.source", verdictSource, "verdict.description", completionStatus)
+			phase.end()
+			iterationSpan.SetAttributes("actions", len(actions), "verdict.completed", taskCompleted, "verdict.source", verdictSource)
+			rec.SaveJSON("verdict", map[string]interface{}{
+				"completed":   taskCompleted,
+				"description": completionStatus,
+				"nextPrompt":  nextPrompt,
+				"checks":      subtaskResults,
+				"source":      verdictSource,
+			})
+			hist.verdict(Verdict{Completed: taskCompleted, Description: completionStatus, NextPrompt: nextPrompt, Source: verdictSource, Checks: subtaskResults})
 			if taskCompleted {
-				log.Println("Completed task: ", subtask.Description)
-				log.Println("TASK COMPLETED! breaking SubTaskLoop...")
+				iterationSpan.End()
+				logger.InfoContext(ctx, "subtask completed", "subtask", subtask.Description, "verdict", completionStatus, "source", verdictSource)
 				promptLog = nil
+				subtaskResult = "completed"
+				trace.completed(task.ID, subtaskIterations)
 				break SubTaskLoop
 			} else {
-				log.Println("task not completed, new prompt is:", nextPrompt)
+				trace.verdict(false)
+				logger.InfoContext(ctx, "subtask not completed", "verdict", completionStatus, "source", verdictSource, "nextPrompt", nextPrompt)
 				prompt = nextPrompt
 				promptLog = append(promptLog, PromptLog{iteration, nextPrompt})
 			}
 
 			iteration += 1
-			prevActionsJSONString = actionsJSONString
 			prevCursorPositionJSONString, _ = getCursorPositionJSON()
+
+			// Escalate while the act-verify cycle makes no progress instead of
+			// looping until the iteration limit
+			stagnation.record(actions, ocrResults, textChanges, colorsDistributionBeforeActions, colorsDistribution)
+			level, reason := stagnation.escalate()
+			// A script's steps are its plan: a replan would drop the until
+			// checks of its llm step and number the new subtasks on its own
+			if level == escalationReplan && (replans >= maxReplansPerTask || script != nil) {
+				level = escalationAskUser
+			}
+			switch level {
+			case escalationWarn:
+				logger.WarnContext(ctx, "task stagnating, warning the model", "reason", reason)
+				stagnationWarning = stagnationNotice(reason)
+			case escalationJitter:
+				logger.WarnContext(ctx, "task still stagnating, jittering next batch", "reason", reason)
+				stagnationWarning = stagnationNotice(reason)
+				jitterNextBatch = true
+			case escalationReplan:
+				replans++
+				stagnation.resetHistory()
+				revised, err := replanRemaining(ctx, task.ID, goal, subtasks, subtaskIndex, reason, ocrResultsJSON, x11WindowsData)
+				if err != nil {
+					logger.WarnContext(ctx, "failed to replan, continuing with current plan", "error", err)
+				} else {
+					subtasks = revised
+					recorder.SetSubtasks(subtasks)
+					hist.setPlan(subtasks, subtaskIndex)
+					promptLog = nil
+					subtaskIndex-- // restart at the same position with the new subtask
+					subtaskResult = "replanned"
+					break SubTaskLoop
+				}
+			case escalationAskUser:
+				if !requestUserAssist(task, subtask, reason) {
+					if task.Context.Err() != nil {
+						logger.InfoContext(ctx, "task canceled", "stage", "waiting for user assistance")
+						UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
+						return
+					}
+					logger.WarnContext(ctx, "task stuck and no user assistance", "reason", reason)
+					UpdateTaskStatus(task.ID, "broken", "Stuck ("+reason+") and no user assistance within "+userAssistWaitTimeout.String())
+					CleanupUserAssistMessages(task.ID)
+					return
+				}
+				// The operator's message goes into the next prompt
+				stagnation.reset()
+			}
+
+			iterationSpan.End()
 			time.Sleep(1 * time.Second)
 		}
+		iterationSpan.End()
+		metrics.SubtaskIterations.With(subtaskResult).Observe(float64(subtaskIterations))
+		subtaskSpan.SetAttributes("subtask.result", subtaskResult, "subtask.iterations", subtaskIterations)
+		subtaskSpan.End()
+		hist.endSubtask(subtaskResult)
+		subtaskIterations = -1
+
+		// A script goes on to its next llm step once the last one is done
+		if script != nil {
+			if subtaskResult != "completed" {
+				failScript(taskCtx, task, fmt.Errorf("llm step %q did not complete (%s)", subtask.Description, subtaskResult))
+				return
+			}
+			if subtaskIndex == len(subtasks)-1 {
+				next, err := script.next(taskCtx)
+				if err != nil {
+					failScript(taskCtx, task, err)
+					return
+				}
+				subtasks = append(subtasks, next...)
+				recorder.SetSubtasks(subtasks)
+				hist.setPlan(subtasks, subtaskIndex+1)
+				for _, s := range next {
+					UpdateSubtask(task.ID, s.Id, s.Description, false, []actionpkg.Action{})
+				}
+			}
+		}
 	}
-	log.Println("GOAL ACHIEVED! breaking AGIloop...")
+	logger.InfoContext(taskCtx, "goal achieved")
 
 	// Update task status to completed
 	UpdateTaskStatus(task.ID, "completed", task.Message) // Keep original message, don't override with status text
@@ -654,6 +910,18 @@ func ExecuteTask(task *Task) {
 	CleanupUserAssistMessages(task.ID)
 }
 
+// failScript ends a script task that could not go on
+func failScript(ctx context.Context, task *Task, err error) {
+	if task.Context.Err() != nil {
+		logger.InfoContext(ctx, "task canceled", "stage", "during script")
+		UpdateTaskStatus(task.ID, "canceled", "Task canceled by user")
+		return
+	}
+	logger.WarnContext(ctx, "script failed", "error", err)
+	UpdateTaskStatus(task.ID, "broken", "Script failed: "+err.Error())
+	CleanupUserAssistMessages(task.ID)
+}
+
 // Helper functions that use the proper mouse package functions
 func getCursorPositionJSON() (string, error) {
 	return mouse.GetCursorPositionJSON()
@@ -663,8 +931,8 @@ func getCursorPosition() (int, int) {
 	return mouse.GetCursorPosition()
 }
 
-func breakGoalIntoSubtasks(goal string) ([]SubTask, error) {
-	llmSubtasks, err := llm.BreakGoalIntoSubtasks(goal, token.AddTokensAndSendUpdate)
+func breakGoalIntoSubtasks(ctx context.Context, goal string) ([]SubTask, error) {
+	llmSubtasks, err := llm.BreakGoalIntoSubtasks(ctx, goal, token.AddTokensAndSendUpdate)
 	if err != nil {
 		return nil, err
 	}
@@ -673,31 +941,45 @@ func breakGoalIntoSubtasks(goal string) ([]SubTask, error) {
 	subtasks := make([]SubTask, len(llmSubtasks))
 	for i, subtask := range llmSubtasks {
 		subtasks[i] = SubTask{
-			Id:          subtask.Id,
-			Description: subtask.Description,
+			Id:             subtask.Id,
+			Description:    subtask.Description,
+			Postconditions: decodePlannerPostconditions(subtask),
 		}
 	}
 	return subtasks, nil
 }
 
+// decodePlannerPostconditions decodes postconditions suggested by the planner,
+// dropping them if they are malformed rather than failing the whole plan
+func decodePlannerPostconditions(subtask llm.SubTask) []Postcondition {
+	if len(subtask.Postconditions) == 0 {
+		return nil
+	}
+
+	var conditions []Postcondition
+	if err := json.Unmarshal(subtask.Postconditions, &conditions); err != nil {
+		logger.Warn("ignoring malformed planner postconditions", "subtaskId", subtask.Id, "error", err)
+		return nil
+	}
+	if err := ValidatePostconditions(conditions); err != nil {
+		logger.Warn("ignoring invalid planner postconditions", "subtaskId", subtask.Id, "error", err)
+		return nil
+	}
+	return conditions
+}
+
 func getX11WindowsData() (string, error) {
-	log.Printf("=== GETTING X11 WINDOWS DATA ===")
+	if b := screen.Current(); b != nil {
+		return b.WindowsJSON()
+	}
 
 	// Get display from config - always pass the display value, even if it's the default ":0"
 	display := *config.Display
-	log.Printf("Using display from config: %s", display)
 
 	x11WindowsJSON, err := x11.GetX11WindowsWithDisplay(display)
 	if err != nil {
-		log.Printf("Failed to get X11 windows data: %v", err)
 		return "[]", err
 	}
-
-	// Log the retrieved data for debugging
-	log.Printf("X11 windows data retrieved successfully:")
-	log.Printf("Raw JSON data: %s", x11WindowsJSON)
-
-	log.Printf("=== X11 WINDOWS DATA END ===")
 	return x11WindowsJSON, nil
 }
 
@@ -710,15 +992,92 @@ func dominantColorsToJSONString(colors []imagepkg.ColorCount) string {
 }
 
 func findBoundingBoxes(img image.Image) []imagepkg.BoundingBox {
+	defer metrics.BoundingBoxSeconds.With().ObserveSince(time.Now())
 	return imagepkg.FindBoundingBoxes(img)
 }
 
+// observeTaskOutcome counts a finished task and its wall time by status and
+// ends its span
+func observeTaskOutcome(span *tracing.Span, status string, message string, startedAt time.Time) {
+	metrics.TaskOutcomes.With(status).Inc()
+	metrics.TaskSeconds.With(status).ObserveSince(startedAt)
+
+	span.SetAttributes("task.status", status)
+	if status == "broken" {
+		span.RecordError(errors.New(message))
+	}
+	span.End()
+}
+
+// runAction executes one action of a batch as a traced, timed span; repeat
+// gets the batch it repeats from
+func runAction(ctx context.Context, actions []actionpkg.Action, i int) {
+	a := &actions[i]
+	ctx, span := tracing.Start(ctx, "action."+a.Action,
+		"action.name", a.Action,
+		"action.index", i,
+		"action.description", a.Description,
+	)
+	defer span.End()
+	startedAt := time.Now()
+	defer func() {
+		metrics.ActionSeconds.With(a.Action).ObserveSince(startedAt)
+		historyFromContext(ctx).actionDone(i, time.Since(startedAt), "")
+	}()
+
+	// Operator input waits for the action to finish
+	inputMutex.Lock()
+	defer inputMutex.Unlock()
+
+	if a.Action == "repeat" {
+		a.Execute(ctx, a, &actions)
+		return
+	}
+	a.Execute(ctx, a)
+}
+
+// phase is a step of an iteration, timed in the trajectory and traced as a
+// span. LLM calls and actions of the phase take its ctx to nest under it.
+type phase struct {
+	ctx       context.Context
+	span      *tracing.Span
+	rec       *trajectory.Iteration
+	name      string
+	startedAt time.Time
+}
+
+func beginPhase(ctx context.Context, rec *trajectory.Iteration, name string, attrs ...interface{}) *phase {
+	rec.StartPhase(name)
+	ctx, span := tracing.Start(ctx, name, attrs...)
+	return &phase{ctx: ctx, span: span, rec: rec, name: name, startedAt: time.Now()}
+}
+
+func (p *phase) set(attrs ...interface{}) {
+	p.span.SetAttributes(attrs...)
+}
+
+func (p *phase) end() {
+	p.rec.EndPhase(p.name)
+	historyFromContext(p.ctx).phaseTiming(p.name, time.Since(p.startedAt))
+	p.span.End()
+}
+
+func captureScreenshot() (image.Image, error) {
+	defer metrics.CaptureSeconds.With().ObserveSince(time.Now())
+	return screenshot.CaptureX11Screenshot()
+}
+
+func runOCR(img image.Image) []ocr.TesseractBoundingBox {
+	defer metrics.OCRSeconds.With().ObserveSince(time.Now())
+	return ocr.OCR(img)
+}
+
 func boundingBoxArrayToJSONString(bbArray []imagepkg.BoundingBox) string {
 	return imagepkg.BoundingBoxArrayToJSONString(bbArray)
 }
 
-func sendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrContext string, ocrDelta string, prevExecutedCommands string, iteration int64, prevCursorPosJSONString string, allWindowsJSONString string, x11WindowsData string, colorsDistribution string) ([]actionpkg.Action, string, error) {
-	llmActions, actionsJSONString, err := llm.SendMessageToLLM(ctx, prompt, bboxes, ocrContext, ocrDelta, prevExecutedCommands, iteration, prevCursorPosJSONString, allWindowsJSONString, x11WindowsData, colorsDistribution)
+func sendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrContext string, ocrDelta string, prevExecutedCommands string, iteration int64, prevCursorPosJSONString string, cursorPosJSONString string, allWindowsJSONString string, x11WindowsData string, colorsDistribution string) ([]actionpkg.Action, string, error) {
+	llmActions, actionsJSONString, err := llm.SendMessageToLLM(ctx, prompt, bboxes, ocrContext, ocrDelta, prevExecutedCommands, iteration, prevCursorPosJSONString, cursorPosJSONString, allWindowsJSONString, x11WindowsData, colorsDistribution)
 	if err != nil {
 		return nil, "", err
 	}
@@ -735,6 +1094,7 @@ func sendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrCont
 			InputString:      llmAction.InputString,
 			KeyTapString:     llmAction.KeyTapString,
 			KeyString:        llmAction.KeyString,
+			Keys:             llmAction.Keys,
 			ActionsRange:     llmAction.ActionsRange,
 			RepeatTimes:      llmAction.RepeatTimes,
 			Description:      llmAction.Description,
@@ -744,19 +1104,27 @@ func sendMessageToLLM(ctx context.Context, prompt string, bboxes string, ocrCont
 	return result, actionsJSONString, nil
 }
 
-func getOCRDeltaAbstractDescription(ocrDelta string) (string, error) {
-	return llm.GetOCRDeltaAbstractDescription(ocrDelta, token.AddTokensAndSendUpdate)
+func getOCRDeltaAbstractDescription(ctx context.Context, ocrDelta string) (string, error) {
+	return llm.GetOCRDeltaAbstractDescription(ctx, ocrDelta, token.AddTokensAndSendUpdate)
 }
 
-func isGoalAchieved(goal string, bboxes string, ocrJSONString string, ocrDelta string, ocrDeltaAbstract string, prevActionsJSONString string, iteration int64, prevCursorPositionJSONString string, allWindowsJSONString string, currentCursorPosition string, ocrDataNearTheCursor string, colorsDistributionBeforeAction string, colorsDistribution string) (bool, string, string) {
-	return llm.IsGoalAchieved(goal, bboxes, ocrJSONString, ocrDelta, ocrDeltaAbstract, prevActionsJSONString, iteration, prevCursorPositionJSONString, allWindowsJSONString, currentCursorPosition, ocrDataNearTheCursor, colorsDistributionBeforeAction, colorsDistribution, token.AddTokensAndSendUpdate)
+func isGoalAchieved(ctx context.Context, goal string, bboxes string, ocrJSONString string, ocrDelta string, ocrDeltaAbstract string, prevActionsJSONString string, iteration int64, prevCursorPositionJSONString string, allWindowsJSONString string, currentCursorPosition string, ocrDataNearTheCursor string, colorsDistributionBeforeAction string, colorsDistribution string, postconditionResults string) (bool, string, string) {
+	return llm.IsGoalAchieved(ctx, goal, bboxes, ocrJSONString, ocrDelta, ocrDeltaAbstract, prevActionsJSONString, iteration, prevCursorPositionJSONString, allWindowsJSONString, currentCursorPosition, ocrDataNearTheCursor, colorsDistributionBeforeAction, colorsDistribution, postconditionResults, token.AddTokensAndSendUpdate)
 }
 
-func setExecuteFunction(action *actionpkg.Action) {
-	// Add debugging to see what we're working with
-	log.Printf("setExecuteFunction called for action: %s", action.Action)
-	log.Printf("Coordinates: %+v", action.Coordinates)
+// broadcastCheckResults sends postcondition results to the execution engine view
+func broadcastCheckResults(taskID string, subtaskID int, results []CheckResult) {
+	if len(results) == 0 {
+		return
+	}
+	BroadcastExecutionEngineUpdate("checkUpdate", map[string]interface{}{
+		"taskId":    taskID,
+		"subtaskId": subtaskID,
+		"results":   results,
+	})
+}
 
+func setExecuteFunction(action *actionpkg.Action) {
 	// Set the execute function using the action package
 	actionpkg.SetExecuteFunction(action)
 }
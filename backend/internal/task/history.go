@@ -0,0 +1,533 @@
+package task
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/llm"
+)
+
+// A task's history is what it planned, did and decided, kept in memory for
+// the REST API. The trajectory bundle has the full inputs; this is the
+// summary a script polls. All history methods are safe on a nil *history.
+
+// maxTaskEvents bounds the event log of one task, the oldest are dropped
+const maxTaskEvents = 2000
+
+// TaskEvent is one entry of a task's event log
+type TaskEvent struct {
+	Seq  int         `json:"seq"`
+	Time time.Time   `json:"time"`
+	Type string      `json:"type"` // created, status, plan, subtask, iteration, actions, action, verdict, llmCall, userAssist, pause, operatorControl, userAction, macroMatch, script, scheduled, queue
+	Data interface{} `json:"data,omitempty"`
+}
+
+// TaskSummary is a task as listed by the API
+type TaskSummary struct {
+	ID         string     `json:"id"`
+	Status     string     `json:"status"`
+	Message    string     `json:"message"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// TaskDetail is a task with everything it did so far
+type TaskDetail struct {
+	TaskSummary
+	DurationMs     float64          `json:"durationMs,omitempty"`
+	Postconditions []Postcondition  `json:"postconditions,omitempty"`
+	Verification   string           `json:"verification,omitempty"`
+	Template       string           `json:"template,omitempty"`
+	Schedule       string           `json:"schedule,omitempty"`
+	Priority       int              `json:"priority,omitempty"`
+	RunAfter       []string         `json:"runAfter,omitempty"`
+	Held           bool             `json:"held,omitempty"`
+	Model          string           `json:"model,omitempty"`
+	Budget         *Budget          `json:"budget,omitempty"`
+	Hints          []string         `json:"hints,omitempty"`
+	Subtasks       []SubtaskHistory `json:"subtasks"`
+	Tokens         TokenUsage       `json:"tokens"`
+	LLMCalls       int              `json:"llmCalls"`
+	Events         int              `json:"events"`
+}
+
+// SubtaskHistory is one run of a subtask, or a planned one not started yet
+type SubtaskHistory struct {
+	ID          int                `json:"id"`
+	Description string             `json:"description"`
+	Result      string             `json:"result"` // pending, active, completed, replanned, limit, aborted
+	StartedAt   *time.Time         `json:"startedAt,omitempty"`
+	DurationMs  float64            `json:"durationMs,omitempty"`
+	Iterations  []IterationHistory `json:"iterations"`
+}
+
+// IterationHistory is one act-verify cycle
+type IterationHistory struct {
+	Number     int64              `json:"iteration"`
+	StartedAt  time.Time          `json:"startedAt"`
+	DurationMs float64            `json:"durationMs,omitempty"`
+	TimingsMs  map[string]float64 `json:"timingsMs"`
+	Actions    []ActionHistory    `json:"actions"`
+	Verdict    *Verdict           `json:"verdict,omitempty"`
+}
+
+// ActionHistory is an action the model asked for and how it went
+type ActionHistory struct {
+	actionpkg.Action
+	Executed   bool    `json:"executed"`
+	Skipped    string  `json:"skipped,omitempty"`
+	DurationMs float64 `json:"durationMs,omitempty"`
+}
+
+// Verdict is how an iteration was judged
+type Verdict struct {
+	Completed   bool          `json:"completed"`
+	Description string        `json:"description"`
+	NextPrompt  string        `json:"nextPrompt,omitempty"`
+	Source      string        `json:"source"` // checks or llm
+	Checks      []CheckResult `json:"checks,omitempty"`
+}
+
+// TokenUsage is the estimated prompt tokens of a task's LLM calls
+type TokenUsage struct {
+	Estimated int            `json:"estimated"`
+	ByKind    map[string]int `json:"byKind"`
+}
+
+type history struct {
+	mutex sync.Mutex
+
+	updatedAt  time.Time
+	startedAt  time.Time
+	finishedAt time.Time
+
+	plan     []SubTask // current plan
+	planNext int       // index of the first planned subtask not started
+	runs     []*SubtaskHistory
+
+	tokens   TokenUsage
+	llmCalls int
+
+	events  []TaskEvent
+	nextSeq int
+
+	// changed is closed and replaced on every event, closed is set once the
+	// task will not record anything more
+	changed chan struct{}
+	closed  bool
+}
+
+func newHistory() *history {
+	return &history{
+		updatedAt: time.Now(),
+		tokens:    TokenUsage{ByKind: make(map[string]int)},
+		changed:   make(chan struct{}),
+	}
+}
+
+// record appends an event, the caller holds the mutex
+func (h *history) record(eventType string, data interface{}) {
+	h.nextSeq++
+	h.updatedAt = time.Now()
+	h.events = append(h.events, TaskEvent{Seq: h.nextSeq, Time: h.updatedAt, Type: eventType, Data: data})
+	if len(h.events) > maxTaskEvents {
+		h.events = h.events[len(h.events)-maxTaskEvents:]
+	}
+	close(h.changed)
+	h.changed = make(chan struct{})
+}
+
+func (h *history) event(eventType string, data interface{}) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.record(eventType, data)
+}
+
+// status records a status change and the start and end of the run
+func (h *history) status(status, message string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	switch status {
+	case "in-progress":
+		if h.startedAt.IsZero() {
+			h.startedAt = now
+		}
+	case "completed", "canceled", "broken":
+		if h.finishedAt.IsZero() {
+			h.finishedAt = now
+		}
+	}
+	h.record("status", map[string]string{"status": status, "message": message})
+}
+
+// close marks the end of the run, event streams stop after what is recorded
+func (h *history) close() {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if !h.closed {
+		h.closed = true
+		close(h.changed)
+		h.changed = make(chan struct{})
+	}
+}
+
+// setPlan replaces the plan, next is the index of the first subtask not run yet
+func (h *history) setPlan(subtasks []SubTask, next int) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.plan = append([]SubTask(nil), subtasks...)
+	h.planNext = next
+	h.record("plan", subtasks)
+}
+
+func (h *history) beginSubtask(index int, subtask SubTask) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	now := time.Now()
+	h.planNext = index + 1
+	h.runs = append(h.runs, &SubtaskHistory{
+		ID:          subtask.Id,
+		Description: subtask.Description,
+		Result:      "active",
+		StartedAt:   &now,
+		Iterations:  []IterationHistory{},
+	})
+	h.record("subtask", map[string]interface{}{"id": subtask.Id, "description": subtask.Description, "result": "active"})
+}
+
+func (h *history) endSubtask(result string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	run := h.currentRun()
+	if run == nil || run.Result != "active" {
+		return
+	}
+	h.endIterationLocked()
+	run.Result = result
+	run.DurationMs = msSince(*run.StartedAt)
+	h.record("subtask", map[string]interface{}{"id": run.ID, "description": run.Description, "result": result})
+}
+
+func (h *history) currentRun() *SubtaskHistory {
+	if len(h.runs) == 0 {
+		return nil
+	}
+	return h.runs[len(h.runs)-1]
+}
+
+// currentIteration returns the last iteration of the active subtask, the
+// caller holds the mutex
+func (h *history) currentIteration() *IterationHistory {
+	run := h.currentRun()
+	if run == nil || len(run.Iterations) == 0 {
+		return nil
+	}
+	return &run.Iterations[len(run.Iterations)-1]
+}
+
+func (h *history) beginIteration(number int64) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	run := h.currentRun()
+	if run == nil {
+		return
+	}
+	h.endIterationLocked()
+	run.Iterations = append(run.Iterations, IterationHistory{
+		Number:    number,
+		StartedAt: time.Now(),
+		TimingsMs: make(map[string]float64),
+		Actions:   []ActionHistory{},
+	})
+	h.record("iteration", map[string]interface{}{"iteration": number, "subtaskId": run.ID})
+}
+
+func (h *history) endIterationLocked() {
+	if it := h.currentIteration(); it != nil && it.DurationMs == 0 {
+		it.DurationMs = msSince(it.StartedAt)
+	}
+}
+
+// phaseTiming adds the duration of an iteration phase, repeated phases add up
+func (h *history) phaseTiming(name string, d time.Duration) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if it := h.currentIteration(); it != nil {
+		it.TimingsMs[name] += float64(d.Microseconds()) / 1000
+	}
+}
+
+func (h *history) setActions(actions []actionpkg.Action) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	it := h.currentIteration()
+	if it == nil {
+		return
+	}
+	it.Actions = make([]ActionHistory, len(actions))
+	for i, a := range actions {
+		a.Execute = nil
+		it.Actions[i] = ActionHistory{Action: a}
+	}
+	h.record("actions", actions)
+}
+
+// actionDone records that action i of the iteration ran, or why it did not
+func (h *history) actionDone(i int, d time.Duration, skipped string) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	it := h.currentIteration()
+	if it == nil || i >= len(it.Actions) {
+		return
+	}
+	a := &it.Actions[i]
+	a.Executed = skipped == ""
+	a.Skipped = skipped
+	a.DurationMs = float64(d.Microseconds()) / 1000
+	h.record("action", map[string]interface{}{"index": i, "action": a.Action.Action, "executed": a.Executed, "skipped": skipped, "durationMs": a.DurationMs})
+}
+
+func (h *history) verdict(v Verdict) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	if it := h.currentIteration(); it != nil {
+		it.Verdict = &v
+		h.endIterationLocked()
+	}
+	h.record("verdict", v)
+}
+
+// estimatedTokens returns the estimated prompt tokens of the task so far
+func (h *history) estimatedTokens() int {
+	if h == nil {
+		return 0
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.tokens.Estimated
+}
+
+// observeExchange counts an LLM call and its tokens, it is the task's
+// llm.ExchangeObserver next to the trajectory recorder
+func (h *history) observeExchange(ex llm.Exchange) {
+	if h == nil {
+		return
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.llmCalls++
+	h.tokens.Estimated += ex.EstimatedTokens
+	h.tokens.ByKind[ex.Kind] += ex.EstimatedTokens
+	data := map[string]interface{}{
+		"kind":            ex.Kind,
+		"durationMs":      float64(ex.Duration.Microseconds()) / 1000,
+		"estimatedTokens": ex.EstimatedTokens,
+	}
+	if ex.Error != "" {
+		data["error"] = ex.Error
+	}
+	h.record("llmCall", data)
+}
+
+// summary builds the API view of a task, the caller holds taskMutex
+func (h *history) summary(t *Task) TaskSummary {
+	s := TaskSummary{ID: t.ID, Status: t.Status, Message: t.Message, CreatedAt: t.CreatedAt, UpdatedAt: t.CreatedAt}
+	if h == nil {
+		return s
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	s.UpdatedAt = h.updatedAt
+	if !h.startedAt.IsZero() {
+		started := h.startedAt
+		s.StartedAt = &started
+	}
+	if !h.finishedAt.IsZero() {
+		finished := h.finishedAt
+		s.FinishedAt = &finished
+	}
+	return s
+}
+
+// detail builds the full API view of a task, the caller holds taskMutex
+func (h *history) detail(t *Task) TaskDetail {
+	d := TaskDetail{
+		TaskSummary:    h.summary(t),
+		Postconditions: t.Postconditions,
+		Verification:   t.Verification,
+		Template:       t.Template,
+		Schedule:       t.ScheduleID,
+		Priority:       t.Priority,
+		RunAfter:       t.RunAfter,
+		Held:           t.Held,
+		Model:          t.Model,
+		Hints:          t.Hints,
+		Subtasks:       []SubtaskHistory{},
+		Tokens:         TokenUsage{ByKind: map[string]int{}},
+	}
+	if t.Budget != (Budget{}) {
+		budget := t.Budget
+		d.Budget = &budget
+	}
+	if h == nil {
+		return d
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if d.StartedAt != nil {
+		end := time.Now()
+		if d.FinishedAt != nil {
+			end = *d.FinishedAt
+		}
+		d.DurationMs = float64(end.Sub(*d.StartedAt).Microseconds()) / 1000
+	}
+	for _, run := range h.runs {
+		copied := *run
+		copied.Iterations = make([]IterationHistory, len(run.Iterations))
+		for i, it := range run.Iterations {
+			it.TimingsMs = copyTimings(it.TimingsMs)
+			it.Actions = append([]ActionHistory(nil), it.Actions...)
+			copied.Iterations[i] = it
+		}
+		d.Subtasks = append(d.Subtasks, copied)
+	}
+	if h.planNext < len(h.plan) {
+		for _, planned := range h.plan[h.planNext:] {
+			d.Subtasks = append(d.Subtasks, SubtaskHistory{ID: planned.Id, Description: planned.Description, Result: "pending", Iterations: []IterationHistory{}})
+		}
+	}
+	d.Tokens.Estimated = h.tokens.Estimated
+	for kind, n := range h.tokens.ByKind {
+		d.Tokens.ByKind[kind] = n
+	}
+	d.LLMCalls = h.llmCalls
+	d.Events = h.nextSeq
+	return d
+}
+
+// eventsSince returns the events after seq, a channel closed when more are
+// recorded, and whether the task will record nothing more
+func (h *history) eventsSince(seq int) ([]TaskEvent, <-chan struct{}, bool) {
+	if h == nil {
+		return nil, nil, true
+	}
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	i := sort.Search(len(h.events), func(i int) bool { return h.events[i].Seq > seq })
+	return append([]TaskEvent(nil), h.events[i:]...), h.changed, h.closed
+}
+
+func copyTimings(m map[string]float64) map[string]float64 {
+	copied := make(map[string]float64, len(m))
+	for k, v := range m {
+		copied[k] = v
+	}
+	return copied
+}
+
+func msSince(t time.Time) float64 {
+	return float64(time.Since(t).Microseconds()) / 1000
+}
+
+type historyKey struct{}
+
+// withHistory lets phases deep in the iteration find the task's history
+func withHistory(ctx context.Context, h *history) context.Context {
+	return context.WithValue(ctx, historyKey{}, h)
+}
+
+func historyFromContext(ctx context.Context) *history {
+	h, _ := ctx.Value(historyKey{}).(*history)
+	return h
+}
+
+// ListTasks returns the tasks with status (any if empty) updated after
+// since (any if zero), oldest first
+func ListTasks(status string, since time.Time) []TaskSummary {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+
+	list := make([]TaskSummary, 0, len(tasks))
+	for _, t := range tasks {
+		if status != "" && t.Status != status {
+			continue
+		}
+		s := t.history.summary(t)
+		if !since.IsZero() && !s.UpdatedAt.After(since) {
+			continue
+		}
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list
+}
+
+// GetTaskDetail returns a task with its subtasks, actions, verdicts, timings and tokens
+func GetTaskDetail(taskID string) (TaskDetail, bool) {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+
+	t, exists := tasks[taskID]
+	if !exists {
+		return TaskDetail{}, false
+	}
+	return t.history.detail(t), true
+}
+
+// GetTaskEvents returns the task's events after seq, a channel closed when
+// more are recorded and whether the task has finished recording
+func GetTaskEvents(taskID string, seq int) (events []TaskEvent, changed <-chan struct{}, finished bool, ok bool) {
+	taskMutex.Lock()
+	t, exists := tasks[taskID]
+	taskMutex.Unlock()
+	if !exists {
+		return nil, nil, false, false
+	}
+	events, changed, finished = t.history.eventsSince(seq)
+	return events, changed, finished, true
+}
@@ -0,0 +1,72 @@
+package task
+
+import (
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/config"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/macro"
+	"useless-agent/internal/ocr"
+)
+
+// subtaskTrace collects what a subtask did, to keep it as a macro once it
+// is verified complete. Only the actions after the last "not done" verdict
+// are kept, the ones before did not complete it; nor is a subtask an
+// operator helped with.
+type subtaskTrace struct {
+	goal     string
+	screen   []string // words on the screen it started from
+	actions  []actionpkg.Action
+	replayed []string // macros run by the current iteration
+	inputs   int      // operator inputs when it started
+}
+
+func newSubtaskTrace(goal string) *subtaskTrace {
+	return &subtaskTrace{goal: goal, inputs: operatorInputCount()}
+}
+
+// examples finds past subtasks like this one on the current screen, to show
+// the model; the first screen seen is the one the subtask started from
+func (t *subtaskTrace) examples(ocrResults []ocr.TesseractBoundingBox) []llm.Example {
+	words := macro.Words(ocrResults)
+	if t.screen == nil {
+		t.screen = words
+	}
+	if *config.MacroExamples <= 0 {
+		return nil
+	}
+	var examples []llm.Example
+	for _, m := range macro.Find(t.goal, words, *config.MacroExamples) {
+		examples = append(examples, llm.Example{ID: m.ID, Goal: m.Goal, Actions: m.Actions})
+	}
+	return examples
+}
+
+// executed records that action i of batch ran
+func (t *subtaskTrace) executed(batch []actionpkg.Action, i int) {
+	if batch[i].Action == "runMacro" {
+		t.replayed = append(t.replayed, batch[i].Name)
+	}
+	t.actions = append(t.actions, macro.Executed(batch, i)...)
+}
+
+// verdict counts the outcome of the macros the iteration replayed; a
+// subtask not done yet starts its sequence over
+func (t *subtaskTrace) verdict(completed bool) {
+	for _, id := range t.replayed {
+		macro.ReplayOutcome(id, completed)
+	}
+	t.replayed = nil
+	if !completed {
+		t.actions = nil
+	}
+}
+
+// completed keeps the subtask as a macro
+func (t *subtaskTrace) completed(taskID string, iterations int) {
+	t.verdict(true)
+	if operatorInputCount() != t.inputs {
+		logger.Info("not keeping a macro of a subtask the operator helped with", "subtask", t.goal)
+		return
+	}
+	macro.Record(t.goal, t.screen, t.actions, iterations, taskID)
+}
@@ -0,0 +1,50 @@
+package task
+
+import (
+	"reflect"
+	"testing"
+
+	actionpkg "useless-agent/internal/action"
+)
+
+func TestSubtaskTraceKeepsActionsAfterLastFailedVerdict(t *testing.T) {
+	batch := func(names ...string) []actionpkg.Action {
+		actions := make([]actionpkg.Action, len(names))
+		for i, name := range names {
+			actions[i] = actionpkg.Action{Action: "printString", InputString: name}
+		}
+		return actions
+	}
+	run := func(trace *subtaskTrace, actions []actionpkg.Action) {
+		for i := range actions {
+			trace.executed(actions, i)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		batches [][]actionpkg.Action // each but the last ends with a "not done" verdict
+		want    []actionpkg.Action
+	}{
+		{"first batch did it", [][]actionpkg.Action{batch("a", "b")}, batch("a", "b")},
+		{"failed attempts are dropped", [][]actionpkg.Action{batch("a"), batch("b", "c"), batch("d")}, batch("d")},
+		{"repeat is expanded", [][]actionpkg.Action{batch("x"), {
+			{Action: "printString", InputString: "y"},
+			{Action: "repeat", ActionsRange: []int{1, 1}, RepeatTimes: 2},
+		}}, batch("y", "y", "y")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			trace := &subtaskTrace{goal: "type"}
+			for i, b := range tt.batches {
+				run(trace, b)
+				if i < len(tt.batches)-1 {
+					trace.verdict(false)
+				}
+			}
+			if !reflect.DeepEqual(trace.actions, tt.want) {
+				t.Errorf("kept %+v, want %+v", trace.actions, tt.want)
+			}
+		})
+	}
+}
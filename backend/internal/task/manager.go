@@ -3,14 +3,24 @@ package task
 import (
 	"context"
 	"fmt"
-	"log"
+	"slices"
 	"sync"
 	"time"
 
 	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
 	"useless-agent/internal/websocket"
 )
 
+var logger = logging.For("task")
+
+func init() {
+	metrics.NewGaugeFunc("agent_task_queue_length", "Tasks waiting in the queue.", func() float64 {
+		return float64(GetQueueLength())
+	})
+}
+
 // Task management globals
 var (
 	tasks         = make(map[string]*Task)
@@ -20,7 +30,7 @@ var (
 
 // Queue management globals
 var (
-	taskQueue   = make([]*Task, 0) // Simple queue of tasks
+	taskQueue   = make([]*Task, 0) // Queued tasks in order, see queue.go
 	queueMutex  sync.RWMutex
 	runningTask *Task // Currently running task
 	queueBusy   bool  // Flag to prevent concurrent queue processing
@@ -32,8 +42,25 @@ var (
 	userAssistMutex    sync.RWMutex
 )
 
+// TaskOptions are the settings of a new task besides its goal, set before
+// the task is published so nothing sees it half made
+type TaskOptions struct {
+	Postconditions []Postcondition
+	Verification   string
+	Script         *Script
+	ScriptParams   map[string]string
+	Template       string
+	Model          string
+	Hints          []string
+	Budget         Budget
+	ScheduleID     string
+	Priority       int
+	RunAfter       []string
+	Held           bool
+}
+
 // CreateTask creates a new task
-func CreateTask(message string) *Task {
+func CreateTask(message string, opts TaskOptions) *Task {
 	taskMutex.Lock()
 	defer taskMutex.Unlock()
 
@@ -50,7 +77,23 @@ func CreateTask(message string) *Task {
 		CreatedAt:  time.Now(),
 		Context:    ctx,
 		CancelFunc: cancelFunc,
+
+		Postconditions: opts.Postconditions,
+		Verification:   opts.Verification,
+		Script:         opts.Script,
+		ScriptParams:   opts.ScriptParams,
+		Template:       opts.Template,
+		Model:          opts.Model,
+		Hints:          opts.Hints,
+		Budget:         opts.Budget,
+		ScheduleID:     opts.ScheduleID,
+		Priority:       opts.Priority,
+		RunAfter:       opts.RunAfter,
+		Held:           opts.Held,
+
+		history: newHistory(),
 	}
+	task.history.event("created", map[string]string{"message": message})
 
 	tasks[taskID] = task
 
@@ -74,6 +117,7 @@ func UpdateTaskStatus(taskID, status, message string) {
 		if message != "" {
 			task.Message = message
 		}
+		task.history.status(status, message)
 		SendTaskUpdate(task)
 
 		// CRITICAL FIX: Send execution engine update for status changes
@@ -108,6 +152,7 @@ func CancelTask(taskID string) bool {
 			}
 
 			task.Status = "canceled"
+			task.history.status("canceled", "Task canceled by user")
 			SendTaskUpdate(task)
 
 			// CRITICAL FIX: Send execution engine update for task completion
@@ -130,6 +175,13 @@ func CancelTask(taskID string) bool {
 					break
 				}
 			}
+			// Tasks that run after it can not run anymore
+			for _, queuedTask := range taskQueue {
+				if slices.Contains(queuedTask.RunAfter, taskID) {
+					kick()
+					break
+				}
+			}
 			queueMutex.Unlock()
 
 			// Also cancel the context
@@ -138,7 +190,11 @@ func CancelTask(taskID string) bool {
 			}
 
 			task.Status = "canceled"
+			task.history.status("canceled", "Task canceled by user")
+			task.history.close() // never reaches ExecuteTask
+			clearPause(taskID)
 			SendTaskUpdate(task)
+			metrics.TaskOutcomes.With("canceled").Inc()
 
 			// CRITICAL FIX: Send execution engine update for queued task cancellation
 			// This ensures execution engine squares are updated when queued task is canceled
@@ -154,35 +210,70 @@ func CancelTask(taskID string) bool {
 	return false
 }
 
-// EnqueueTask adds a task to the queue
+// CancelAll cancels the running task and every queued one, with message
+// as their final status message, and returns how many were canceled
+func CancelAll(message string) int {
+	queueMutex.RLock()
+	var ids []string
+	if runningTask != nil {
+		ids = append(ids, runningTask.ID)
+	}
+	for _, queuedTask := range taskQueue {
+		ids = append(ids, queuedTask.ID)
+	}
+	queueMutex.RUnlock()
+
+	canceled := 0
+	for _, id := range ids {
+		if CancelTask(id) {
+			UpdateTaskStatus(id, "canceled", message)
+			canceled++
+		}
+	}
+	return canceled
+}
+
+// WaitIdle waits until no task is running, or ctx is done
+func WaitIdle(ctx context.Context) error {
+	ticker := time.NewTicker(50 * time.Millisecond)
+	defer ticker.Stop()
+	for IsTaskRunning() {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// EnqueueTask adds a task to the queue, behind the tasks of the same or a
+// higher priority
 func EnqueueTask(task *Task) {
 	queueMutex.Lock()
 	defer queueMutex.Unlock()
 
 	// Add task to the global queue
-	taskQueue = append(taskQueue, task)
-	log.Printf("Task %s enqueued (queue length: %d)", task.ID, len(taskQueue))
+	insertQueued(task)
+	logger.Info("task enqueued", "taskId", task.ID, "priority", task.Priority, "queueLength", len(taskQueue))
 
 	// Start processing if no task is currently running
-	if runningTask == nil && !queueBusy {
-		go ProcessNextTask()
-	}
+	kick()
 }
 
-// DequeueNextTask gets the next task from the queue
+// DequeueNextTask takes the next task that can run from the queue
 func DequeueNextTask() *Task {
+	statuses := taskStatuses()
 	queueMutex.Lock()
 	defer queueMutex.Unlock()
 
-	if len(taskQueue) == 0 {
+	_, next := planQueue(statuses)
+	if next < 0 {
 		return nil
 	}
+	task := removeQueued(next)
 
-	// Get the first task (FIFO)
-	task := taskQueue[0]
-	taskQueue = taskQueue[1:]
-
-	log.Printf("Task %s dequeued (remaining: %d)", task.ID, len(taskQueue))
+	logger.Info("task dequeued", "taskId", task.ID, "queueLength", len(taskQueue))
 	return task
 }
 
@@ -202,13 +293,13 @@ func IsTaskRunning() bool {
 
 // ProcessNextTask processes the next task in the queue
 func ProcessNextTask() {
-	log.Printf("=== PROCESS NEXT TASK ===")
-
+	cancelBlockedTasks()
+	statuses := taskStatuses()
 	queueMutex.Lock()
 
 	// Prevent concurrent queue processing
 	if queueBusy {
-		log.Printf("Queue already busy, exiting")
+		logger.Debug("queue already busy")
 		queueMutex.Unlock()
 		return
 	}
@@ -218,7 +309,7 @@ func ProcessNextTask() {
 
 	// Check if there's already a task running
 	if runningTask != nil {
-		log.Printf("Task %s already running", runningTask.ID)
+		logger.Debug("task already running", "taskId", runningTask.ID)
 		queueBusy = false
 		queueMutex.Unlock()
 		return
@@ -227,22 +318,28 @@ func ProcessNextTask() {
 	// Get the next task from queue (inline to avoid deadlock)
 	var task *Task
 	if len(taskQueue) == 0 {
-		log.Printf("No tasks in queue")
+		logger.Debug("no tasks in queue")
 		queueBusy = false
 		queueMutex.Unlock()
 		return
 	}
 
-	// Get the first task (FIFO)
-	task = taskQueue[0]
-	taskQueue = taskQueue[1:]
-	log.Printf("Task %s dequeued (remaining: %d)", task.ID, len(taskQueue))
+	// Get the first task that can run, see queue.go
+	entries, next := planQueue(statuses)
+	if next < 0 {
+		logger.Info("no queued task can run yet", "queueLength", len(taskQueue))
+		queueBusy = false
+		queueMutex.Unlock()
+		return
+	}
+	task = removeQueued(next)
+	lastDecision = &SchedulingDecision{Time: time.Now(), TaskID: task.ID, Reason: entries[next].Reason, Passed: entries[:next]}
+	task.history.event("queue", map[string]interface{}{"started": lastDecision.Reason})
+	logger.Info("starting task", "taskId", task.ID, "reason", lastDecision.Reason, "queueLength", len(taskQueue))
 
 	// Mark this task as running
 	runningTask = task
 
-	log.Printf("Starting task %s", task.ID)
-
 	// Update task status to in-progress
 	UpdateTaskStatus(task.ID, "in-progress", task.Message)
 
@@ -253,16 +350,14 @@ func ProcessNextTask() {
 
 	// Execute the task - launch goroutine outside of mutex lock
 	go func() {
-		log.Printf("=== GOROUTINE STARTED for task %s ===", taskRef.ID)
 		defer func() {
-			log.Printf("=== GOROUTINE ENDING for task %s ===", taskRef.ID)
 			// Clear the running task when done
 			queueMutex.Lock()
 			runningTask = nil
 			queueBusy = false
 			queueMutex.Unlock()
 
-			log.Printf("Task %s completed, processing next task", taskRef.ID)
+			logger.Debug("task finished, processing next task", "taskId", taskRef.ID)
 
 			// CRITICAL FIX: Add small delay before processing next task
 			// This prevents race condition where completion events interfere with next task status
@@ -274,8 +369,6 @@ func ProcessNextTask() {
 
 		ExecuteTask(taskRef)
 	}()
-
-	log.Printf("=== PROCESS NEXT TASK COMPLETED ===")
 }
 
 // CreateContext creates a new context with cancellation
@@ -299,18 +392,18 @@ func AddUserAssistMessage(taskID, message string) bool {
 	taskMutex.Unlock()
 
 	if !exists {
-		log.Printf("Task %s not found, cannot add user-assist message", taskID)
+		logger.Warn("task not found, cannot add user-assist message", "taskId", taskID)
 		return false
 	}
 
 	if task.Status != "in-progress" {
-		log.Printf("Task %s is not in progress (status: %s), ignoring user-assist message", taskID, task.Status)
+		logger.Warn("task is not in progress, ignoring user-assist message", "taskId", taskID, "status", task.Status)
 		return false
 	}
 
 	// Check if there's already a non-injected user-assist message for this task
 	if existingMsg, exists := userAssistMessages[taskID]; exists && !existingMsg.Injected {
-		log.Printf("Task %s already has a pending user-assist message, replacing it", taskID)
+		logger.Info("replacing pending user-assist message", "taskId", taskID)
 	}
 
 	// Add or update the user-assist message
@@ -321,7 +414,7 @@ func AddUserAssistMessage(taskID, message string) bool {
 		Injected:  false,
 	}
 
-	log.Printf("Added user-assist message for task %s: %s", taskID, message)
+	logger.Info("added user-assist message", "taskId", taskID, "message", message)
 	return true
 }
 
@@ -337,22 +430,48 @@ func GetUserAssistMessage(taskID string) *UserAssistMessage {
 
 	// Mark as injected
 	msg.Injected = true
-	log.Printf("Retrieved and marked user-assist message as injected for task %s: %s", taskID, msg.Message)
+	logger.Debug("user-assist message injected", "taskId", taskID)
 
 	return msg
 }
 
+// HasPendingUserAssistMessage reports whether a user-assist message is waiting to be injected
+func HasPendingUserAssistMessage(taskID string) bool {
+	userAssistMutex.RLock()
+	defer userAssistMutex.RUnlock()
+
+	msg, exists := userAssistMessages[taskID]
+	return exists && !msg.Injected
+}
+
 // CleanupUserAssistMessages removes user-assist messages for completed/canceled tasks
 func CleanupUserAssistMessages(taskID string) {
 	userAssistMutex.Lock()
 	defer userAssistMutex.Unlock()
 
 	delete(userAssistMessages, taskID)
-	log.Printf("Cleaned up user-assist messages for task %s", taskID)
+	logger.Debug("cleaned up user-assist messages", "taskId", taskID)
 }
 
-// GetExecutionState returns the current execution engine state
+// GetExecutionState returns the current execution engine state, with the
+// queue in order and the reasons of the scheduling decisions
 func GetExecutionState() *ExecutionState {
+	// Read the queue before locking the tasks for the rest
+	statuses := taskStatuses()
+	queueMutex.RLock()
+	var runningTaskID string
+	if runningTask != nil {
+		runningTaskID = runningTask.ID
+	}
+	queue, _ := planQueue(statuses)
+	decision := lastDecision
+	queueMutex.RUnlock()
+
+	var queuedTasks []string
+	for _, entry := range queue {
+		queuedTasks = append(queuedTasks, entry.TaskID)
+	}
+
 	taskMutex.Lock()
 	defer taskMutex.Unlock()
 
@@ -364,27 +483,16 @@ func GetExecutionState() *ExecutionState {
 		i++
 	}
 
-	// Get selected and running tasks
+	// Get selected task
 	var selectedTask string
-	var runningTaskID string
-	var queuedTasks []string
-
-	queueMutex.RLock()
-	if runningTask != nil {
-		runningTaskID = runningTask.ID
-	}
-
-	// Add queued tasks to execution state
-	for _, queuedTask := range taskQueue {
-		queuedTasks = append(queuedTasks, queuedTask.ID)
-	}
-	queueMutex.RUnlock()
 
 	return &ExecutionState{
 		Tasks:        tasks,
 		SelectedTask: selectedTask,
 		RunningTask:  runningTaskID,
 		QueuedTasks:  queuedTasks,
+		Queue:        queue,
+		LastDecision: decision,
 	}
 }
 
@@ -412,6 +520,7 @@ func UpdateAction(taskID string, subtaskID int, actionIndex int, action actionpk
 		"inputString":      action.InputString,
 		"keyTapString":     action.KeyTapString,
 		"keyString":        action.KeyString,
+		"keys":             action.Keys,
 		"actionsRange":     action.ActionsRange,
 		"repeatTimes":      action.RepeatTimes,
 		"parameters":       action.Parameters,
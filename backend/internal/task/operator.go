@@ -0,0 +1,225 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/config"
+)
+
+// An operator takes control of the keyboard and mouse with their first
+// remote input, or explicitly with TakeControl. While they have it the agent
+// waits before its next action: the action in flight finishes first, and
+// inputs wait for it. Control ends with ReleaseControl or after
+// -operator-idle-timeout without input. A batch planned on a screen the
+// operator has since changed is dropped, the next iteration looks again.
+
+// OperatorControl describes who has control of the input devices
+type OperatorControl struct {
+	Principal string    `json:"principal"`
+	TaskID    string    `json:"taskId,omitempty"` // the task running when control was taken
+	Since     time.Time `json:"since"`
+	LastInput time.Time `json:"lastInput"`
+	Inputs    int       `json:"inputs"`
+}
+
+// ErrControlHeld means another operator has control
+var ErrControlHeld = errors.New("another operator has control")
+
+var (
+	controlMutex    sync.Mutex
+	control         *OperatorControl
+	controlReleased chan struct{} // closed when control is released
+	controlTimer    *time.Timer
+	operatorInputs  int // inputs applied since start, see operatorInputCount
+)
+
+// inputObservers get every batch of applied operator input
+var inputObservers []func(principal string, inputs []actionpkg.Input)
+
+// inputMutex is held while an agent action or an operator input runs, so
+// the two never interleave
+var inputMutex sync.Mutex
+
+// OnOperatorInput registers fn to get the operator inputs applied by
+// OperatorInput, such as the skill recorder. Register before serving.
+func OnOperatorInput(fn func(principal string, inputs []actionpkg.Input)) {
+	inputObservers = append(inputObservers, fn)
+}
+
+// CurrentControl returns who has control, false if nobody
+func CurrentControl() (OperatorControl, bool) {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	if control == nil {
+		return OperatorControl{}, false
+	}
+	return *control, true
+}
+
+// TakeControl gives principal control, or extends it if they have it
+func TakeControl(principal string) (OperatorControl, error) {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	if control != nil {
+		if control.Principal != principal {
+			return *control, fmt.Errorf("%w: %s", ErrControlHeld, control.Principal)
+		}
+		controlTimer.Reset(*config.OperatorIdleTimeout)
+		return *control, nil
+	}
+
+	now := time.Now()
+	control = &OperatorControl{Principal: principal, Since: now, LastInput: now}
+	controlReleased = make(chan struct{})
+	queueMutex.RLock()
+	running := runningTask
+	queueMutex.RUnlock()
+	if running != nil {
+		control.TaskID = running.ID
+	}
+	owned := control
+	controlTimer = time.AfterFunc(*config.OperatorIdleTimeout, func() {
+		controlMutex.Lock()
+		expired := control == owned
+		controlMutex.Unlock()
+		if expired {
+			logger.Info("operator control timed out", "principal", principal)
+			releaseControl(principal, "idle")
+		}
+	})
+
+	logger.Info("operator took control", "principal", principal, "taskId", control.TaskID)
+	broadcastControl(running, *control, true, "")
+	return *control, nil
+}
+
+// ReleaseControl gives control back to the agent, false if principal did
+// not have it
+func ReleaseControl(principal string) (bool, error) {
+	return releaseControl(principal, "released")
+}
+
+func releaseControl(principal, reason string) (bool, error) {
+	controlMutex.Lock()
+	if control == nil {
+		controlMutex.Unlock()
+		return false, nil
+	}
+	if control.Principal != principal {
+		holder := control.Principal
+		controlMutex.Unlock()
+		return false, fmt.Errorf("%w: %s", ErrControlHeld, holder)
+	}
+	released := *control
+	control = nil
+	controlTimer.Stop()
+	close(controlReleased)
+	controlMutex.Unlock()
+
+	// Nothing the operator held down outlives their control
+	inputMutex.Lock()
+	actionpkg.ReleaseOperatorInputs()
+	inputMutex.Unlock()
+
+	logger.Info("operator released control", "principal", principal, "reason", reason, "inputs", released.Inputs)
+	task, _ := GetTask(released.TaskID)
+	broadcastControl(task, released, false, reason)
+	return true, nil
+}
+
+// OperatorInput validates and applies inputs of principal, taking control
+// first, and records them in the history of the running task. Inputs after
+// one that fails are not applied.
+func OperatorInput(principal string, inputs []actionpkg.Input) (OperatorControl, error) {
+	for i, in := range inputs {
+		if err := actionpkg.ValidateInput(in); err != nil {
+			return OperatorControl{}, fmt.Errorf("input %d: %w", i, err)
+		}
+	}
+	if _, err := TakeControl(principal); err != nil {
+		return OperatorControl{}, err
+	}
+
+	inputMutex.Lock()
+	applied := 0
+	var err error
+	for i, in := range inputs {
+		if err = actionpkg.ApplyInput(in); err != nil {
+			err = fmt.Errorf("input %d: %w", i, err)
+			break
+		}
+		applied++
+	}
+	inputMutex.Unlock()
+
+	controlMutex.Lock()
+	var current OperatorControl
+	if control != nil && control.Principal == principal {
+		control.Inputs += applied
+		control.LastInput = time.Now()
+		current = *control
+	}
+	operatorInputs += applied
+	controlMutex.Unlock()
+
+	if applied > 0 {
+		for _, observe := range inputObservers {
+			observe(principal, inputs[:applied])
+		}
+		queueMutex.RLock()
+		running := runningTask
+		queueMutex.RUnlock()
+		if running != nil {
+			running.history.event("userAction", map[string]interface{}{
+				"principal": principal,
+				"inputs":    inputs[:applied],
+			})
+		}
+	}
+	return current, err
+}
+
+// operatorInputCount counts operator inputs, a batch is stale once it changed
+func operatorInputCount() int {
+	controlMutex.Lock()
+	defer controlMutex.Unlock()
+	return operatorInputs
+}
+
+// waitForOperator blocks while an operator has control and the task is not
+// canceled
+func waitForOperator(ctx context.Context, task *Task) {
+	controlMutex.Lock()
+	c, released := control, controlReleased
+	controlMutex.Unlock()
+	if c == nil {
+		return
+	}
+
+	logger.InfoContext(ctx, "waiting for the operator to release control", "principal", c.Principal)
+	select {
+	case <-released:
+	case <-task.Context.Done():
+	}
+}
+
+func broadcastControl(task *Task, c OperatorControl, active bool, reason string) {
+	data := map[string]interface{}{
+		"taskId":    c.TaskID,
+		"principal": c.Principal,
+		"active":    active,
+	}
+	if !active {
+		data["inputs"] = c.Inputs
+		data["reason"] = reason
+	}
+	if task != nil {
+		task.history.event("operatorControl", data)
+	}
+	BroadcastExecutionEngineUpdate("operatorControl", data)
+}
@@ -0,0 +1,103 @@
+package task
+
+import (
+	"context"
+	"sync"
+)
+
+// A paused task finishes the action it is running and then waits, before
+// its next action or iteration, until it is resumed or canceled. A queued
+// task can be paused too, it then waits as soon as it starts.
+
+var (
+	pauseMutex sync.Mutex
+	pauses     = make(map[string]chan struct{}) // closed on resume
+)
+
+// PauseTask pauses a queued or running task, false if there is no such
+// task or it has finished
+func PauseTask(taskID string) bool {
+	// A task finishes under taskMutex and then clears its pause, so holding
+	// it from the check to the insert never leaves a finished task paused
+	taskMutex.Lock()
+	if !isActive(taskID) {
+		taskMutex.Unlock()
+		return false
+	}
+	pauseMutex.Lock()
+	_, already := pauses[taskID]
+	if !already {
+		pauses[taskID] = make(chan struct{})
+	}
+	pauseMutex.Unlock()
+	taskMutex.Unlock()
+
+	if !already {
+		logger.Info("task paused", "taskId", taskID)
+		broadcastPause(taskID, true)
+	}
+	return true
+}
+
+// ResumeTask lets a paused task continue, false if it was not paused
+func ResumeTask(taskID string) bool {
+	pauseMutex.Lock()
+	resume, paused := pauses[taskID]
+	delete(pauses, taskID)
+	pauseMutex.Unlock()
+
+	if !paused {
+		return false
+	}
+	close(resume)
+	logger.Info("task resumed", "taskId", taskID)
+	broadcastPause(taskID, false)
+	return true
+}
+
+// IsTaskPaused reports whether a task is paused
+func IsTaskPaused(taskID string) bool {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	_, paused := pauses[taskID]
+	return paused
+}
+
+// waitIfPaused blocks while the task is paused and not canceled
+func waitIfPaused(ctx context.Context, task *Task) {
+	pauseMutex.Lock()
+	resume, paused := pauses[task.ID]
+	pauseMutex.Unlock()
+	if !paused {
+		return
+	}
+
+	logger.InfoContext(ctx, "waiting for the task to be resumed")
+	select {
+	case <-resume:
+	case <-task.Context.Done():
+	}
+}
+
+// clearPause forgets the pause of a finished task
+func clearPause(taskID string) {
+	pauseMutex.Lock()
+	defer pauseMutex.Unlock()
+	delete(pauses, taskID)
+}
+
+// isActive reports whether a task is queued or running, with taskMutex held
+func isActive(taskID string) bool {
+	task, exists := tasks[taskID]
+	return exists && (task.Status == "in-the-queue" || task.Status == "in-progress")
+}
+
+func broadcastPause(taskID string, paused bool) {
+	if task, ok := GetTask(taskID); ok {
+		task.history.event("pause", map[string]interface{}{"paused": paused})
+	}
+	BroadcastExecutionEngineUpdate("pauseUpdate", map[string]interface{}{
+		"taskId": taskID,
+		"paused": paused,
+	})
+}
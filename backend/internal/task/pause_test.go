@@ -0,0 +1,32 @@
+package task
+
+import "testing"
+
+func TestPauseTask(t *testing.T) {
+	tests := []struct {
+		status string
+		want   bool
+	}{
+		{"in-the-queue", true},
+		{"in-progress", true},
+		{"completed", false},
+		{"broken", false},
+		{"canceled", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.status, func(t *testing.T) {
+			isolateQueue(t)
+			tasks["task-1"] = &Task{ID: "task-1", Status: tt.status, history: newHistory()}
+			defer clearPause("task-1")
+			if got := PauseTask("task-1"); got != tt.want {
+				t.Errorf("PauseTask = %v, want %v", got, tt.want)
+			}
+			if IsTaskPaused("task-1") != tt.want {
+				t.Errorf("paused = %v, want %v", !tt.want, tt.want)
+			}
+		})
+	}
+	if PauseTask("no-such-task") || IsTaskPaused("no-such-task") {
+		t.Error("paused a task that does not exist")
+	}
+}
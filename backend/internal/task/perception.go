@@ -0,0 +1,102 @@
+package task
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"internal/vision"
+	imagepkg "useless-agent/internal/image"
+	"useless-agent/internal/ocr"
+	"useless-agent/internal/screenshot"
+	"useless-agent/internal/trajectory"
+)
+
+// Perception is what the agent extracts from one screenshot
+type Perception struct {
+	Screenshot          image.Image
+	Grayscale           *image.Gray
+	OCR                 []ocr.TesseractBoundingBox
+	OCRJSON             string
+	DetectedWindowsJSON string
+	BoundingBoxesJSON   string
+	ColorsDistribution  string
+}
+
+// Perceive runs the perception pipeline of the agent loop on a screenshot.
+// It needs no X server, so recorded screenshots can be analysed offline.
+func Perceive(ctx context.Context, img image.Image, rec *trajectory.Iteration) (*Perception, error) {
+	p := &Perception{Screenshot: img}
+	p.ColorsDistribution = imagepkg.DominantColorsToJSONString(imagepkg.DominantColors(img, 10))
+	p.Grayscale = screenshot.ConvertToGrayscale(img)
+
+	phase := beginPhase(ctx, rec, "ocr")
+	p.OCR = runOCR(p.Grayscale)
+	phase.set("ocr.elements", len(p.OCR))
+	phase.end()
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	phase = beginPhase(ctx, rec, "windowDetection")
+	detected, err := detectWindows(ctx, p.Grayscale, p.OCR)
+	phase.end()
+	if err != nil {
+		return nil, err
+	}
+	p.DetectedWindowsJSON = detected
+
+	p.OCRJSON = ocrPromptJSON(p.OCR)
+	p.BoundingBoxesJSON = boundingBoxArrayToJSONString(findBoundingBoxes(img))
+	return p, nil
+}
+
+// detectWindows looks for a window header around every OCR element and
+// returns the detected windows as a JSON array
+func detectWindows(ctx context.Context, grayscaleScreenshot *image.Gray, ocrResults []ocr.TesseractBoundingBox) (string, error) {
+	var detectedWindowsJSON string = "["
+	for index, ocrElement := range ocrResults {
+		if err := ctx.Err(); err != nil {
+			return "", err
+		}
+
+		ocrElementBB := image.Rect(ocrElement.BoundingBox.XMin, ocrElement.BoundingBox.YMin, ocrElement.BoundingBox.XMax, ocrElement.BoundingBox.YMax)
+		windowsJSONString, err := vision.DetectWindow(grayscaleScreenshot, ocrElementBB, ocrElement.Text)
+		if err != nil {
+			logger.DebugContext(ctx, "failed to detect window", "text", ocrElement.Text, "error", err)
+			continue
+		}
+		detectedWindowsJSON += windowsJSONString
+		if index != (len(ocrResults) - 1) {
+			detectedWindowsJSON += ","
+		}
+	}
+	detectedWindowsJSON += "]"
+	return detectedWindowsJSON, nil
+}
+
+// ocrPromptJSON encodes OCR results for the prompt, merging close words when
+// the raw result is too long
+func ocrPromptJSON(ocrResults []ocr.TesseractBoundingBox) string {
+	ocrResultsJSON := ocr.OCRtoJSONString(ocrResults)
+	if len(ocrResultsJSON) > 10000 {
+		ocrDataMerged := ocr.MergeCloseText(ocrResults, 20, 40)
+		ocrResultsJSON = ocr.OCRtoJSONString(ocrDataMerged)
+	}
+	return ocrResultsJSON
+}
+
+// ocrNearCursor recognizes the text in a full-width band 23 pixels above
+// and below the cursor
+func ocrNearCursor(grayscaleScreenshot *image.Gray, cursorY int) string {
+	rect := image.Rect(0, max(0, cursorY-23), grayscaleScreenshot.Bounds().Max.X, min(cursorY+23, grayscaleScreenshot.Bounds().Max.Y))
+	imgUnderCursor := image.NewGray(rect)
+	draw.Draw(imgUnderCursor, imgUnderCursor.Bounds(), grayscaleScreenshot, rect.Min, draw.Src)
+	ocrDataNearTheCursor := ocr.OCRtoJSONString(ocr.OCR(imgUnderCursor))
+	return ocrDataNearTheCursor
+}
+
+// OCRNearCursor recognizes the text around the given cursor row of the screenshot
+func (p *Perception) OCRNearCursor(cursorY int) string {
+	return ocrNearCursor(p.Grayscale, cursorY)
+}
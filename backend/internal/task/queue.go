@@ -0,0 +1,230 @@
+package task
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// The queue runs its tasks in order, the first that can run next: a held
+// task waits for an operator to release it, a task with RunAfter for those
+// tasks to complete. A task is queued behind the ones of the same or a
+// higher priority; an operator can move it anywhere after that.
+
+// ErrNotQueued means the task is not waiting in the queue
+var ErrNotQueued = errors.New("task is not in the queue")
+
+// QueueEntry is a queued task and why it runs when it does
+type QueueEntry struct {
+	TaskID   string   `json:"taskId"`
+	Position int      `json:"position"` // 1 is the first
+	Priority int      `json:"priority"`
+	Held     bool     `json:"held,omitempty"`
+	RunAfter []string `json:"runAfter,omitempty"`
+	Reason   string   `json:"reason"`
+}
+
+// SchedulingDecision is why the queue started a task
+type SchedulingDecision struct {
+	Time   time.Time    `json:"time"`
+	TaskID string       `json:"taskId"`
+	Reason string       `json:"reason"`
+	Passed []QueueEntry `json:"passed,omitempty"` // the tasks ahead of it, which could not run
+}
+
+// QueueUpdate changes a queued task; nil fields are left as they are
+type QueueUpdate struct {
+	Priority *int  `json:"priority,omitempty"` // requeues the task behind those of the same or a higher priority
+	Position *int  `json:"position,omitempty"` // moves the task, 1 is the first
+	Held     *bool `json:"held,omitempty"`
+}
+
+// lastDecision is the last task the queue started, guarded by queueMutex
+var lastDecision *SchedulingDecision
+
+// ValidateRunAfter checks the tasks a new task runs after: they exist and
+// have not failed
+func ValidateRunAfter(ids []string) error {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+	for _, id := range ids {
+		t, ok := tasks[id]
+		if !ok {
+			return fmt.Errorf("runAfter: no task %s", id)
+		}
+		if t.Status == "canceled" || t.Status == "broken" {
+			return fmt.Errorf("runAfter: %s is %s, the task would never run", id, t.Status)
+		}
+	}
+	return nil
+}
+
+// taskStatuses returns the status of every task, taken before queueMutex
+// so that the queue does not lock the tasks
+func taskStatuses() map[string]string {
+	taskMutex.Lock()
+	defer taskMutex.Unlock()
+	statuses := make(map[string]string, len(tasks))
+	for id, t := range tasks {
+		statuses[id] = t.Status
+	}
+	return statuses
+}
+
+// waitingFor returns why t can not run yet, empty if it can; blocked means
+// it never will, a task it runs after failed
+func waitingFor(t *Task, statuses map[string]string) (reason string, blocked bool) {
+	for _, id := range t.RunAfter {
+		switch status := statuses[id]; status {
+		case "completed":
+		case "canceled", "broken":
+			return fmt.Sprintf("%s, which it runs after, is %s", id, status), true
+		case "":
+			return fmt.Sprintf("%s, which it runs after, is gone", id), true
+		default:
+			return fmt.Sprintf("waiting for %s (%s)", id, status), false
+		}
+	}
+	if t.Held {
+		return "held by an operator", false
+	}
+	return "", false
+}
+
+// planQueue returns the queue with the reasons of its order, and the index
+// of the task to run next, -1 if none can; queueMutex held
+func planQueue(statuses map[string]string) (entries []QueueEntry, next int) {
+	next = -1
+	for i, t := range taskQueue {
+		entry := QueueEntry{TaskID: t.ID, Position: i + 1, Priority: t.Priority, Held: t.Held, RunAfter: t.RunAfter}
+		reason, _ := waitingFor(t, statuses)
+		switch {
+		case reason != "":
+			entry.Reason = reason
+		case next >= 0:
+			entry.Reason = "behind " + taskQueue[next].ID
+		default:
+			next = i
+			entry.Reason = "first in the queue"
+			if i > 0 {
+				entry.Reason = fmt.Sprintf("first in the queue that can run, the %d ahead are held or waiting", i)
+			}
+			if t.Priority != 0 {
+				entry.Reason += fmt.Sprintf(", priority %d", t.Priority)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries, next
+}
+
+// insertQueued queues t behind the tasks of the same or a higher priority;
+// queueMutex held
+func insertQueued(t *Task) {
+	i := len(taskQueue)
+	for j, queued := range taskQueue {
+		if queued.Priority < t.Priority {
+			i = j
+			break
+		}
+	}
+	insertAt(t, i)
+}
+
+func insertAt(t *Task, i int) {
+	taskQueue = append(taskQueue, nil)
+	copy(taskQueue[i+1:], taskQueue[i:])
+	taskQueue[i] = t
+}
+
+// removeQueued takes a task out of the queue; queueMutex held
+func removeQueued(i int) *Task {
+	t := taskQueue[i]
+	taskQueue = append(taskQueue[:i], taskQueue[i+1:]...)
+	return t
+}
+
+// kick starts the next task if none is running; queueMutex held
+func kick() {
+	if runningTask == nil && !queueBusy {
+		go ProcessNextTask()
+	}
+}
+
+// GetQueue returns the queued tasks in order, each with why it is there
+func GetQueue() []QueueEntry {
+	statuses := taskStatuses()
+	queueMutex.RLock()
+	defer queueMutex.RUnlock()
+	entries, _ := planQueue(statuses)
+	return entries
+}
+
+// UpdateQueuedTask changes the priority, position or hold of a queued task
+// and returns its place in the queue
+func UpdateQueuedTask(taskID string, update QueueUpdate) (QueueEntry, error) {
+	if update.Position != nil && *update.Position < 1 {
+		return QueueEntry{}, errors.New("position starts at 1")
+	}
+	statuses := taskStatuses()
+	queueMutex.Lock()
+	defer queueMutex.Unlock()
+
+	i := -1
+	for j, t := range taskQueue {
+		if t.ID == taskID {
+			i = j
+			break
+		}
+	}
+	if i < 0 {
+		return QueueEntry{}, ErrNotQueued
+	}
+	t := taskQueue[i]
+	if update.Priority != nil && *update.Priority != t.Priority {
+		t.Priority = *update.Priority
+		if update.Position == nil {
+			insertQueued(removeQueued(i))
+		}
+	}
+	if update.Position != nil {
+		removeQueued(i)
+		insertAt(t, min(*update.Position, len(taskQueue)+1)-1)
+	}
+	if update.Held != nil {
+		t.Held = *update.Held
+	}
+
+	entries, _ := planQueue(statuses)
+	var entry QueueEntry
+	for _, e := range entries {
+		if e.TaskID == taskID {
+			entry = e
+		}
+	}
+	t.history.event("queue", map[string]interface{}{"position": entry.Position, "priority": t.Priority, "held": t.Held})
+	logger.Info("queued task updated", "taskId", taskID, "position", entry.Position, "priority", t.Priority, "held", t.Held)
+	kick()
+	return entry, nil
+}
+
+// cancelBlockedTasks cancels the queued tasks that run after a task that
+// failed, so they do not wait forever
+func cancelBlockedTasks() {
+	statuses := taskStatuses()
+	queueMutex.RLock()
+	blocked := make(map[string]string)
+	for _, t := range taskQueue {
+		if reason, ok := waitingFor(t, statuses); ok {
+			blocked[t.ID] = reason
+		}
+	}
+	queueMutex.RUnlock()
+
+	for id, reason := range blocked {
+		if CancelTask(id) {
+			logger.Info("canceled queued task", "taskId", id, "reason", reason)
+			UpdateTaskStatus(id, "canceled", "Task canceled, "+reason)
+		}
+	}
+}
@@ -0,0 +1,195 @@
+package task
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"useless-agent/internal/config"
+)
+
+// isolateQueue gives a test empty tasks, queue and schedules, and keeps the
+// queue from starting the tasks it is given
+func isolateQueue(t *testing.T) {
+	t.Helper()
+	prevTasks, prevQueue, prevRunning, prevBusy := tasks, taskQueue, runningTask, queueBusy
+	prevSchedules, prevRunner, prevFile := schedules, scheduleRunner, *config.SchedulesFile
+	tasks, taskQueue, runningTask, queueBusy = make(map[string]*Task), nil, nil, true
+	schedules, scheduleRunner, *config.SchedulesFile = make(map[string]*Schedule), nil, ""
+	t.Cleanup(func() {
+		tasks, taskQueue, runningTask, queueBusy = prevTasks, prevQueue, prevRunning, prevBusy
+		schedules, scheduleRunner, *config.SchedulesFile = prevSchedules, prevRunner, prevFile
+	})
+}
+
+// queued is a task in a test queue
+func queued(id string, priority int) *Task {
+	return &Task{ID: id, Status: "in-the-queue", Priority: priority, history: newHistory()}
+}
+
+func queueIDs() []string {
+	ids := make([]string, len(taskQueue))
+	for i, t := range taskQueue {
+		ids[i] = t.ID
+	}
+	return ids
+}
+
+func TestInsertQueued(t *testing.T) {
+	tests := []struct {
+		name       string
+		priorities []int
+		want       []string
+	}{
+		{"same priority in order", []int{0, 0, 0}, []string{"t0", "t1", "t2"}},
+		{"higher priority first", []int{0, 5, 1}, []string{"t1", "t2", "t0"}},
+		{"behind the same priority", []int{5, 0, 5}, []string{"t0", "t2", "t1"}},
+		{"negative priority last", []int{-1, 0, -1}, []string{"t1", "t0", "t2"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateQueue(t)
+			for i, p := range tt.priorities {
+				insertQueued(queued("t"+string(rune('0'+i)), p))
+			}
+			if got := queueIDs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queue %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlanQueue(t *testing.T) {
+	held := func(t *Task) *Task { t.Held = true; return t }
+	after := func(t *Task, ids ...string) *Task { t.RunAfter = ids; return t }
+	tests := []struct {
+		name     string
+		queue    []*Task
+		statuses map[string]string
+		next     int
+		reasons  []string
+	}{
+		{"empty", nil, nil, -1, nil},
+		{
+			name:    "first runs",
+			queue:   []*Task{queued("a", 0), queued("b", 0)},
+			next:    0,
+			reasons: []string{"first in the queue", "behind a"},
+		},
+		{
+			name:    "priority is told",
+			queue:   []*Task{queued("a", 3)},
+			next:    0,
+			reasons: []string{"first in the queue, priority 3"},
+		},
+		{
+			name:    "held task is passed",
+			queue:   []*Task{held(queued("a", 0)), queued("b", 0)},
+			next:    1,
+			reasons: []string{"held by an operator", "first in the queue that can run, the 1 ahead are held or waiting"},
+		},
+		{
+			name:     "waiting for a running task",
+			queue:    []*Task{after(queued("a", 0), "x"), queued("b", 0)},
+			statuses: map[string]string{"x": "in-progress"},
+			next:     1,
+			reasons:  []string{"waiting for x (in-progress)", "first in the queue that can run, the 1 ahead are held or waiting"},
+		},
+		{
+			name:     "runs after a completed task",
+			queue:    []*Task{after(queued("a", 0), "x", "y")},
+			statuses: map[string]string{"x": "completed", "y": "completed"},
+			next:     0,
+			reasons:  []string{"first in the queue"},
+		},
+		{
+			name:     "blocked by a failed or missing task",
+			queue:    []*Task{after(queued("a", 0), "x"), after(queued("b", 0), "gone")},
+			statuses: map[string]string{"x": "broken"},
+			next:     -1,
+			reasons:  []string{"x, which it runs after, is broken", "gone, which it runs after, is gone"},
+		},
+		{
+			name:     "held and waiting tells what it waits for first",
+			queue:    []*Task{held(after(queued("a", 0), "x"))},
+			statuses: map[string]string{"x": "in-the-queue"},
+			next:     -1,
+			reasons:  []string{"waiting for x (in-the-queue)"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateQueue(t)
+			taskQueue = tt.queue
+			entries, next := planQueue(tt.statuses)
+			if next != tt.next {
+				t.Errorf("next = %d, want %d", next, tt.next)
+			}
+			var reasons []string
+			for i, e := range entries {
+				if e.Position != i+1 || e.TaskID != tt.queue[i].ID {
+					t.Errorf("entry %d is %s at %d", i, e.TaskID, e.Position)
+				}
+				reasons = append(reasons, e.Reason)
+			}
+			if !reflect.DeepEqual(reasons, tt.reasons) {
+				t.Errorf("reasons %q, want %q", reasons, tt.reasons)
+			}
+		})
+	}
+
+	blocked := after(queued("a", 0), "x")
+	if reason, ok := waitingFor(blocked, map[string]string{"x": "canceled"}); !ok || reason == "" {
+		t.Errorf("waitingFor a canceled task = %q, %v, want blocked", reason, ok)
+	}
+}
+
+func TestUpdateQueuedTask(t *testing.T) {
+	intp := func(v int) *int { return &v }
+	boolp := func(v bool) *bool { return &v }
+	tests := []struct {
+		name    string
+		taskID  string
+		update  QueueUpdate
+		want    []string
+		wantPos int
+		wantErr error
+	}{
+		{"raise priority", "c", QueueUpdate{Priority: intp(5)}, []string{"a", "c", "b", "d"}, 2, nil},
+		{"same priority keeps the place", "c", QueueUpdate{Priority: intp(0)}, []string{"a", "b", "c", "d"}, 3, nil},
+		{"lower priority", "a", QueueUpdate{Priority: intp(-1)}, []string{"b", "c", "d", "a"}, 4, nil},
+		{"move to the front", "d", QueueUpdate{Position: intp(1)}, []string{"d", "a", "b", "c"}, 1, nil},
+		{"move past the end", "a", QueueUpdate{Position: intp(10)}, []string{"b", "c", "d", "a"}, 4, nil},
+		{"position wins over priority", "d", QueueUpdate{Priority: intp(-3), Position: intp(2)}, []string{"a", "d", "b", "c"}, 2, nil},
+		{"hold", "b", QueueUpdate{Held: boolp(true)}, []string{"a", "b", "c", "d"}, 2, nil},
+		{"position 0", "b", QueueUpdate{Position: intp(0)}, []string{"a", "b", "c", "d"}, 0, errors.New("position starts at 1")},
+		{"not queued", "x", QueueUpdate{Held: boolp(true)}, []string{"a", "b", "c", "d"}, 0, ErrNotQueued},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			isolateQueue(t)
+			// a has priority 5, the others 0
+			for _, qt := range []*Task{queued("a", 5), queued("b", 0), queued("c", 0), queued("d", 0)} {
+				tasks[qt.ID] = qt
+				insertQueued(qt)
+			}
+			entry, err := UpdateQueuedTask(tt.taskID, tt.update)
+			if tt.wantErr != nil {
+				if err == nil || err.Error() != tt.wantErr.Error() {
+					t.Fatalf("error = %v, want %v", err, tt.wantErr)
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+			if got := queueIDs(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("queue %v, want %v", got, tt.want)
+			}
+			if entry.Position != tt.wantPos {
+				t.Errorf("position %d, want %d", entry.Position, tt.wantPos)
+			}
+			if tt.wantErr == nil && tt.update.Held != nil && entry.Held != *tt.update.Held {
+				t.Errorf("held %v, want %v", entry.Held, *tt.update.Held)
+			}
+		})
+	}
+}
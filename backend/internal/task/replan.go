@@ -0,0 +1,65 @@
+package task
+
+import (
+	"context"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/token"
+)
+
+// replanRemaining asks the planner for a new plan replacing subtasks[index:].
+// New subtasks get IDs after the highest existing one so the UI keeps the
+// history of abandoned steps.
+func replanRemaining(ctx context.Context, taskID string, goal string, subtasks []SubTask, index int, reason string, ocrJSONString string, x11WindowsData string) ([]SubTask, error) {
+	completed := make([]llm.SubTask, 0, index)
+	for _, s := range subtasks[:index] {
+		completed = append(completed, llm.SubTask{Id: s.Id, Description: s.Description})
+	}
+	remaining := make([]llm.SubTask, 0, len(subtasks)-index)
+	for _, s := range subtasks[index:] {
+		remaining = append(remaining, llm.SubTask{Id: s.Id, Description: s.Description})
+	}
+
+	llmSubtasks, err := llm.ReplanSubtasks(ctx, goal, completed, remaining, reason, ocrJSONString, x11WindowsData, token.AddTokensAndSendUpdate)
+	if err != nil {
+		return nil, err
+	}
+
+	nextID := 0
+	for _, s := range subtasks {
+		if s.Id > nextID {
+			nextID = s.Id
+		}
+	}
+
+	newPlan := make([]SubTask, len(llmSubtasks))
+	for i, s := range llmSubtasks {
+		nextID++
+		newPlan[i] = SubTask{
+			Id:             nextID,
+			Description:    s.Description,
+			Postconditions: decodePlannerPostconditions(s),
+		}
+	}
+
+	revised := append(append([]SubTask{}, subtasks[:index]...), newPlan...)
+
+	// Show the new steps in the UI and tell the execution engine the plan changed
+	for _, s := range newPlan {
+		UpdateSubtask(taskID, s.Id, s.Description, false, []actionpkg.Action{})
+	}
+	replaced := make([]int, 0, len(subtasks)-index)
+	for _, s := range subtasks[index:] {
+		replaced = append(replaced, s.Id)
+	}
+	BroadcastExecutionEngineUpdate("planUpdate", map[string]interface{}{
+		"taskId":            taskID,
+		"reason":            reason,
+		"replacedSubtasks":  replaced,
+		"remainingSubtasks": newPlan,
+	})
+
+	logger.InfoContext(ctx, "replanned task", "reason", reason, "replacedSubtasks", replaced, "newSubtasks", len(newPlan))
+	return revised, nil
+}
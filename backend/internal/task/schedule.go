@@ -0,0 +1,435 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"useless-agent/internal/config"
+)
+
+// Missed run policies: what a schedule does about a run that was due while
+// the server was down, or too busy to fire it on time
+const (
+	MissedRunsSkip    = "skip"    // default: wait for the next run
+	MissedRunsRunOnce = "runOnce" // run once now, however many were missed
+)
+
+// missedRunGrace is how late a run may fire before it counts as missed
+const missedRunGrace = time.Minute
+
+// ErrScheduleNotFound means there is no schedule with the ID
+var ErrScheduleNotFound = errors.New("schedule not found")
+
+// Schedule queues a task on a cron expression, or once at a set time.
+// Request is the body of POST /api/v2/tasks, a goal, script or template;
+// it is rendered again on every run so a template edit applies to the
+// next one.
+type Schedule struct {
+	ID            string          `json:"id"`
+	Name          string          `json:"name,omitempty"`
+	Cron          string          `json:"cron,omitempty"`     // five fields or @hourly, @daily, @weekly, @monthly, @yearly
+	At            *time.Time      `json:"at,omitempty"`       // a single run instead of Cron
+	Timezone      string          `json:"timezone,omitempty"` // IANA zone of Cron, the server's by default
+	Request       json.RawMessage `json:"request"`
+	Enabled       bool            `json:"enabled"`
+	MissedRuns    string          `json:"missedRuns,omitempty"`    // skip (default) or runOnce
+	SkipIfRunning bool            `json:"skipIfRunning,omitempty"` // don't queue a run while the last one is queued or running
+
+	CreatedAt  time.Time  `json:"createdAt"`
+	UpdatedAt  time.Time  `json:"updatedAt"`
+	NextRun    *time.Time `json:"nextRun,omitempty"`
+	LastRun    *time.Time `json:"lastRun,omitempty"`
+	LastTaskID string     `json:"lastTaskId,omitempty"`
+	LastResult string     `json:"lastResult,omitempty"` // queued, missed, skipped: ..., failed: ...
+	Runs       int        `json:"runs"`                 // tasks queued so far
+}
+
+var (
+	schedules     = make(map[string]*Schedule)
+	scheduleMutex sync.Mutex
+
+	// scheduleRunner turns the request of a schedule into a new task of
+	// the schedule, not queued yet, see SetScheduleRunner
+	scheduleRunner func(scheduleID string, request json.RawMessage) (*Task, error)
+
+	schedulerStop chan struct{}
+	schedulerDone chan struct{}
+)
+
+// SetScheduleRunner sets how a schedule's request becomes a task: the HTTP
+// layer validates it and renders its template like POST /api/v2/tasks, and
+// creates the task with its ScheduleID set. Set it before StartScheduler.
+func SetScheduleRunner(fn func(scheduleID string, request json.RawMessage) (*Task, error)) {
+	scheduleRunner = fn
+}
+
+// validate checks a schedule's timing, not its request
+func (s *Schedule) validate() error {
+	if (s.Cron == "") == (s.At == nil) {
+		return errors.New("a schedule needs either cron or at")
+	}
+	if s.Cron != "" {
+		if _, err := parseCron(s.Cron); err != nil {
+			return err
+		}
+	}
+	if _, err := s.location(); err != nil {
+		return err
+	}
+	switch s.MissedRuns {
+	case "", MissedRunsSkip, MissedRunsRunOnce:
+	default:
+		return fmt.Errorf(`missedRuns must be %q or %q`, MissedRunsSkip, MissedRunsRunOnce)
+	}
+	if len(s.Request) == 0 {
+		return errors.New("request is required")
+	}
+	return nil
+}
+
+func (s *Schedule) location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, fmt.Errorf("unknown timezone %q", s.Timezone)
+	}
+	return loc, nil
+}
+
+// plan sets the next run after t; a one-off that has run has none
+func (s *Schedule) plan(t time.Time) {
+	s.NextRun = nil
+	if !s.Enabled {
+		return
+	}
+	if s.At != nil {
+		if s.LastRun == nil {
+			at := *s.At
+			s.NextRun = &at
+		}
+		return
+	}
+	c, err := parseCron(s.Cron)
+	if err != nil {
+		return
+	}
+	loc, err := s.location()
+	if err != nil {
+		return
+	}
+	if next := c.next(t.In(loc)); !next.IsZero() {
+		s.NextRun = &next
+	}
+}
+
+// ListSchedules returns the schedules, oldest first
+func ListSchedules() []Schedule {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	list := make([]Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		list = append(list, *s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	return list
+}
+
+// GetSchedule returns a schedule
+func GetSchedule(id string) (Schedule, bool) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	s, ok := schedules[id]
+	if !ok {
+		return Schedule{}, false
+	}
+	return *s, true
+}
+
+// CreateSchedule adds a schedule; the caller has checked its request
+func CreateSchedule(s Schedule) (Schedule, error) {
+	if err := s.validate(); err != nil {
+		return Schedule{}, err
+	}
+	now := time.Now()
+	if s.At != nil && s.At.Before(now) {
+		return Schedule{}, errors.New("at is in the past")
+	}
+	s.ID = "schedule-" + strconv.FormatInt(now.UnixNano(), 36)
+	s.CreatedAt, s.UpdatedAt = now, now
+	s.NextRun, s.LastRun, s.LastTaskID, s.LastResult, s.Runs = nil, nil, "", "", 0
+	s.plan(now)
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	schedules[s.ID] = &s
+	saveSchedules()
+	logger.Info("schedule created", "scheduleId", s.ID, "name", s.Name, "nextRun", s.NextRun)
+	return s, nil
+}
+
+// UpdateSchedule replaces the definition of a schedule, keeping its ID
+// and run history; a one-off given a new time runs again
+func UpdateSchedule(id string, update Schedule) (Schedule, error) {
+	if err := update.validate(); err != nil {
+		return Schedule{}, err
+	}
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	s, ok := schedules[id]
+	if !ok {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	now := time.Now()
+	if update.At != nil && (s.At == nil || !update.At.Equal(*s.At)) {
+		if update.At.Before(now) {
+			return Schedule{}, errors.New("at is in the past")
+		}
+		s.LastRun = nil
+	}
+	s.Name, s.Cron, s.At, s.Timezone = update.Name, update.Cron, update.At, update.Timezone
+	s.Request, s.Enabled, s.MissedRuns, s.SkipIfRunning = update.Request, update.Enabled, update.MissedRuns, update.SkipIfRunning
+	s.UpdatedAt = now
+	s.plan(now)
+	saveSchedules()
+	logger.Info("schedule updated", "scheduleId", id, "nextRun", s.NextRun)
+	return *s, nil
+}
+
+// SetScheduleEnabled enables or disables a schedule; enabled again, it
+// runs next from now on, not for the runs it missed
+func SetScheduleEnabled(id string, enabled bool) (Schedule, error) {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	s, ok := schedules[id]
+	if !ok {
+		return Schedule{}, ErrScheduleNotFound
+	}
+	if s.Enabled != enabled {
+		s.Enabled = enabled
+		s.UpdatedAt = time.Now()
+		s.plan(s.UpdatedAt)
+		saveSchedules()
+		logger.Info("schedule toggled", "scheduleId", id, "enabled", enabled, "nextRun", s.NextRun)
+	}
+	return *s, nil
+}
+
+// DeleteSchedule removes a schedule; tasks it queued are left alone
+func DeleteSchedule(id string) error {
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	if _, ok := schedules[id]; !ok {
+		return ErrScheduleNotFound
+	}
+	delete(schedules, id)
+	saveSchedules()
+	logger.Info("schedule deleted", "scheduleId", id)
+	return nil
+}
+
+// loadSchedules reads -schedules-file, keeping the next runs as saved so
+// the ones that passed while the server was down count as missed
+func loadSchedules() error {
+	if *config.SchedulesFile == "" {
+		return nil
+	}
+	data, err := os.ReadFile(*config.SchedulesFile)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []*Schedule
+	if err := json.Unmarshal(data, &list); err != nil {
+		return fmt.Errorf("%s: %w", *config.SchedulesFile, err)
+	}
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	for _, s := range list {
+		if err := s.validate(); err != nil || s.ID == "" {
+			logger.Warn("skipping invalid schedule", "scheduleId", s.ID, "error", err)
+			continue
+		}
+		if s.Enabled && s.NextRun == nil {
+			s.plan(time.Now())
+		}
+		schedules[s.ID] = s
+	}
+	logger.Info("loaded schedules", "count", len(schedules))
+	return nil
+}
+
+// saveSchedules writes -schedules-file, with scheduleMutex held
+func saveSchedules() {
+	if *config.SchedulesFile == "" {
+		return
+	}
+	list := make([]*Schedule, 0, len(schedules))
+	for _, s := range schedules {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].CreatedAt.Before(list[j].CreatedAt) })
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		logger.Warn("failed to encode schedules", "error", err)
+		return
+	}
+	if dir := filepath.Dir(*config.SchedulesFile); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			logger.Warn("failed to save schedules", "error", err)
+			return
+		}
+	}
+	tmp := *config.SchedulesFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err == nil {
+		err = os.Rename(tmp, *config.SchedulesFile)
+	}
+	if err != nil {
+		logger.Warn("failed to save schedules", "file", *config.SchedulesFile, "error", err)
+	}
+}
+
+// StartScheduler loads the saved schedules and runs them until
+// StopScheduler
+func StartScheduler() error {
+	if err := loadSchedules(); err != nil {
+		return fmt.Errorf("failed to load schedules: %w", err)
+	}
+	schedulerStop = make(chan struct{})
+	schedulerDone = make(chan struct{})
+	go func() {
+		defer close(schedulerDone)
+		ticker := time.NewTicker(time.Second)
+		defer ticker.Stop()
+		for {
+			runDueSchedules(time.Now())
+			select {
+			case <-schedulerStop:
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return nil
+}
+
+// StopScheduler stops queueing scheduled tasks, before the server cancels
+// the queued ones on shutdown
+func StopScheduler() {
+	if schedulerStop == nil {
+		return
+	}
+	close(schedulerStop)
+	<-schedulerDone
+	schedulerStop = nil
+}
+
+// scheduledRun is a due run of a schedule, fired without scheduleMutex
+// held since queueing a task takes the task and queue locks
+type scheduledRun struct {
+	scheduleID    string
+	name          string
+	request       json.RawMessage
+	skipIfRunning bool
+	lastTaskID    string
+
+	taskID string
+	result string
+}
+
+// runDueSchedules fires the schedules whose next run has come
+func runDueSchedules(now time.Time) {
+	var due []*scheduledRun
+	scheduleMutex.Lock()
+	changed := false
+	for _, s := range schedules {
+		if !s.Enabled || s.NextRun == nil || s.NextRun.After(now) {
+			continue
+		}
+		if now.Sub(*s.NextRun) > missedRunGrace && s.MissedRuns != MissedRunsRunOnce {
+			logger.Warn("schedule missed a run", "scheduleId", s.ID, "due", *s.NextRun)
+			s.LastResult = "missed"
+		} else {
+			last := now
+			s.LastRun = &last
+			due = append(due, &scheduledRun{
+				scheduleID:    s.ID,
+				name:          s.Name,
+				request:       s.Request,
+				skipIfRunning: s.SkipIfRunning,
+				lastTaskID:    s.LastTaskID,
+			})
+		}
+		if s.At != nil {
+			// A one-off has run, or missed its only run
+			last := now
+			s.LastRun = &last
+			s.Enabled = false
+		}
+		s.plan(now)
+		changed = true
+	}
+	if changed && len(due) == 0 {
+		saveSchedules()
+	}
+	scheduleMutex.Unlock()
+	if len(due) == 0 {
+		return
+	}
+
+	for _, run := range due {
+		run.fire()
+	}
+
+	scheduleMutex.Lock()
+	defer scheduleMutex.Unlock()
+	for _, run := range due {
+		// The schedule may have been deleted while its task was queued
+		s, ok := schedules[run.scheduleID]
+		if !ok {
+			continue
+		}
+		s.LastResult = run.result
+		if run.taskID != "" {
+			s.LastTaskID = run.taskID
+			s.Runs++
+		}
+	}
+	saveSchedules()
+}
+
+// fire queues the task of a due run
+func (run *scheduledRun) fire() {
+	if run.skipIfRunning && run.lastTaskID != "" {
+		if t, ok := GetTask(run.lastTaskID); ok && (t.Status == "in-the-queue" || t.Status == "in-progress") {
+			logger.Info("schedule skipped, its last task has not finished", "scheduleId", run.scheduleID, "taskId", t.ID)
+			run.result = "skipped: " + t.ID + " is " + t.Status
+			return
+		}
+	}
+	if scheduleRunner == nil {
+		run.result = "failed: scheduled tasks are not supported"
+		return
+	}
+	t, err := scheduleRunner(run.scheduleID, run.request)
+	if err != nil {
+		logger.Warn("scheduled task failed", "scheduleId", run.scheduleID, "error", err)
+		run.result = "failed: " + err.Error()
+		return
+	}
+	t.history.event("scheduled", map[string]string{"scheduleId": run.scheduleID, "name": run.name})
+	EnqueueTask(t)
+	run.taskID = t.ID
+	run.result = "queued"
+	logger.Info("scheduled task queued", "scheduleId", run.scheduleID, "taskId", t.ID)
+}
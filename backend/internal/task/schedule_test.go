@@ -0,0 +1,75 @@
+package task
+
+import (
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRunDueSchedules(t *testing.T) {
+	isolateQueue(t)
+	now := time.Now()
+	due := now.Add(-time.Second)
+	missed := now.Add(-time.Hour)
+	later := now.Add(time.Hour)
+	schedules = map[string]*Schedule{
+		"due":     {ID: "due", Cron: "* * * * *", Enabled: true, NextRun: &due, Request: json.RawMessage(`"run"`)},
+		"missed":  {ID: "missed", Cron: "* * * * *", Enabled: true, NextRun: &missed, Request: json.RawMessage(`"run"`)},
+		"catchUp": {ID: "catchUp", Cron: "* * * * *", Enabled: true, NextRun: &missed, MissedRuns: MissedRunsRunOnce, Request: json.RawMessage(`"run"`)},
+		"later":   {ID: "later", Cron: "* * * * *", Enabled: true, NextRun: &later, Request: json.RawMessage(`"run"`)},
+		"failing": {ID: "failing", At: &due, Enabled: true, NextRun: &due, Request: json.RawMessage(`"fail"`)},
+	}
+	SetScheduleRunner(func(scheduleID string, request json.RawMessage) (*Task, error) {
+		// The runner may use the schedules, they are not locked while it runs
+		if _, ok := GetSchedule(scheduleID); !ok {
+			t.Errorf("runner called for unknown schedule %s", scheduleID)
+		}
+		if string(request) == `"fail"` {
+			return nil, errors.New("template is gone")
+		}
+		return CreateTask("scheduled goal", TaskOptions{ScheduleID: scheduleID}), nil
+	})
+
+	done := make(chan struct{})
+	go func() {
+		runDueSchedules(now)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("runDueSchedules did not return")
+	}
+
+	for id, want := range map[string]string{"due": "queued", "missed": "missed", "catchUp": "queued", "later": "", "failing": "failed: template is gone"} {
+		s, _ := GetSchedule(id)
+		if s.LastResult != want {
+			t.Errorf("%s: last result %q, want %q", id, s.LastResult, want)
+		}
+		if want != "queued" {
+			continue
+		}
+		task, ok := GetTask(s.LastTaskID)
+		if !ok || task.ScheduleID != id || s.Runs != 1 {
+			t.Errorf("%s: last task %q (found %v, schedule %q), %d runs", id, s.LastTaskID, ok, task.ScheduleID, s.Runs)
+		}
+		if s.NextRun == nil || !s.NextRun.After(now) {
+			t.Errorf("%s: next run %v is not after now", id, s.NextRun)
+		}
+	}
+	if len(taskQueue) != 2 {
+		t.Errorf("%d tasks queued, want 2", len(taskQueue))
+	}
+	if s, _ := GetSchedule("failing"); s.Enabled || s.NextRun != nil {
+		t.Errorf("one-off after its run: enabled %v, next run %v", s.Enabled, s.NextRun)
+	}
+
+	// A run whose last task is still queued is skipped
+	schedules["due"].SkipIfRunning = true
+	schedules["due"].NextRun = &due
+	runDueSchedules(now)
+	if s, _ := GetSchedule("due"); s.LastResult != "skipped: "+s.LastTaskID+" is in-the-queue" || s.Runs != 1 {
+		t.Errorf("skipIfRunning: last result %q, %d runs", s.LastResult, s.Runs)
+	}
+}
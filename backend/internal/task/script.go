@@ -0,0 +1,590 @@
+package task
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/screenshot"
+	"useless-agent/internal/tracing"
+)
+
+// A script is a task written as steps instead of a goal: fixed inputs,
+// waits and checks run as written, and only its llm steps go through the
+// agent loop, each as a subtask that ends when the model's verdict or the
+// step's "until" checks say its goal is reached. Strings of steps can use
+// {{name}} for parameters and variables.
+
+const (
+	maxScriptSteps        = 10000 // steps one run may execute, loops included
+	defaultWhileRounds    = 20
+	defaultWaitTimeout    = 30 * time.Second
+	scriptPollInterval    = time.Second
+	scriptActionPause     = 100 * time.Millisecond
+	maxScriptNestingDepth = 16
+)
+
+var (
+	scriptVarName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	scriptVarRef  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+)
+
+// Script is a task as a list of steps
+type Script struct {
+	Name   string            `json:"name,omitempty"`
+	Params []ScriptParam     `json:"params,omitempty"`
+	Vars   map[string]string `json:"vars,omitempty"` // initial values, may use parameters
+	Steps  []ScriptStep      `json:"steps"`
+}
+
+// ScriptParam is a value given when the script is submitted; one without a
+// default is required
+type ScriptParam struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Default     string `json:"default,omitempty"`
+}
+
+// ScriptStep is one step of a script; exactly one of its kinds is set
+type ScriptStep struct {
+	Action      *actionpkg.Action `json:"action,omitempty"`      // any action the model could send
+	Hotkey      string            `json:"hotkey,omitempty"`      // key combo, e.g. "ctrl+l"
+	Text        string            `json:"text,omitempty"`        // typed as is
+	WaitForText string            `json:"waitForText,omitempty"` // phrase OCR must find, polled until timeout
+	Assert      *Condition        `json:"assert,omitempty"`      // fails the task if false
+	LLM         string            `json:"llm,omitempty"`         // goal handed to the agent loop
+	If          *Condition        `json:"if,omitempty"`
+	Set         map[string]string `json:"set,omitempty"`     // assigns variables
+	Repeat      int               `json:"repeat,omitempty"`  // runs do this many times
+	While       *Condition        `json:"while,omitempty"`   // runs do while true, up to max rounds
+	ForEach     []string          `json:"forEach,omitempty"` // runs do with as set to each value
+	Sleep       string            `json:"sleep,omitempty"`   // duration, e.g. "500ms"
+
+	Timeout string          `json:"timeout,omitempty"` // waitForText, default 30s
+	Message string          `json:"message,omitempty"` // assert: why the task failed
+	Until   []Postcondition `json:"until,omitempty"`   // llm: checks that settle the goal
+	Then    []ScriptStep    `json:"then,omitempty"`    // if
+	Else    []ScriptStep    `json:"else,omitempty"`    // if
+	Do      []ScriptStep    `json:"do,omitempty"`      // repeat, while, forEach
+	As      string          `json:"as,omitempty"`      // forEach: variable
+	Max     int             `json:"max,omitempty"`     // while: rounds, default 20
+}
+
+// Condition is a postcondition checked by a script step, negated by not
+type Condition struct {
+	Postcondition
+	Not bool `json:"not,omitempty"`
+}
+
+// kind names what a step does
+func (s *ScriptStep) kind() string {
+	var kinds []string
+	for _, k := range []struct {
+		name string
+		set  bool
+	}{
+		{"action", s.Action != nil},
+		{"hotkey", s.Hotkey != ""},
+		{"text", s.Text != ""},
+		{"waitForText", s.WaitForText != ""},
+		{"assert", s.Assert != nil},
+		{"llm", s.LLM != ""},
+		{"if", s.If != nil},
+		{"set", len(s.Set) > 0},
+		{"repeat", s.Repeat > 0},
+		{"while", s.While != nil},
+		{"forEach", s.ForEach != nil},
+		{"sleep", s.Sleep != ""},
+	} {
+		if k.set {
+			kinds = append(kinds, k.name)
+		}
+	}
+	if len(kinds) != 1 {
+		return fmt.Sprint(kinds)
+	}
+	return kinds[0]
+}
+
+// ValidateScript checks that a script is well-formed before a task is queued
+func ValidateScript(s *Script) error {
+	if len(s.Steps) == 0 {
+		return errors.New("steps are required")
+	}
+	for _, p := range s.Params {
+		if !scriptVarName.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+	}
+	for name := range s.Vars {
+		if !scriptVarName.MatchString(name) {
+			return fmt.Errorf("invalid variable name %q", name)
+		}
+	}
+	return validateSteps(s.Steps, "step", 0)
+}
+
+func validateSteps(steps []ScriptStep, path string, depth int) error {
+	if depth > maxScriptNestingDepth {
+		return fmt.Errorf("%s: nested too deep", path)
+	}
+	for i := range steps {
+		step := &steps[i]
+		at := fmt.Sprintf("%s %d", path, i+1)
+		if err := validateStep(step, at, depth); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func validateStep(step *ScriptStep, at string, depth int) error {
+	kind := step.kind()
+	switch kind {
+	case "action":
+		if step.Action.Action == "" {
+			return fmt.Errorf("%s: action needs an action name", at)
+		}
+	case "waitForText":
+		if step.Timeout != "" {
+			if _, err := time.ParseDuration(step.Timeout); err != nil {
+				return fmt.Errorf("%s: invalid timeout: %w", at, err)
+			}
+		}
+	case "assert", "if", "while":
+		c := step.Assert
+		if kind == "if" {
+			c = step.If
+		} else if kind == "while" {
+			c = step.While
+		}
+		if err := ValidatePostconditions([]Postcondition{c.Postcondition}); err != nil {
+			return fmt.Errorf("%s: %s: %w", at, kind, err)
+		}
+	case "llm":
+		if err := ValidatePostconditions(step.Until); err != nil {
+			return fmt.Errorf("%s: until: %w", at, err)
+		}
+	case "set":
+		for name := range step.Set {
+			if !scriptVarName.MatchString(name) {
+				return fmt.Errorf("%s: invalid variable name %q", at, name)
+			}
+		}
+	case "forEach":
+		if !scriptVarName.MatchString(step.As) {
+			return fmt.Errorf("%s: forEach needs a variable name as \"as\"", at)
+		}
+	case "sleep":
+		if _, err := time.ParseDuration(step.Sleep); err != nil {
+			return fmt.Errorf("%s: invalid sleep: %w", at, err)
+		}
+	case "hotkey", "text", "repeat":
+	default:
+		return fmt.Errorf("%s: a step needs exactly one of action, hotkey, text, waitForText, assert, llm, if, set, repeat, while, forEach, sleep, has %s", at, kind)
+	}
+
+	if kind == "if" {
+		if err := validateSteps(step.Then, at+" then", depth+1); err != nil {
+			return err
+		}
+		return validateSteps(step.Else, at+" else", depth+1)
+	}
+	if kind == "repeat" || kind == "while" || kind == "forEach" {
+		if len(step.Do) == 0 {
+			return fmt.Errorf("%s: %s needs steps in do", at, kind)
+		}
+		return validateSteps(step.Do, at+" do", depth+1)
+	}
+	if len(step.Then) > 0 || len(step.Else) > 0 || len(step.Do) > 0 {
+		return fmt.Errorf("%s: only if has then and else, only loops have do", at)
+	}
+	return nil
+}
+
+// BindScriptParams returns the variables a script starts with: its
+// parameters, from params or their defaults, and its vars
+func BindScriptParams(s *Script, params map[string]string) (map[string]string, error) {
+	vars := make(map[string]string, len(s.Params)+len(s.Vars))
+	declared := make(map[string]bool, len(s.Params))
+	for _, p := range s.Params {
+		declared[p.Name] = true
+		value, ok := params[p.Name]
+		if !ok {
+			if p.Default == "" {
+				return nil, fmt.Errorf("parameter %q is required", p.Name)
+			}
+			value = p.Default
+		}
+		vars[p.Name] = value
+	}
+	for name := range params {
+		if !declared[name] {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+	initial := make(map[string]string, len(s.Vars))
+	for name, value := range s.Vars {
+		rendered, err := renderScript(value, vars)
+		if err != nil {
+			return nil, fmt.Errorf("variable %q: %w", name, err)
+		}
+		initial[name] = rendered
+	}
+	for name, value := range initial {
+		vars[name] = value
+	}
+	return vars, nil
+}
+
+// renderScript replaces the {{name}} references of text
+func renderScript(text string, vars map[string]string) (string, error) {
+	var err error
+	rendered := scriptVarRef.ReplaceAllStringFunc(text, func(ref string) string {
+		name := scriptVarRef.FindStringSubmatch(ref)[1]
+		value, ok := vars[name]
+		if !ok && err == nil {
+			err = fmt.Errorf("undefined variable %q", name)
+		}
+		return value
+	})
+	return rendered, err
+}
+
+// scriptRun is a script being executed: a stack of step lists, the body of
+// an if or a loop on top of the steps around it
+type scriptRun struct {
+	task     *Task
+	vars     map[string]string
+	stack    []*scriptFrame
+	executed int
+	nextID   int // of the subtask of the next llm step
+}
+
+type scriptFrame struct {
+	steps []ScriptStep
+	pc    int
+	loop  *ScriptStep // the repeat, while or forEach this is the body of
+	round int
+}
+
+func newScriptRun(task *Task) (*scriptRun, error) {
+	vars, err := BindScriptParams(task.Script, task.ScriptParams)
+	if err != nil {
+		return nil, err
+	}
+	return &scriptRun{
+		task:  task,
+		vars:  vars,
+		stack: []*scriptFrame{{steps: task.Script.Steps}},
+	}, nil
+}
+
+// next runs steps up to the next llm step and returns its subtask, none at
+// the end of the script
+func (r *scriptRun) next(ctx context.Context) ([]SubTask, error) {
+	for len(r.stack) > 0 {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		frame := r.stack[len(r.stack)-1]
+		if frame.pc == len(frame.steps) {
+			again, err := r.loopAgain(ctx, frame)
+			if err != nil {
+				return nil, err
+			}
+			if !again {
+				r.stack = r.stack[:len(r.stack)-1]
+			}
+			continue
+		}
+		step := &frame.steps[frame.pc]
+		frame.pc++
+		r.executed++
+		if r.executed > maxScriptSteps {
+			return nil, fmt.Errorf("more than %d steps, a loop does not end", maxScriptSteps)
+		}
+		subtask, err := r.run(ctx, step)
+		if err != nil {
+			return nil, err
+		}
+		if subtask != nil {
+			return []SubTask{*subtask}, nil
+		}
+	}
+	return nil, nil
+}
+
+// loopAgain starts the next round of the loop frame is the body of
+func (r *scriptRun) loopAgain(ctx context.Context, frame *scriptFrame) (bool, error) {
+	loop := frame.loop
+	if loop == nil {
+		return false, nil
+	}
+	frame.round++
+	switch {
+	case loop.Repeat > 0:
+		if frame.round >= loop.Repeat {
+			return false, nil
+		}
+	case loop.ForEach != nil:
+		if frame.round >= len(loop.ForEach) {
+			return false, nil
+		}
+		if err := r.setEach(loop, frame.round); err != nil {
+			return false, err
+		}
+	case loop.While != nil:
+		rounds := loop.Max
+		if rounds <= 0 {
+			rounds = defaultWhileRounds
+		}
+		if frame.round >= rounds {
+			return false, nil
+		}
+		passed, _, err := r.check(ctx, loop.While)
+		if err != nil || !passed {
+			return false, err
+		}
+	}
+	frame.pc = 0
+	return true, nil
+}
+
+func (r *scriptRun) setEach(loop *ScriptStep, i int) error {
+	value, err := renderScript(loop.ForEach[i], r.vars)
+	if err != nil {
+		return err
+	}
+	r.vars[loop.As] = value
+	return nil
+}
+
+// run executes a step, returning the subtask of an llm step
+func (r *scriptRun) run(ctx context.Context, step *ScriptStep) (*SubTask, error) {
+	kind := step.kind()
+	ctx, span := tracing.Start(ctx, "script."+kind)
+	defer span.End()
+
+	switch kind {
+	case "action":
+		a := *step.Action
+		if err := r.renderAction(&a); err != nil {
+			return nil, err
+		}
+		return nil, r.act(ctx, a)
+	case "hotkey":
+		combo, err := renderScript(step.Hotkey, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		return nil, r.act(ctx, actionpkg.Action{Action: "hotkey", KeyString: combo})
+	case "text":
+		text, err := renderScript(step.Text, r.vars)
+		if err != nil {
+			return nil, err
+		}
+		return nil, r.act(ctx, actionpkg.Action{Action: "printString", InputString: text})
+	case "waitForText":
+		return nil, r.waitForText(ctx, step)
+	case "assert":
+		passed, detail, err := r.check(ctx, step.Assert)
+		if err != nil {
+			return nil, err
+		}
+		if !passed {
+			message, _ := renderScript(step.Message, r.vars)
+			if message == "" {
+				message = "assert failed"
+			}
+			return nil, fmt.Errorf("%s: %s", message, detail)
+		}
+	case "llm":
+		return r.subtask(step)
+	case "if":
+		passed, _, err := r.check(ctx, step.If)
+		if err != nil {
+			return nil, err
+		}
+		branch := step.Else
+		if passed {
+			branch = step.Then
+		}
+		if len(branch) > 0 {
+			r.stack = append(r.stack, &scriptFrame{steps: branch})
+		}
+	case "set":
+		assigned := make(map[string]string, len(step.Set))
+		for name, value := range step.Set {
+			rendered, err := renderScript(value, r.vars)
+			if err != nil {
+				return nil, err
+			}
+			assigned[name] = rendered
+		}
+		for name, value := range assigned {
+			r.vars[name] = value
+		}
+		r.event(kind, assigned)
+	case "repeat":
+		r.stack = append(r.stack, &scriptFrame{steps: step.Do, loop: step})
+	case "forEach":
+		if len(step.ForEach) > 0 {
+			if err := r.setEach(step, 0); err != nil {
+				return nil, err
+			}
+			r.stack = append(r.stack, &scriptFrame{steps: step.Do, loop: step})
+		}
+	case "while":
+		passed, _, err := r.check(ctx, step.While)
+		if err != nil {
+			return nil, err
+		}
+		if passed {
+			r.stack = append(r.stack, &scriptFrame{steps: step.Do, loop: step})
+		}
+	case "sleep":
+		d, _ := time.ParseDuration(step.Sleep)
+		r.event(kind, map[string]interface{}{"durationMs": d.Milliseconds()})
+		select {
+		case <-time.After(d):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	return nil, nil
+}
+
+// renderAction replaces the references in the string fields of a
+func (r *scriptRun) renderAction(a *actionpkg.Action) error {
+	var err error
+	for _, field := range []*string{&a.InputString, &a.KeyTapString, &a.KeyString, &a.Name, &a.Description} {
+		if *field, err = renderScript(*field, r.vars); err != nil {
+			return err
+		}
+	}
+	keys := make([]string, len(a.Keys))
+	for i, key := range a.Keys {
+		if keys[i], err = renderScript(key, r.vars); err != nil {
+			return err
+		}
+	}
+	a.Keys = keys
+	return nil
+}
+
+// renderCheck replaces the references in the string fields of c
+func (r *scriptRun) renderCheck(c Postcondition) (Postcondition, error) {
+	var err error
+	for _, field := range []*string{&c.WindowClass, &c.Title, &c.Text, &c.Path, &c.Process, &c.Pattern} {
+		if *field, err = renderScript(*field, r.vars); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// act executes an action the way the agent loop does, waiting for an
+// operator who has control
+func (r *scriptRun) act(ctx context.Context, a actionpkg.Action) error {
+	waitIfPaused(ctx, r.task)
+	waitForOperator(ctx, r.task)
+	setExecuteFunction(&a)
+	if err := actionpkg.Validate(&a); err != nil {
+		return fmt.Errorf("action %s: %v", a.Action, err)
+	}
+	r.event("action", a)
+
+	inputMutex.Lock()
+	startedAt := time.Now()
+	a.Execute(ctx, &a)
+	inputMutex.Unlock()
+	actionpkg.ReleaseAll()
+	logger.InfoContext(ctx, "script action done", "action", a.Action, "durationMs", time.Since(startedAt).Milliseconds())
+
+	time.Sleep(scriptActionPause)
+	return nil
+}
+
+// waitForText polls the screen until OCR finds the phrase of step
+func (r *scriptRun) waitForText(ctx context.Context, step *ScriptStep) error {
+	text, err := renderScript(step.WaitForText, r.vars)
+	if err != nil {
+		return err
+	}
+	timeout := defaultWaitTimeout
+	if step.Timeout != "" {
+		timeout, _ = time.ParseDuration(step.Timeout)
+	}
+	c := &Condition{Postcondition: Postcondition{Type: CheckTextPresent, Text: text}}
+	deadline := time.Now().Add(timeout)
+	for {
+		passed, detail, err := r.check(ctx, c)
+		if err != nil {
+			return err
+		}
+		if passed {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("%q did not appear within %s: %s", text, timeout, detail)
+		}
+		select {
+		case <-time.After(scriptPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// check evaluates a condition against the current screen, capturing only
+// what its type needs
+func (r *scriptRun) check(ctx context.Context, c *Condition) (bool, string, error) {
+	pc, err := r.renderCheck(c.Postcondition)
+	if err != nil {
+		return false, "", err
+	}
+
+	var checkCtx CheckContext
+	switch pc.Type {
+	case CheckTextPresent:
+		img, err := captureScreenshot()
+		if err != nil {
+			return false, "", fmt.Errorf("failed to capture screenshot: %w", err)
+		}
+		checkCtx.OCR = runOCR(screenshot.ConvertToGrayscale(img))
+	case CheckWindowVisible:
+		checkCtx.X11WindowsJSON, _ = getX11WindowsData()
+	}
+	result := EvaluatePostconditions([]Postcondition{pc}, checkCtx)[0]
+	passed := result.Passed != c.Not
+	r.event("check", map[string]interface{}{"postcondition": pc, "not": c.Not, "passed": passed, "detail": result.Detail})
+	logger.InfoContext(ctx, "script check", "type", pc.Type, "not", c.Not, "passed", passed, "detail", result.Detail)
+	return passed, result.Detail, nil
+}
+
+// subtask renders an llm step as a subtask of the agent loop
+func (r *scriptRun) subtask(step *ScriptStep) (*SubTask, error) {
+	goal, err := renderScript(step.LLM, r.vars)
+	if err != nil {
+		return nil, err
+	}
+	until := make([]Postcondition, len(step.Until))
+	for i, c := range step.Until {
+		if until[i], err = r.renderCheck(c); err != nil {
+			return nil, err
+		}
+	}
+	subtask := &SubTask{Id: r.nextID, Description: goal, Postconditions: until}
+	r.nextID++
+	r.event("llm", map[string]interface{}{"subtaskId": subtask.Id, "goal": goal})
+	return subtask, nil
+}
+
+// event records a script step in the task history
+func (r *scriptRun) event(kind string, data interface{}) {
+	r.task.history.event("script", map[string]interface{}{"step": kind, "data": data})
+}
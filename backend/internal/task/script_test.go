@@ -0,0 +1,238 @@
+package task
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	actionpkg "useless-agent/internal/action"
+)
+
+func TestValidateScript(t *testing.T) {
+	fileCheck := &Condition{Postcondition: Postcondition{Type: CheckFileExists, Path: "/tmp/x"}}
+	tests := []struct {
+		name    string
+		script  Script
+		wantErr string
+	}{
+		{"no steps", Script{}, "steps are required"},
+		{"valid", Script{
+			Params: []ScriptParam{{Name: "url"}},
+			Vars:   map[string]string{"page": "{{url}}/index"},
+			Steps: []ScriptStep{
+				{Hotkey: "ctrl+l"},
+				{Text: "{{page}}"},
+				{WaitForText: "Welcome", Timeout: "10s"},
+				{If: fileCheck, Then: []ScriptStep{{Sleep: "1s"}}, Else: []ScriptStep{{LLM: "download it"}}},
+				{ForEach: []string{"a", "b"}, As: "item", Do: []ScriptStep{{Text: "{{item}}"}}},
+			},
+		}, ""},
+		{"bad parameter name", Script{Params: []ScriptParam{{Name: "1st"}}, Steps: []ScriptStep{{Text: "a"}}}, `invalid parameter name "1st"`},
+		{"bad variable name", Script{Vars: map[string]string{"a-b": ""}, Steps: []ScriptStep{{Text: "a"}}}, `invalid variable name "a-b"`},
+		{"step with two kinds", Script{Steps: []ScriptStep{{Text: "a", Hotkey: "enter"}}}, "step 1: a step needs exactly one of"},
+		{"empty step", Script{Steps: []ScriptStep{{}}}, "step 1: a step needs exactly one of"},
+		{"action without name", Script{Steps: []ScriptStep{{Action: &actionpkg.Action{}}}}, "step 1: action needs an action name"},
+		{"bad timeout", Script{Steps: []ScriptStep{{WaitForText: "a", Timeout: "soon"}}}, "step 1: invalid timeout"},
+		{"bad sleep", Script{Steps: []ScriptStep{{Sleep: "5"}}}, "step 1: invalid sleep"},
+		{"bad assert", Script{Steps: []ScriptStep{{Assert: &Condition{Postcondition: Postcondition{Type: CheckFileExists}}}}}, "step 1: assert: postcondition 1: path is required"},
+		{"bad until", Script{Steps: []ScriptStep{{LLM: "a", Until: []Postcondition{{Type: CheckTextPresent}}}}}, "step 1: until"},
+		{"forEach without as", Script{Steps: []ScriptStep{{ForEach: []string{"a"}, Do: []ScriptStep{{Text: "a"}}}}}, `forEach needs a variable name as "as"`},
+		{"loop without body", Script{Steps: []ScriptStep{{Repeat: 2}}}, "step 1: repeat needs steps in do"},
+		{"body outside a loop", Script{Steps: []ScriptStep{{Text: "a", Do: []ScriptStep{{Text: "b"}}}}}, "only loops have do"},
+		{"nested error has its path", Script{Steps: []ScriptStep{{Text: "a"}, {Repeat: 2, Do: []ScriptStep{{If: fileCheck, Then: []ScriptStep{{Sleep: "x"}}}}}}}, "step 2 do 1 then 1: invalid sleep"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := ValidateScript(&tt.script)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestBindScriptParams(t *testing.T) {
+	script := &Script{
+		Params: []ScriptParam{{Name: "host"}, {Name: "port", Default: "80"}},
+		Vars:   map[string]string{"url": "http://{{host}}:{{port}}/"},
+	}
+	tests := []struct {
+		name    string
+		script  *Script
+		params  map[string]string
+		want    map[string]string
+		wantErr string
+	}{
+		{"defaults", script, map[string]string{"host": "example.org"},
+			map[string]string{"host": "example.org", "port": "80", "url": "http://example.org:80/"}, ""},
+		{"given", script, map[string]string{"host": "a", "port": "8080"},
+			map[string]string{"host": "a", "port": "8080", "url": "http://a:8080/"}, ""},
+		{"required missing", script, nil, nil, `parameter "host" is required`},
+		{"unknown", script, map[string]string{"host": "a", "user": "b"}, nil, `unknown parameter "user"`},
+		{"undefined reference", &Script{Vars: map[string]string{"a": "{{b}}"}}, nil, nil, `variable "a": undefined variable "b"`},
+		// vars see the parameters, not each other
+		{"vars do not chain", &Script{Vars: map[string]string{"a": "1", "b": "{{a}}"}}, nil, nil, `undefined variable "a"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := BindScriptParams(tt.script, tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("vars = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	vars := map[string]string{"name": "Ann", "n": "3"}
+	tests := []struct {
+		text    string
+		want    string
+		wantErr bool
+	}{
+		{"plain", "plain", false},
+		{"hi {{name}}", "hi Ann", false},
+		{"{{ name }} x{{n}}", "Ann x3", false},
+		{"{{missing}}", "", true},
+		{"{{not a ref}}", "{{not a ref}}", false},
+	}
+	for _, tt := range tests {
+		got, err := renderScript(tt.text, vars)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("renderScript(%q) error = %v, want error %v", tt.text, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && got != tt.want {
+			t.Errorf("renderScript(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+// TestScriptRun runs scripts without screen steps and checks the llm steps
+// they hand to the agent loop
+func TestScriptRun(t *testing.T) {
+	dir := t.TempDir()
+	present := filepath.Join(dir, "present")
+	if err := os.WriteFile(present, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	exists := &Condition{Postcondition: Postcondition{Type: CheckFileExists, Path: present}}
+	missing := &Condition{Postcondition: Postcondition{Type: CheckFileExists, Path: filepath.Join(dir, "missing")}}
+
+	tests := []struct {
+		name    string
+		script  Script
+		params  map[string]string
+		want    []string
+		wantErr string
+	}{
+		{
+			name:   "plain steps",
+			script: Script{Steps: []ScriptStep{{LLM: "first"}, {Sleep: "1ms"}, {LLM: "second"}}},
+			want:   []string{"first", "second"},
+		},
+		{
+			name: "parameters and set",
+			script: Script{
+				Params: []ScriptParam{{Name: "site"}},
+				Steps:  []ScriptStep{{LLM: "open {{site}}"}, {Set: map[string]string{"site": "{{site}}/login"}}, {LLM: "open {{site}}"}},
+			},
+			params: map[string]string{"site": "example.org"},
+			want:   []string{"open example.org", "open example.org/login"},
+		},
+		{
+			name:   "if and else",
+			script: Script{Steps: []ScriptStep{{If: exists, Then: []ScriptStep{{LLM: "then"}}, Else: []ScriptStep{{LLM: "else"}}}, {If: missing, Then: []ScriptStep{{LLM: "then"}}, Else: []ScriptStep{{LLM: "else"}}}}},
+			want:   []string{"then", "else"},
+		},
+		{
+			name:   "negated condition",
+			script: Script{Steps: []ScriptStep{{If: &Condition{Postcondition: missing.Postcondition, Not: true}, Then: []ScriptStep{{LLM: "not there"}}}}},
+			want:   []string{"not there"},
+		},
+		{
+			name:   "nested repeat and forEach",
+			script: Script{Steps: []ScriptStep{{Repeat: 2, Do: []ScriptStep{{ForEach: []string{"a", "b"}, As: "x", Do: []ScriptStep{{LLM: "{{x}}"}}}, {LLM: "end of round"}}}, {LLM: "done"}}},
+			want:   []string{"a", "b", "end of round", "a", "b", "end of round", "done"},
+		},
+		{
+			name:   "while runs at most max rounds",
+			script: Script{Steps: []ScriptStep{{While: exists, Max: 3, Do: []ScriptStep{{LLM: "again"}}}}},
+			want:   []string{"again", "again", "again"},
+		},
+		{
+			name:   "while that is false never runs",
+			script: Script{Steps: []ScriptStep{{While: missing, Do: []ScriptStep{{LLM: "never"}}}, {LLM: "after"}}},
+			want:   []string{"after"},
+		},
+		{
+			name:    "assert fails with its message",
+			script:  Script{Steps: []ScriptStep{{LLM: "before"}, {Assert: missing, Message: "no {{what}}"}}, Vars: map[string]string{"what": "file"}},
+			want:    []string{"before"},
+			wantErr: "no file: file",
+		},
+		{
+			name:    "undefined variable",
+			script:  Script{Steps: []ScriptStep{{LLM: "open {{site}}"}}},
+			wantErr: `undefined variable "site"`,
+		},
+		{
+			name:    "endless loop is stopped",
+			script:  Script{Steps: []ScriptStep{{While: exists, Max: 2 * maxScriptSteps, Do: []ScriptStep{{Set: map[string]string{"a": "b"}}}}}},
+			wantErr: "a loop does not end",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := ValidateScript(&tt.script); err != nil {
+				t.Fatalf("invalid script: %v", err)
+			}
+			run, err := newScriptRun(&Task{Script: &tt.script, ScriptParams: tt.params})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var goals []string
+			for {
+				subtasks, err := run.next(context.Background())
+				if err != nil {
+					if tt.wantErr == "" || !strings.Contains(err.Error(), tt.wantErr) {
+						t.Fatalf("error = %v, want %q", err, tt.wantErr)
+					}
+					break
+				}
+				if len(subtasks) == 0 {
+					if tt.wantErr != "" {
+						t.Fatalf("script ended, want error %q", tt.wantErr)
+					}
+					break
+				}
+				if subtasks[0].Id != len(goals) {
+					t.Errorf("subtask %q has id %d, want %d", subtasks[0].Description, subtasks[0].Id, len(goals))
+				}
+				goals = append(goals, subtasks[0].Description)
+			}
+			if !reflect.DeepEqual(goals, tt.want) && (len(goals) != 0 || len(tt.want) != 0) {
+				t.Errorf("llm steps = %q, want %q", goals, tt.want)
+			}
+		})
+	}
+}
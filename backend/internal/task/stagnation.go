@@ -0,0 +1,247 @@
+package task
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"math/rand"
+	"sort"
+	"time"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/ocr"
+	"useless-agent/internal/websocket"
+)
+
+// Escalation levels, applied in order while the act-verify cycle stagnates
+const (
+	escalationNone    = iota
+	escalationWarn    // tell the model explicitly that it is stuck
+	escalationJitter  // additionally nudge mouse coordinates of the next batch
+	escalationReplan  // replace the remaining plan
+	escalationAskUser // request help through the user-assist channel
+)
+
+// Stagnation detection thresholds, per subtask
+const (
+	maxFailedVerifications = 5 // "not done" verdicts in a row that made no progress
+	maxIdenticalBatches    = 3 // the same action batch was produced this many times in a row
+	maxUnchangedScreens    = 3 // actions produced no visible change this many times in a row
+	maxReplansPerTask      = 3
+	stagnationHistorySize  = 8
+	jitterRadius           = 6 // pixels
+	userAssistWaitTimeout  = 2 * time.Minute
+)
+
+// iterationRecord is what the detector remembers about one iteration that
+// ended with a "not done" verdict
+type iterationRecord struct {
+	actionsSignature string
+	screenSignature  uint64
+	screenChanged    bool
+}
+
+// stagnationDetector spots repeated action batches, unchanged screens and
+// oscillation in the iteration history of the current subtask and decides
+// which escalation measure to apply
+type stagnationDetector struct {
+	history []iterationRecord
+	level   int
+}
+
+// reset forgets the history and the escalation level, e.g. for a new subtask
+func (d *stagnationDetector) reset() {
+	d.history = nil
+	d.level = escalationNone
+}
+
+// resetHistory forgets the history but keeps the escalation level, so a new
+// plan that stagnates again escalates further instead of starting over
+func (d *stagnationDetector) resetHistory() {
+	d.history = nil
+}
+
+// record registers an iteration the verdict said did not finish the subtask
+func (d *stagnationDetector) record(actions []actionpkg.Action, ocrResults []ocr.TesseractBoundingBox, delta ocr.Delta, colorsBefore, colorsAfter string) {
+	deltaEmpty := len(delta.Added) == 0 && len(delta.Removed) == 0 && len(delta.Modified) == 0
+	d.history = append(d.history, iterationRecord{
+		actionsSignature: actionsSignature(actions),
+		screenSignature:  screenSignature(ocrResults),
+		screenChanged:    !deltaEmpty || colorsBefore != colorsAfter,
+	})
+	if len(d.history) > stagnationHistorySize {
+		d.history = d.history[len(d.history)-stagnationHistorySize:]
+	}
+}
+
+// stalled reports whether the i-th iteration made no progress: the screen
+// did not change, the batch repeated the one before, or the screen went
+// back to the one two iterations earlier
+func (d *stagnationDetector) stalled(i int) bool {
+	r := d.history[i]
+	switch {
+	case !r.screenChanged:
+		return true
+	case i >= 1 && r.actionsSignature == d.history[i-1].actionsSignature:
+		return true
+	case i >= 2 && r.screenSignature == d.history[i-2].screenSignature:
+		return true
+	}
+	return false
+}
+
+// analyze returns a description of the stagnation pattern, or "" if the subtask is progressing
+func (d *stagnationDetector) analyze() string {
+	n := len(d.history)
+	if n == 0 {
+		return ""
+	}
+
+	identical, unchanged, failed := 0, 0, 0
+	for i := n - 1; i >= 0; i-- {
+		if d.history[i].actionsSignature != d.history[n-1].actionsSignature {
+			break
+		}
+		identical++
+	}
+	for i := n - 1; i >= 0 && !d.history[i].screenChanged; i-- {
+		unchanged++
+	}
+	// A "not done" verdict alone is no sign of being stuck, a subtask may
+	// take many iterations that each change the screen
+	for i := n - 1; i >= 0 && d.stalled(i); i-- {
+		failed++
+	}
+
+	switch {
+	case identical >= maxIdenticalBatches:
+		return fmt.Sprintf("the same actions were issued %d times in a row", identical)
+	case unchanged >= maxUnchangedScreens:
+		return fmt.Sprintf("the screen did not change for %d iterations", unchanged)
+	case n >= 4 && d.oscillating(n):
+		return "the screen is oscillating between the same two states"
+	case failed >= maxFailedVerifications:
+		return fmt.Sprintf("verification failed %d times in a row without progress", failed)
+	}
+	return ""
+}
+
+// oscillating detects an A, B, A, B pattern in the last four screens or batches
+func (d *stagnationDetector) oscillating(n int) bool {
+	a, b, c, e := d.history[n-4], d.history[n-3], d.history[n-2], d.history[n-1]
+	screens := a.screenSignature == c.screenSignature && b.screenSignature == e.screenSignature && a.screenSignature != b.screenSignature
+	batches := a.actionsSignature == c.actionsSignature && b.actionsSignature == e.actionsSignature && a.actionsSignature != b.actionsSignature
+	return screens || batches
+}
+
+// escalate returns the measure to apply after the latest iteration. A
+// detected pattern raises the level by one; the history then starts over
+// from the latest iteration, so the next level needs the pattern to show
+// again after the measure had its chance. An iteration that makes progress
+// resets the level.
+func (d *stagnationDetector) escalate() (int, string) {
+	n := len(d.history)
+	reason := d.analyze()
+	if reason == "" {
+		if n > 0 && !d.stalled(n-1) {
+			d.level = escalationNone
+		}
+		return escalationNone, ""
+	}
+	if d.level < escalationAskUser {
+		d.level++
+	}
+	d.history = d.history[n-1:]
+	return d.level, reason
+}
+
+// actionsSignature identifies a batch by what it does, ignoring IDs and descriptions
+func actionsSignature(actions []actionpkg.Action) string {
+	normalized := make([]actionpkg.Action, len(actions))
+	for i, a := range actions {
+		normalized[i] = a
+		normalized[i].ActionSequenceID = 0
+		normalized[i].Description = ""
+	}
+	data, err := json.Marshal(normalized)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// screenSignature hashes the set of words on screen, ignoring positions
+func screenSignature(ocrResults []ocr.TesseractBoundingBox) uint64 {
+	words := make([]string, len(ocrResults))
+	for i, box := range ocrResults {
+		words[i] = box.Text
+	}
+	sort.Strings(words)
+
+	h := fnv.New64a()
+	for _, w := range words {
+		h.Write([]byte(w))
+		h.Write([]byte{0})
+	}
+	return h.Sum64()
+}
+
+// stagnationNotice is the explicit warning injected into the next prompt
+func stagnationNotice(reason string) string {
+	return "\n\nWARNING FROM THE SYSTEM: you are stuck, " + reason + ". Your previous actions did not move the task forward. " +
+		"Do NOT repeat them. Try a different approach: other coordinates, a hotkey instead of the mouse, a different menu or application."
+}
+
+// jitterCoordinates nudges absolute mouse targets by a few pixels, which often
+// helps when the model keeps clicking the border of an element
+func jitterCoordinates(a *actionpkg.Action) {
+	switch a.Action {
+	case "mouseMove", "dragSmooth":
+	default:
+		return
+	}
+	if a.Coordinates.X == 0 && a.Coordinates.Y == 0 {
+		return
+	}
+	dx := rand.Intn(2*jitterRadius+1) - jitterRadius
+	dy := rand.Intn(2*jitterRadius+1) - jitterRadius
+	logger.Debug("jittering action coordinates", "action", a.Action, "dx", dx, "dy", dy)
+	a.Coordinates.X = max(0, a.Coordinates.X+dx)
+	a.Coordinates.Y = max(0, a.Coordinates.Y+dy)
+}
+
+// requestUserAssist asks the operator for help and waits until a user-assist
+// message arrives, the task is canceled or the timeout expires
+func requestUserAssist(task *Task, subtask SubTask, reason string) bool {
+	logger.Warn("task is stuck, requesting user assistance", "taskId", task.ID, "subtaskId", subtask.Id, "reason", reason)
+	websocket.BroadcastMessage("userAssistRequest", map[string]interface{}{
+		"taskId":      task.ID,
+		"subtaskId":   subtask.Id,
+		"description": subtask.Description,
+		"reason":      reason,
+	})
+	BroadcastExecutionEngineUpdate("userAssistRequest", map[string]interface{}{
+		"taskId":    task.ID,
+		"subtaskId": subtask.Id,
+		"reason":    reason,
+	})
+
+	deadline := time.NewTimer(userAssistWaitTimeout)
+	defer deadline.Stop()
+	ticker := time.NewTicker(500 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-task.Context.Done():
+			return false
+		case <-deadline.C:
+			logger.Info("no user assistance in time, continuing", "taskId", task.ID, "timeout", userAssistWaitTimeout)
+			return false
+		case <-ticker.C:
+			if HasPendingUserAssistMessage(task.ID) {
+				return true
+			}
+		}
+	}
+}
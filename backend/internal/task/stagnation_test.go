@@ -0,0 +1,182 @@
+package task
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	actionpkg "useless-agent/internal/action"
+	"useless-agent/internal/ocr"
+)
+
+// iteration is one "not done" iteration: the batch, the words on screen
+// after it and whether the actions changed the screen
+type iteration struct {
+	batch   string
+	screen  string
+	changed bool
+}
+
+func (it iteration) record(d *stagnationDetector) {
+	actions := []actionpkg.Action{{Action: "printString", InputString: it.batch}}
+	var words []ocr.TesseractBoundingBox
+	for _, w := range strings.Fields(it.screen) {
+		words = append(words, ocr.TesseractBoundingBox{Text: w})
+	}
+	colorsAfter := "before"
+	if it.changed {
+		colorsAfter = "after"
+	}
+	d.record(actions, words, ocr.Delta{}, "before", colorsAfter)
+}
+
+func TestStagnationDetector(t *testing.T) {
+	N, W, J, R, A := escalationNone, escalationWarn, escalationJitter, escalationReplan, escalationAskUser
+	tests := []struct {
+		name       string
+		iterations []iteration
+		want       []int
+		reason     string // of the first escalation
+	}{
+		{
+			name: "progress over many iterations",
+			iterations: []iteration{
+				{"a", "s1", true}, {"b", "s2", true}, {"c", "s3", true}, {"d", "s4", true},
+				{"e", "s5", true}, {"f", "s6", true}, {"g", "s7", true}, {"h", "s8", true},
+			},
+			want: []int{N, N, N, N, N, N, N, N},
+		},
+		{
+			name:       "identical batches",
+			iterations: []iteration{{"a", "s1", true}, {"a", "s2", true}, {"a", "s3", true}},
+			want:       []int{N, N, W},
+			reason:     "the same actions were issued 3 times in a row",
+		},
+		{
+			name:       "unchanged screen",
+			iterations: []iteration{{"a", "s1", false}, {"b", "s1", false}, {"c", "s1", false}},
+			want:       []int{N, N, W},
+			reason:     "the screen did not change for 3 iterations",
+		},
+		{
+			name:       "oscillating screen",
+			iterations: []iteration{{"a", "s1", true}, {"b", "s2", true}, {"c", "s1", true}, {"d", "s2", true}},
+			want:       []int{N, N, N, W},
+			reason:     "the screen is oscillating between the same two states",
+		},
+		{
+			name:       "oscillating batches",
+			iterations: []iteration{{"a", "s1", true}, {"b", "s2", true}, {"a", "s3", true}, {"b", "s4", true}},
+			want:       []int{N, N, N, W},
+			reason:     "the screen is oscillating between the same two states",
+		},
+		{
+			name: "failed verdicts without progress",
+			iterations: []iteration{
+				{"x", "s1", true}, {"x", "s2", true}, {"y", "s2", false},
+				{"y", "s3", true}, {"z", "s3", false}, {"z", "s4", true},
+			},
+			want:   []int{N, N, N, N, N, W},
+			reason: "verification failed 5 times in a row without progress",
+		},
+		{
+			name: "each level needs the pattern again",
+			iterations: []iteration{
+				{"a", "s1", true}, {"a", "s2", true}, {"a", "s3", true},
+				{"a", "s4", true}, {"a", "s5", true},
+				{"a", "s6", true}, {"a", "s7", true},
+				{"a", "s8", true}, {"a", "s9", true},
+				{"a", "s10", true}, {"a", "s11", true},
+			},
+			want: []int{N, N, W, N, J, N, R, N, A, N, A},
+		},
+		{
+			name: "progress resets the level",
+			iterations: []iteration{
+				{"a", "s1", true}, {"a", "s2", true}, {"a", "s3", true},
+				{"b", "s4", true},
+				{"b", "s5", true}, {"b", "s6", true},
+			},
+			want: []int{N, N, W, N, N, W},
+		},
+		{
+			name: "a stalled iteration keeps the level",
+			iterations: []iteration{
+				{"a", "s1", true}, {"a", "s2", true}, {"a", "s3", true},
+				{"b", "s3", false},
+				{"b", "s4", true},
+				{"b", "s5", true},
+			},
+			want: []int{N, N, W, N, N, J},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var d stagnationDetector
+			var levels []int
+			var reason string
+			for _, it := range tt.iterations {
+				it.record(&d)
+				level, r := d.escalate()
+				if level != escalationNone && reason == "" {
+					reason = r
+				}
+				levels = append(levels, level)
+			}
+			if !reflect.DeepEqual(levels, tt.want) {
+				t.Errorf("levels = %v, want %v", levels, tt.want)
+			}
+			if tt.reason != "" && reason != tt.reason {
+				t.Errorf("reason = %q, want %q", reason, tt.reason)
+			}
+		})
+	}
+}
+
+func TestStagnationReset(t *testing.T) {
+	var d stagnationDetector
+	for _, it := range []iteration{{"a", "s1", false}, {"a", "s1", false}, {"a", "s1", false}} {
+		it.record(&d)
+	}
+	if level, _ := d.escalate(); level != escalationWarn {
+		t.Fatalf("level = %d, want warn", level)
+	}
+
+	// A new plan keeps the level, a new subtask starts over
+	d.resetHistory()
+	if d.level != escalationWarn || len(d.history) != 0 {
+		t.Errorf("after resetHistory: level %d, %d records", d.level, len(d.history))
+	}
+	d.reset()
+	if d.level != escalationNone || len(d.history) != 0 {
+		t.Errorf("after reset: level %d, %d records", d.level, len(d.history))
+	}
+}
+
+func TestActionsSignature(t *testing.T) {
+	a := []actionpkg.Action{{ActionSequenceID: 1, Action: "mouseClickLeft", Description: "click OK"}}
+	b := []actionpkg.Action{{ActionSequenceID: 7, Action: "mouseClickLeft", Description: "press the button"}}
+	c := []actionpkg.Action{{ActionSequenceID: 1, Action: "mouseClickRight"}}
+	if actionsSignature(a) != actionsSignature(b) {
+		t.Error("batches differing only in IDs and descriptions have different signatures")
+	}
+	if actionsSignature(a) == actionsSignature(c) {
+		t.Error("different batches have the same signature")
+	}
+}
+
+func TestScreenSignature(t *testing.T) {
+	words := func(texts ...string) []ocr.TesseractBoundingBox {
+		boxes := make([]ocr.TesseractBoundingBox, len(texts))
+		for i, text := range texts {
+			boxes[i] = ocr.TesseractBoundingBox{Text: text, BoundingBox: ocr.Box{XMin: i * 10}}
+		}
+		return boxes
+	}
+	if screenSignature(words("File", "Edit")) != screenSignature(words("Edit", "File")) {
+		t.Error("the same words in another order have different signatures")
+	}
+	if screenSignature(words("ab", "c")) == screenSignature(words("a", "bc")) {
+		t.Error("different words have the same signature")
+	}
+}
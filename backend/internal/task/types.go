@@ -13,6 +13,25 @@ type Task struct {
 	CreatedAt  time.Time
 	Context    context.Context    // Context for cancellation
 	CancelFunc context.CancelFunc // Function to cancel the context
+
+	Postconditions []Postcondition // Optional machine-checkable goal checks
+	Verification   string          // "auto" (default) or "checksOnly"
+
+	Script       *Script           // Optional steps run instead of planning the goal, see script.go
+	ScriptParams map[string]string // Values of the script's parameters
+
+	Template string   // Name of the template the task was rendered from, if any
+	Model    string   // Optional LLM model instead of the configured one
+	Hints    []string // Optional operator hints added to the planning and actions prompts
+	Budget   Budget   // Optional limits, see budget.go
+
+	ScheduleID string // ID of the schedule that queued the task, if any, see schedule.go
+
+	Priority int      // Higher runs first, see queue.go
+	RunAfter []string // Tasks that must complete before this one runs
+	Held     bool     // Waits in the queue until released
+
+	history *history // what the run did, see history.go
 }
 
 // TaskUpdate represents a task status update
@@ -25,10 +44,31 @@ type TaskUpdate struct {
 
 // SubTask represents a subtask in goal breakdown
 type SubTask struct {
-	Id          int      `json:"id"`
-	Description string   `json:"description"`
-	IsActive    bool     `json:"isActive"`
-	Actions     []Action `json:"actions"`
+	Id             int             `json:"id"`
+	Description    string          `json:"description"`
+	IsActive       bool            `json:"isActive"`
+	Actions        []Action        `json:"actions"`
+	Postconditions []Postcondition `json:"postconditions,omitempty"`
+}
+
+// Postcondition is a deterministic check of the desktop state, see checker.go
+type Postcondition struct {
+	Type        string  `json:"type"`                  // windowVisible, textPresent, fileExists, processRunning, clipboardMatches
+	WindowClass string  `json:"windowClass,omitempty"` // windowVisible: WM_CLASS substring
+	Title       string  `json:"title,omitempty"`       // windowVisible: window title substring
+	Text        string  `json:"text,omitempty"`        // textPresent: phrase to find in OCR text
+	Region      *Region `json:"region,omitempty"`      // textPresent: optional screen region
+	Path        string  `json:"path,omitempty"`        // fileExists: file path, "~/" is expanded
+	Process     string  `json:"process,omitempty"`     // processRunning: process name, on the agent's display
+	Pattern     string  `json:"pattern,omitempty"`     // clipboardMatches: regular expression
+}
+
+// Region represents a rectangular screen area in pixels
+type Region struct {
+	XMin int `json:"xMin"`
+	YMin int `json:"yMin"`
+	XMax int `json:"xMax"`
+	YMax int `json:"yMax"`
 }
 
 // Action represents an action being executed
@@ -40,6 +80,7 @@ type Action struct {
 	InputString      string      `json:"inputString"`
 	KeyTapString     string      `json:"keyTapString"`
 	KeyString        string      `json:"keyString"`
+	Keys             []string    `json:"keys"`
 	ActionsRange     interface{} `json:"actionsRange"`
 	RepeatTimes      int         `json:"repeatTimes"`
 	Description      string      `json:"description"`
@@ -51,6 +92,9 @@ type ExecutionState struct {
 	SelectedTask string   `json:"selectedTask"`
 	RunningTask  string   `json:"runningTask"`
 	QueuedTasks  []string `json:"queuedTasks"`
+
+	Queue        []QueueEntry        `json:"queue"`                  // the queued tasks in order, each with why
+	LastDecision *SchedulingDecision `json:"lastDecision,omitempty"` // why the last task was started
 }
 
 // PromptLog represents a log of prompts used in task execution
@@ -0,0 +1,347 @@
+// Package template keeps the task catalogue: named tasks with typed
+// parameters, written {{name}} in their goal, checks and hints, that an
+// operator starts with POST /api/v2/tasks {template, params} instead of
+// retyping a prompt. A template can also carry a script, the model to use,
+// a budget and hints. Templates are files <templates-dir>/<name>.json, read
+// on every request so the catalogue can be edited in place.
+package template
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/task"
+)
+
+var logger = logging.For("template")
+
+// Parameter types
+const (
+	TypeString = "string"
+	TypeInt    = "int"
+	TypeNumber = "number"
+	TypeBool   = "bool"
+	TypeEnum   = "enum"
+	TypeURL    = "url"
+	TypePath   = "path"
+)
+
+var (
+	validName = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,63}$`)
+	paramName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	paramRef  = regexp.MustCompile(`\{\{\s*([A-Za-z_][A-Za-z0-9_]*)\s*\}\}`)
+
+	// ErrNotFound means there is no template of that name
+	ErrNotFound = errors.New("no such template")
+	// ErrDisabled means -templates-dir is empty
+	ErrDisabled = errors.New("templates are disabled")
+)
+
+// Param is a typed parameter of a template; one without a default is
+// required
+type Param struct {
+	Name        string   `json:"name"`
+	Type        string   `json:"type,omitempty"` // string (default), int, number, bool, enum, url, path
+	Description string   `json:"description,omitempty"`
+	Default     string   `json:"default,omitempty"`
+	Enum        []string `json:"enum,omitempty"` // the values of an enum
+}
+
+// Template is a named task with parameters
+type Template struct {
+	Name        string  `json:"name"`
+	Description string  `json:"description,omitempty"`
+	Params      []Param `json:"params,omitempty"`
+
+	Goal   string       `json:"goal,omitempty"`
+	Script *task.Script `json:"script,omitempty"` // run instead of planning the goal, gets the parameters
+
+	Postconditions []task.Postcondition `json:"postconditions,omitempty"`
+	Verification   string               `json:"verification,omitempty"`
+	Model          string               `json:"model,omitempty"`
+	Budget         task.Budget          `json:"budget,omitempty"`
+	Hints          []string             `json:"hints,omitempty"`
+}
+
+// Rendered is a template with its parameters filled in: the settings of a
+// new task
+type Rendered struct {
+	Goal           string
+	Script         *task.Script
+	ScriptParams   map[string]string
+	Postconditions []task.Postcondition
+	Verification   string
+	Model          string
+	Budget         task.Budget
+	Hints          []string
+}
+
+// path returns the file of a template
+func path(name string) (string, error) {
+	if *config.TemplatesDir == "" {
+		return "", ErrDisabled
+	}
+	if !validName.MatchString(name) {
+		return "", fmt.Errorf("invalid template name %q, use lowercase letters, digits and dashes", name)
+	}
+	return filepath.Join(*config.TemplatesDir, name+".json"), nil
+}
+
+// List returns the valid templates of the catalogue by name; invalid ones
+// are logged and left out
+func List() []Template {
+	if *config.TemplatesDir == "" {
+		return nil
+	}
+	entries, err := os.ReadDir(*config.TemplatesDir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			logger.Warn("failed to read templates", "dir", *config.TemplatesDir, "error", err)
+		}
+		return nil
+	}
+	var templates []Template
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if entry.IsDir() || !ok || !validName.MatchString(name) {
+			continue
+		}
+		t, err := Get(name)
+		if err != nil {
+			logger.Warn("skipping template", "name", name, "error", err)
+			continue
+		}
+		templates = append(templates, *t)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Get reads and validates a template
+func Get(name string) (*Template, error) {
+	p, err := path(name)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	var t Template
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&t); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	t.Name = name
+	if err := t.validate(); err != nil {
+		return nil, fmt.Errorf("template %s: %w", name, err)
+	}
+	return &t, nil
+}
+
+// validate checks a template by rendering it with its defaults, or sample
+// values for required parameters
+func (t *Template) validate() error {
+	if (t.Goal == "") == (t.Script == nil) {
+		return errors.New("a template needs either a goal or a script")
+	}
+	sample := make(map[string]interface{}, len(t.Params))
+	seen := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		if !paramName.MatchString(p.Name) {
+			return fmt.Errorf("invalid parameter name %q", p.Name)
+		}
+		if seen[p.Name] {
+			return fmt.Errorf("parameter %q is declared twice", p.Name)
+		}
+		seen[p.Name] = true
+		switch p.Type {
+		case "", TypeString, TypeInt, TypeNumber, TypeBool, TypeURL, TypePath:
+		case TypeEnum:
+			if len(p.Enum) == 0 {
+				return fmt.Errorf("parameter %q: an enum needs its values", p.Name)
+			}
+		default:
+			return fmt.Errorf("parameter %q: unknown type %q", p.Name, p.Type)
+		}
+		if p.Default != "" {
+			if _, err := p.value(p.Default); err != nil {
+				return fmt.Errorf("parameter %q: default: %w", p.Name, err)
+			}
+		} else {
+			sample[p.Name] = p.sample()
+		}
+	}
+	if t.Script != nil {
+		if len(t.Postconditions) > 0 {
+			return errors.New("a script checks its results with assert steps, not postconditions")
+		}
+		if err := task.ValidateScript(t.Script); err != nil {
+			return fmt.Errorf("invalid script: %w", err)
+		}
+		declared := make(map[string]bool, len(t.Script.Params))
+		for _, p := range t.Script.Params {
+			declared[p.Name] = true
+		}
+		for _, p := range t.Params {
+			if !declared[p.Name] {
+				return fmt.Errorf("parameter %q is not a parameter of the script", p.Name)
+			}
+		}
+	}
+	if err := task.ValidateBudget(t.Budget); err != nil {
+		return err
+	}
+	_, err := t.Render(sample)
+	return err
+}
+
+// sample is a valid value of a required parameter
+func (p Param) sample() string {
+	switch p.Type {
+	case TypeInt, TypeNumber:
+		return "1"
+	case TypeBool:
+		return "true"
+	case TypeEnum:
+		return p.Enum[0]
+	case TypeURL:
+		return "https://example.com"
+	case TypePath:
+		return "/tmp/x"
+	}
+	return "x"
+}
+
+// value checks a value against the type of p and returns it as text
+func (p Param) value(v interface{}) (string, error) {
+	var s string
+	switch v := v.(type) {
+	case string:
+		s = v
+	case float64:
+		s = strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		s = strconv.FormatBool(v)
+	default:
+		return "", fmt.Errorf("must be a string, number or boolean")
+	}
+	switch p.Type {
+	case TypeInt:
+		if _, err := strconv.ParseInt(s, 10, 64); err != nil {
+			return "", fmt.Errorf("%q is not an integer", s)
+		}
+	case TypeNumber:
+		if _, err := strconv.ParseFloat(s, 64); err != nil {
+			return "", fmt.Errorf("%q is not a number", s)
+		}
+	case TypeBool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return "", fmt.Errorf("%q is not true or false", s)
+		}
+		s = strconv.FormatBool(b)
+	case TypeEnum:
+		for _, e := range p.Enum {
+			if s == e {
+				return s, nil
+			}
+		}
+		return "", fmt.Errorf("%q is not one of %s", s, strings.Join(p.Enum, ", "))
+	case TypeURL:
+		u, err := url.Parse(s)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return "", fmt.Errorf("%q is not an absolute URL", s)
+		}
+	case TypePath:
+		if strings.TrimSpace(s) == "" {
+			return "", fmt.Errorf("path is empty")
+		}
+	}
+	return s, nil
+}
+
+// Render fills in the parameters of a template, values given as JSON
+// strings, numbers or booleans
+func (t *Template) Render(params map[string]interface{}) (*Rendered, error) {
+	values := make(map[string]string, len(t.Params))
+	declared := make(map[string]bool, len(t.Params))
+	for _, p := range t.Params {
+		declared[p.Name] = true
+		v, ok := params[p.Name]
+		if !ok {
+			if p.Default == "" {
+				return nil, fmt.Errorf("parameter %q is required", p.Name)
+			}
+			v = p.Default
+		}
+		s, err := p.value(v)
+		if err != nil {
+			return nil, fmt.Errorf("parameter %q: %w", p.Name, err)
+		}
+		values[p.Name] = s
+	}
+	for name := range params {
+		if !declared[name] {
+			return nil, fmt.Errorf("unknown parameter %q", name)
+		}
+	}
+
+	var err error
+	render := func(text string) string {
+		return paramRef.ReplaceAllStringFunc(text, func(ref string) string {
+			name := paramRef.FindStringSubmatch(ref)[1]
+			value, ok := values[name]
+			if !ok && err == nil {
+				err = fmt.Errorf("undefined parameter %q", name)
+			}
+			return value
+		})
+	}
+
+	r := &Rendered{
+		Goal:         render(t.Goal),
+		Script:       t.Script,
+		Verification: t.Verification,
+		Model:        t.Model,
+		Budget:       t.Budget,
+	}
+	if t.Script != nil {
+		// A script renders its own {{name}} references as it runs
+		r.ScriptParams = values
+		if r.Goal == "" {
+			r.Goal = t.Name
+		}
+	}
+	for _, c := range t.Postconditions {
+		for _, field := range []*string{&c.WindowClass, &c.Title, &c.Text, &c.Path, &c.Process, &c.Pattern} {
+			*field = render(*field)
+		}
+		r.Postconditions = append(r.Postconditions, c)
+	}
+	for _, hint := range t.Hints {
+		r.Hints = append(r.Hints, render(hint))
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := task.ValidatePostconditions(r.Postconditions); err != nil {
+		return nil, fmt.Errorf("invalid postconditions: %w", err)
+	}
+	return r, nil
+}
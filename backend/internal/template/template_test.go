@@ -0,0 +1,218 @@
+package template
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/task"
+)
+
+func openURL() *Template {
+	return &Template{
+		Name: "open-url",
+		Goal: "Open {{ url }} in {{browser}} and wait {{seconds}}s",
+		Params: []Param{
+			{Name: "url", Type: TypeURL},
+			{Name: "browser", Type: TypeEnum, Enum: []string{"firefox", "chromium"}, Default: "firefox"},
+			{Name: "seconds", Type: TypeInt, Default: "5"},
+			{Name: "verbose", Type: TypeBool, Default: "false"},
+		},
+		Postconditions: []task.Postcondition{{Type: task.CheckWindowVisible, WindowClass: "{{browser}}"}},
+		Hints:          []string{"use {{browser}}", "verbose: {{verbose}}"},
+		Model:          "small",
+	}
+}
+
+func TestRender(t *testing.T) {
+	tests := []struct {
+		name    string
+		params  map[string]interface{}
+		goal    string
+		hints   []string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			params: map[string]interface{}{"url": "https://a.example"},
+			goal:   "Open https://a.example in firefox and wait 5s",
+			hints:  []string{"use firefox", "verbose: false"},
+		},
+		{
+			name:   "JSON values",
+			params: map[string]interface{}{"url": "https://a.example/x?y=1", "browser": "chromium", "seconds": float64(10), "verbose": true},
+			goal:   "Open https://a.example/x?y=1 in chromium and wait 10s",
+			hints:  []string{"use chromium", "verbose: true"},
+		},
+		{
+			name:   "values as text",
+			params: map[string]interface{}{"url": "https://a.example", "seconds": "7", "verbose": "1"},
+			goal:   "Open https://a.example in firefox and wait 7s",
+			hints:  []string{"use firefox", "verbose: true"},
+		},
+		{name: "required missing", params: nil, wantErr: `parameter "url" is required`},
+		{name: "unknown parameter", params: map[string]interface{}{"url": "https://a.example", "tab": "new"}, wantErr: `unknown parameter "tab"`},
+		{name: "relative URL", params: map[string]interface{}{"url": "a.example"}, wantErr: `parameter "url": "a.example" is not an absolute URL`},
+		{name: "not in the enum", params: map[string]interface{}{"url": "https://a.example", "browser": "safari"}, wantErr: `"safari" is not one of firefox, chromium`},
+		{name: "fraction for an int", params: map[string]interface{}{"url": "https://a.example", "seconds": 1.5}, wantErr: `"1.5" is not an integer`},
+		{name: "not a bool", params: map[string]interface{}{"url": "https://a.example", "verbose": "maybe"}, wantErr: `"maybe" is not true or false`},
+		{name: "object value", params: map[string]interface{}{"url": map[string]interface{}{}}, wantErr: "must be a string, number or boolean"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r, err := openURL().Render(tt.params)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if r.Goal != tt.goal {
+				t.Errorf("goal %q, want %q", r.Goal, tt.goal)
+			}
+			if !reflect.DeepEqual(r.Hints, tt.hints) {
+				t.Errorf("hints %q, want %q", r.Hints, tt.hints)
+			}
+			if len(r.Postconditions) != 1 || r.Postconditions[0].WindowClass != strings.Fields(tt.hints[0])[1] {
+				t.Errorf("postconditions %+v", r.Postconditions)
+			}
+			if r.Model != "small" || r.Script != nil || r.ScriptParams != nil {
+				t.Errorf("rendered %+v", r)
+			}
+		})
+	}
+}
+
+func TestRenderDoesNotChangeTheTemplate(t *testing.T) {
+	tmpl := openURL()
+	if _, err := tmpl.Render(map[string]interface{}{"url": "https://a.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(tmpl, openURL()) {
+		t.Errorf("template changed to %+v", tmpl)
+	}
+}
+
+func TestRenderUndefinedReference(t *testing.T) {
+	tmpl := &Template{Name: "typo", Goal: "Open {{ ulr }}", Params: []Param{{Name: "url"}}}
+	if _, err := tmpl.Render(map[string]interface{}{"url": "x"}); err == nil || err.Error() != `undefined parameter "ulr"` {
+		t.Errorf("error = %v", err)
+	}
+}
+
+func TestRenderScript(t *testing.T) {
+	tmpl := &Template{
+		Name:   "search",
+		Params: []Param{{Name: "query"}, {Name: "engine", Default: "web"}},
+		Script: &task.Script{
+			Params: []task.ScriptParam{{Name: "query"}, {Name: "engine"}},
+			Steps:  []task.ScriptStep{{Text: "{{query}}"}},
+		},
+	}
+	r, err := tmpl.Render(map[string]interface{}{"query": "go templates"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Goal != "search" || r.Script != tmpl.Script {
+		t.Errorf("goal %q, script %p", r.Goal, r.Script)
+	}
+	// The script renders its own references as it runs
+	if want := map[string]string{"query": "go templates", "engine": "web"}; !reflect.DeepEqual(r.ScriptParams, want) {
+		t.Errorf("script params %v, want %v", r.ScriptParams, want)
+	}
+	if r.Script.Steps[0].Text != "{{query}}" {
+		t.Errorf("step rendered to %q", r.Script.Steps[0].Text)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	script := func(params ...string) *task.Script {
+		s := &task.Script{Steps: []task.ScriptStep{{Text: "x"}}}
+		for _, p := range params {
+			s.Params = append(s.Params, task.ScriptParam{Name: p})
+		}
+		return s
+	}
+	tests := []struct {
+		name    string
+		tmpl    Template
+		wantErr string
+	}{
+		{"goal", *openURL(), ""},
+		{"script", Template{Params: []Param{{Name: "q"}}, Script: script("q")}, ""},
+		{"required params get samples", Template{Goal: "{{a}} {{b}} {{c}}", Params: []Param{{Name: "a", Type: TypePath}, {Name: "b", Type: TypeNumber}, {Name: "c", Type: TypeEnum, Enum: []string{"x"}}}}, ""},
+		{"neither goal nor script", Template{}, "either a goal or a script"},
+		{"goal and script", Template{Goal: "x", Script: script()}, "either a goal or a script"},
+		{"bad parameter name", Template{Goal: "x", Params: []Param{{Name: "my-param"}}}, `invalid parameter name "my-param"`},
+		{"duplicate parameter", Template{Goal: "x", Params: []Param{{Name: "a"}, {Name: "a"}}}, `parameter "a" is declared twice`},
+		{"enum without values", Template{Goal: "x", Params: []Param{{Name: "a", Type: TypeEnum}}}, "an enum needs its values"},
+		{"unknown type", Template{Goal: "x", Params: []Param{{Name: "a", Type: "date"}}}, `unknown type "date"`},
+		{"bad default", Template{Goal: "x", Params: []Param{{Name: "a", Type: TypeInt, Default: "many"}}}, `parameter "a": default: "many" is not an integer`},
+		{"undefined reference", Template{Goal: "{{b}}", Params: []Param{{Name: "a"}}}, `undefined parameter "b"`},
+		{"script with postconditions", Template{Script: script(), Postconditions: []task.Postcondition{{Type: task.CheckFileExists, Path: "/tmp"}}}, "assert steps, not postconditions"},
+		{"parameter the script lacks", Template{Params: []Param{{Name: "q"}}, Script: script()}, `parameter "q" is not a parameter of the script`},
+		{"invalid rendered postcondition", Template{Goal: "x", Postconditions: []task.Postcondition{{Type: task.CheckFileExists}}}, "invalid postconditions"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.tmpl.validate()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCatalogue(t *testing.T) {
+	dir := *config.TemplatesDir
+	*config.TemplatesDir = t.TempDir()
+	t.Cleanup(func() { *config.TemplatesDir = dir })
+	for name, body := range map[string]string{
+		"open-url.json": `{"goal": "Open {{url}}", "params": [{"name": "url", "type": "url"}]}`,
+		"broken.json":   `{"goal": "Open {{url}}"}`,
+		"unknown.json":  `{"goal": "x", "retries": 3}`,
+		"Bad_Name.json": `{"goal": "x"}`,
+		"notes.txt":     `not a template`,
+	} {
+		if err := os.WriteFile(filepath.Join(*config.TemplatesDir, name), []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	list := List()
+	if len(list) != 1 || list[0].Name != "open-url" {
+		t.Errorf("List() = %+v, want only open-url", list)
+	}
+	if tmpl, err := Get("open-url"); err != nil || tmpl.Name != "open-url" || len(tmpl.Params) != 1 {
+		t.Errorf("Get(open-url) = %+v, %v", tmpl, err)
+	}
+	for name, wantErr := range map[string]string{
+		"missing":  ErrNotFound.Error(),
+		"broken":   `undefined parameter "url"`,
+		"unknown":  `unknown field "retries"`,
+		"Bad_Name": "invalid template name",
+		"../x":     "invalid template name",
+	} {
+		if _, err := Get(name); err == nil || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("Get(%q) error = %v, want %q", name, err, wantErr)
+		}
+	}
+
+	*config.TemplatesDir = ""
+	if _, err := Get("open-url"); !errors.Is(err, ErrDisabled) || List() != nil {
+		t.Errorf("disabled templates: Get error %v", err)
+	}
+}
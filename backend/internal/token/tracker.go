@@ -2,12 +2,14 @@ package token
 
 import (
 	"encoding/json"
-	"log"
 	"sync"
 
+	"useless-agent/internal/logging"
 	"useless-agent/internal/websocket"
 )
 
+var logger = logging.For("token")
+
 // Token tracking globals
 var (
 	totalTokensUsed int
@@ -23,7 +25,7 @@ func AddTokensAndSendUpdate(tokens int) {
 
 	// Send update to all websocket clients (non-blocking)
 	go websocket.SendTokenUpdate(currentTotal)
-	log.Printf("Token usage updated: %d (added: %d)", currentTotal, tokens)
+	logger.Debug("token usage updated", "total", currentTotal, "added", tokens)
 }
 
 // ResetTokenCounter resets the token counter and sends update
@@ -34,7 +36,7 @@ func ResetTokenCounter() {
 
 	// Send reset update to all websocket clients (non-blocking)
 	go websocket.SendTokenUpdate(0)
-	log.Printf("Token counter reset")
+	logger.Info("token counter reset")
 }
 
 // GetTotalTokens returns the current total tokens used
@@ -0,0 +1,324 @@
+package tracing
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Spans are exported in batches of up to batchSize, at least every flushInterval
+const (
+	batchSize     = 256
+	flushInterval = 2 * time.Second
+	queueSize     = 4096
+)
+
+// exporter sends one OTLP ExportTraceServiceRequest
+type exporter interface {
+	export(body []byte) error
+	close() error
+}
+
+// processor batches ended spans and hands them to the exporters
+type processor struct {
+	serviceName string
+	exporters   []exporter
+
+	queue chan *Span
+	done  chan struct{}
+
+	// closed guards against enqueueing while shutdown closes the queue
+	mutex  sync.RWMutex
+	closed bool
+}
+
+func newProcessor(serviceName string, exporters []exporter) *processor {
+	p := &processor{
+		serviceName: serviceName,
+		exporters:   exporters,
+		queue:       make(chan *Span, queueSize),
+		done:        make(chan struct{}),
+	}
+	go p.run()
+	return p
+}
+
+// enqueue never blocks the agent loop, spans are dropped when the exporter
+// falls too far behind
+func (p *processor) enqueue(s *Span) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	if p.closed {
+		return
+	}
+	select {
+	case p.queue <- s:
+	default:
+		logger.Warn("span queue full, dropping span", "span", s.name)
+	}
+}
+
+func (p *processor) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(flushInterval)
+	defer ticker.Stop()
+
+	var batch []*Span
+	for {
+		select {
+		case s, ok := <-p.queue:
+			if !ok {
+				p.flush(batch)
+				return
+			}
+			batch = append(batch, s)
+			if len(batch) >= batchSize {
+				p.flush(batch)
+				batch = nil
+			}
+		case <-ticker.C:
+			p.flush(batch)
+			batch = nil
+		}
+	}
+}
+
+func (p *processor) flush(batch []*Span) {
+	if len(batch) == 0 {
+		return
+	}
+	body, err := json.Marshal(p.encode(batch))
+	if err != nil {
+		logger.Error("failed to encode spans", "error", err)
+		return
+	}
+	for _, e := range p.exporters {
+		if err := e.export(body); err != nil {
+			logger.Warn("failed to export spans", "spans", len(batch), "error", err)
+		}
+	}
+}
+
+// shutdown exports what is queued and closes the exporters
+func (p *processor) shutdown() {
+	p.mutex.Lock()
+	if p.closed {
+		p.mutex.Unlock()
+		return
+	}
+	p.closed = true
+	close(p.queue)
+	p.mutex.Unlock()
+
+	<-p.done
+	for _, e := range p.exporters {
+		e.close()
+	}
+}
+
+// OTLP JSON encoding, see opentelemetry-proto trace/v1 and the JSON mapping:
+// IDs are hex, 64 bit integers are strings
+
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+func (p *processor) encode(batch []*Span) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		s.mutex.Lock()
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attrs),
+		}
+		if s.parentID != ([8]byte{}) {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		if s.errMsg != "" {
+			span.Status = otlpStatus{Code: statusCodeError, Message: s.errMsg}
+		}
+		s.mutex.Unlock()
+		spans = append(spans, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpKeyValue{stringAttribute("service.name", p.serviceName)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "useless-agent"}, Spans: spans}},
+	}}}
+}
+
+// encodeAttributes keeps the last value of each key
+func encodeAttributes(attrs []attribute) []otlpKeyValue {
+	index := make(map[string]int, len(attrs))
+	var encoded []otlpKeyValue
+	for _, a := range attrs {
+		kv := otlpKeyValue{Key: a.key, Value: encodeValue(a.value)}
+		if i, ok := index[a.key]; ok {
+			encoded[i] = kv
+			continue
+		}
+		index[a.key] = len(encoded)
+		encoded = append(encoded, kv)
+	}
+	return encoded
+}
+
+func encodeValue(v interface{}) otlpAnyValue {
+	switch v := v.(type) {
+	case string:
+		return otlpAnyValue{StringValue: &v}
+	case bool:
+		return otlpAnyValue{BoolValue: &v}
+	case int:
+		return intValue(int64(v))
+	case int64:
+		return intValue(v)
+	case int32:
+		return intValue(int64(v))
+	case float64:
+		return otlpAnyValue{DoubleValue: &v}
+	case float32:
+		f := float64(v)
+		return otlpAnyValue{DoubleValue: &f}
+	case time.Duration:
+		f := v.Seconds()
+		return otlpAnyValue{DoubleValue: &f}
+	case error:
+		s := v.Error()
+		return otlpAnyValue{StringValue: &s}
+	default:
+		s := fmt.Sprint(v)
+		return otlpAnyValue{StringValue: &s}
+	}
+}
+
+func intValue(v int64) otlpAnyValue {
+	s := strconv.FormatInt(v, 10)
+	return otlpAnyValue{IntValue: &s}
+}
+
+func stringAttribute(key, value string) otlpKeyValue {
+	return otlpKeyValue{Key: key, Value: otlpAnyValue{StringValue: &value}}
+}
+
+// otlpExporter posts to an OTLP/HTTP collector with the JSON encoding
+type otlpExporter struct {
+	url    string
+	client *http.Client
+}
+
+func newOTLPExporter(endpoint string) *otlpExporter {
+	url := strings.TrimRight(endpoint, "/")
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+	return &otlpExporter{url: url, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+func (e *otlpExporter) export(body []byte) error {
+	resp, err := e.client.Post(e.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+func (e *otlpExporter) close() error {
+	e.client.CloseIdleConnections()
+	return nil
+}
+
+// fileExporter appends one request per line, the format the collector's
+// otlpjsonfile receiver reads
+type fileExporter struct {
+	file *os.File
+}
+
+func newFileExporter(path string) (*fileExporter, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create trace directory: %w", err)
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trace file: %w", err)
+	}
+	return &fileExporter{file: f}, nil
+}
+
+func (e *fileExporter) export(body []byte) error {
+	_, err := e.file.Write(append(body, '\n'))
+	return err
+}
+
+func (e *fileExporter) close() error {
+	return e.file.Close()
+}
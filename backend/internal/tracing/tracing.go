@@ -0,0 +1,201 @@
+// Package tracing records nested spans of the agent loop (task, subtask,
+// iteration and its phases) and exports them as OTLP JSON. Without an
+// exporter Start returns a nil span and every span method is a no-op.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/logging"
+)
+
+var logger = logging.For("tracing")
+
+// Options configure where spans are exported to
+type Options struct {
+	ServiceName  string
+	OTLPEndpoint string // OTLP/HTTP base URL or full /v1/traces URL
+	File         string // OTLP JSON lines
+}
+
+// Span is one timed operation, child of the span in the context it was
+// started with
+type Span struct {
+	traceID  [16]byte
+	spanID   [8]byte
+	parentID [8]byte
+	name     string
+	start    time.Time
+
+	mutex  sync.Mutex
+	end    time.Time
+	attrs  []attribute
+	errMsg string
+	ended  bool
+}
+
+type attribute struct {
+	key   string
+	value interface{}
+}
+
+var (
+	active      *processor
+	activeMutex sync.RWMutex
+)
+
+// Setup starts exporting spans, replacing a previous setup
+func Setup(opts Options) error {
+	var exporters []exporter
+	if opts.OTLPEndpoint != "" {
+		exporters = append(exporters, newOTLPExporter(opts.OTLPEndpoint))
+	}
+	if opts.File != "" {
+		e, err := newFileExporter(opts.File)
+		if err != nil {
+			return err
+		}
+		exporters = append(exporters, e)
+	}
+
+	var p *processor
+	if len(exporters) > 0 {
+		p = newProcessor(opts.ServiceName, exporters)
+	}
+
+	activeMutex.Lock()
+	previous := active
+	active = p
+	activeMutex.Unlock()
+
+	if previous != nil {
+		previous.shutdown()
+	}
+	return nil
+}
+
+// OptionsFromFlags builds the options from the -trace-* command line flags
+func OptionsFromFlags() Options {
+	return Options{
+		ServiceName:  *config.TraceServiceName,
+		OTLPEndpoint: *config.TraceOTLPEndpoint,
+		File:         *config.TraceFile,
+	}
+}
+
+// Shutdown exports the pending spans and stops tracing
+func Shutdown() {
+	activeMutex.Lock()
+	previous := active
+	active = nil
+	activeMutex.Unlock()
+
+	if previous != nil {
+		previous.shutdown()
+	}
+}
+
+// Enabled reports whether spans are being exported
+func Enabled() bool {
+	return current() != nil
+}
+
+func current() *processor {
+	activeMutex.RLock()
+	defer activeMutex.RUnlock()
+	return active
+}
+
+type spanKey struct{}
+
+// Start begins a span named name as a child of the span in ctx, if any.
+// attrs are key/value pairs as for slog, e.g. "action.name", "keyTap".
+func Start(ctx context.Context, name string, attrs ...interface{}) (context.Context, *Span) {
+	if current() == nil {
+		return ctx, nil
+	}
+
+	s := &Span{name: name, start: time.Now()}
+	if parent := FromContext(ctx); parent != nil {
+		s.traceID = parent.traceID
+		s.parentID = parent.spanID
+	} else {
+		rand.Read(s.traceID[:])
+	}
+	rand.Read(s.spanID[:])
+	s.SetAttributes(attrs...)
+	return context.WithValue(ctx, spanKey{}, s), s
+}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(spanKey{}).(*Span)
+	return s
+}
+
+// SetAttributes adds key/value pairs to the span, later values win
+func (s *Span) SetAttributes(attrs ...interface{}) {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for i := 0; i < len(attrs); i += 2 {
+		key, ok := attrs[i].(string)
+		if !ok {
+			key = fmt.Sprint(attrs[i])
+		}
+		var value interface{} = "!MISSING"
+		if i+1 < len(attrs) {
+			value = attrs[i+1]
+		}
+		s.attrs = append(s.attrs, attribute{key: key, value: value})
+	}
+}
+
+// RecordError marks the span as failed, context cancellation included
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mutex.Lock()
+	s.errMsg = err.Error()
+	s.mutex.Unlock()
+	if errors.Is(err, context.Canceled) {
+		s.SetAttributes("canceled", true)
+	}
+}
+
+// End finishes the span and queues it for export, later calls are ignored
+func (s *Span) End() {
+	if s == nil {
+		return
+	}
+	s.mutex.Lock()
+	if s.ended {
+		s.mutex.Unlock()
+		return
+	}
+	s.ended = true
+	s.end = time.Now()
+	s.mutex.Unlock()
+
+	if p := current(); p != nil {
+		p.enqueue(s)
+	}
+}
+
+// TraceID returns the hex trace ID, empty for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return hex.EncodeToString(s.traceID[:])
+}
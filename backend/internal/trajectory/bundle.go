@@ -0,0 +1,65 @@
+package trajectory
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+)
+
+// WriteZip streams the bundle of a task as a zip archive
+func WriteZip(taskID string, w io.Writer) error {
+	dir, err := BundleDir(taskID)
+	if err != nil {
+		return err
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile)); err != nil {
+		return fmt.Errorf("no trajectory recorded for task %s", taskID)
+	}
+
+	zw := zip.NewWriter(w)
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		// Screenshots are already compressed, store them as-is
+		header := &zip.FileHeader{Name: filepath.ToSlash(filepath.Join(taskID, rel)), Method: zip.Deflate}
+		if filepath.Ext(path) == ".png" {
+			header.Method = zip.Store
+		}
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(entry, f)
+		return err
+	})
+	if err != nil {
+		zw.Close()
+		return err
+	}
+	return zw.Close()
+}
+
+// Exists reports whether a bundle has been recorded for the task
+func Exists(taskID string) bool {
+	dir, err := BundleDir(taskID)
+	if err != nil {
+		return false
+	}
+	_, err = os.Stat(filepath.Join(dir, ManifestFile))
+	return err == nil
+}
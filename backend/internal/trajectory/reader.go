@@ -0,0 +1,119 @@
+package trajectory
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"useless-agent/internal/llm"
+)
+
+// Bundle is a recorded trajectory opened for reading
+type Bundle struct {
+	Manifest Manifest
+	fsys     fs.FS
+	closer   io.Closer
+}
+
+// OpenBundle opens a bundle directory or a zip archive downloaded from the
+// trajectory endpoint
+func OpenBundle(bundlePath string) (*Bundle, error) {
+	info, err := os.Stat(bundlePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open trajectory bundle: %w", err)
+	}
+
+	b := &Bundle{}
+	if info.IsDir() {
+		b.fsys = os.DirFS(bundlePath)
+	} else {
+		zr, err := zip.OpenReader(bundlePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open trajectory zip: %w", err)
+		}
+		b.closer = zr
+
+		// Archives written by WriteZip keep the bundle in a <taskID>/ directory
+		root := "."
+		for _, f := range zr.File {
+			if path.Base(f.Name) == ManifestFile {
+				root = path.Dir(f.Name)
+				break
+			}
+		}
+		if b.fsys, err = fs.Sub(zr, root); err != nil {
+			zr.Close()
+			return nil, err
+		}
+	}
+
+	if err := b.ReadJSON(ManifestFile, &b.Manifest); err != nil {
+		b.Close()
+		return nil, err
+	}
+	return b, nil
+}
+
+// Close releases the underlying archive, if any
+func (b *Bundle) Close() error {
+	if b.closer != nil {
+		return b.closer.Close()
+	}
+	return nil
+}
+
+// ReadJSON decodes a bundle file given by its manifest path
+func (b *Bundle) ReadJSON(rel string, v interface{}) error {
+	data, err := fs.ReadFile(b.fsys, path.Clean(filepath.ToSlash(rel)))
+	if err != nil {
+		return fmt.Errorf("failed to read %s from trajectory bundle: %w", rel, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to decode %s from trajectory bundle: %w", rel, err)
+	}
+	return nil
+}
+
+// IterationJSON decodes a named file of an iteration; it reports false if the
+// iteration has no such file
+func (b *Bundle) IterationJSON(it *Iteration, name string, v interface{}) (bool, error) {
+	rel, ok := it.Files[name]
+	if !ok {
+		return false, nil
+	}
+	return true, b.ReadJSON(rel, v)
+}
+
+// IterationImage decodes a named screenshot of an iteration
+func (b *Bundle) IterationImage(it *Iteration, name string) (image.Image, error) {
+	rel, ok := it.Files[name]
+	if !ok {
+		return nil, fmt.Errorf("iteration %d has no %s screenshot", it.Number, name)
+	}
+	f, err := b.fsys.Open(path.Clean(filepath.ToSlash(rel)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s from trajectory bundle: %w", rel, err)
+	}
+	defer f.Close()
+	return png.Decode(f)
+}
+
+// IterationExchanges returns the LLM exchanges recorded during an iteration, in call order
+func (b *Bundle) IterationExchanges(it *Iteration) ([]llm.Exchange, error) {
+	exchanges := make([]llm.Exchange, 0, len(it.LLMCalls))
+	for _, rel := range it.LLMCalls {
+		var ex llm.Exchange
+		if err := b.ReadJSON(rel, &ex); err != nil {
+			return nil, err
+		}
+		exchanges = append(exchanges, ex)
+	}
+	return exchanges, nil
+}
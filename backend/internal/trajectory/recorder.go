@@ -0,0 +1,353 @@
+package trajectory
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"useless-agent/internal/config"
+	"useless-agent/internal/llm"
+	"useless-agent/internal/logging"
+)
+
+var logger = logging.For("trajectory")
+
+// ManifestFile is the name of the manifest at the root of every bundle
+const ManifestFile = "manifest.json"
+
+// Manifest describes a recorded task run and indexes every file in the bundle
+type Manifest struct {
+	Version    int             `json:"version"`
+	TaskID     string          `json:"taskId"`
+	Goal       string          `json:"goal"`
+	StartedAt  time.Time       `json:"startedAt"`
+	FinishedAt *time.Time      `json:"finishedAt,omitempty"`
+	Status     string          `json:"status"`
+	Message    string          `json:"message,omitempty"`
+	Subtasks   interface{}     `json:"subtasks,omitempty"`
+	Iterations []*Iteration    `json:"iterations"`
+	LLMCalls   []LLMCallRecord `json:"llmCalls"`
+}
+
+// Iteration is one act-verify cycle of the agent loop
+type Iteration struct {
+	Number      int64              `json:"iteration"`
+	SubtaskID   int                `json:"subtaskId"`
+	Subtask     string             `json:"subtask"`
+	StartedAt   time.Time          `json:"startedAt"`
+	Files       map[string]string  `json:"files"`
+	TimingsMs   map[string]float64 `json:"timingsMs"`
+	LLMCalls    []string           `json:"llmCalls"`
+	dir         string
+	recorder    *Recorder
+	phaseStarts map[string]time.Time
+}
+
+// LLMCallRecord indexes a recorded LLM exchange
+type LLMCallRecord struct {
+	File       string    `json:"file"`
+	Kind       string    `json:"kind"`
+	Iteration  int64     `json:"iteration"`
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs float64   `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
+
+	EstimatedTokens int `json:"estimatedTokens,omitempty"`
+}
+
+// Recorder writes the trajectory bundle of one task. All methods are safe
+// to call on a nil *Recorder, which records nothing.
+type Recorder struct {
+	dir      string
+	manifest Manifest
+	current  *Iteration
+	llmCount int
+	mutex    sync.Mutex
+	// Serializes manifest writes, which share a temporary file
+	writeMutex sync.Mutex
+}
+
+// NewRecorder creates the bundle directory for a task. It returns nil if
+// recording is disabled or the directory can not be created.
+func NewRecorder(taskID, goal string) *Recorder {
+	if *config.TrajectoryDir == "" {
+		return nil
+	}
+
+	dir, err := BundleDir(taskID)
+	if err != nil {
+		logger.Warn("trajectory recording disabled", "taskId", taskID, "error", err)
+		return nil
+	}
+	if *config.TrajectoryKeep > 0 {
+		prune(*config.TrajectoryKeep - 1)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "llm"), 0o755); err != nil {
+		logger.Warn("trajectory recording disabled", "taskId", taskID, "error", err)
+		return nil
+	}
+
+	r := &Recorder{
+		dir: dir,
+		manifest: Manifest{
+			Version:    1,
+			TaskID:     taskID,
+			Goal:       goal,
+			StartedAt:  time.Now(),
+			Status:     "in-progress",
+			Iterations: []*Iteration{},
+			LLMCalls:   []LLMCallRecord{},
+		},
+	}
+	r.writeManifest()
+	logger.Info("recording trajectory", "taskId", taskID, "dir", dir)
+	return r
+}
+
+// BundleDir returns the bundle directory of a task, rejecting IDs that could escape the trajectory root
+func BundleDir(taskID string) (string, error) {
+	if taskID == "" || taskID != filepath.Base(taskID) || taskID == "." || taskID == ".." {
+		return "", fmt.Errorf("invalid task ID %q", taskID)
+	}
+	return filepath.Join(*config.TrajectoryDir, taskID), nil
+}
+
+// prune deletes the oldest bundles of the trajectory root beyond keep
+func prune(keep int) {
+	entries, err := os.ReadDir(*config.TrajectoryDir)
+	if err != nil {
+		return
+	}
+	type bundle struct {
+		dir     string
+		started time.Time
+	}
+	var bundles []bundle
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		dir := filepath.Join(*config.TrajectoryDir, e.Name())
+		info, err := os.Stat(filepath.Join(dir, ManifestFile))
+		if err != nil {
+			// Not a bundle, leave it alone
+			continue
+		}
+		bundles = append(bundles, bundle{dir, info.ModTime()})
+	}
+	if len(bundles) <= keep {
+		return
+	}
+	sort.Slice(bundles, func(i, j int) bool { return bundles[i].started.Before(bundles[j].started) })
+	for _, b := range bundles[:len(bundles)-keep] {
+		if err := os.RemoveAll(b.dir); err != nil {
+			logger.Warn("failed to delete old trajectory", "dir", b.dir, "error", err)
+			continue
+		}
+		logger.Info("deleted old trajectory", "dir", b.dir)
+	}
+}
+
+// SetSubtasks stores the current plan in the manifest
+func (r *Recorder) SetSubtasks(subtasks interface{}) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	r.manifest.Subtasks = subtasks
+	r.mutex.Unlock()
+	r.writeManifest()
+}
+
+// BeginIteration starts recording a new iteration. The manifest is written
+// then, with the files of the iteration before, so a crashed run still
+// leaves a usable bundle.
+func (r *Recorder) BeginIteration(number int64, subtaskID int, subtask string) *Iteration {
+	if r == nil {
+		return nil
+	}
+
+	it := &Iteration{
+		Number:      number,
+		SubtaskID:   subtaskID,
+		Subtask:     subtask,
+		StartedAt:   time.Now(),
+		Files:       make(map[string]string),
+		TimingsMs:   make(map[string]float64),
+		LLMCalls:    []string{},
+		dir:         fmt.Sprintf("iteration-%04d", number),
+		recorder:    r,
+		phaseStarts: make(map[string]time.Time),
+	}
+	if err := os.MkdirAll(filepath.Join(r.dir, it.dir), 0o755); err != nil {
+		logger.Error("failed to create trajectory iteration directory", "error", err)
+	}
+
+	r.mutex.Lock()
+	r.manifest.Iterations = append(r.manifest.Iterations, it)
+	r.current = it
+	r.mutex.Unlock()
+	r.writeManifest()
+	return it
+}
+
+// SaveScreenshot stores an image as PNG under the given name
+func (it *Iteration) SaveScreenshot(name string, img image.Image) {
+	if it == nil || img == nil {
+		return
+	}
+	rel := filepath.Join(it.dir, name+".png")
+	f, err := os.Create(filepath.Join(it.recorder.dir, rel))
+	if err != nil {
+		logger.Error("failed to save trajectory screenshot", "file", rel, "error", err)
+		return
+	}
+	defer f.Close()
+
+	encoder := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := encoder.Encode(f, img); err != nil {
+		logger.Error("failed to encode trajectory screenshot", "file", rel, "error", err)
+		return
+	}
+	it.addFile(name, rel)
+}
+
+// SaveJSON stores a value as indented JSON under the given name
+func (it *Iteration) SaveJSON(name string, v interface{}) {
+	if it == nil {
+		return
+	}
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		logger.Error("failed to marshal trajectory data", "name", name, "error", err)
+		return
+	}
+	it.saveBytes(name, data)
+}
+
+// SaveRawJSON stores an already encoded JSON string under the given name
+func (it *Iteration) SaveRawJSON(name string, data string) {
+	if it == nil {
+		return
+	}
+	it.saveBytes(name, []byte(data))
+}
+
+func (it *Iteration) saveBytes(name string, data []byte) {
+	rel := filepath.Join(it.dir, name+".json")
+	if err := os.WriteFile(filepath.Join(it.recorder.dir, rel), data, 0o644); err != nil {
+		logger.Error("failed to save trajectory file", "file", rel, "error", err)
+		return
+	}
+	it.addFile(name, rel)
+}
+
+// StartPhase marks the beginning of a timed phase (capture, ocr, act, verify...)
+func (it *Iteration) StartPhase(phase string) {
+	if it == nil {
+		return
+	}
+	it.recorder.mutex.Lock()
+	it.phaseStarts[phase] = time.Now()
+	it.recorder.mutex.Unlock()
+}
+
+// EndPhase records the duration of a phase started with StartPhase
+func (it *Iteration) EndPhase(phase string) {
+	if it == nil {
+		return
+	}
+	it.recorder.mutex.Lock()
+	if start, ok := it.phaseStarts[phase]; ok {
+		it.TimingsMs[phase] += float64(time.Since(start).Microseconds()) / 1000
+		delete(it.phaseStarts, phase)
+	}
+	it.recorder.mutex.Unlock()
+}
+
+func (it *Iteration) addFile(name, rel string) {
+	it.recorder.mutex.Lock()
+	it.Files[name] = rel
+	it.recorder.mutex.Unlock()
+}
+
+// Finish records the final task status and writes the manifest
+func (r *Recorder) Finish(status, message string) {
+	if r == nil {
+		return
+	}
+	now := time.Now()
+	r.mutex.Lock()
+	r.manifest.Status = status
+	r.manifest.Message = message
+	r.manifest.FinishedAt = &now
+	r.current = nil
+	r.mutex.Unlock()
+	r.writeManifest()
+	logger.Info("trajectory finished", "taskId", r.manifest.TaskID, "status", status)
+}
+
+// writeManifest rewrites the manifest
+func (r *Recorder) writeManifest() {
+	r.writeMutex.Lock()
+	defer r.writeMutex.Unlock()
+
+	r.mutex.Lock()
+	data, err := json.MarshalIndent(r.manifest, "", "  ")
+	r.mutex.Unlock()
+	if err != nil {
+		logger.Error("failed to marshal trajectory manifest", "error", err)
+		return
+	}
+
+	tmp := filepath.Join(r.dir, ManifestFile+".tmp")
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		logger.Error("failed to write trajectory manifest", "error", err)
+		return
+	}
+	if err := os.Rename(tmp, filepath.Join(r.dir, ManifestFile)); err != nil {
+		logger.Error("failed to write trajectory manifest", "error", err)
+	}
+}
+
+// RecordLLMExchange stores an LLM request/response pair and links it to the current iteration
+func (r *Recorder) RecordLLMExchange(ex llm.Exchange) {
+	if r == nil {
+		return
+	}
+
+	r.mutex.Lock()
+	r.llmCount++
+	rel := filepath.Join("llm", fmt.Sprintf("%04d-%s.json", r.llmCount, ex.Kind))
+	var iteration int64
+	if r.current != nil {
+		iteration = r.current.Number
+		r.current.LLMCalls = append(r.current.LLMCalls, rel)
+	}
+	r.manifest.LLMCalls = append(r.manifest.LLMCalls, LLMCallRecord{
+		File:       rel,
+		Kind:       ex.Kind,
+		Iteration:  iteration,
+		StartedAt:  ex.StartedAt,
+		DurationMs: float64(ex.Duration.Microseconds()) / 1000,
+		Error:      ex.Error,
+
+		EstimatedTokens: ex.EstimatedTokens,
+	})
+	r.mutex.Unlock()
+
+	data, err := json.MarshalIndent(ex, "", "  ")
+	if err != nil {
+		logger.Error("failed to marshal LLM exchange", "error", err)
+		return
+	}
+	if err := os.WriteFile(filepath.Join(r.dir, rel), data, 0o644); err != nil {
+		logger.Error("failed to save LLM exchange", "file", rel, "error", err)
+	}
+}
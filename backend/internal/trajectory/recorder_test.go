@@ -0,0 +1,100 @@
+package trajectory
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"useless-agent/internal/config"
+)
+
+func useTrajectoryDir(t *testing.T, keep int) string {
+	t.Helper()
+	dir, prevKeep := *config.TrajectoryDir, *config.TrajectoryKeep
+	*config.TrajectoryDir, *config.TrajectoryKeep = t.TempDir(), keep
+	t.Cleanup(func() { *config.TrajectoryDir, *config.TrajectoryKeep = dir, prevKeep })
+	return *config.TrajectoryDir
+}
+
+func readManifest(t *testing.T, dir string) Manifest {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var m Manifest
+	if err := json.Unmarshal(data, &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestRecorderDisabled(t *testing.T) {
+	useTrajectoryDir(t, 0)
+	*config.TrajectoryDir = ""
+	r := NewRecorder("task-1", "goal")
+	if r != nil {
+		t.Fatal("recorder created with recording disabled")
+	}
+	// A nil recorder records nothing and does not panic
+	r.BeginIteration(1, 0, "subtask").SaveJSON("verdict", map[string]bool{"completed": true})
+	r.Finish("completed", "")
+}
+
+func TestRecorderManifest(t *testing.T) {
+	root := useTrajectoryDir(t, 0)
+	r := NewRecorder("task-1", "open the editor")
+	if r == nil {
+		t.Fatal("no recorder")
+	}
+	dir := filepath.Join(root, "task-1")
+
+	it := r.BeginIteration(1, 0, "open the menu")
+	it.SaveJSON("verdict", map[string]bool{"completed": false})
+	it.SaveRawJSON("ocr", "[]")
+	// Files are in the manifest from the next iteration on
+	if m := readManifest(t, dir); len(m.Iterations) != 1 || len(m.Iterations[0].Files) != 0 {
+		t.Errorf("manifest before the next iteration: %+v", m.Iterations)
+	}
+
+	r.BeginIteration(2, 0, "open the menu")
+	m := readManifest(t, dir)
+	if len(m.Iterations) != 2 || m.Iterations[0].Files["verdict"] != filepath.Join("iteration-0001", "verdict.json") || m.Iterations[0].Files["ocr"] == "" {
+		t.Errorf("manifest after the next iteration: %+v", m.Iterations)
+	}
+
+	r.Finish("completed", "done")
+	m = readManifest(t, dir)
+	if m.Status != "completed" || m.FinishedAt == nil || m.Goal != "open the editor" {
+		t.Errorf("finished manifest: status %q finishedAt %v goal %q", m.Status, m.FinishedAt, m.Goal)
+	}
+	if _, err := os.Stat(filepath.Join(dir, ManifestFile+".tmp")); !os.IsNotExist(err) {
+		t.Error("temporary manifest left behind")
+	}
+}
+
+func TestRecorderPrunesOldBundles(t *testing.T) {
+	root := useTrajectoryDir(t, 3)
+	if err := os.Mkdir(filepath.Join(root, "not-a-bundle"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now().Add(-time.Hour)
+	for i := 1; i <= 4; i++ {
+		id := fmt.Sprintf("task-%d", i)
+		NewRecorder(id, "goal").Finish("completed", "")
+		at := start.Add(time.Duration(i) * time.Minute)
+		if err := os.Chtimes(filepath.Join(root, id, ManifestFile), at, at); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for id, want := range map[string]bool{"task-1": false, "task-2": true, "task-3": true, "task-4": true, "not-a-bundle": true} {
+		_, err := os.Stat(filepath.Join(root, id))
+		if exists := err == nil; exists != want {
+			t.Errorf("%s exists = %v, want %v", id, exists, want)
+		}
+	}
+}
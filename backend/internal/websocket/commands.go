@@ -0,0 +1,289 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"useless-agent/internal/auth"
+)
+
+// Clients send commands on the same socket:
+//
+//	{"id": "1", "command": "subscribe", "params": {"topics": ["task:task-1-1760000000"]}}
+//
+// and get a response event with the same id:
+//
+//	{"type": "response", ..., "id": "1", "ok": true, "result": {...}}
+//
+// subscribe, unsubscribe and logFilter are handled here, the others are
+// registered with HandleCommand. The older {"type": "logFilter", ...}
+// message still works and gets no response.
+
+// Topics a connection can subscribe to; "task:<id>" delivers the task and
+// log events of one task
+const (
+	TopicTasks       = "tasks"
+	TopicLogs        = "logs"
+	TopicTokens      = "tokens"
+	TopicScreenshots = "screenshots"
+
+	taskTopicPrefix = "task:"
+)
+
+// defaultTopics are the subscriptions of a connection without ?topics=
+var defaultTopics = []string{TopicTasks, TopicLogs, TopicTokens}
+
+// eventTopic is the topic an event type is published on
+func eventTopic(eventType string) string {
+	switch eventType {
+	case "log":
+		return TopicLogs
+	case "tokenUpdate":
+		return TopicTokens
+	case "screenshot":
+		return TopicScreenshots
+	default:
+		return TopicTasks
+	}
+}
+
+// CommandHandler runs a command; the result becomes the response's result
+type CommandHandler func(ctx context.Context, params json.RawMessage) (interface{}, error)
+
+// CommandError is a failed command with a machine-readable code
+type CommandError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *CommandError) Error() string { return e.Message }
+
+// Response answers a command
+type Response struct {
+	ID     string        `json:"id"`
+	OK     bool          `json:"ok"`
+	Result interface{}   `json:"result,omitempty"`
+	Error  *CommandError `json:"error,omitempty"`
+}
+
+// clientMessage is what a client sends; Type, Level and TaskID are the
+// fields of the older logFilter message
+type clientMessage struct {
+	ID      string          `json:"id"`
+	Command string          `json:"command"`
+	Params  json.RawMessage `json:"params"`
+
+	Type   string `json:"type"`
+	Level  string `json:"level"`
+	TaskID string `json:"taskId"`
+}
+
+type command struct {
+	scope   auth.Scope
+	handler CommandHandler
+}
+
+var (
+	commandsMutex sync.RWMutex
+	commands      = make(map[string]command)
+)
+
+// HandleCommand registers the handler of a command, callable by principals
+// with scope
+func HandleCommand(name string, scope auth.Scope, handler CommandHandler) {
+	commandsMutex.Lock()
+	defer commandsMutex.Unlock()
+	commands[name] = command{scope: scope, handler: handler}
+}
+
+func init() {
+	HandleCommand("subscribe", auth.ScopeView, nil)
+	HandleCommand("unsubscribe", auth.ScopeView, nil)
+	HandleCommand("logFilter", auth.ScopeView, nil)
+}
+
+// commandTimeout bounds a command so the read loop stays responsive
+const commandTimeout = 10 * time.Second
+
+// handleMessage runs a command from the client and queues its response
+func (c *WebSocketConnection) handleMessage(ctx context.Context, message []byte) {
+	var msg clientMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		c.respond(Response{Error: &CommandError{Code: "invalid_json", Message: err.Error()}})
+		return
+	}
+	if msg.Command == "" && msg.Type == "logFilter" {
+		c.setLogFilter(msg.Level, msg.TaskID)
+		return
+	}
+
+	commandsMutex.RLock()
+	cmd, ok := commands[msg.Command]
+	commandsMutex.RUnlock()
+	if !ok {
+		c.respond(Response{ID: msg.ID, Error: &CommandError{Code: "unknown_command", Message: fmt.Sprintf("unknown command %q", msg.Command)}})
+		return
+	}
+	if !c.principal.Has(cmd.scope) {
+		c.respond(Response{ID: msg.ID, Error: &CommandError{Code: "forbidden", Message: fmt.Sprintf("command %s needs scope %q", msg.Command, cmd.scope)}})
+		return
+	}
+
+	var result interface{}
+	var err error
+	switch msg.Command {
+	case "subscribe", "unsubscribe":
+		result, err = c.changeTopics(msg.Command == "subscribe", msg.Params)
+	case "logFilter":
+		var filter struct {
+			Level  string `json:"level"`
+			TaskID string `json:"taskId"`
+		}
+		if err = DecodeParams(msg.Params, &filter); err == nil {
+			c.setLogFilter(filter.Level, filter.TaskID)
+		}
+	default:
+		ctx, cancel := context.WithTimeout(ctx, commandTimeout)
+		result, err = cmd.handler(ctx, msg.Params)
+		cancel()
+	}
+	if err != nil {
+		var cmdErr *CommandError
+		if !errors.As(err, &cmdErr) {
+			cmdErr = &CommandError{Code: "failed", Message: err.Error()}
+		}
+		c.respond(Response{ID: msg.ID, Error: cmdErr})
+		return
+	}
+	logger.Debug("websocket command", "command", msg.Command, "principal", c.principal.Name)
+	c.respond(Response{ID: msg.ID, OK: true, Result: result})
+}
+
+// respond queues a response; responses are not part of the event sequence
+// and carry seq 0
+func (c *WebSocketConnection) respond(response Response) {
+	data, err := encode(Envelope{Type: "response", Version: EnvelopeVersion, Timestamp: time.Now()}, response)
+	if err != nil {
+		logger.Error("failed to encode command response", "error", err)
+		return
+	}
+	c.enqueue(data)
+}
+
+// DecodeParams decodes the params of a command strictly, missing params
+// decode as {}
+func DecodeParams(params json.RawMessage, v interface{}) error {
+	if len(params) == 0 || string(params) == "null" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader(params))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(v); err != nil {
+		return &CommandError{Code: "invalid_params", Message: err.Error()}
+	}
+	return nil
+}
+
+// changeTopics subscribes to or unsubscribes from topics and returns the
+// subscriptions after the change
+func (c *WebSocketConnection) changeTopics(subscribe bool, params json.RawMessage) (interface{}, error) {
+	var req struct {
+		Topics []string `json:"topics"`
+	}
+	if err := DecodeParams(params, &req); err != nil {
+		return nil, err
+	}
+	if len(req.Topics) == 0 {
+		return nil, &CommandError{Code: "invalid_params", Message: "topics is required"}
+	}
+	if err := checkTopics(c.principal, req.Topics); err != nil {
+		return nil, &CommandError{Code: "invalid_params", Message: err.Error()}
+	}
+	if subscribe {
+		c.subscribe(req.Topics)
+		if c.subscribed(TopicScreenshots) {
+			startScreenshots()
+		}
+	} else {
+		c.unsubscribe(req.Topics)
+	}
+	return map[string]interface{}{"topics": c.subscriptions()}, nil
+}
+
+func splitTopics(list string) []string {
+	var topics []string
+	for _, topic := range strings.Split(list, ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// checkTopics rejects unknown topics; every topic needs the view scope the
+// connection was opened with
+func checkTopics(principal auth.Principal, topics []string) error {
+	if !principal.Has(auth.ScopeView) {
+		return errors.New(`topics need scope "view"`)
+	}
+	for _, topic := range topics {
+		switch {
+		case topic == TopicTasks, topic == TopicLogs, topic == TopicTokens, topic == TopicScreenshots:
+		case strings.HasPrefix(topic, taskTopicPrefix) && len(topic) > len(taskTopicPrefix):
+		default:
+			return fmt.Errorf("unknown topic %q, want tasks, logs, tokens, screenshots or task:<id>", topic)
+		}
+	}
+	return nil
+}
+
+func (c *WebSocketConnection) subscribe(topics []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+}
+
+func (c *WebSocketConnection) unsubscribe(topics []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, topic := range topics {
+		delete(c.topics, topic)
+	}
+}
+
+func (c *WebSocketConnection) subscribed(topic string) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.topics[topic]
+}
+
+func (c *WebSocketConnection) subscriptions() []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	topics := make([]string, 0, len(c.topics))
+	for topic := range c.topics {
+		topics = append(topics, topic)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// wants reports whether the connection is subscribed to an event and, for
+// a log event, whether it passes the log filter
+func (c *WebSocketConnection) wants(e *event) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.topics[e.topic] && (e.taskID == "" || e.topic == TopicTokens || !c.topics[taskTopicPrefix+e.taskID]) {
+		return false
+	}
+	return e.log == nil || c.wantsLog(*e.log)
+}
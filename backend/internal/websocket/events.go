@@ -0,0 +1,321 @@
+package websocket
+
+import (
+	"crypto/rand"
+	_ "embed"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
+)
+
+// Every message to a client is one event: an envelope (type, version, seq,
+// taskId, timestamp) with the fields of its type next to it, at the top
+// level where older clients read them. schema.json describes every type.
+//
+// Events are numbered by one sequence per server run (SessionID) and the
+// last ringSize are kept, so a client that reconnects with
+// ?since=<seq>&session=<id> gets what it missed before anything new. A
+// client receives the events in seq order, but not every seq: log events
+// are filtered per connection.
+
+// EnvelopeVersion changes when a field of an existing event type changes meaning
+const EnvelopeVersion = 1
+
+// ringSize is how many events a reconnecting client can catch up on
+const ringSize = 2048
+
+//go:embed schema.json
+var schemaDocument []byte
+
+// SessionID identifies this server run, sequence numbers restart with it
+var SessionID = newSessionID()
+
+func newSessionID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// Envelope is the header every event carries
+type Envelope struct {
+	Type      string    `json:"type"`
+	Version   int       `json:"version"`
+	Seq       uint64    `json:"seq"`
+	TaskID    string    `json:"taskId,omitempty"`
+	Timestamp time.Time `json:"timestamp"`
+}
+
+// Hello is sent first on every connection
+type Hello struct {
+	SessionID string `json:"sessionId"`
+	LatestSeq uint64 `json:"latestSeq"` // seq of the newest event so far
+	OldestSeq uint64 `json:"oldestSeq"` // oldest event that can still be replayed
+	Resumed   bool   `json:"resumed"`   // events after since follow
+	Gap       bool   `json:"gap"`       // some events after since are gone, refetch state
+}
+
+// TaskUpdate is a task status change
+type TaskUpdate struct {
+	Status  string `json:"status"`
+	Message string `json:"message"`
+}
+
+// TokenUpdate is the running total of estimated tokens
+type TokenUpdate struct {
+	Total int `json:"total"`
+}
+
+// LogEvent is a log record; Data is the plain line older clients display
+type LogEvent struct {
+	Data  string        `json:"data"`
+	Entry logging.Entry `json:"entry"`
+}
+
+// ExecutionEngineUpdate feeds the execution engine view
+type ExecutionEngineUpdate struct {
+	UpdateType string      `json:"updateType"`
+	Data       interface{} `json:"data"`
+}
+
+// SubtaskUpdate is a subtask and the actions planned for it
+type SubtaskUpdate struct {
+	SubtaskID int          `json:"subtaskId"`
+	Subtask   SubtaskState `json:"subtask"`
+}
+
+// SubtaskState is the subtask of a SubtaskUpdate
+type SubtaskState struct {
+	ID          int           `json:"id"`
+	Description string        `json:"description"`
+	IsActive    bool          `json:"isActive"`
+	Actions     []interface{} `json:"actions"`
+}
+
+// ActionUpdate is an action about to be executed
+type ActionUpdate struct {
+	SubtaskID   int         `json:"subtaskId"`
+	ActionIndex int         `json:"actionIndex"`
+	Action      interface{} `json:"action"`
+}
+
+// Message carries the data of the other event types (llmInputReceived,
+// userAssistRequest)
+type Message struct {
+	Data interface{} `json:"data"`
+}
+
+// event is an encoded event as kept in the ring
+type event struct {
+	seq    uint64
+	topic  string
+	taskID string
+	data   []byte
+	log    *logging.Entry // set for log events, which clients filter
+}
+
+var (
+	hubMutex sync.Mutex
+	lastSeq  uint64
+	ring     [ringSize]*event
+	wake     = make(chan struct{}, 1)
+
+	droppedEvents = metrics.NewCounterVec("agent_websocket_dropped_events_total", "Events overwritten in the ring before they were delivered.")
+)
+
+func init() {
+	go dispatch()
+}
+
+// Publish numbers, records and broadcasts an event of eventType; payload
+// is a struct whose fields sit next to the envelope's
+func Publish(eventType, taskID string, payload interface{}) {
+	publish(eventType, taskID, payload, nil)
+}
+
+func publish(eventType, taskID string, payload interface{}, entry *logging.Entry) {
+	hubMutex.Lock()
+	lastSeq++
+	data, err := encode(Envelope{Type: eventType, Version: EnvelopeVersion, Seq: lastSeq, TaskID: taskID, Timestamp: time.Now()}, payload)
+	if err != nil {
+		lastSeq--
+		hubMutex.Unlock()
+		// The error log would be an event itself, so not for log events
+		if entry == nil {
+			logger.Error("failed to encode event", "type", eventType, "error", err)
+		}
+		return
+	}
+	defer hubMutex.Unlock()
+	ring[lastSeq%ringSize] = &event{seq: lastSeq, topic: eventTopic(eventType), taskID: taskID, data: data, log: entry}
+
+	select {
+	case wake <- struct{}{}:
+	default:
+	}
+}
+
+// encode merges the envelope and payload objects into one
+func encode(envelope Envelope, payload interface{}) ([]byte, error) {
+	head, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, err
+	}
+	if payload == nil {
+		return head, nil
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) <= 2 {
+		return head, nil // {}
+	}
+	merged := make([]byte, 0, len(head)+len(body))
+	merged = append(merged, head[:len(head)-1]...)
+	merged = append(merged, ',')
+	return append(merged, body[1:]...), nil
+}
+
+// oldestSeq is the oldest event still in the ring, with hubMutex held
+func oldestSeq() uint64 {
+	if lastSeq > ringSize {
+		return lastSeq - ringSize + 1
+	}
+	return 1
+}
+
+// dispatch writes events to the clients in seq order, reading them from
+// the ring so Publish never waits for a slow client
+func dispatch() {
+	var delivered uint64
+	for range wake {
+		for {
+			hubMutex.Lock()
+			if delivered >= lastSeq {
+				hubMutex.Unlock()
+				break
+			}
+			if oldest := oldestSeq(); delivered+1 < oldest {
+				droppedEvents.With().Add(float64(oldest - delivered - 1))
+				delivered = oldest - 1
+			}
+			var batch []*event
+			for seq := delivered + 1; seq <= lastSeq; seq++ {
+				batch = append(batch, ring[seq%ringSize])
+			}
+			delivered = lastSeq
+			hubMutex.Unlock()
+
+			wsmutex.Lock()
+			connections := make([]*WebSocketConnection, len(websocketConnections))
+			copy(connections, websocketConnections)
+			wsmutex.Unlock()
+
+			for _, e := range batch {
+				for _, wsConn := range connections {
+					wsConn.deliver(e)
+				}
+			}
+		}
+	}
+}
+
+// deliver queues an event unless the connection already got it while
+// catching up or is not subscribed to it
+func (c *WebSocketConnection) deliver(e *event) {
+	if e.seq > c.replayedThrough && c.wants(e) {
+		c.enqueue(e.data)
+	}
+}
+
+// register adds a connection and returns the hello for it and the events
+// after since it missed, atomically with respect to Publish so nothing
+// falls between the replay and live delivery
+func register(c *WebSocketConnection, session string, since uint64, resume bool) (Hello, []*event) {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+
+	hello := Hello{SessionID: SessionID, LatestSeq: lastSeq, OldestSeq: oldestSeq()}
+
+	var missed []*event
+	if resume {
+		// After a server restart every buffered event is new to the client
+		if session != SessionID || since > lastSeq {
+			since = 0
+		}
+		hello.Resumed = true
+		hello.Gap = since+1 < hello.OldestSeq
+		from := since + 1
+		if from < hello.OldestSeq {
+			from = hello.OldestSeq
+		}
+		for seq := from; seq <= lastSeq; seq++ {
+			missed = append(missed, ring[seq%ringSize])
+		}
+	}
+	c.replayedThrough = lastSeq
+
+	wsmutex.Lock()
+	websocketConnections = append(websocketConnections, c)
+	wsmutex.Unlock()
+	return hello, missed
+}
+
+// SchemaHandler serves the JSON Schema of the WebSocket events
+func SchemaHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.Write(schemaDocument)
+}
+
+// taskIDOf finds the task an untyped payload is about
+func taskIDOf(data interface{}) string {
+	if m, ok := data.(map[string]interface{}); ok {
+		if id, ok := m["taskId"].(string); ok {
+			return id
+		}
+	}
+	return ""
+}
+
+// SendTaskUpdate sends a task status change
+func SendTaskUpdate(taskID, status, message string) {
+	Publish("taskUpdate", taskID, TaskUpdate{Status: status, Message: message})
+}
+
+// SendTokenUpdate sends the running token total
+func SendTokenUpdate(total int) {
+	Publish("tokenUpdate", "", TokenUpdate{Total: total})
+}
+
+// BroadcastMessage sends an event whose fields are in data
+func BroadcastMessage(messageType string, data interface{}) {
+	Publish(messageType, taskIDOf(data), Message{Data: data})
+}
+
+// SendLogEntry sends a log record to the clients whose log filter it passes
+func SendLogEntry(entry logging.Entry) {
+	publish("log", entry.TaskID, LogEvent{Data: entry.Line(), Entry: entry}, &entry)
+}
+
+// SendExecutionEngineUpdate sends an execution engine state update
+func SendExecutionEngineUpdate(updateType string, data interface{}) {
+	Publish("executionEngineUpdate", taskIDOf(data), ExecutionEngineUpdate{UpdateType: updateType, Data: data})
+}
+
+// SendSubtaskUpdate sends a subtask and its planned actions
+func SendSubtaskUpdate(taskID string, subtaskID int, description string, isActive bool, actions []interface{}) {
+	Publish("subtaskUpdate", taskID, SubtaskUpdate{
+		SubtaskID: subtaskID,
+		Subtask:   SubtaskState{ID: subtaskID, Description: description, IsActive: isActive, Actions: actions},
+	})
+}
+
+// SendActionUpdate sends an action about to be executed
+func SendActionUpdate(taskID string, subtaskID int, actionIndex int, action interface{}) {
+	Publish("actionUpdate", taskID, ActionUpdate{SubtaskID: subtaskID, ActionIndex: actionIndex, Action: action})
+}
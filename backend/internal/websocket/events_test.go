@@ -0,0 +1,155 @@
+package websocket
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// latest returns the newest and oldest seq the ring holds
+func latest() (last, oldest uint64) {
+	hubMutex.Lock()
+	defer hubMutex.Unlock()
+	return lastSeq, oldestSeq()
+}
+
+// testConnection is a connection without a socket, subscribed to topics
+func testConnection(topics ...string) *WebSocketConnection {
+	c := &WebSocketConnection{send: make(chan []byte, 16), done: make(chan struct{}), topics: make(map[string]bool)}
+	for _, topic := range topics {
+		c.topics[topic] = true
+	}
+	return c
+}
+
+func unregister(c *WebSocketConnection) {
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
+	for i, conn := range websocketConnections {
+		if conn == c {
+			websocketConnections = append(websocketConnections[:i], websocketConnections[i+1:]...)
+			return
+		}
+	}
+}
+
+func TestRegisterResume(t *testing.T) {
+	// More events than the ring holds, so the oldest are gone
+	for i := 0; i < ringSize+10; i++ {
+		SendTokenUpdate(i)
+	}
+	last, oldest := latest()
+	if oldest != last-ringSize+1 {
+		t.Fatalf("oldest seq %d with last %d, want the last %d kept", oldest, last, ringSize)
+	}
+
+	tests := []struct {
+		name    string
+		session string
+		since   uint64
+		resume  bool
+		gap     bool
+		from    uint64 // first missed event, 0 for none
+	}{
+		{name: "no resume", session: SessionID, since: last, resume: false},
+		{name: "up to date", session: SessionID, since: last, resume: true},
+		{name: "a few behind", session: SessionID, since: last - 5, resume: true, from: last - 4},
+		{name: "oldest kept is next", session: SessionID, since: oldest - 1, resume: true, from: oldest},
+		{name: "older than the ring", session: SessionID, since: oldest - 2, resume: true, gap: true, from: oldest},
+		{name: "another server run", session: "restarted", since: last - 5, resume: true, gap: true, from: oldest},
+		{name: "since in the future", session: SessionID, since: last + 100, resume: true, gap: true, from: oldest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := testConnection()
+			hello, missed := register(c, tt.session, tt.since, tt.resume)
+			defer unregister(c)
+
+			if hello.SessionID != SessionID || hello.LatestSeq != last || hello.OldestSeq != oldest {
+				t.Errorf("hello %+v, want session %s latest %d oldest %d", hello, SessionID, last, oldest)
+			}
+			if hello.Resumed != tt.resume || hello.Gap != tt.gap {
+				t.Errorf("resumed %v gap %v, want %v %v", hello.Resumed, hello.Gap, tt.resume, tt.gap)
+			}
+			var want uint64
+			if tt.from != 0 {
+				want = last - tt.from + 1
+			}
+			if uint64(len(missed)) != want {
+				t.Fatalf("%d missed events, want %d", len(missed), want)
+			}
+			for i, e := range missed {
+				if e.seq != tt.from+uint64(i) {
+					t.Fatalf("missed[%d] has seq %d, want %d", i, e.seq, tt.from+uint64(i))
+				}
+			}
+			if c.replayedThrough != last {
+				t.Errorf("replayed through %d, want %d", c.replayedThrough, last)
+			}
+		})
+	}
+}
+
+func TestDeliver(t *testing.T) {
+	c := testConnection(TopicTokens, taskTopicPrefix+"task-1")
+	c.replayedThrough = 10
+	tests := []struct {
+		name string
+		e    *event
+		want bool
+	}{
+		{"replayed already", &event{seq: 10, topic: TopicTokens}, false},
+		{"subscribed topic", &event{seq: 11, topic: TopicTokens}, true},
+		{"other topic", &event{seq: 12, topic: TopicTasks}, false},
+		{"subscribed task", &event{seq: 13, topic: TopicTasks, taskID: "task-1"}, true},
+		{"other task", &event{seq: 14, topic: TopicTasks, taskID: "task-2"}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.e.data = []byte(tt.name)
+			c.deliver(tt.e)
+			select {
+			case data := <-c.send:
+				if !tt.want || string(data) != tt.name {
+					t.Errorf("delivered %q", data)
+				}
+			default:
+				if tt.want {
+					t.Error("not delivered")
+				}
+			}
+		})
+	}
+}
+
+func TestEncode(t *testing.T) {
+	envelope := Envelope{Type: "taskUpdate", Version: EnvelopeVersion, Seq: 7, TaskID: "task-1"}
+	tests := []struct {
+		name    string
+		payload interface{}
+		want    map[string]interface{}
+	}{
+		{"no payload", nil, map[string]interface{}{}},
+		{"empty payload", struct{}{}, map[string]interface{}{}},
+		{"payload fields", TaskUpdate{Status: "completed", Message: "done"}, map[string]interface{}{"status": "completed", "message": "done"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := encode(envelope, tt.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(data, &got); err != nil {
+				t.Fatalf("%s: %v", data, err)
+			}
+			if got["type"] != "taskUpdate" || got["seq"] != float64(7) || got["taskId"] != "task-1" {
+				t.Errorf("envelope fields missing in %s", data)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+		})
+	}
+}
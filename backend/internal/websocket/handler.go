@@ -1,367 +1,274 @@
 package websocket
 
 import (
-	"encoding/json"
-	"fmt"
-	"log"
+	"log/slog"
 	"net/http"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"useless-agent/internal/auth"
+	"useless-agent/internal/logging"
+	"useless-agent/internal/metrics"
 )
 
+var logger = logging.For("websocket")
+
+var slowConsumers = metrics.NewCounterVec("agent_websocket_slow_consumers_total", "WebSocket clients dropped because their send queue was full.")
+
+func init() {
+	metrics.NewGaugeFunc("agent_websocket_clients", "Connected WebSocket clients.", func() float64 {
+		wsmutex.Lock()
+		defer wsmutex.Unlock()
+		return float64(len(websocketConnections))
+	})
+}
+
 var upgrader = websocket.Upgrader{
-	ReadBufferSize:  1024,
-	WriteBufferSize: 1024,
-	CheckOrigin: func(r *http.Request) bool {
-		return true // Adjust as needed for CORS
-	},
+	ReadBufferSize:   1024,
+	WriteBufferSize:  1024,
+	CheckOrigin:      auth.CheckOrigin,
 	HandshakeTimeout: 10 * time.Second,
 }
 
-// WebSocketConnection wraps a websocket connection with its own mutex
+const (
+	// sendQueueSize is how many messages may wait for a client; a client
+	// that falls further behind is dropped and resumes with ?since=, which
+	// can not replay more than the ring holds anyway
+	sendQueueSize = ringSize
+
+	writeTimeout = 2 * time.Second
+
+	// Keep-alive: a ping every pingInterval, a client that has not answered
+	// within pongTimeout is dropped
+	pingInterval = 30 * time.Second
+	pongTimeout  = 2 * pingInterval
+
+	// maxMessageSize limits what a client may send
+	maxMessageSize = 64 << 10
+)
+
+// WebSocketConnection is a client connection. Only its writer goroutine
+// writes messages; everyone else queues them with enqueue.
 type WebSocketConnection struct {
-	conn   *websocket.Conn
-	mutex  sync.Mutex
-	closed bool
+	conn      *websocket.Conn
+	principal auth.Principal
+	send      chan []byte
+	done      chan struct{} // closed when the connection is dropped
+	closeOnce sync.Once
+	dropping  atomic.Bool
+
+	// Events up to this seq were sent while the client caught up
+	replayedThrough uint64
+
+	mutex sync.Mutex // guards the subscription below
+
+	// Log channel filter: minimum level and, if set, the only task shown
+	logLevel  slog.Level
+	logTaskID string
+
+	topics map[string]bool
 }
 
 var websocketConnections []*WebSocketConnection
 var wsmutex sync.Mutex
 
-// Helper function to remove a failed connection
-func removeConnection(wsConn *WebSocketConnection) {
+// removeConnection drops a connection, with a close frame unless code is 0
+func removeConnection(wsConn *WebSocketConnection, code int, reason string) {
 	wsmutex.Lock()
-	defer wsmutex.Unlock()
 	for i, c := range websocketConnections {
 		if c == wsConn {
 			websocketConnections = append(websocketConnections[:i], websocketConnections[i+1:]...)
-			wsConn.mutex.Lock()
-			if !wsConn.closed {
-				wsConn.conn.Close()
-				wsConn.closed = true
-			}
-			wsConn.mutex.Unlock()
 			break
 		}
 	}
+	wsmutex.Unlock()
+	wsConn.close(code, reason)
 }
 
-// Helper function to safely write to a WebSocket connection
-func safeWrite(wsConn *WebSocketConnection, messageType int, data []byte) error {
-	wsConn.mutex.Lock()
-	defer wsConn.mutex.Unlock()
-
-	if wsConn.closed {
-		return fmt.Errorf("connection is closed")
-	}
-
-	// Set write deadline to prevent blocking
-	wsConn.conn.SetWriteDeadline(time.Now().Add(2 * time.Second))
-	err := wsConn.conn.WriteMessage(messageType, data)
-	if err != nil {
-		// Mark as closed and remove from connections
-		wsConn.closed = true
-		go removeConnection(wsConn)
-	}
-	return err
+func (c *WebSocketConnection) close(code int, reason string) {
+	c.closeOnce.Do(func() {
+		if code != 0 {
+			frame := websocket.FormatCloseMessage(code, reason)
+			c.conn.WriteControl(websocket.CloseMessage, frame, time.Now().Add(time.Second))
+		}
+		c.conn.Close()
+		close(c.done)
+	})
 }
 
-// WSHandler handles WebSocket connections
-func WSHandler(w http.ResponseWriter, r *http.Request) {
-	conn, err := upgrader.Upgrade(w, r, nil)
-	if err != nil {
-		log.Println(err)
-		return
-	}
-
-	wsConn := &WebSocketConnection{
-		conn:   conn,
-		mutex:  sync.Mutex{},
-		closed: false,
-	}
-
+// CloseAll sends every client a going-away close frame with reason and
+// drops the connections, for a graceful server shutdown
+func CloseAll(reason string) {
 	wsmutex.Lock()
-	websocketConnections = append(websocketConnections, wsConn)
+	connections := websocketConnections
+	websocketConnections = nil
 	wsmutex.Unlock()
 
-	defer func() {
-		wsConn.mutex.Lock()
-		if !wsConn.closed {
-			wsConn.conn.Close()
-			wsConn.closed = true
-		}
-		wsConn.mutex.Unlock()
-		removeConnection(wsConn)
-	}()
-
-	send := func(message string) {
-		err := safeWrite(wsConn, websocket.TextMessage, []byte(message))
-		if err != nil {
-			return
-		}
+	for _, wsConn := range connections {
+		wsConn.close(websocket.CloseGoingAway, reason)
 	}
-
-	go func() {
-		ticker := time.NewTicker(2 * time.Second)
-		defer ticker.Stop()
-		for {
-			select {
-			case <-ticker.C:
-				currentTime := time.Now()
-				send(currentTime.Format("2006-01-02 15:04:05"))
-			}
-		}
-	}()
-
-	for {
-		_, _, err := conn.ReadMessage()
-		if err != nil {
-			log.Println("Read error:", err)
-			break
-		}
+	if len(connections) > 0 {
+		logger.Info("closed websocket clients", "count", len(connections))
 	}
 }
 
-// SendTaskUpdate sends a task update to all WebSocket clients
-func SendTaskUpdate(taskID, status, message string) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type":    "taskUpdate",
-		"taskId":  taskID,
-		"status":  status,
-		"message": message,
+// enqueue queues a message for the client. A client whose queue is full is
+// dropped: it reads too slowly to keep up, and replaying from the ring on
+// reconnect is cheaper than buffering without bound.
+func (c *WebSocketConnection) enqueue(data []byte) bool {
+	select {
+	case c.send <- data:
+		return true
+	case <-c.done:
+		return false
+	default:
 	}
-
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling task update:", err)
-		return
+	if c.dropping.CompareAndSwap(false, true) {
+		slowConsumers.With().Inc()
+		logger.Warn("dropping slow websocket client", "principal", c.principal.Name)
+		go removeConnection(c, websocket.CloseTryAgainLater, "slow consumer, reconnect with since")
 	}
-
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending task update:", err)
-			}
-		}
-	}()
+	return false
 }
 
-// SendTokenUpdate sends a token update to all WebSocket clients
-func SendTokenUpdate(total int) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type":  "tokenUpdate",
-		"total": total,
-	}
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling token update:", err)
-		return
-	}
-
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending token update:", err)
-			}
-		}
-	}()
+// write writes a message directly; only the writer goroutine, or the
+// handler before starting it, may call it
+func (c *WebSocketConnection) write(data []byte) error {
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteMessage(websocket.TextMessage, data)
 }
 
-// BroadcastMessage broadcasts a message to all WebSocket clients
-func BroadcastMessage(messageType string, data interface{}) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type": messageType,
-		"data": data,
-	}
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling broadcast message:", err)
-		return
-	}
-
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending broadcast message:", err)
+// writeLoop writes the queued messages and keep-alive pings
+func (c *WebSocketConnection) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case data := <-c.send:
+			if err := c.write(data); err != nil {
+				logger.Debug("websocket write failed", "error", err)
+				removeConnection(c, 0, "")
+				return
 			}
+		case <-ticker.C:
+			if c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout)) != nil {
+				removeConnection(c, 0, "")
+				return
+			}
+		case <-c.done:
+			return
 		}
-	}()
+	}
 }
 
-// SendLogMessage sends log data to all WebSocket clients
-func SendLogMessage(logData string) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type": "log",
-		"data": logData,
+// WSHandler handles WebSocket connections. A client that reconnects passes
+// the seq of the last event it got and the session of its hello as
+// ?since=<seq>&session=<id> and receives the events it missed first.
+// ?topics= picks the initial subscriptions, see commands.go.
+func WSHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	var since uint64
+	resume := query.Has("since")
+	if resume {
+		var err error
+		if since, err = strconv.ParseUint(query.Get("since"), 10, 64); err != nil {
+			http.Error(w, "since must be an event sequence number", http.StatusBadRequest)
+			return
+		}
 	}
-
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling log message:", err)
-		return
+	topics := defaultTopics
+	if query.Has("topics") {
+		topics = splitTopics(query.Get("topics"))
 	}
-
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending log message:", err)
-			}
-		}
-	}()
-}
-
-// SendExecutionEngineUpdate sends execution engine state updates to all WebSocket clients
-func SendExecutionEngineUpdate(updateType string, data interface{}) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type":       "executionEngineUpdate",
-		"updateType": updateType,
-		"data":       data,
+	principal := auth.FromContext(r.Context())
+	if err := checkTopics(principal, topics); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 
-	updateJSON, err := json.Marshal(update)
+	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
-		log.Println("Error marshaling execution engine update:", err)
+		logger.Warn("websocket upgrade failed", "error", err)
 		return
 	}
 
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
+	wsConn := &WebSocketConnection{
+		conn:      conn,
+		principal: principal,
+		send:      make(chan []byte, sendQueueSize),
+		done:      make(chan struct{}),
+		logLevel:  slog.LevelInfo,
+		topics:    make(map[string]bool),
+	}
+	// The log channel filter can be given on connect and changed later
+	wsConn.setLogFilter(query.Get("logLevel"), query.Get("logTask"))
+	wsConn.subscribe(topics)
+	defer removeConnection(wsConn, 0, "")
+
+	// Live events queue up until the hello and replay are written
+	hello, missed := register(wsConn, query.Get("session"), since, resume)
+	if data, err := encode(Envelope{Type: "hello", Version: EnvelopeVersion, Seq: hello.LatestSeq, Timestamp: time.Now()}, hello); err == nil {
+		err = wsConn.write(data)
+		for _, e := range missed {
 			if err != nil {
-				log.Println("Error sending execution engine update:", err)
+				break
+			}
+			if wsConn.wants(e) {
+				err = wsConn.write(e.data)
 			}
 		}
-	}()
-}
-
-// SendSubtaskUpdate sends subtask updates to all WebSocket clients
-func SendSubtaskUpdate(taskID string, subtaskID int, description string, isActive bool, actions []interface{}) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type":      "subtaskUpdate",
-		"taskId":    taskID,
-		"subtaskId": subtaskID,
-		"subtask": map[string]interface{}{
-			"id":          subtaskID,
-			"description": description,
-			"isActive":    isActive,
-			"actions":     actions,
-		},
+		if err != nil {
+			logger.Debug("websocket write failed", "error", err)
+			return
+		}
 	}
-
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling subtask update:", err)
-		return
+	if resume {
+		logger.Debug("websocket client resumed", "since", since, "replayed", len(missed), "gap", hello.Gap)
+	}
+	go wsConn.writeLoop()
+	if wsConn.subscribed(TopicScreenshots) {
+		startScreenshots()
 	}
 
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
+	conn.SetReadLimit(maxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(pongTimeout))
+	})
 
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending subtask update:", err)
-			}
+	for {
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			logger.Debug("websocket read failed", "error", err)
+			break
 		}
-	}()
+		conn.SetReadDeadline(time.Now().Add(pongTimeout))
+		wsConn.handleMessage(r.Context(), message)
+	}
 }
 
-// SendActionUpdate sends action updates to all WebSocket clients
-func SendActionUpdate(taskID string, subtaskID int, actionIndex int, action interface{}) {
-	wsmutex.Lock()
-	defer wsmutex.Unlock()
-
-	update := map[string]interface{}{
-		"type":        "actionUpdate",
-		"taskId":      taskID,
-		"subtaskId":   subtaskID,
-		"actionIndex": actionIndex,
-		"action":      action,
+// setLogFilter sets the minimum level and task of delivered log records, an
+// invalid or empty level keeps the current one
+func (c *WebSocketConnection) setLogFilter(level, taskID string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if level != "" {
+		if parsed, err := logging.ParseLevel(level); err == nil {
+			c.logLevel = parsed
+		}
 	}
+	c.logTaskID = taskID
+}
 
-	updateJSON, err := json.Marshal(update)
-	if err != nil {
-		log.Println("Error marshaling action update:", err)
-		return
+// wantsLog reports whether a log record passes the connection's filter,
+// with c.mutex held
+func (c *WebSocketConnection) wantsLog(entry logging.Entry) bool {
+	if entry.SlogLevel() < c.logLevel {
+		return false
 	}
-
-	// Use a goroutine to send updates without blocking
-	go func() {
-		// Create a copy of connections to avoid holding mutex for too long
-		wsmutex.Lock()
-		connections := make([]*WebSocketConnection, len(websocketConnections))
-		copy(connections, websocketConnections)
-		wsmutex.Unlock()
-
-		for _, wsConn := range connections {
-			err := safeWrite(wsConn, websocket.TextMessage, updateJSON)
-			if err != nil {
-				log.Println("Error sending action update:", err)
-			}
-		}
-	}()
+	return c.logTaskID == "" || c.logTaskID == entry.TaskID
 }
@@ -0,0 +1,90 @@
+package websocket
+
+import (
+	"sync"
+	"time"
+
+	"useless-agent/internal/config"
+)
+
+// Clients subscribed to "screenshots" get a screenshot event every
+// -ws-screenshot-interval from one capture loop that runs while anyone is
+// subscribed. Screenshots are too big for the ring: they carry seq 0, are
+// not replayed and are skipped for a client that is falling behind.
+
+// Screenshot is a screenshot event
+type Screenshot struct {
+	ContentType string `json:"contentType"`
+	Image       []byte `json:"image"` // base64 in JSON
+}
+
+var (
+	screenshotMutex   sync.Mutex
+	screenshotSource  func() ([]byte, string, error)
+	screenshotRunning bool
+)
+
+// SetScreenshotSource sets how screenshots are taken: the encoded image
+// and its content type
+func SetScreenshotSource(source func() ([]byte, string, error)) {
+	screenshotMutex.Lock()
+	defer screenshotMutex.Unlock()
+	screenshotSource = source
+}
+
+// startScreenshots starts the capture loop unless it is running
+func startScreenshots() {
+	screenshotMutex.Lock()
+	defer screenshotMutex.Unlock()
+	if screenshotRunning || screenshotSource == nil {
+		return
+	}
+	screenshotRunning = true
+	go screenshotLoop(screenshotSource)
+}
+
+func screenshotLoop(source func() ([]byte, string, error)) {
+	ticker := time.NewTicker(*config.WSScreenshotInterval)
+	defer ticker.Stop()
+	for range ticker.C {
+		subscribers := screenshotSubscribers()
+		if len(subscribers) == 0 {
+			screenshotMutex.Lock()
+			// A subscribe may have raced with the check above
+			if subscribers = screenshotSubscribers(); len(subscribers) == 0 {
+				screenshotRunning = false
+				screenshotMutex.Unlock()
+				return
+			}
+			screenshotMutex.Unlock()
+		}
+
+		image, contentType, err := source()
+		if err != nil {
+			logger.Warn("failed to capture screenshot for websocket clients", "error", err)
+			continue
+		}
+		data, err := encode(Envelope{Type: "screenshot", Version: EnvelopeVersion, Timestamp: time.Now()}, Screenshot{ContentType: contentType, Image: image})
+		if err != nil {
+			continue
+		}
+		for _, c := range subscribers {
+			// A screenshot is worth less than the events behind it
+			if len(c.send) < cap(c.send)/2 {
+				c.enqueue(data)
+			}
+		}
+	}
+}
+
+func screenshotSubscribers() []*WebSocketConnection {
+	wsmutex.Lock()
+	defer wsmutex.Unlock()
+	var subscribers []*WebSocketConnection
+	for _, c := range websocketConnections {
+		if c.subscribed(TopicScreenshots) {
+			subscribers = append(subscribers, c)
+		}
+	}
+	return subscribers
+}
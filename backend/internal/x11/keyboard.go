@@ -0,0 +1,472 @@
+package x11
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/BurntSushi/xgb"
+	"github.com/BurntSushi/xgb/xproto"
+	"github.com/BurntSushi/xgb/xtest"
+)
+
+// KeyboardLayout describes the active XKB configuration as published on the root window
+type KeyboardLayout struct {
+	Rules   string `json:"rules"`
+	Model   string `json:"model"`
+	Layout  string `json:"layout"`
+	Variant string `json:"variant"`
+	Options string `json:"options"`
+}
+
+// Groups returns the number of layouts (XKB groups) configured, at least 1
+func (l KeyboardLayout) Groups() int {
+	if l.Layout == "" {
+		return 1
+	}
+	return len(strings.Split(l.Layout, ","))
+}
+
+// Keyboard types text and key combos through the XTest extension using the
+// server's current keyboard mapping instead of a hardcoded US layout
+type Keyboard struct {
+	conn              *xgb.Conn
+	root              xproto.Window
+	minKeycode        xproto.Keycode
+	maxKeycode        xproto.Keycode
+	keysymsPerKeycode int
+	keysyms           []xproto.Keysym
+	layout            KeyboardLayout
+	scratchKeycode    xproto.Keycode
+	// Keysym a held named key bound to the scratch keycode, see sendNamedKey
+	scratchHeld xproto.Keysym
+	// A MappingNotify arrived since the last Refresh
+	stale bool
+	mutex sync.Mutex
+}
+
+// keyLocation is where a keysym lives in the current mapping
+type keyLocation struct {
+	keycode xproto.Keycode
+	shift   bool
+}
+
+// Delay the server and clients need to pick up a temporary keycode remap
+const remapSettleDelay = 20 * time.Millisecond
+
+// NewKeyboard connects to the given display and loads its keyboard mapping
+func NewKeyboard(display string) (*Keyboard, error) {
+	if display != "" {
+		if err := setDisplay(display); err != nil {
+			return nil, fmt.Errorf("failed to set display: %w", err)
+		}
+	}
+
+	conn, err := xgb.NewConn()
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to X11 server: %w", err)
+	}
+
+	if err := xtest.Init(conn); err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("XTest extension not available: %w", err)
+	}
+
+	setup := xproto.Setup(conn)
+	kb := &Keyboard{
+		conn:       conn,
+		root:       setup.DefaultScreen(conn).Root,
+		minKeycode: setup.MinKeycode,
+		maxKeycode: setup.MaxKeycode,
+	}
+
+	if err := kb.Refresh(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return kb, nil
+}
+
+// Close closes the X11 connection
+func (kb *Keyboard) Close() {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+	kb.conn.Close()
+}
+
+// Layout returns the XKB layout read during the last Refresh
+func (kb *Keyboard) Layout() KeyboardLayout {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+	return kb.layout
+}
+
+// Refresh reloads the keyboard mapping and the configured XKB layouts
+func (kb *Keyboard) Refresh() error {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+	return kb.refresh()
+}
+
+// RefreshIfChanged reloads the keyboard mapping if the server announced a
+// change (e.g. setxkbmap) since the last load. Switching between the
+// configured layouts changes no mapping, the active group is read per key.
+func (kb *Keyboard) RefreshIfChanged() error {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+	kb.drainEvents()
+	if !kb.stale {
+		return nil
+	}
+	return kb.refresh()
+}
+
+// drainEvents reads the pending events of the connection, which would
+// otherwise pile up, and marks the mapping stale on a MappingNotify other
+// than the ones our own scratch keycode remaps cause; mutex held
+func (kb *Keyboard) drainEvents() {
+	for {
+		ev, err := kb.conn.PollForEvent()
+		if ev == nil && err == nil {
+			return
+		}
+		e, ok := ev.(xproto.MappingNotifyEvent)
+		if !ok {
+			continue
+		}
+		if e.Request == xproto.MappingKeyboard && e.FirstKeycode == kb.scratchKeycode && e.Count == 1 {
+			continue
+		}
+		kb.stale = true
+	}
+}
+
+// activeGroup returns the XKB group (layout) in use, 0 for the first. The
+// server reports it in bits 13-14 of the core modifier state.
+func (kb *Keyboard) activeGroup() int {
+	reply, err := xproto.QueryPointer(kb.conn, kb.root).Reply()
+	if err != nil {
+		return 0
+	}
+	return int(reply.Mask>>13) & 3
+}
+
+func (kb *Keyboard) refresh() error {
+	count := byte(int(kb.maxKeycode) - int(kb.minKeycode) + 1)
+	reply, err := xproto.GetKeyboardMapping(kb.conn, kb.minKeycode, count).Reply()
+	if err != nil {
+		return fmt.Errorf("failed to get keyboard mapping: %w", err)
+	}
+	kb.keysymsPerKeycode = int(reply.KeysymsPerKeycode)
+	kb.keysyms = reply.Keysyms
+	kb.stale = false
+
+	// Pick a keycode with no symbols at all as scratch space for characters
+	// the layout cannot produce. Search from the top, where spare codes
+	// usually are. One bound to a held key stays until its release.
+	if kb.scratchHeld == NoSymbol {
+		kb.scratchKeycode = 0
+	}
+	for kc := int(kb.maxKeycode); kc >= int(kb.minKeycode) && kb.scratchKeycode == 0; kc-- {
+		if kb.isEmptyKeycode(xproto.Keycode(kc)) {
+			kb.scratchKeycode = xproto.Keycode(kc)
+			break
+		}
+	}
+
+	layout, err := readKeyboardLayout(kb.conn, kb.root)
+	if err != nil {
+		log.Printf("Failed to read XKB layout, assuming single group: %v", err)
+	}
+	kb.layout = layout
+
+	log.Printf("Keyboard mapping loaded: layout=%q variant=%q keysymsPerKeycode=%d scratchKeycode=%d",
+		layout.Layout, layout.Variant, kb.keysymsPerKeycode, kb.scratchKeycode)
+	return nil
+}
+
+// TypeString types text character by character, waiting delay between characters
+func (kb *Keyboard) TypeString(text string, delay time.Duration) error {
+	for _, r := range text {
+		if err := kb.TypeRune(r); err != nil {
+			return fmt.Errorf("failed to type %q: %w", r, err)
+		}
+		if delay > 0 {
+			time.Sleep(delay)
+		}
+	}
+	return nil
+}
+
+// TypeRune types a single character with its key in the active layout,
+// remapping the scratch keycode when that layout has no key for it
+func (kb *Keyboard) TypeRune(r rune) error {
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	keysym := RuneToKeysym(r)
+	group := kb.activeGroup()
+	if loc, ok := kb.findKeysym(keysym, r, group); ok {
+		return kb.tapLocation(loc, group)
+	}
+	return kb.typeWithScratchKeycode(keysym, group)
+}
+
+// KeyDown presses a named key (see KeyNameToKeysym)
+func (kb *Keyboard) KeyDown(name string) error {
+	return kb.sendNamedKey(name, xproto.KeyPress)
+}
+
+// KeyUp releases a named key (see KeyNameToKeysym)
+func (kb *Keyboard) KeyUp(name string) error {
+	return kb.sendNamedKey(name, xproto.KeyRelease)
+}
+
+// Hotkey presses all keys in order and releases them in reverse order
+func (kb *Keyboard) Hotkey(keys []string) error {
+	pressed := make([]string, 0, len(keys))
+	var pressErr error
+	for _, key := range keys {
+		if err := kb.KeyDown(key); err != nil {
+			pressErr = err
+			break
+		}
+		pressed = append(pressed, key)
+	}
+
+	// Always release what was pressed, even if a later key failed
+	for i := len(pressed) - 1; i >= 0; i-- {
+		if err := kb.KeyUp(pressed[i]); err != nil && pressErr == nil {
+			pressErr = err
+		}
+	}
+	return pressErr
+}
+
+func (kb *Keyboard) sendNamedKey(name string, eventType byte) error {
+	keysym, ok := KeyNameToKeysym(name)
+	if !ok {
+		return fmt.Errorf("unknown key name %q", name)
+	}
+
+	kb.mutex.Lock()
+	defer kb.mutex.Unlock()
+
+	if kb.scratchHeld == keysym {
+		return kb.scratchKey(eventType, keysym)
+	}
+	if loc, ok := kb.findKeysym(keysym, 0, kb.activeGroup()); ok {
+		return kb.fakeKey(eventType, loc.keycode)
+	}
+	if kb.scratchKeycode != 0 {
+		return kb.scratchKey(eventType, keysym)
+	}
+	// Without a spare keycode the key of another layout is the best there
+	// is, applications that match shortcuts by keycode still see it
+	if loc, ok := kb.findKeysym(keysym, 0, 0); ok {
+		return kb.fakeKey(eventType, loc.keycode)
+	}
+	return fmt.Errorf("key %q (keysym 0x%x) is not in the layout and no spare keycode is available", name, uint32(keysym))
+}
+
+// scratchKey presses a named key the layout lacks on the scratch keycode,
+// which stays bound to it until the release; mutex held
+func (kb *Keyboard) scratchKey(eventType byte, keysym xproto.Keysym) error {
+	if eventType == xproto.KeyRelease {
+		if kb.scratchHeld != keysym {
+			// Never pressed, nothing to release
+			return nil
+		}
+		err := kb.fakeKey(xproto.KeyRelease, kb.scratchKeycode)
+		time.Sleep(remapSettleDelay)
+		kb.unbindScratch()
+		kb.scratchHeld = NoSymbol
+		return err
+	}
+
+	if kb.scratchHeld != NoSymbol {
+		if kb.scratchHeld == keysym {
+			return nil
+		}
+		return fmt.Errorf("keysym 0x%x is not in the layout and the spare keycode is held for keysym 0x%x", uint32(keysym), uint32(kb.scratchHeld))
+	}
+	if err := kb.bindScratch(keysym); err != nil {
+		return err
+	}
+	if err := kb.fakeKey(xproto.KeyPress, kb.scratchKeycode); err != nil {
+		kb.unbindScratch()
+		return err
+	}
+	kb.scratchHeld = keysym
+	return nil
+}
+
+// findKeysym looks for a keysym in the given group of the mapping. For
+// letters whose shifted slot is empty, X derives the uppercase form from the
+// lowercase one, so r is used to match those implicitly.
+func (kb *Keyboard) findKeysym(keysym xproto.Keysym, r rune, group int) (keyLocation, bool) {
+	lowerKeysym := NoSymbol
+	if r != 0 && unicode.IsUpper(r) {
+		lowerKeysym = RuneToKeysym(unicode.ToLower(r))
+	}
+
+	for kc := int(kb.minKeycode); kc <= int(kb.maxKeycode); kc++ {
+		plain, shifted := kb.groupKeysyms(xproto.Keycode(kc), group)
+
+		if plain == keysym {
+			return keyLocation{keycode: xproto.Keycode(kc)}, true
+		}
+		if shifted == keysym {
+			return keyLocation{keycode: xproto.Keycode(kc), shift: true}, true
+		}
+		if lowerKeysym != NoSymbol && plain == lowerKeysym && shifted == NoSymbol {
+			return keyLocation{keycode: xproto.Keycode(kc), shift: true}, true
+		}
+	}
+	return keyLocation{}, false
+}
+
+// groupKeysyms returns the plain and shifted keysyms of a key in a group.
+// The core mapping holds the first two levels of groups 1 and 2 in columns
+// 0-3; groups 3 and 4 come after the extra levels of those, whose number
+// varies by key, so they are not looked up. A key without symbols in the
+// group has only the first one, which XKB wraps the group into.
+func (kb *Keyboard) groupKeysyms(keycode xproto.Keycode, group int) (xproto.Keysym, xproto.Keysym) {
+	if group == 1 {
+		plain, shifted := kb.keysymAt(keycode, 2), kb.keysymAt(keycode, 3)
+		if plain != NoSymbol || shifted != NoSymbol {
+			return plain, shifted
+		}
+	} else if group > 1 {
+		return NoSymbol, NoSymbol
+	}
+	return kb.keysymAt(keycode, 0), kb.keysymAt(keycode, 1)
+}
+
+func (kb *Keyboard) keysymAt(keycode xproto.Keycode, column int) xproto.Keysym {
+	if column >= kb.keysymsPerKeycode {
+		return NoSymbol
+	}
+	i := (int(keycode)-int(kb.minKeycode))*kb.keysymsPerKeycode + column
+	if i < 0 || i >= len(kb.keysyms) {
+		return NoSymbol
+	}
+	return kb.keysyms[i]
+}
+
+func (kb *Keyboard) isEmptyKeycode(keycode xproto.Keycode) bool {
+	for column := 0; column < kb.keysymsPerKeycode; column++ {
+		if kb.keysymAt(keycode, column) != NoSymbol {
+			return false
+		}
+	}
+	return true
+}
+
+func (kb *Keyboard) tapLocation(loc keyLocation, group int) error {
+	var shiftKeycode xproto.Keycode
+	if loc.shift {
+		shiftLoc, ok := kb.findKeysym(keyNames["shift"], 0, group)
+		if !ok {
+			return fmt.Errorf("no Shift key in the keyboard mapping")
+		}
+		shiftKeycode = shiftLoc.keycode
+		if err := kb.fakeKey(xproto.KeyPress, shiftKeycode); err != nil {
+			return err
+		}
+	}
+
+	err := kb.fakeKey(xproto.KeyPress, loc.keycode)
+	if releaseErr := kb.fakeKey(xproto.KeyRelease, loc.keycode); err == nil {
+		err = releaseErr
+	}
+
+	if loc.shift {
+		if releaseErr := kb.fakeKey(xproto.KeyRelease, shiftKeycode); err == nil {
+			err = releaseErr
+		}
+	}
+	return err
+}
+
+// typeWithScratchKeycode binds the keysym to every slot of the spare keycode,
+// taps it and restores the empty mapping afterwards
+func (kb *Keyboard) typeWithScratchKeycode(keysym xproto.Keysym, group int) error {
+	if kb.scratchKeycode == 0 {
+		return fmt.Errorf("keysym 0x%x is not in the layout and no spare keycode is available", uint32(keysym))
+	}
+	if kb.scratchHeld != NoSymbol {
+		return fmt.Errorf("keysym 0x%x is not in the layout and the spare keycode is held for keysym 0x%x", uint32(keysym), uint32(kb.scratchHeld))
+	}
+
+	if err := kb.bindScratch(keysym); err != nil {
+		return err
+	}
+	err := kb.tapLocation(keyLocation{keycode: kb.scratchKeycode}, group)
+	time.Sleep(remapSettleDelay)
+	kb.unbindScratch()
+	return err
+}
+
+// bindScratch binds the keysym to every slot of the spare keycode
+func (kb *Keyboard) bindScratch(keysym xproto.Keysym) error {
+	slots := make([]xproto.Keysym, kb.keysymsPerKeycode)
+	for i := range slots {
+		slots[i] = keysym
+	}
+	if err := xproto.ChangeKeyboardMappingChecked(kb.conn, 1, kb.scratchKeycode, byte(kb.keysymsPerKeycode), slots).Check(); err != nil {
+		return fmt.Errorf("failed to remap spare keycode %d: %w", kb.scratchKeycode, err)
+	}
+	time.Sleep(remapSettleDelay)
+	return nil
+}
+
+// unbindScratch restores the empty mapping of the spare keycode and reads
+// the MappingNotify events the remaps caused
+func (kb *Keyboard) unbindScratch() {
+	empty := make([]xproto.Keysym, kb.keysymsPerKeycode)
+	if err := xproto.ChangeKeyboardMappingChecked(kb.conn, 1, kb.scratchKeycode, byte(kb.keysymsPerKeycode), empty).Check(); err != nil {
+		log.Printf("Failed to restore spare keycode %d: %v", kb.scratchKeycode, err)
+	}
+	kb.drainEvents()
+}
+
+func (kb *Keyboard) fakeKey(eventType byte, keycode xproto.Keycode) error {
+	return xtest.FakeInputChecked(kb.conn, eventType, byte(keycode), 0, kb.root, 0, 0, 0).Check()
+}
+
+// readKeyboardLayout reads the _XKB_RULES_NAMES property that setxkbmap
+// stores on the root window: rules, model, layout, variant and options
+// separated by NUL bytes
+func readKeyboardLayout(conn *xgb.Conn, root xproto.Window) (KeyboardLayout, error) {
+	const atomName = "_XKB_RULES_NAMES"
+	atomReply, err := xproto.InternAtom(conn, true, uint16(len(atomName)), atomName).Reply()
+	if err != nil {
+		return KeyboardLayout{}, fmt.Errorf("failed to intern %s: %w", atomName, err)
+	}
+	if atomReply.Atom == xproto.AtomNone {
+		return KeyboardLayout{}, fmt.Errorf("%s is not set on the root window", atomName)
+	}
+
+	prop, err := xproto.GetProperty(conn, false, root, atomReply.Atom, xproto.GetPropertyTypeAny, 0, 1024).Reply()
+	if err != nil {
+		return KeyboardLayout{}, fmt.Errorf("failed to read %s: %w", atomName, err)
+	}
+
+	fields := strings.Split(string(prop.Value), "\x00")
+	for len(fields) < 5 {
+		fields = append(fields, "")
+	}
+
+	return KeyboardLayout{
+		Rules:   fields[0],
+		Model:   fields[1],
+		Layout:  fields[2],
+		Variant: fields[3],
+		Options: fields[4],
+	}, nil
+}
@@ -0,0 +1,70 @@
+package x11
+
+import (
+	"testing"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// testKeyboard builds a keyboard from a core mapping of four columns per
+// keycode, starting at keycode 10, without a connection
+func testKeyboard(rows ...[4]xproto.Keysym) *Keyboard {
+	kb := &Keyboard{minKeycode: 10, maxKeycode: xproto.Keycode(10 + len(rows) - 1), keysymsPerKeycode: 4}
+	for _, row := range rows {
+		kb.keysyms = append(kb.keysyms, row[:]...)
+	}
+	return kb
+}
+
+func TestFindKeysym(t *testing.T) {
+	ru := func(r rune) xproto.Keysym { return RuneToKeysym(r) }
+	// A us,ru layout: groups 1 and 2 in columns 0-1 and 2-3
+	kb := testKeyboard(
+		[4]xproto.Keysym{'t', 'T', ru('е'), ru('Е')},           // 10
+		[4]xproto.Keysym{'1', '!', '1', ru('№')},               // 11
+		[4]xproto.Keysym{0xffe1, NoSymbol, NoSymbol, NoSymbol}, // 12 Shift_L, one group only
+		[4]xproto.Keysym{'q', NoSymbol, ru('й'), NoSymbol},     // 13 no explicit uppercase
+		[4]xproto.Keysym{}, // 14 spare
+	)
+	tests := []struct {
+		name   string
+		keysym xproto.Keysym
+		r      rune
+		group  int
+		want   keyLocation
+		found  bool
+	}{
+		{"latin in group 1", 't', 't', 0, keyLocation{keycode: 10}, true},
+		{"shifted latin in group 1", 'T', 'T', 0, keyLocation{keycode: 10, shift: true}, true},
+		{"latin not in group 2", 't', 't', 1, keyLocation{}, false},
+		{"cyrillic in group 2", ru('е'), 'е', 1, keyLocation{keycode: 10}, true},
+		{"shifted cyrillic in group 2", ru('Е'), 'Е', 1, keyLocation{keycode: 10, shift: true}, true},
+		{"cyrillic not in group 1", ru('е'), 'е', 0, keyLocation{}, false},
+		{"symbol of group 2 only", ru('№'), '№', 1, keyLocation{keycode: 11, shift: true}, true},
+		{"key with one group wraps", 0xffe1, 0, 1, keyLocation{keycode: 12}, true},
+		{"implicit uppercase", 'Q', 'Q', 0, keyLocation{keycode: 13, shift: true}, true},
+		{"implicit uppercase in group 2", ru('Й'), 'Й', 1, keyLocation{keycode: 13, shift: true}, true},
+		{"groups 3 and 4 are not looked up", 't', 't', 2, keyLocation{}, false},
+		{"not in the mapping", ru('ж'), 'ж', 1, keyLocation{}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := kb.findKeysym(tt.keysym, tt.r, tt.group)
+			if found != tt.found || got != tt.want {
+				t.Errorf("findKeysym(0x%x, %q, %d) = %+v, %v, want %+v, %v", uint32(tt.keysym), tt.r, tt.group, got, found, tt.want, tt.found)
+			}
+		})
+	}
+
+	if !kb.isEmptyKeycode(14) || kb.isEmptyKeycode(12) {
+		t.Error("isEmptyKeycode does not tell the spare keycode")
+	}
+}
+
+func TestKeyboardLayoutGroups(t *testing.T) {
+	for layout, want := range map[string]int{"": 1, "us": 1, "us,ru": 2, "us,ru,de": 3} {
+		if got := (KeyboardLayout{Layout: layout}).Groups(); got != want {
+			t.Errorf("Groups(%q) = %d, want %d", layout, got, want)
+		}
+	}
+}
@@ -0,0 +1,200 @@
+package x11
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+// NoSymbol is the keysym value X11 uses for an empty mapping slot
+const NoSymbol xproto.Keysym = 0
+
+// keyNames maps the key names accepted by keyTap, keyDown, keyUp and hotkey
+// actions to X11 keysyms. Single printable characters are resolved through
+// RuneToKeysym and do not need an entry here.
+var keyNames = map[string]xproto.Keysym{
+	// Modifiers
+	"shift":    0xffe1,
+	"lshift":   0xffe1,
+	"rshift":   0xffe2,
+	"ctrl":     0xffe3,
+	"control":  0xffe3,
+	"lctrl":    0xffe3,
+	"rctrl":    0xffe4,
+	"alt":      0xffe9,
+	"lalt":     0xffe9,
+	"ralt":     0xffea,
+	"altgr":    0xfe03,
+	"super":    0xffeb,
+	"lsuper":   0xffeb,
+	"rsuper":   0xffec,
+	"win":      0xffeb,
+	"cmd":      0xffeb,
+	"meta":     0xffeb,
+	"capslock": 0xffe5,
+	"numlock":  0xff7f,
+
+	// Editing and navigation
+	"enter":       0xff0d,
+	"return":      0xff0d,
+	"tab":         0xff09,
+	"esc":         0xff1b,
+	"escape":      0xff1b,
+	"backspace":   0xff08,
+	"delete":      0xffff,
+	"del":         0xffff,
+	"insert":      0xff63,
+	"home":        0xff50,
+	"end":         0xff57,
+	"left":        0xff51,
+	"up":          0xff52,
+	"right":       0xff53,
+	"down":        0xff54,
+	"pageup":      0xff55,
+	"pagedown":    0xff56,
+	"space":       0x0020,
+	"menu":        0xff67,
+	"print":       0xff61,
+	"printscreen": 0xff61,
+	"pause":       0xff13,
+	"scrolllock":  0xff14,
+
+	// Punctuation by name, for combos such as "ctrl+minus"
+	"minus":        0x002d,
+	"plus":         0x002b,
+	"equal":        0x003d,
+	"comma":        0x002c,
+	"period":       0x002e,
+	"slash":        0x002f,
+	"backslash":    0x005c,
+	"semicolon":    0x003b,
+	"quote":        0x0027,
+	"grave":        0x0060,
+	"bracketleft":  0x005b,
+	"bracketright": 0x005d,
+}
+
+func init() {
+	// Function keys F1..F24 are contiguous in keysymdef.h
+	for i := 1; i <= 24; i++ {
+		keyNames[fmt.Sprintf("f%d", i)] = xproto.Keysym(0xffbe + i - 1)
+	}
+}
+
+// modifierKeysyms lists the keysyms treated as modifiers when building hotkeys
+var modifierKeysyms = map[xproto.Keysym]bool{
+	0xffe1: true, 0xffe2: true, // Shift
+	0xffe3: true, 0xffe4: true, // Control
+	0xffe9: true, 0xffea: true, // Alt
+	0xffeb: true, 0xffec: true, // Super
+	0xfe03: true, // ISO_Level3_Shift (AltGr)
+}
+
+// cyrillicLower lists lowercase Cyrillic letters in the order of the legacy
+// keysyms 0x6c0..0x6df; the uppercase letters follow at 0x6e0..0x6ff.
+const cyrillicLower = "юабцдефгхийклмнопярстужвьызшэщчъ"
+
+// legacyKeysyms maps runes to the pre-Unicode keysyms that layouts such as
+// "ru" and "ua" actually put on their keys. Without this table a Cyrillic
+// character would never match the active layout and would always fall back
+// to the scratch keycode.
+var legacyKeysyms = map[rune]xproto.Keysym{
+	'ё': 0x6a3, 'Ё': 0x6b3,
+	'є': 0x6a4, 'Є': 0x6b4,
+	'і': 0x6a6, 'І': 0x6b6,
+	'ї': 0x6a7, 'Ї': 0x6b7,
+	'ґ': 0x6ad, 'Ґ': 0x6bd,
+	'ў': 0x6ae, 'Ў': 0x6be,
+	'№': 0x6b0,
+}
+
+func init() {
+	i := 0
+	for _, r := range cyrillicLower {
+		legacyKeysyms[r] = xproto.Keysym(0x6c0 + i)
+		legacyKeysyms[unicode.ToUpper(r)] = xproto.Keysym(0x6e0 + i)
+		i++
+	}
+}
+
+// RuneToKeysym converts a character to the keysym a layout would use for it
+func RuneToKeysym(r rune) xproto.Keysym {
+	switch r {
+	case '\n', '\r':
+		return keyNames["enter"]
+	case '\t':
+		return keyNames["tab"]
+	case '\b':
+		return keyNames["backspace"]
+	}
+
+	// Latin-1 keysyms are identical to their code points
+	if (r >= 0x20 && r <= 0x7e) || (r >= 0xa0 && r <= 0xff) {
+		return xproto.Keysym(r)
+	}
+
+	if ks, ok := legacyKeysyms[r]; ok {
+		return ks
+	}
+
+	// Everything else uses the Unicode keysym range
+	return xproto.Keysym(0x01000000 | uint32(r))
+}
+
+// KeyNameToKeysym resolves a key name (e.g. "ctrl", "f5", "t", "ä") to a keysym
+func KeyNameToKeysym(name string) (xproto.Keysym, bool) {
+	if ks, ok := keyNames[strings.ToLower(name)]; ok {
+		return ks, true
+	}
+
+	runes := []rune(name)
+	if len(runes) == 1 && unicode.IsPrint(runes[0]) {
+		return RuneToKeysym(unicode.ToLower(runes[0])), true
+	}
+
+	return NoSymbol, false
+}
+
+// IsModifierKey reports whether the named key is a modifier
+func IsModifierKey(name string) bool {
+	ks, ok := KeyNameToKeysym(name)
+	return ok && modifierKeysyms[ks]
+}
+
+// ParseKeyCombo splits a combo such as "ctrl+shift+t" into validated key names.
+// The trailing "+" of a combo like "ctrl++" is read as the plus key.
+func ParseKeyCombo(combo string) ([]string, error) {
+	combo = strings.TrimSpace(combo)
+	if combo == "" {
+		return nil, fmt.Errorf("empty key combo")
+	}
+
+	var keys []string
+	if strings.HasSuffix(combo, "++") {
+		keys = append(strings.Split(strings.TrimSuffix(combo, "++"), "+"), "plus")
+	} else if combo == "+" {
+		keys = []string{"plus"}
+	} else {
+		keys = strings.Split(combo, "+")
+	}
+
+	for i, key := range keys {
+		key = strings.TrimSpace(key)
+		if _, ok := KeyNameToKeysym(key); !ok {
+			return nil, fmt.Errorf("unknown key name %q in combo %q", key, combo)
+		}
+		keys[i] = key
+	}
+	return keys, nil
+}
+
+// KnownKeyNames returns the named (non single character) keys, for prompts and errors
+func KnownKeyNames() []string {
+	names := make([]string, 0, len(keyNames))
+	for name := range keyNames {
+		names = append(names, name)
+	}
+	return names
+}
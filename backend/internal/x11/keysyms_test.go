@@ -0,0 +1,125 @@
+package x11
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/BurntSushi/xgb/xproto"
+)
+
+func TestParseKeyCombo(t *testing.T) {
+	tests := []struct {
+		combo   string
+		want    []string
+		wantErr bool
+	}{
+		{combo: "enter", want: []string{"enter"}},
+		{combo: "ctrl+shift+t", want: []string{"ctrl", "shift", "t"}},
+		{combo: " ctrl + L ", want: []string{"ctrl", "L"}},
+		{combo: "alt+F4", want: []string{"alt", "F4"}},
+		{combo: "ctrl++", want: []string{"ctrl", "plus"}},
+		{combo: "+", want: []string{"plus"}},
+		{combo: "ctrl+alt+ф", want: []string{"ctrl", "alt", "ф"}},
+		{combo: "", wantErr: true},
+		{combo: "   ", wantErr: true},
+		{combo: "ctrl+", wantErr: true},
+		{combo: "ctrl+foo", wantErr: true},
+		{combo: "hyper+t", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.combo, func(t *testing.T) {
+			got, err := ParseKeyCombo(tt.combo)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ParseKeyCombo(%q) = %q, want an error", tt.combo, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseKeyCombo(%q): %v", tt.combo, err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ParseKeyCombo(%q) = %q, want %q", tt.combo, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestKeyNameToKeysym(t *testing.T) {
+	tests := []struct {
+		name string
+		want xproto.Keysym
+		ok   bool
+	}{
+		{"ctrl", 0xffe3, true},
+		{"CTRL", 0xffe3, true},
+		{"Return", 0xff0d, true},
+		{"f1", 0xffbe, true},
+		{"f12", 0xffc9, true},
+		{"f24", 0xffd5, true},
+		{"t", 't', true},
+		{"T", 't', true}, // keys are named by their unshifted symbol
+		{"ä", 0xe4, true},
+		{"ф", 0x6c6, true},
+		{"Ф", 0x6c6, true},
+		{"€", 0x010020ac, true},
+		{"minus", '-', true},
+		{"f25", NoSymbol, false},
+		{"ab", NoSymbol, false},
+		{"", NoSymbol, false},
+		{"\x01", NoSymbol, false},
+	}
+	for _, tt := range tests {
+		got, ok := KeyNameToKeysym(tt.name)
+		if got != tt.want || ok != tt.ok {
+			t.Errorf("KeyNameToKeysym(%q) = 0x%x, %v, want 0x%x, %v", tt.name, uint32(got), ok, uint32(tt.want), tt.ok)
+		}
+	}
+}
+
+func TestRuneToKeysym(t *testing.T) {
+	tests := []struct {
+		r    rune
+		want xproto.Keysym
+	}{
+		{'a', 0x61},
+		{'A', 0x41},
+		{' ', 0x20},
+		{'~', 0x7e},
+		{'é', 0xe9},
+		{'\n', 0xff0d},
+		{'\r', 0xff0d},
+		{'\t', 0xff09},
+		{'\b', 0xff08},
+		// legacy Cyrillic keysyms, as ru and ua layouts use them
+		{'ю', 0x6c0},
+		{'а', 0x6c1},
+		{'ъ', 0x6df},
+		{'Ю', 0x6e0},
+		{'Ъ', 0x6ff},
+		{'ё', 0x6a3},
+		{'Ё', 0x6b3},
+		{'і', 0x6a6},
+		{'№', 0x6b0},
+		// everything else in the Unicode range
+		{'€', 0x010020ac},
+		{'中', 0x01004e2d},
+		{'😀', 0x0101f600},
+	}
+	for _, tt := range tests {
+		if got := RuneToKeysym(tt.r); got != tt.want {
+			t.Errorf("RuneToKeysym(%q) = 0x%x, want 0x%x", tt.r, uint32(got), uint32(tt.want))
+		}
+	}
+}
+
+func TestIsModifierKey(t *testing.T) {
+	for name, want := range map[string]bool{
+		"shift": true, "rshift": true, "ctrl": true, "alt": true, "altgr": true, "super": true, "cmd": true,
+		"capslock": false, "enter": false, "a": false, "unknown": false,
+	} {
+		if got := IsModifierKey(name); got != want {
+			t.Errorf("IsModifierKey(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -186,7 +186,7 @@ func getWindowInfo(conn *xgb.Conn, windowID xproto.Window) (X11Window, error) {
 	}
 
 	// Detect window buttons (this is approximate since X11 doesn't expose button info directly)
-	window.Buttons = detectWindowButtons(window.Position, window.Size)
+	window.Buttons = DetectWindowButtons(window.Position, window.Size)
 
 	return window, nil
 }
@@ -336,8 +336,8 @@ func getAtomName(conn *xgb.Conn, atom xproto.Atom) (string, error) {
 	return name.Name, nil
 }
 
-// detectWindowButtons approximates window button positions based on window geometry
-func detectWindowButtons(pos WindowPosition, size WindowSize) []WindowButton {
+// DetectWindowButtons approximates window button positions based on window geometry
+func DetectWindowButtons(pos WindowPosition, size WindowSize) []WindowButton {
 	var buttons []WindowButton
 
 	// Standard window button size and positioning
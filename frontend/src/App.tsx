@@ -59,6 +59,8 @@ const App: React.FC = () => {
 
   const mainContentRef = useRef<HTMLDivElement>(null);
   const spaceKeyPressedRef = useRef<boolean>(false);
+  // Last WebSocket event seen per session, so a reconnect resumes after it
+  const eventCursorsRef = useRef<Record<string, { serverSession: string; seq: number }>>({});
   
   // Monitor task status changes to deactivate user-assist
   useEffect(() => {
@@ -578,6 +580,38 @@ const App: React.FC = () => {
     }
   };
 
+  // Query that makes the server replay the events a session missed
+  const resumeQuery = (sessionId: string) => {
+    const cursor = eventCursorsRef.current[sessionId];
+    return cursor ? `?since=${cursor.seq}&session=${cursor.serverSession}` : '';
+  };
+
+  // Follows the event sequence of a session's WebSocket; returns false for
+  // the hello and for events already handled before a reconnect
+  const trackEvent = (sessionId: string, data: any) => {
+    if (data.type === 'hello') {
+      const cursor = eventCursorsRef.current[sessionId];
+      if (!data.resumed) {
+        eventCursorsRef.current[sessionId] = { serverSession: data.sessionId, seq: data.latestSeq };
+      } else if (!cursor || cursor.serverSession !== data.sessionId) {
+        // The server restarted, everything it replays is new
+        eventCursorsRef.current[sessionId] = { serverSession: data.sessionId, seq: 0 };
+      }
+      if (data.gap) {
+        console.warn('Some WebSocket events were missed and can not be replayed');
+      }
+      return false;
+    }
+    const cursor = eventCursorsRef.current[sessionId];
+    if (cursor && typeof data.seq === 'number') {
+      if (data.seq <= cursor.seq) {
+        return false;
+      }
+      cursor.seq = data.seq;
+    }
+    return true;
+  };
+
   // Setup WebSocket connection for a session
   const setupSessionWebSocket = (session: Session) => {
     // Clear any existing ping interval
@@ -591,7 +625,7 @@ const App: React.FC = () => {
     }
     
     // Create new WebSocket
-    const ws = new WebSocket(`${CONFIG.BACKEND_WS_URL}/ws`);
+    const ws = new WebSocket(`${CONFIG.BACKEND_WS_URL}/ws${resumeQuery(session.id)}`);
     
     ws.onopen = () => {
       console.log(`Connected to server ${session.ip}`);
@@ -607,16 +641,12 @@ const App: React.FC = () => {
     ws.onmessage = (event) => {
       const message = event.data.trim();
       
-      // Check if message is a time string (format: "2006-01-02 15:04:05")
-      if (/^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$/.test(message)) {
-        // This is a time string, ignore it or handle it as needed
-        console.log('Received time message:', message);
-        return;
-      }
-      
       // Try to parse as JSON
       try {
         const data = JSON.parse(message);
+        if (!trackEvent(session.id, data)) {
+          return;
+        }
         
         if (data.type === 'taskUpdate') {
           // Handle task updates
@@ -842,7 +872,7 @@ const App: React.FC = () => {
       setSelectedSessionId(sessionId);
       
       // Setup WebSocket connection with a callback to activate user-assist when connected
-      const ws = new WebSocket(`${CONFIG.BACKEND_WS_URL.replace(/localhost[^:]*/, sessionIp)}/ws`);
+      const ws = new WebSocket(`${CONFIG.BACKEND_WS_URL.replace(/localhost[^:]*/, sessionIp)}/ws${resumeQuery(sessionId)}`);
       
       ws.onopen = () => {
         console.log(`Connected to server ${sessionIp}`);
@@ -871,16 +901,12 @@ const App: React.FC = () => {
       ws.onmessage = (event) => {
         const message = event.data.trim();
         
-        // Check if message is a time string (format: "2006-01-02 15:04:05")
-        if (/^\d{4}-\d{2}-\d{2} \d{2}:\d{2}:\d{2}$/.test(message)) {
-          // This is a time string, ignore it or handle it as needed
-          console.log('Received time message:', message);
-          return;
-        }
-        
         // Try to parse as JSON
         try {
           const data = JSON.parse(message);
+          if (!trackEvent(sessionId, data)) {
+            return;
+          }
           
           if (data.type === 'taskUpdate') {
             // Handle task updates